@@ -0,0 +1,102 @@
+package gofakes3
+
+// Group URIs used by the canned ACLs:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview.html#specifying-grantee-predefined-groups
+const (
+	GroupAllUsers           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	GroupAuthenticatedUsers = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+	GroupLogDelivery        = "http://acs.amazonaws.com/groups/s3/LogDelivery"
+)
+
+// CannedACL is one of the predefined grant sets that can be applied by name
+// using the 'x-amz-acl' header:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview.html#canned-acl
+type CannedACL string
+
+const (
+	ACLPrivate                CannedACL = "private"
+	ACLPublicRead             CannedACL = "public-read"
+	ACLPublicReadWrite        CannedACL = "public-read-write"
+	ACLAuthenticatedRead      CannedACL = "authenticated-read"
+	ACLBucketOwnerRead        CannedACL = "bucket-owner-read"
+	ACLBucketOwnerFullControl CannedACL = "bucket-owner-full-control"
+	ACLLogDeliveryWrite       CannedACL = "log-delivery-write"
+)
+
+// Grants returns the grant set the canned ACL maps to for the given owner.
+//
+// As GoFakeS3 only has a single owner, the bucket owner and the object owner
+// are always the same, so 'bucket-owner-read' and 'bucket-owner-full-control'
+// are equivalent to 'private'.
+func (c CannedACL) Grants(owner UserInfo) ([]Grant, error) {
+	grants := []Grant{
+		{Grantee: ownerGrantee(owner), Permission: PermissionFullControl},
+	}
+
+	switch c {
+	case ACLPrivate, ACLBucketOwnerRead, ACLBucketOwnerFullControl:
+	case ACLPublicRead:
+		grants = append(grants, groupGrant(GroupAllUsers, PermissionRead))
+	case ACLPublicReadWrite:
+		grants = append(grants,
+			groupGrant(GroupAllUsers, PermissionRead),
+			groupGrant(GroupAllUsers, PermissionWrite))
+	case ACLAuthenticatedRead:
+		grants = append(grants, groupGrant(GroupAuthenticatedUsers, PermissionRead))
+	case ACLLogDeliveryWrite:
+		grants = append(grants,
+			groupGrant(GroupLogDelivery, PermissionWrite),
+			groupGrant(GroupLogDelivery, PermissionReadACP))
+	default:
+		return nil, ErrorInvalidArgument("x-amz-acl", string(c), "")
+	}
+
+	return grants, nil
+}
+
+// withOwnerFullControl returns grants with a FULL_CONTROL grant for the owner
+// added to the front, unless grants already contains one.
+func withOwnerFullControl(grants []Grant, owner UserInfo) []Grant {
+	for _, grant := range grants {
+		if grant.Permission == PermissionFullControl &&
+			grant.Grantee.Type == GranteeCanonicalUser &&
+			grant.Grantee.ID == owner.ID {
+			return grants
+		}
+	}
+	full := Grant{Grantee: ownerGrantee(owner), Permission: PermissionFullControl}
+	return append([]Grant{full}, grants...)
+}
+
+func ownerGrantee(owner UserInfo) Grantee {
+	return Grantee{Type: GranteeCanonicalUser, ID: owner.ID, DisplayName: owner.DisplayName}
+}
+
+func groupGrant(uri string, perm Permission) Grant {
+	return Grant{Grantee: Grantee{Type: GranteeGroup, URI: uri}, Permission: perm}
+}
+
+// validateGrants checks the grants supplied in an AccessControlPolicy request
+// body. Grantees must be of a known type and supply the field that type
+// requires.
+func validateGrants(grants []Grant) error {
+	for _, grant := range grants {
+		if !grant.Permission.Valid() {
+			return ErrorMessagef(ErrMalformedACLError, "Invalid permission %q", grant.Permission)
+		}
+
+		var ok bool
+		switch grant.Grantee.Type {
+		case GranteeCanonicalUser:
+			ok = grant.Grantee.ID != ""
+		case GranteeGroup:
+			ok = grant.Grantee.URI != ""
+		case GranteeEmail:
+			ok = grant.Grantee.EmailAddress != ""
+		}
+		if !ok {
+			return ErrorMessage(ErrMalformedACLError, "The XML you provided was not well-formed or did not validate against our published schema")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package gofakes3
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/textproto"
+)
+
+// adminSeedRequest is the body of a 'POST /__admin/seed' request. See
+// WithAdminAPI.
+type adminSeedRequest struct {
+	Buckets []string          `json:"buckets"`
+	Objects []adminSeedObject `json:"objects"`
+}
+
+type adminSeedObject struct {
+	Bucket   string            `json:"bucket"`
+	Key      string            `json:"key"`
+	Metadata map[string]string `json:"metadata"`
+
+	// Only one of Body or BodyBase64 should be set.
+	Body       string `json:"body"`
+	BodyBase64 string `json:"bodyBase64"`
+}
+
+// adminPost responds with ErrMethodNotAllowed to anything but a POST. The
+// admin routes can't include the method in their patterns, as that depends
+// on the Go version of the main module (see http.ServeMux).
+func (g *GoFakeS3) adminPost(handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			g.httpError(w, r, ErrMethodNotAllowed)
+			return
+		}
+		handler(w, r)
+	})
+}
+
+// adminReset handles 'POST /__admin/reset', which deletes every bucket along
+// with everything in it.
+func (g *GoFakeS3) adminReset(w http.ResponseWriter, r *http.Request) {
+	g.log.Print(LogInfo, "ADMIN RESET")
+
+	if err := g.reset(); err != nil {
+		g.httpError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminSeed handles 'POST /__admin/seed', which creates the buckets and
+// objects in the adminSeedRequest in the body. Buckets that already exist are
+// left alone, as are any objects that are not replaced.
+func (g *GoFakeS3) adminSeed(w http.ResponseWriter, r *http.Request) {
+	g.log.Print(LogInfo, "ADMIN SEED")
+
+	if err := g.seed(r); err != nil {
+		g.httpError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminDeletePrefix handles 'POST /__admin/delete-prefix', which deletes every
+// object under the 'prefix' in the 'bucket' query string parameters. It
+// responds with the number of objects deleted.
+func (g *GoFakeS3) adminDeletePrefix(w http.ResponseWriter, r *http.Request) {
+	bucket, prefix := r.URL.Query().Get("bucket"), r.URL.Query().Get("prefix")
+	g.log.Print(LogInfo, "ADMIN DELETE PREFIX:", bucket, prefix)
+
+	deleted, err := g.Client().DeletePrefix(bucket, prefix)
+	if err != nil {
+		g.httpError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(adminDeletePrefixResult{Deleted: deleted}); err != nil {
+		g.log.Print(LogErr, err)
+	}
+}
+
+type adminDeletePrefixResult struct {
+	Deleted int `json:"deleted"`
+}
+
+func (g *GoFakeS3) reset() error {
+	buckets, err := g.storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+
+	for _, bucket := range buckets {
+		if err := g.emptyBucket(bucket.Name); err != nil {
+			return err
+		}
+		if err := g.storage.DeleteBucket(bucket.Name); err != nil {
+			return err
+		}
+		g.subresources.RemoveBucket(bucket.Name)
+	}
+
+	g.uploader.AbortAll()
+	return nil
+}
+
+// emptyBucket deletes every object in the bucket, including every version and
+// delete marker if the bucket has ever had versioning enabled.
+func (g *GoFakeS3) emptyBucket(bucket string) error {
+	if _, err := g.Client().DeletePrefix(bucket, ""); err != nil {
+		return err
+	}
+
+	if g.versioned == nil {
+		return nil
+	}
+	config, err := g.versioned.VersioningConfiguration(bucket)
+	if err != nil {
+		return err
+	}
+	if config.Status == "" {
+		return nil
+	}
+
+	// Deleting the objects above will have left delete markers in their
+	// place, which are removed here along with the other versions:
+	var page ListBucketVersionsPage
+	for {
+		versions, err := g.versioned.ListBucketVersions(bucket, nil, &page)
+		if err != nil {
+			return err
+		}
+		for _, item := range versions.Versions {
+			var key string
+			switch item := item.(type) {
+			case *Version:
+				key = item.Key
+			case *DeleteMarker:
+				key = item.Key
+			}
+			if _, err := g.versioned.DeleteObjectVersion(bucket, key, item.GetVersionID()); err != nil {
+				return err
+			}
+		}
+
+		if !versions.IsTruncated {
+			return nil
+		}
+		page = ListBucketVersionsPage{
+			KeyMarker:          versions.NextKeyMarker,
+			HasKeyMarker:       versions.NextKeyMarker != "",
+			VersionIDMarker:    versions.NextVersionIDMarker,
+			HasVersionIDMarker: versions.NextVersionIDMarker != "",
+		}
+	}
+}
+
+func (g *GoFakeS3) seed(r *http.Request) error {
+	var in adminSeedRequest
+	defer r.Body.Close()
+	if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+		return ErrorMessage(ErrInvalidRequest, "invalid seed request: "+err.Error())
+	}
+
+	buckets := append([]string{}, in.Buckets...)
+	for _, obj := range in.Objects {
+		buckets = append(buckets, obj.Bucket)
+	}
+	for _, bucket := range buckets {
+		if err := ValidateBucketName(bucket); err != nil {
+			return err
+		}
+		if err := g.storage.CreateBucket(bucket); err != nil && !IsAlreadyExists(err) {
+			return err
+		}
+	}
+
+	client := g.Client()
+	for _, obj := range in.Objects {
+		body := []byte(obj.Body)
+		if obj.BodyBase64 != "" {
+			var err error
+			if body, err = base64.StdEncoding.DecodeString(obj.BodyBase64); err != nil {
+				return ErrorInvalidArgument("bodyBase64", obj.Key, "invalid base64 body")
+			}
+		}
+
+		meta := make(map[string]string, len(obj.Metadata))
+		for k, v := range obj.Metadata {
+			meta[textproto.CanonicalMIMEHeaderKey(k)] = v
+		}
+
+		if _, err := client.PutObject(obj.Bucket, obj.Key, meta, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
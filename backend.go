@@ -2,6 +2,8 @@ package gofakes3
 
 import (
 	"io"
+	"sort"
+	"strings"
 )
 
 const (
@@ -58,6 +60,53 @@ func (b *ObjectList) AddPrefix(prefix string) {
 	b.CommonPrefixes = append(b.CommonPrefixes, CommonPrefix{Prefix: prefix})
 }
 
+// applyPage trims a complete listing of a bucket down to the requested page.
+// It is used for backends that return ErrInternalPageNotImplemented.
+//
+// As in S3, contents and common prefixes are interleaved in key order and
+// both count towards MaxKeys. A common prefix is skipped if the marker falls
+// inside it, as all of its keys were rolled up into an earlier page.
+func (b *ObjectList) applyPage(page ListBucketPage) *ObjectList {
+	type entry struct {
+		key     string
+		content *Content
+	}
+	entries := make([]entry, 0, len(b.Contents)+len(b.CommonPrefixes))
+	for _, c := range b.Contents {
+		entries = append(entries, entry{key: c.Key, content: c})
+	}
+	for _, cp := range b.CommonPrefixes {
+		entries = append(entries, entry{key: cp.Prefix})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	out := NewObjectList()
+	var cnt int64
+	for idx, e := range entries {
+		if page.Marker != "" {
+			if e.key <= page.Marker {
+				continue
+			} else if e.content == nil && strings.HasPrefix(page.Marker, e.key) {
+				continue
+			}
+		}
+
+		if e.content != nil {
+			out.Add(e.content)
+		} else {
+			out.AddPrefix(e.key)
+		}
+
+		cnt++
+		if page.MaxKeys > 0 && cnt >= page.MaxKeys {
+			out.NextMarker = e.key
+			out.IsTruncated = idx < len(entries)-1
+			break
+		}
+	}
+	return out
+}
+
 type ObjectDeleteResult struct {
 	// Specifies whether the versioned object that was permanently deleted was
 	// (true) or was not (false) a delete marker. In a simple DELETE, this
@@ -126,6 +175,9 @@ type PutObjectResult struct {
 // Backend provides a set of operations to be implemented in order to support
 // gofakes3.
 //
+// A Backend may also implement io.Closer, in which case it will be closed by
+// GoFakeS3.Close().
+//
 // The Backend API is not yet stable; if you create your own Backend, breakage
 // is likely until this notice is removed.
 //
@@ -149,18 +201,21 @@ type Backend interface {
 	//
 	// At this stage, implementers MAY return gofakes3.ErrInternalPageNotImplemented
 	// if the page argument is non-empty. In this case, gofakes3 may or may
-	// not, depending on how it was configured, retry the same request with no page.
-	// We have observed (though not yet confirmed) that simple clients tend to
-	// work fine if you ignore the pagination request, but this may not suit
-	// your application. Not all backends bundled with gofakes3 correctly
-	// support this pagination yet, but that will change.
+	// not, depending on how it was configured, retry the same request with no page
+	// and select the page from the full listing itself. This is correct, but
+	// reads the entire bucket for every page, so backends that can seek to
+	// the marker and stop after MaxKeys should implement paging themselves.
+	// Not all backends bundled with gofakes3 correctly support this pagination
+	// yet, but that will change.
 	ListBucket(name string, prefix *Prefix, page ListBucketPage) (*ObjectList, error)
 
 	// CreateBucket creates the bucket if it does not already exist. The name
 	// should be assumed to be a valid name.
 	//
 	// If the bucket already exists, a gofakes3.ResourceError with
-	// gofakes3.ErrBucketAlreadyExists MUST be returned.
+	// gofakes3.ErrBucketAlreadyExists MUST be returned. GoFakeS3 will
+	// report this to the client as gofakes3.ErrBucketAlreadyOwnedByYou, as
+	// all buckets belong to the same owner.
 	CreateBucket(name string) error
 
 	// BucketExists should return a boolean indicating the bucket existence, or
@@ -231,6 +286,101 @@ type Backend interface {
 	DeleteMulti(bucketName string, objects ...string) (MultiDeleteResult, error)
 }
 
+// PutCondition restricts a write to the state of the object it would
+// replace. The zero value has no conditions.
+type PutCondition struct {
+	// IfAbsent requires that the object does not exist, as requested by an
+	// 'If-None-Match: *' header. An object whose latest version is a delete
+	// marker does not exist.
+	IfAbsent bool
+
+	// IfMatch, if not empty, requires that the object exists and that its
+	// ETag, including the surrounding quotes, is equal to IfMatch, as
+	// requested by an 'If-Match' header.
+	IfMatch string
+}
+
+func (c PutCondition) IsEmpty() bool {
+	return c == PutCondition{}
+}
+
+// Check returns the error S3 reports if the condition is not met by the
+// object currently stored under key. etag must be the object's ETag,
+// including the surrounding quotes, or empty if the object does not exist.
+func (c PutCondition) Check(key string, etag string) error {
+	if c.IfAbsent && etag != "" {
+		return ErrPreconditionFailed
+	}
+	if c.IfMatch != "" {
+		if etag == "" {
+			return KeyNotFound(key)
+		} else if etag != c.IfMatch {
+			return ErrPreconditionFailed
+		}
+	}
+	return nil
+}
+
+// ConditionalPutBackend may be optionally implemented by a Backend in order to
+// make conditional writes atomic.
+//
+// If you don't implement ConditionalPutBackend, GoFakeS3 checks the
+// condition using HeadObject before calling PutObject. This races with any
+// other write to the same key, so a warning is logged each time it happens.
+//
+type ConditionalPutBackend interface {
+	// PutObjectIf behaves like PutObject, but must only store the object if
+	// the condition is met. Checking the condition and storing the object
+	// MUST happen atomically with respect to any other write to the key. If
+	// the condition is not met, the error returned by cond.Check MUST be
+	// returned.
+	//
+	// A Backend that wraps another Backend may return
+	// gofakes3.ErrNotImplemented, without reading from input, if the wrapped
+	// Backend does not support PutObjectIf. GoFakeS3 then falls back as if
+	// ConditionalPutBackend was not implemented.
+	PutObjectIf(bucketName, key string, cond PutCondition, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error)
+}
+
+// PartsManifest records the parts an object was assembled from by
+// CompleteMultipartUpload, in the order they make up the object, along with
+// the composite checksum of the object if the upload was given a checksum
+// algorithm.
+type PartsManifest struct {
+	Parts    []ObjectPart
+	Checksum Checksums
+}
+
+func (pm *PartsManifest) clone() *PartsManifest {
+	out := &PartsManifest{Parts: make([]ObjectPart, len(pm.Parts)), Checksum: pm.Checksum}
+	copy(out.Parts, pm.Parts)
+	return out
+}
+
+// ObjectPartsBackend may be optionally implemented by a Backend in order to
+// store the parts manifest of an object created by CompleteMultipartUpload
+// alongside the object, for GetObject and HeadObject's 'partNumber' parameter
+// and GetObjectAttributes' ObjectParts.
+//
+// If you don't implement ObjectPartsBackend, GoFakeS3 keeps the manifests in
+// memory instead. They are then lost when GoFakeS3 is discarded, and are
+// only known for the current version of an object.
+type ObjectPartsBackend interface {
+	// PutObjectWithParts behaves like PutObject, but also stores the manifest
+	// with the new object (or the new version of it). Objects created by
+	// PutObject MUST NOT have a manifest, even if they replace one that did.
+	PutObjectWithParts(bucketName, key string, meta map[string]string, input io.Reader, size int64, manifest PartsManifest) (PutObjectResult, error)
+
+	// ObjectParts returns the manifest stored with the object, or with the
+	// given version of it if versionID is not empty. It MUST return a nil
+	// manifest and a nil error if the object has no manifest.
+	//
+	// ObjectParts must return a gofakes3.ErrNoSuchKey error if the object
+	// does not exist, and gofakes3.ErrNoSuchVersion if the version does not
+	// exist.
+	ObjectParts(bucketName, key string, versionID VersionID) (*PartsManifest, error)
+}
+
 // VersionedBackend may be optionally implemented by a Backend in order to support
 // operations on S3 object versions.
 //
@@ -287,3 +287,16 @@ func TestPutDeleteMulti(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateBucketAlreadyExists(t *testing.T) {
+	multi, err := MultiBucket(afero.NewMemMapFs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.CreateBucket("test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := multi.CreateBucket("test"); !gofakes3.HasErrorCode(err, gofakes3.ErrBucketAlreadyExists) {
+		t.Fatal("expected ErrBucketAlreadyExists, found", err)
+	}
+}
@@ -218,7 +218,7 @@ func (db *MultiBucketBackend) CreateBucket(name string) error {
 	db.lock.Lock()
 	defer db.lock.Unlock()
 
-	if err := db.bucketFs.Mkdir(name, 0600); os.IsNotExist(err) {
+	if err := db.bucketFs.Mkdir(name, 0600); os.IsExist(err) {
 		return gofakes3.ResourceError(gofakes3.ErrBucketAlreadyExists, name)
 	} else {
 		return err
@@ -24,7 +24,10 @@ type Backend struct {
 	metaBucketName []byte
 }
 
-var _ gofakes3.Backend = &Backend{}
+var (
+	_ gofakes3.Backend = &Backend{}
+	_ io.Closer        = &Backend{}
+)
 
 type Option func(b *Backend)
 
@@ -57,6 +60,12 @@ func New(bolt *bolt.DB, opts ...Option) *Backend {
 	return b
 }
 
+// Close closes the underlying bolt.DB, including one that was passed to New.
+// It is called by GoFakeS3.Close().
+func (db *Backend) Close() error {
+	return db.bolt.Close()
+}
+
 // metaBucket returns a utility that manages access to the metadata bucket.
 // The returned struct is valid only for the lifetime of the bolt.Tx.
 // The metadata bucket may not exist if this is an older database.
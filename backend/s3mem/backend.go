@@ -27,6 +27,8 @@ type Backend struct {
 
 var _ gofakes3.Backend = &Backend{}
 var _ gofakes3.VersionedBackend = &Backend{}
+var _ gofakes3.ConditionalPutBackend = &Backend{}
+var _ gofakes3.ObjectPartsBackend = &Backend{}
 
 type Option func(b *Backend)
 
@@ -91,8 +93,12 @@ func (db *Backend) ListBucket(name string, prefix *gofakes3.Prefix, page gofakes
 	var match gofakes3.PrefixMatch
 
 	if page.Marker != "" {
-		iter.Seek(page.Marker)
-		iter.Next() // Move to the next item after the Marker
+		// The marker need not be an existing key (for example, a V2
+		// 'start-after' value), so only skip the item we land on if it is
+		// the marker itself:
+		if iter.Seek(page.Marker) && iter.Key().(string) == page.Marker {
+			iter.Next()
+		}
 	}
 
 	var cnt int64 = 0
@@ -177,11 +183,20 @@ func (db *Backend) HeadObject(bucketName, objectName string) (*gofakes3.Object,
 	}
 
 	obj := bucket.object(objectName)
-	if obj == nil || obj.data.deleteMarker {
+	if obj == nil || obj.data == nil || obj.data.deleteMarker {
 		return nil, gofakes3.KeyNotFound(objectName)
 	}
 
-	return obj.data.toObject(nil, false)
+	result, err := obj.data.toObject(nil, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if obj.data.nullVersion {
+		result.VersionID = ""
+	}
+
+	return result, nil
 }
 
 func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
@@ -194,7 +209,7 @@ func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofake
 	}
 
 	obj := bucket.object(objectName)
-	if obj == nil || obj.data.deleteMarker {
+	if obj == nil || obj.data == nil || obj.data.deleteMarker {
 		// FIXME: If the current version of the object is a delete marker,
 		// Amazon S3 behaves as if the object was deleted and includes
 		// x-amz-delete-marker: true in the response.
@@ -209,7 +224,7 @@ func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofake
 		return nil, err
 	}
 
-	if bucket.versioning != gofakes3.VersioningEnabled {
+	if obj.data.nullVersion {
 		result.VersionID = ""
 	}
 
@@ -217,6 +232,20 @@ func (db *Backend) GetObject(bucketName, objectName string, rangeRequest *gofake
 }
 
 func (db *Backend) PutObject(bucketName, objectName string, meta map[string]string, input io.Reader, size int64) (result gofakes3.PutObjectResult, err error) {
+	return db.PutObjectIf(bucketName, objectName, gofakes3.PutCondition{}, meta, input, size)
+}
+
+func (db *Backend) PutObjectIf(bucketName, objectName string, cond gofakes3.PutCondition, meta map[string]string, input io.Reader, size int64) (result gofakes3.PutObjectResult, err error) {
+	return db.putObject(bucketName, objectName, cond, meta, input, size, nil)
+}
+
+func (db *Backend) PutObjectWithParts(bucketName, objectName string, meta map[string]string, input io.Reader, size int64, manifest gofakes3.PartsManifest) (result gofakes3.PutObjectResult, err error) {
+	parts := make([]gofakes3.ObjectPart, len(manifest.Parts))
+	copy(parts, manifest.Parts)
+	return db.putObject(bucketName, objectName, gofakes3.PutCondition{}, meta, input, size, &gofakes3.PartsManifest{Parts: parts, Checksum: manifest.Checksum})
+}
+
+func (db *Backend) putObject(bucketName, objectName string, cond gofakes3.PutCondition, meta map[string]string, input io.Reader, size int64, parts *gofakes3.PartsManifest) (result gofakes3.PutObjectResult, err error) {
 	// No need to lock the backend while we read the data into memory; it holds
 	// the write lock open unnecessarily, and could be blocked for an unreasonably
 	// long time by a connection timing out:
@@ -233,6 +262,16 @@ func (db *Backend) PutObject(bucketName, objectName string, meta map[string]stri
 		return result, gofakes3.BucketNotFound(bucketName)
 	}
 
+	if !cond.IsEmpty() {
+		var etag string
+		if obj := bucket.object(objectName); obj != nil && obj.data != nil && !obj.data.deleteMarker {
+			etag = obj.data.etag
+		}
+		if err := cond.Check(objectName, etag); err != nil {
+			return result, err
+		}
+	}
+
 	hash := md5.Sum(bts)
 
 	item := &bucketData{
@@ -242,6 +281,7 @@ func (db *Backend) PutObject(bucketName, objectName string, meta map[string]stri
 		etag:         `"` + hex.EncodeToString(hash[:]) + `"`,
 		metadata:     meta,
 		lastModified: db.timeSource.Now(),
+		parts:        parts,
 	}
 	bucket.put(objectName, item)
 
@@ -253,6 +293,37 @@ func (db *Backend) PutObject(bucketName, objectName string, meta map[string]stri
 	return result, nil
 }
 
+func (db *Backend) ObjectParts(bucketName, objectName string, versionID gofakes3.VersionID) (*gofakes3.PartsManifest, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	bucket := db.buckets[bucketName]
+	if bucket == nil {
+		return nil, gofakes3.BucketNotFound(bucketName)
+	}
+
+	var data *bucketData
+	if versionID == "" {
+		obj := bucket.object(objectName)
+		if obj == nil || obj.data == nil || obj.data.deleteMarker {
+			return nil, gofakes3.KeyNotFound(objectName)
+		}
+		data = obj.data
+	} else {
+		var err error
+		if data, err = bucket.objectVersion(objectName, versionID); err != nil {
+			return nil, err
+		}
+	}
+
+	if data.parts == nil {
+		return nil, nil
+	}
+	parts := make([]gofakes3.ObjectPart, len(data.parts.Parts))
+	copy(parts, data.parts.Parts)
+	return &gofakes3.PartsManifest{Parts: parts, Checksum: data.parts.Checksum}, nil
+}
+
 func (db *Backend) DeleteObject(bucketName, objectName string) (result gofakes3.ObjectDeleteResult, rerr error) {
 	db.lock.Lock()
 	defer db.lock.Unlock()
@@ -278,7 +349,6 @@ func (db *Backend) DeleteMulti(bucketName string, objects ...string) (result gof
 
 	for _, object := range objects {
 		dresult, err := bucket.rm(object, now)
-		_ = dresult // FIXME: what to do with rm result in multi delete?
 
 		if err != nil {
 			errres := gofakes3.ErrorResultFromError(err)
@@ -289,9 +359,12 @@ func (db *Backend) DeleteMulti(bucketName string, objects ...string) (result gof
 			result.Error = append(result.Error, errres)
 
 		} else {
-			result.Deleted = append(result.Deleted, gofakes3.ObjectID{
-				Key: object,
-			})
+			deleted := gofakes3.ObjectID{Key: object}
+			if dresult.IsDeleteMarker {
+				deleted.DeleteMarker = true
+				deleted.DeleteMarkerVersionID = string(dresult.VersionID)
+			}
+			result.Deleted = append(result.Deleted, deleted)
 		}
 	}
 
@@ -116,10 +116,19 @@ type bucketData struct {
 	lastModified time.Time
 	versionID    gofakes3.VersionID
 	deleteMarker bool
+
+	// nullVersion is set if the object was written while versioning was not
+	// enabled. Its versionID is then hidden from GetObject and HeadObject, as
+	// S3 reports no version for it.
+	nullVersion bool
+
 	body         []byte
 	hash         []byte
 	etag         string
 	metadata     map[string]string
+
+	// parts is set if the object was created by CompleteMultipartUpload.
+	parts *gofakes3.PartsManifest
 }
 
 func (bi *bucketData) toObject(rangeRequest *gofakes3.ObjectRangeRequest, withBody bool) (obj *gofakes3.Object, err error) {
@@ -142,7 +151,7 @@ func (bi *bucketData) toObject(rangeRequest *gofakes3.ObjectRangeRequest, withBo
 
 		// The data slice should be completely replaced if the bucket item is edited, so
 		// it should be safe to return the data slice directly.
-		contents = s3io.ReaderWithDummyCloser{bytes.NewReader(data)}
+		contents = s3io.ReaderWithDummyCloser{Reader: bytes.NewReader(data)}
 
 	} else {
 		contents = s3io.NoOpReadCloser{}
@@ -200,6 +209,7 @@ func (b *bucket) objectVersion(objectName string, versionID gofakes3.VersionID)
 func (b *bucket) put(name string, item *bucketData) {
 	// Always generate a version for convenience; we can just mask it on return.
 	item.versionID = b.versionGen()
+	item.nullVersion = b.versioning != gofakes3.VersioningEnabled
 
 	object := b.object(name)
 	if object == nil {
@@ -254,6 +264,17 @@ func (b *bucket) rmVersion(name string, versionID gofakes3.VersionID, at time.Ti
 		result.IsDeleteMarker = object.data.deleteMarker
 		object.data = nil
 
+		// Removing the current version makes the most recent of the
+		// remaining versions current again, as it does in S3:
+		if object.versions != nil {
+			if iter := object.versions.SeekToLast(); iter != nil {
+				latest := iter.Key().(gofakes3.VersionID)
+				iter.Close()
+				versionIface, _ := object.versions.Delete(latest)
+				object.data = versionIface.(*bucketData)
+			}
+		}
+
 	} else if object.versions != nil {
 		versionIface, ok := object.versions.Delete(versionID)
 		if !ok {
@@ -0,0 +1,207 @@
+package gofakes3
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ChecksumAlgorithm is one of the additional checksums S3 can calculate for
+// an upload, alongside the MD5 ETag:
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/checking-object-integrity.html
+type ChecksumAlgorithm string
+
+const (
+	ChecksumCRC32  ChecksumAlgorithm = "CRC32"
+	ChecksumCRC32C ChecksumAlgorithm = "CRC32C"
+	ChecksumSHA1   ChecksumAlgorithm = "SHA1"
+	ChecksumSHA256 ChecksumAlgorithm = "SHA256"
+)
+
+var checksumAlgorithms = []ChecksumAlgorithm{ChecksumCRC32, ChecksumCRC32C, ChecksumSHA1, ChecksumSHA256}
+
+func parseChecksumAlgorithm(s string) (ChecksumAlgorithm, bool) {
+	for _, alg := range checksumAlgorithms {
+		if strings.EqualFold(s, string(alg)) {
+			return alg, true
+		}
+	}
+	return "", false
+}
+
+// header is the name of the header the checksum is sent in, like
+// 'x-amz-checksum-crc32c'.
+func (alg ChecksumAlgorithm) header() string {
+	return "x-amz-checksum-" + strings.ToLower(string(alg))
+}
+
+func (alg ChecksumAlgorithm) newHash() hash.Hash {
+	switch alg {
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case ChecksumSHA1:
+		return sha1.New()
+	case ChecksumSHA256:
+		return sha256.New()
+	default:
+		panic(fmt.Errorf("gofakes3: unknown checksum algorithm %q", alg))
+	}
+}
+
+// Checksums holds the base64-encoded checksums of an object or part. At most
+// one is set. It is embedded in the XML messages that report checksums.
+type Checksums struct {
+	ChecksumCRC32  string `xml:"ChecksumCRC32,omitempty"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA1   string `xml:"ChecksumSHA1,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+func newChecksums(alg ChecksumAlgorithm, value string) (cs Checksums) {
+	switch alg {
+	case ChecksumCRC32:
+		cs.ChecksumCRC32 = value
+	case ChecksumCRC32C:
+		cs.ChecksumCRC32C = value
+	case ChecksumSHA1:
+		cs.ChecksumSHA1 = value
+	case ChecksumSHA256:
+		cs.ChecksumSHA256 = value
+	}
+	return cs
+}
+
+// Get returns the checksum for the algorithm, or an empty string if there
+// isn't one.
+func (cs Checksums) Get(alg ChecksumAlgorithm) string {
+	switch alg {
+	case ChecksumCRC32:
+		return cs.ChecksumCRC32
+	case ChecksumCRC32C:
+		return cs.ChecksumCRC32C
+	case ChecksumSHA1:
+		return cs.ChecksumSHA1
+	case ChecksumSHA256:
+		return cs.ChecksumSHA256
+	}
+	return ""
+}
+
+// requestedChecksum is the checksum a client asked for when uploading a
+// part. If expected is nil, the client only named the algorithm (with
+// 'x-amz-sdk-checksum-algorithm') and the checksum is calculated without
+// being checked.
+type requestedChecksum struct {
+	algorithm ChecksumAlgorithm
+	expected  []byte
+}
+
+// checksumFromHeader returns the checksum requested by an upload's
+// 'x-amz-checksum-*' or 'x-amz-sdk-checksum-algorithm' headers. If neither
+// was sent, the algorithm is empty.
+//
+// Checksums sent in an 'aws-chunked' trailer are not supported.
+func checksumFromHeader(hdr http.Header) (rc requestedChecksum, err error) {
+	for _, alg := range checksumAlgorithms {
+		value := hdr.Get(alg.header())
+		if value == "" {
+			continue
+		}
+		if rc.algorithm != "" {
+			return rc, ErrorMessage(ErrInvalidRequest, "Expecting a single x-amz-checksum- header. Multiple checksum Types are not allowed.")
+		}
+
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(expected) != alg.newHash().Size() {
+			return rc, ErrorMessagef(ErrInvalidRequest, "Value for %s header is invalid.", alg.header())
+		}
+		rc = requestedChecksum{algorithm: alg, expected: expected}
+	}
+
+	if sdk := hdr.Get("x-amz-sdk-checksum-algorithm"); sdk != "" {
+		alg, ok := parseChecksumAlgorithm(sdk)
+		if !ok {
+			return rc, ErrorMessage(ErrInvalidRequest, "Value for x-amz-sdk-checksum-algorithm header is invalid.")
+		}
+		if rc.algorithm == "" {
+			rc.algorithm = alg
+		} else if rc.algorithm != alg {
+			return rc, ErrorMessage(ErrInvalidRequest, "Value for x-amz-sdk-checksum-algorithm header is invalid.")
+		}
+	}
+
+	return rc, nil
+}
+
+// checksumReader calculates a checksum of the data read through it, and
+// checks it against the expected checksum, if there is one, once the inner
+// reader returns EOF.
+type checksumReader struct {
+	inner    io.Reader
+	checksum requestedChecksum
+	hash     hash.Hash
+	sum      []byte
+}
+
+func newChecksumReader(inner io.Reader, checksum requestedChecksum) *checksumReader {
+	return &checksumReader{inner: inner, checksum: checksum, hash: checksum.algorithm.newHash()}
+}
+
+// Value returns the base64-encoded checksum. It is only valid once the
+// inner reader has returned EOF.
+func (cr *checksumReader) Value() string {
+	return base64.StdEncoding.EncodeToString(cr.sum)
+}
+
+func (cr *checksumReader) Read(p []byte) (n int, err error) {
+	n, err = cr.inner.Read(p)
+	if n != 0 {
+		cr.hash.Write(p[:n]) // Hash.Write never returns an error.
+	}
+	if err == io.EOF {
+		cr.sum = cr.hash.Sum(nil)
+		if cr.checksum.expected != nil && !bytes.Equal(cr.sum, cr.checksum.expected) {
+			return n, ErrorMessagef(ErrBadDigest, "The %s you specified did not match the calculated checksum.", cr.checksum.algorithm)
+		}
+	}
+	return n, err
+}
+
+// writeHeaders sets the 'x-amz-checksum-*' header for the checksum that is
+// set, if any.
+func (cs Checksums) writeHeaders(hdr http.Header) {
+	for _, alg := range checksumAlgorithms {
+		if value := cs.Get(alg); value != "" {
+			hdr.Set(alg.header(), value)
+		}
+	}
+}
+
+// compositeChecksum calculates the checksum S3 reports for an object
+// assembled from parts: the checksum of the concatenated (decoded) checksums
+// of the parts, followed by '-' and the number of parts. It returns an empty
+// string if any of the parts has no checksum for the algorithm.
+func compositeChecksum(alg ChecksumAlgorithm, parts []ObjectPart) string {
+	h := alg.newHash()
+	for _, part := range parts {
+		value := part.Get(alg)
+		if value == "" {
+			return ""
+		}
+		raw, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return ""
+		}
+		h.Write(raw)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(h.Sum(nil)), len(parts))
+}
@@ -0,0 +1,44 @@
+package gofakes3
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChecksumFromHeader(t *testing.T) {
+	const crc32c = "yZRlqg==" // CRC32C of "abc"
+
+	for _, tc := range []struct {
+		hdr map[string]string
+		alg ChecksumAlgorithm
+		err ErrorCode
+	}{
+		{map[string]string{}, "", ""},
+		{map[string]string{"x-amz-checksum-crc32c": crc32c}, ChecksumCRC32C, ""},
+		{map[string]string{"x-amz-sdk-checksum-algorithm": "sha256"}, ChecksumSHA256, ""},
+		{map[string]string{"x-amz-sdk-checksum-algorithm": "CRC32C", "x-amz-checksum-crc32c": crc32c}, ChecksumCRC32C, ""},
+		{map[string]string{"x-amz-sdk-checksum-algorithm": "SHA1", "x-amz-checksum-crc32c": crc32c}, "", ErrInvalidRequest},
+		{map[string]string{"x-amz-sdk-checksum-algorithm": "MD5"}, "", ErrInvalidRequest},
+		{map[string]string{"x-amz-checksum-crc32": crc32c, "x-amz-checksum-crc32c": crc32c}, "", ErrInvalidRequest},
+		{map[string]string{"x-amz-checksum-crc32c": "not base64"}, "", ErrInvalidRequest},
+		{map[string]string{"x-amz-checksum-sha256": crc32c}, "", ErrInvalidRequest},
+	} {
+		hdr := make(http.Header)
+		for k, v := range tc.hdr {
+			hdr.Set(k, v)
+		}
+		rc, err := checksumFromHeader(hdr)
+		if tc.err != "" {
+			if !HasErrorCode(err, tc.err) {
+				t.Fatal("expected", tc.err, "for", tc.hdr, "found", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rc.algorithm != tc.alg {
+			t.Fatal("expected", tc.alg, "for", tc.hdr, "found", rc.algorithm)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package gofakes3
+
+import (
+	"bytes"
+	"encoding/hex"
+)
+
+// Client operates directly on the objects stored by a GoFakeS3, without
+// going through HTTP or an S3 SDK. It is intended for seeding data and
+// making assertions in tests.
+//
+// Client uses the same Backend as the GoFakeS3 it was created from, including
+// any key transform configured using WithKeyTransform, so what it stores is
+// what the HTTP API serves.
+type Client struct {
+	g *GoFakeS3
+}
+
+// ClientObject is an object retrieved using Client.GetObject.
+type ClientObject struct {
+	Key      string
+	Metadata map[string]string
+	Body     []byte
+
+	// ETag, including the surrounding quotes, as it would be reported in
+	// the ETag header.
+	ETag string
+
+	// VersionID will be empty if bucket versioning has not been enabled.
+	VersionID VersionID
+}
+
+// Client returns a Client for the objects stored by g.
+func (g *GoFakeS3) Client() *Client {
+	return &Client{g: g}
+}
+
+// PutObject stores body in the bucket under key. meta may be nil.
+func (c *Client) PutObject(bucket, key string, meta map[string]string, body []byte) (PutObjectResult, error) {
+	if len(key) > KeySizeLimit {
+		return PutObjectResult{}, ResourceError(ErrKeyTooLong, key)
+	}
+	if meta == nil {
+		meta = map[string]string{}
+	}
+
+	result, err := c.g.storage.PutObject(bucket, key, meta, bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return result, err
+	}
+
+	// A new object does not inherit the tags or ACL of the one it replaces:
+	c.g.subresources.RemoveObject(bucket, key)
+	return result, nil
+}
+
+// GetObject retrieves the latest version of the object stored in the bucket
+// under key, reading its entire body.
+func (c *Client) GetObject(bucket, key string) (*ClientObject, error) {
+	obj, err := c.g.storage.GetObject(bucket, key, nil)
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, KeyNotFound(key)
+	}
+	defer obj.Contents.Close()
+
+	if obj.IsDeleteMarker {
+		return nil, KeyNotFound(key)
+	}
+
+	body, err := ReadAll(obj.Contents, obj.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClientObject{
+		Key:       key,
+		Metadata:  obj.Metadata,
+		Body:      body,
+		ETag:      `"` + hex.EncodeToString(obj.Hash) + `"`,
+		VersionID: obj.VersionID,
+	}, nil
+}
+
+// ListObjects lists every object in the bucket that matches prefix, which may
+// be nil. Unlike the S3 API, the result is not split into pages.
+func (c *Client) ListObjects(bucket string, prefix *Prefix) (*ObjectList, error) {
+	if prefix == nil {
+		prefix = &Prefix{}
+	}
+	return c.g.storage.ListBucket(bucket, prefix, ListBucketPage{})
+}
+
+// DeleteObject deletes the object stored in the bucket under key. As with the
+// S3 API, it is not an error if the object does not exist.
+func (c *Client) DeleteObject(bucket, key string) (ObjectDeleteResult, error) {
+	result, err := c.g.storage.DeleteObject(bucket, key)
+	if err != nil {
+		return result, err
+	}
+	c.g.subresources.RemoveObject(bucket, key)
+	return result, nil
+}
+
+// DeletePrefix deletes every object in the bucket whose key starts with
+// prefix, and returns the number of objects deleted. An empty prefix deletes
+// every object in the bucket.
+//
+// Unlike DeleteObjects in the S3 API, there is no limit on the number of
+// objects that can be deleted at once.
+func (c *Client) DeletePrefix(bucket, prefix string) (deleted int, err error) {
+	objects, err := c.g.storage.ListBucket(bucket, &Prefix{HasPrefix: prefix != "", Prefix: prefix}, ListBucketPage{})
+	if err != nil {
+		return 0, err
+	}
+	if len(objects.Contents) == 0 {
+		return 0, nil
+	}
+
+	keys := make([]string, len(objects.Contents))
+	for i, item := range objects.Contents {
+		keys[i] = item.Key
+	}
+
+	result, err := c.g.storage.DeleteMulti(bucket, keys...)
+	for _, obj := range result.Deleted {
+		c.g.subresources.RemoveObject(bucket, obj.Key)
+	}
+	if err != nil {
+		return len(result.Deleted), err
+	}
+	return len(result.Deleted), result.AsError()
+}
@@ -0,0 +1,92 @@
+package gofakes3_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestClient(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	client := ts.Client()
+
+	ts.OKAll(client.PutObject(defaultBucket, "dir/a", map[string]string{"Content-Type": "text/plain"}, []byte("hello")))
+	ts.OKAll(client.PutObject(defaultBucket, "dir/b", nil, []byte("world")))
+	ts.OKAll(client.PutObject(defaultBucket, "c", nil, []byte("!")))
+
+	// Objects stored by the client are visible over HTTP:
+	ts.assertObject(defaultBucket, "dir/a", map[string]string{"Content-Type": "text/plain"}, "hello")
+
+	// And objects stored over HTTP are visible to the client:
+	ts.OKAll(ts.s3Client().PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dir/b"),
+		Body:   strings.NewReader("replaced"),
+	}))
+	obj, err := client.GetObject(defaultBucket, "dir/b")
+	ts.OK(err)
+	if string(obj.Body) != "replaced" || obj.Key != "dir/b" {
+		t.Fatal("unexpected object", obj.Key, string(obj.Body))
+	}
+	if sum := md5.Sum([]byte("replaced")); obj.ETag != `"`+hex.EncodeToString(sum[:])+`"` {
+		t.Fatal("unexpected etag", obj.ETag)
+	}
+
+	list, err := client.ListObjects(defaultBucket, &gofakes3.Prefix{Prefix: "dir/", HasPrefix: true})
+	ts.OK(err)
+	if len(list.Contents) != 2 || list.Contents[0].Key != "dir/a" || list.Contents[1].Key != "dir/b" {
+		t.Fatal("unexpected list", list.Contents)
+	}
+
+	ts.OKAll(client.DeleteObject(defaultBucket, "dir/a"))
+	if _, err := client.GetObject(defaultBucket, "dir/a"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected ErrNoSuchKey, found", err)
+	}
+	ts.assertLs(defaultBucket, "dir/", nil, []string{"dir/b"})
+
+	if _, err := client.GetObject("nope", "c"); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected ErrNoSuchBucket, found", err)
+	}
+}
+
+func TestClientDeletePrefix(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	client := ts.Client()
+
+	for _, key := range []string{"dir/a", "dir/b", "dir/sub/c", "dirt", "other"} {
+		ts.OKAll(client.PutObject(defaultBucket, key, nil, []byte("hello")))
+	}
+
+	deleted, err := client.DeletePrefix(defaultBucket, "dir/")
+	ts.OK(err)
+	if deleted != 3 {
+		t.Fatal("unexpected deleted count", deleted)
+	}
+	ts.assertLs(defaultBucket, "", nil, []string{"dirt", "other"})
+
+	// Nothing left to delete under the prefix:
+	deleted, err = client.DeletePrefix(defaultBucket, "dir/")
+	ts.OK(err)
+	if deleted != 0 {
+		t.Fatal("unexpected deleted count", deleted)
+	}
+
+	// An empty prefix deletes everything:
+	deleted, err = client.DeletePrefix(defaultBucket, "")
+	ts.OK(err)
+	if deleted != 2 {
+		t.Fatal("unexpected deleted count", deleted)
+	}
+	ts.assertLs(defaultBucket, "", nil, nil)
+
+	if _, err := client.DeletePrefix("nope", ""); !gofakes3.HasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected ErrNoSuchBucket, found", err)
+	}
+}
@@ -29,12 +29,13 @@ func main() {
 }
 
 type fakeS3Flags struct {
-	host          string
-	backendKind   string
-	initialBucket string
-	fixedTimeStr  string
-	noIntegrity   bool
-	hostBucket    bool
+	host           string
+	backendKind    string
+	initialBucket  string
+	fixedTimeStr   string
+	noIntegrity    bool
+	hostBucket     bool
+	hostBucketBase string
 
 	boltDb         string
 	directFsPath   string
@@ -53,6 +54,7 @@ func (f *fakeS3Flags) attach(flagSet *flag.FlagSet) {
 	flagSet.StringVar(&f.initialBucket, "initialbucket", "", "If passed, this bucket will be created on startup if it does not already exist.")
 	flagSet.BoolVar(&f.noIntegrity, "no-integrity", false, "Pass this flag to disable Content-MD5 validation when uploading.")
 	flagSet.BoolVar(&f.hostBucket, "hostbucket", false, "If passed, the bucket name will be extracted from the first segment of the hostname, rather than the first part of the URL path.")
+	flagSet.StringVar(&f.hostBucketBase, "hostbucket.base", "", "If passed with -hostbucket, the bucket name will be everything in the hostname to the left of this domain, which allows bucket names containing dots. Requests to other hosts use the URL path.")
 
 	// Backend specific:
 	flagSet.StringVar(&f.backendKind, "backend", "", "Backend to use to store data (memory, bolt, directfs, fs)")
@@ -221,6 +223,7 @@ func run() error {
 		gofakes3.WithTimeSource(timeSource),
 		gofakes3.WithLogger(gofakes3.GlobalLog()),
 		gofakes3.WithHostBucket(values.hostBucket),
+		gofakes3.WithHostBucketBase(values.hostBucketBase),
 	)
 
 	return listenAndServe(values.host, faker.Server())
@@ -0,0 +1,99 @@
+package gofakes3
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// compressionMiddleware gzips XML responses for clients that accept it. See
+// WithResponseCompression.
+func (g *GoFakeS3) compressionMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		// Object bodies are always sent as they were stored, even if the
+		// object itself is XML:
+		op := requestOperation(rq)
+		if op == OpGetObject || op == OpHeadObject || !acceptsGzip(rq.Header.Get("Accept-Encoding")) {
+			handler.ServeHTTP(w, rq)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, method: rq.Method}
+		defer gw.Close()
+		handler.ServeHTTP(gw, rq)
+	})
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows a gzipped
+// response, i.e. it lists 'gzip' without 'q=0'.
+func acceptsGzip(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(params[0]), "gzip") {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") && strings.Trim(param[2:], "0.") == "" {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses the response if, when the status is
+// written, it is an XML document that isn't already encoded. Anything else
+// is passed through unchanged.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	method string
+
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+
+	hdr := gw.Header()
+	if hdr.Get("Content-Type") == "application/xml" && hdr.Get("Content-Encoding") == "" && bodyAllowed(gw.method, status) {
+		// The length and checksum of the uncompressed body no longer
+		// apply to what is sent:
+		hdr.Del("Content-Length")
+		hdr.Del("Content-MD5")
+		hdr.Set("Content-Encoding", "gzip")
+		hdr.Add("Vary", "Accept-Encoding")
+		gw.gz = gzip.NewWriter(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(status)
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	gw.WriteHeader(http.StatusOK)
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+func (gw *gzipResponseWriter) Flush() {
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if flusher, ok := gw.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Close writes the end of the gzip stream, if the response was compressed.
+func (gw *gzipResponseWriter) Close() error {
+	if gw.gz != nil {
+		return gw.gz.Close()
+	}
+	return nil
+}
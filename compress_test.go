@@ -0,0 +1,25 @@
+package gofakes3
+
+import "testing"
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		accept string
+		gzip   bool
+	}{
+		{"", false},
+		{"identity", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"deflate, gzip", true},
+		{"gzip;q=0.5", true},
+		{"gzip; q=1.0, identity", true},
+		{"gzip;q=0", false},
+		{"gzip;q=0.000", false},
+		{"x-gzip", false},
+	} {
+		if acceptsGzip(tc.accept) != tc.gzip {
+			t.Fatal("unexpected result for", tc.accept)
+		}
+	}
+}
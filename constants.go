@@ -28,6 +28,10 @@ const (
 
 	DefaultSkewLimit = 15 * time.Minute
 
+	// Value sent in the Retry-After header while GoFakeS3.SetMaintenance is
+	// enabled. Retry-After only supports whole seconds.
+	MaintenanceRetryAfter = 1 * time.Second
+
 	MaxUploadsLimit       = 1000
 	DefaultMaxUploads     = 1000
 	MaxUploadPartsLimit   = 1000
@@ -41,4 +45,22 @@ const (
 
 	// From the docs: "Part numbers can be any number from 1 to 10,000, inclusive."
 	MaxUploadPartNumber = 10000
+
+	// From https://docs.aws.amazon.com/AmazonS3/latest/dev/object-tagging.html:
+	//	"You can associate up to 10 tags with an object. Tags that are
+	//	associated with an object must have unique tag keys."
+	//	"A tag key can be up to 128 Unicode characters in length, and tag
+	//	values can be up to 256 Unicode characters in length."
+	MaxObjectTags     = 10
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
+
+	// XMLNamespace is the namespace of S3's XML responses, as given in the
+	// S3 schema (http://doc.s3.amazonaws.com/2006-03-01/AmazonS3.xsd). See
+	// WithXMLNamespace.
+	XMLNamespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+	// DefaultServerHeader is the value of the Server header S3 sends with
+	// every response. See WithServerHeader.
+	DefaultServerHeader = "AmazonS3"
 )
@@ -1,6 +1,7 @@
 package gofakes3
 
 import (
+	"fmt"
 	"net/http"
 	"strings"
 )
@@ -23,16 +24,38 @@ var (
 		"x-amz-meta-to",
 	}
 	corsHeadersString = strings.Join(corsHeaders, ", ")
+
+	corsMethods = []string{"POST", "GET", "OPTIONS", "PUT", "DELETE", "HEAD"}
+
+	// defaultCORSRules apply to preflight requests for buckets that do not
+	// have a CORS configuration of their own. They allow the same methods and
+	// headers that are advertised for every other request.
+	defaultCORSRules = []CORSRule{{
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "PUT", "POST", "DELETE", "HEAD"},
+		AllowedHeaders: corsHeaders,
+	}}
 )
 
 type withCORS struct {
 	r   http.Handler
 	log Logger
+
+	// rules returns the CORS rules that apply to the bucket; see
+	// GoFakeS3.corsRules.
+	rules func(bucket string) []CORSRule
+
+	httpError func(w http.ResponseWriter, r *http.Request, err error)
 }
 
 func (s *withCORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+		s.preflight(w, r)
+		return
+	}
+
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, HEAD")
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(corsMethods, ", "))
 	w.Header().Set("Access-Control-Allow-Headers", corsHeadersString)
 
 	if r.Method == "OPTIONS" {
@@ -41,3 +64,146 @@ func (s *withCORS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	s.r.ServeHTTP(w, r)
 }
+
+// preflight responds to a CORS preflight request, which is allowed if any of
+// the bucket's CORS rules allows the origin, the method and all of the
+// headers. Only the allowed method and headers that were requested are sent
+// back, as S3 does:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTOPTIONSobject.html
+func (s *withCORS) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+
+	var headers []string
+	for _, hdr := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if hdr = strings.ToLower(strings.TrimSpace(hdr)); hdr != "" {
+			headers = append(headers, hdr)
+		}
+	}
+
+	if origin == "" {
+		s.httpError(w, r, ErrorMessage(ErrBadRequest, "Insufficient information. Origin request header needed."))
+		return
+	}
+
+	bucket, _ := splitBucketObject(r.URL.Path)
+
+	for _, rule := range s.rules(bucket) {
+		if !rule.allows(origin, method, headers) {
+			continue
+		}
+
+		hdr := w.Header()
+		if rule.allowsAnyOrigin() {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+		}
+		hdr.Set("Access-Control-Allow-Methods", method)
+		if len(headers) > 0 {
+			hdr.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		}
+		if len(rule.ExposeHeaders) > 0 {
+			hdr.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+		}
+		if rule.MaxAgeSeconds > 0 {
+			hdr.Set("Access-Control-Max-Age", fmt.Sprintf("%d", rule.MaxAgeSeconds))
+		}
+		hdr.Set("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+		return
+	}
+
+	s.httpError(w, r, ErrorMessage(ErrAccessDenied, "CORSResponse: This CORS request is not allowed. "+
+		"This is usually because the evalution of Origin, request method / Access-Control-Request-Method "+
+		"or Access-Control-Request-Headers are not whitelisted by the resource's CORS spec."))
+}
+
+// allows reports whether the rule allows a preflight request from the origin
+// for the method and headers.
+func (rule CORSRule) allows(origin, method string, headers []string) bool {
+	if !matchesAnyWildcard(rule.AllowedOrigins, origin, false) {
+		return false
+	}
+
+	methodAllowed := false
+	for _, m := range rule.AllowedMethods {
+		if m == method {
+			methodAllowed = true
+			break
+		}
+	}
+	if !methodAllowed {
+		return false
+	}
+
+	for _, hdr := range headers {
+		if !matchesAnyWildcard(rule.AllowedHeaders, hdr, true) {
+			return false
+		}
+	}
+	return true
+}
+
+func (rule CORSRule) allowsAnyOrigin() bool {
+	for _, o := range rule.AllowedOrigins {
+		if o == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyWildcard reports whether value matches any of the patterns. As
+// in S3's CORS configuration, a pattern may contain at most one '*', which
+// matches any sequence of characters.
+func matchesAnyWildcard(patterns []string, value string, foldCase bool) bool {
+	if foldCase {
+		value = strings.ToLower(value)
+	}
+	for _, pattern := range patterns {
+		if foldCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if idx := strings.IndexByte(pattern, '*'); idx >= 0 {
+			prefix, suffix := pattern[:idx], pattern[idx+1:]
+			if len(value) >= len(prefix)+len(suffix) && strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix) {
+				return true
+			}
+		} else if pattern == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validateCORSRules applies the restrictions S3 places on a CORS
+// configuration:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/cors.html
+func validateCORSRules(rules []CORSRule) error {
+	if len(rules) == 0 {
+		return ErrMalformedXML
+	}
+	for _, rule := range rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return ErrMalformedXML
+		}
+		for _, m := range rule.AllowedMethods {
+			switch m {
+			case "GET", "PUT", "POST", "DELETE", "HEAD":
+			default:
+				return ErrorMessagef(ErrInvalidRequest, "Found unsupported HTTP method in CORS config. Unsupported method is %s", m)
+			}
+		}
+		for _, o := range rule.AllowedOrigins {
+			if strings.Count(o, "*") > 1 {
+				return ErrorMessagef(ErrInvalidRequest, "AllowedOrigin %q can not have more than one wildcard.", o)
+			}
+		}
+		for _, h := range rule.AllowedHeaders {
+			if strings.Count(h, "*") > 1 {
+				return ErrorMessagef(ErrInvalidRequest, "AllowedHeader %q can not have more than one wildcard.", h)
+			}
+		}
+	}
+	return nil
+}
@@ -15,14 +15,37 @@ import (
 const (
 	ErrNone ErrorCode = ""
 
+	// Access Denied. See WithPolicyEnforcement.
+	ErrAccessDenied ErrorCode = "AccessDenied"
+
 	// The Content-MD5 you specified did not match what we received.
 	ErrBadDigest ErrorCode = "BadDigest"
 
+	// Returned for some malformed requests that do not have a more specific
+	// code, for example when more than MaxObjectTags tags are sent.
+	ErrBadRequest ErrorCode = "BadRequest"
+
+	// The requested bucket name is not available. The bucket namespace is
+	// shared by all users of the system.
 	ErrBucketAlreadyExists ErrorCode = "BucketAlreadyExists"
 
+	// The bucket you tried to create already exists, and you own it. As
+	// GoFakeS3 has only one owner (see WithOwner), this is the code returned
+	// to clients when they attempt to create a bucket that already exists.
+	ErrBucketAlreadyOwnedByYou ErrorCode = "BucketAlreadyOwnedByYou"
+
 	// Raised when attempting to delete a bucket that still contains items.
 	ErrBucketNotEmpty ErrorCode = "BucketNotEmpty"
 
+	// Your proposed upload is smaller than the minimum allowed object size.
+	// Each part of a multipart upload must be at least 5 MB in size, except
+	// the last part.
+	ErrEntityTooSmall ErrorCode = "EntityTooSmall"
+
+	// Your proposed upload exceeds the maximum allowed object size. See
+	// WithMaxObjectSize.
+	ErrEntityTooLarge ErrorCode = "EntityTooLarge"
+
 	// "Indicates that the versioning configuration specified in the request is invalid"
 	ErrIllegalVersioningConfiguration ErrorCode = "IllegalVersioningConfigurationException"
 
@@ -42,17 +65,36 @@ const (
 
 	ErrInvalidArgument ErrorCode = "InvalidArgument"
 
+	// The object queried by SelectObjectContent could not be decompressed
+	// using the requested CompressionType.
+	ErrInvalidCompressionFormat ErrorCode = "InvalidCompressionFormat"
+
+	// The InputSerialization sent to SelectObjectContent did not name
+	// exactly one of CSV, JSON or Parquet.
+	ErrInvalidDataSource ErrorCode = "InvalidDataSource"
+
+	// The ExpressionType sent to SelectObjectContent was not SQL.
+	ErrInvalidExpressionType ErrorCode = "InvalidExpressionType"
+
 	// https://docs.aws.amazon.com/AmazonS3/latest/dev/BucketRestrictions.html#bucketnamingrules
 	ErrInvalidBucketName ErrorCode = "InvalidBucketName"
 
 	// The Content-MD5 you specified is not valid.
 	ErrInvalidDigest ErrorCode = "InvalidDigest"
 
+	// The LocationConstraint given to CreateBucket is not the region set
+	// with WithRegion.
+	ErrInvalidLocationConstraint ErrorCode = "InvalidLocationConstraint"
+
 	ErrInvalidRange         ErrorCode = "InvalidRange"
 	ErrInvalidToken         ErrorCode = "InvalidToken"
 	ErrKeyTooLong           ErrorCode = "KeyTooLongError" // This is not a typo: Error is part of the string, but redundant in the constant name
 	ErrMalformedPOSTRequest ErrorCode = "MalformedPOSTRequest"
 
+	// The policy document sent to the '?policy' subresource was not a valid
+	// JSON object.
+	ErrMalformedPolicy ErrorCode = "MalformedPolicy"
+
 	// One or more of the specified parts could not be found. The part might
 	// not have been uploaded, or the specified entity tag might not have
 	// matched the part's entity tag.
@@ -62,8 +104,23 @@ const (
 	// specified in order by part number.
 	ErrInvalidPartOrder ErrorCode = "InvalidPartOrder"
 
+	// The part requested with GetObject's 'partNumber' parameter does not
+	// exist in the object.
+	ErrInvalidPartNumber ErrorCode = "InvalidPartNumber"
+
+	// The request is not valid in its current form, for example if the
+	// Authorization header is malformed. See WithStrictHeaders.
+	ErrInvalidRequest ErrorCode = "InvalidRequest"
+
+	// The tag provided was not a valid tag. See validateTagSet.
+	ErrInvalidTag ErrorCode = "InvalidTag"
+
 	ErrInvalidURI ErrorCode = "InvalidURI"
 
+	// The XML you provided was not well-formed or did not validate against
+	// the published schema for an AccessControlPolicy.
+	ErrMalformedACLError ErrorCode = "MalformedACLError"
+
 	ErrMetadataTooLarge ErrorCode = "MetadataTooLarge"
 	ErrMethodNotAllowed ErrorCode = "MethodNotAllowed"
 	ErrMalformedXML     ErrorCode = "MalformedXML"
@@ -71,22 +128,41 @@ const (
 	// You must provide the Content-Length HTTP header.
 	ErrMissingContentLength ErrorCode = "MissingContentLength"
 
+	// Your request is missing a required header. See WithStrictHeaders.
+	ErrMissingSecurityHeader ErrorCode = "MissingSecurityHeader"
+
 	// See BucketNotFound() for a helper function for this error:
 	ErrNoSuchBucket ErrorCode = "NoSuchBucket"
 
 	// See KeyNotFound() for a helper function for this error:
 	ErrNoSuchKey ErrorCode = "NoSuchKey"
 
+	// The specified bucket does not have a bucket policy.
+	ErrNoSuchBucketPolicy ErrorCode = "NoSuchBucketPolicy"
+
+	// The specified bucket does not have a CORS configuration.
+	ErrNoSuchCORSConfiguration ErrorCode = "NoSuchCORSConfiguration"
+
 	// The specified multipart upload does not exist. The upload ID might be
 	// invalid, or the multipart upload might have been aborted or completed.
 	ErrNoSuchUpload ErrorCode = "NoSuchUpload"
 
 	ErrNoSuchVersion ErrorCode = "NoSuchVersion"
 
+	// At least one of the preconditions you specified did not hold.
+	ErrPreconditionFailed ErrorCode = "PreconditionFailed"
+
 	ErrRequestTimeTooSkewed ErrorCode = "RequestTimeTooSkewed"
 	ErrTooManyBuckets       ErrorCode = "TooManyBuckets"
 	ErrNotImplemented       ErrorCode = "NotImplemented"
 
+	// Reduce your request rate. Returned for every request while
+	// GoFakeS3.SetMaintenance is enabled.
+	ErrServiceUnavailable ErrorCode = "ServiceUnavailable"
+
+	// The body did not match the SHA256 in the x-amz-content-sha256 header.
+	ErrXAmzContentSHA256Mismatch ErrorCode = "XAmzContentSHA256Mismatch"
+
 	ErrInternal ErrorCode = "InternalError"
 )
 
@@ -216,10 +292,32 @@ func (e ErrorCode) Message() string {
 	switch e {
 	case ErrNoSuchBucket:
 		return "The specified bucket does not exist"
+	case ErrAccessDenied:
+		return "Access Denied"
+	case ErrNoSuchBucketPolicy:
+		return "The bucket policy does not exist"
+	case ErrNoSuchCORSConfiguration:
+		return "The CORS configuration does not exist"
+	case ErrBucketAlreadyOwnedByYou:
+		return "Your previous request to create the named bucket succeeded and you already own it."
+	case ErrBucketNotEmpty:
+		return "The bucket you tried to delete is not empty"
 	case ErrRequestTimeTooSkewed:
 		return "The difference between the request time and the current time is too large"
+	case ErrServiceUnavailable:
+		return "Reduce your request rate."
+	case ErrEntityTooLarge:
+		return "Your proposed upload exceeds the maximum allowed size"
+	case ErrPreconditionFailed:
+		return "At least one of the pre-conditions you specified did not hold"
 	case ErrMalformedXML:
 		return "The XML you provided was not well-formed or did not validate against our published schema"
+	case ErrXAmzContentSHA256Mismatch:
+		return "The provided 'x-amz-content-sha256' header does not match what was computed."
+	case ErrInvalidLocationConstraint:
+		return "The specified location-constraint is not valid"
+	case ErrInvalidDataSource:
+		return "Invalid data source type. Only CSV, JSON, and Parquet are supported at this time."
 	default:
 		return ""
 	}
@@ -228,36 +326,57 @@ func (e ErrorCode) Message() string {
 func (e ErrorCode) Status() int {
 	switch e {
 	case ErrBucketAlreadyExists,
+		ErrBucketAlreadyOwnedByYou,
 		ErrBucketNotEmpty:
 		return http.StatusConflict
 
 	case ErrBadDigest,
+		ErrBadRequest,
+		ErrEntityTooLarge,
+		ErrEntityTooSmall,
 		ErrIllegalVersioningConfiguration,
 		ErrIncompleteBody,
 		ErrIncorrectNumberOfFilesInPostRequest,
 		ErrInlineDataTooLarge,
 		ErrInvalidArgument,
 		ErrInvalidBucketName,
+		ErrInvalidCompressionFormat,
+		ErrInvalidDataSource,
 		ErrInvalidDigest,
+		ErrInvalidExpressionType,
+		ErrInvalidLocationConstraint,
 		ErrInvalidPart,
 		ErrInvalidPartOrder,
+		ErrInvalidRequest,
+		ErrInvalidTag,
 		ErrInvalidToken,
 		ErrInvalidURI,
 		ErrKeyTooLong,
 		ErrMetadataTooLarge,
 		ErrMethodNotAllowed,
+		ErrMalformedACLError,
 		ErrMalformedPOSTRequest,
+		ErrMalformedPolicy,
 		ErrMalformedXML,
-		ErrTooManyBuckets:
+		ErrMissingSecurityHeader,
+		ErrTooManyBuckets,
+		ErrXAmzContentSHA256Mismatch:
 		return http.StatusBadRequest
 
-	case ErrRequestTimeTooSkewed:
+	case ErrAccessDenied,
+		ErrRequestTimeTooSkewed:
 		return http.StatusForbidden
 
-	case ErrInvalidRange:
+	case ErrInvalidRange,
+		ErrInvalidPartNumber:
 		return http.StatusRequestedRangeNotSatisfiable
 
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed
+
 	case ErrNoSuchBucket,
+		ErrNoSuchBucketPolicy,
+		ErrNoSuchCORSConfiguration,
 		ErrNoSuchKey,
 		ErrNoSuchUpload,
 		ErrNoSuchVersion:
@@ -266,6 +385,9 @@ func (e ErrorCode) Status() int {
 	case ErrNotImplemented:
 		return http.StatusNotImplemented
 
+	case ErrServiceUnavailable:
+		return http.StatusServiceUnavailable
+
 	case ErrMissingContentLength:
 		return http.StatusLengthRequired
 
@@ -298,7 +420,8 @@ func HasErrorCode(err error, code ErrorCode) bool {
 // IsAlreadyExists asserts that the error is a kind that indicates the resource
 // already exists, similar to os.IsExist.
 func IsAlreadyExists(err error) bool {
-	return HasErrorCode(err, ErrBucketAlreadyExists)
+	return HasErrorCode(err, ErrBucketAlreadyExists) ||
+		HasErrorCode(err, ErrBucketAlreadyOwnedByYou)
 }
 
 type resourceErrorResponse struct {
@@ -329,11 +452,37 @@ var _ errorResponse = &requestTimeTooSkewedResponse{}
 func requestTimeTooSkewed(at time.Time, max time.Duration) error {
 	code := ErrRequestTimeTooSkewed
 	return &requestTimeTooSkewedResponse{
+		// S3 reports the ServerTime in UTC, to the second:
+		ErrorResponse{Code: code, Message: code.Message()},
+		at.UTC().Truncate(time.Second), durationAsMilliseconds(max),
+	}
+}
+
+type entityTooLargeResponse struct {
+	ErrorResponse
+	ProposedSize   int64 `xml:",omitempty"`
+	MaxSizeAllowed int64
+}
+
+var _ errorResponse = &entityTooLargeResponse{}
+
+// entityTooLarge reports an object that is larger than max. If the size was
+// not known in advance, proposed should be 0.
+func entityTooLarge(proposed, max int64) error {
+	code := ErrEntityTooLarge
+	return &entityTooLargeResponse{
 		ErrorResponse{Code: code, Message: code.Message()},
-		at, durationAsMilliseconds(max),
+		proposed, max,
 	}
 }
 
+// invalidPartNumber reports a 'partNumber' that is not an integer between 1
+// and max.
+func invalidPartNumber(partNumber string, max int) error {
+	return ErrorInvalidArgument("partNumber", partNumber,
+		fmt.Sprintf("Part number must be an integer between 1 and %d, inclusive", max))
+}
+
 // durationAsMilliseconds tricks xml.Marsha into serialising a time.Duration as
 // truncated milliseconds instead of nanoseconds.
 type durationAsMilliseconds time.Duration
@@ -0,0 +1,104 @@
+package gofakes3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"io"
+)
+
+// eventStreamWriter encodes messages in the 'application/vnd.amazon.eventstream'
+// format used by SelectObjectContent responses:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTSelectObjectAppendix.html
+//
+// Each message is framed as follows, with all integers big-endian:
+//
+//	total length  (4 bytes, including the prelude and the message CRC)
+//	headers length (4 bytes)
+//	prelude CRC   (4 bytes, CRC32 of the preceding 8 bytes)
+//	headers
+//	payload
+//	message CRC   (4 bytes, CRC32 of everything preceding it)
+type eventStreamWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// eventStreamHeader is a header with a string value, which is the only type
+// S3 sends in a SelectObjectContent response.
+type eventStreamHeader struct {
+	name  string
+	value string
+}
+
+// eventStreamStringType is the header value type for strings.
+const eventStreamStringType = 7
+
+func newEventStreamWriter(w io.Writer) *eventStreamWriter {
+	return &eventStreamWriter{w: w}
+}
+
+func (es *eventStreamWriter) writeMessage(headers []eventStreamHeader, payload []byte) error {
+	var hdrs bytes.Buffer
+	for _, h := range headers {
+		hdrs.WriteByte(byte(len(h.name)))
+		hdrs.WriteString(h.name)
+		hdrs.WriteByte(eventStreamStringType)
+		binary.Write(&hdrs, binary.BigEndian, uint16(len(h.value)))
+		hdrs.WriteString(h.value)
+	}
+
+	const preludeLen, crcLen = 12, 4
+	total := preludeLen + hdrs.Len() + len(payload) + crcLen
+
+	es.buf.Reset()
+	binary.Write(&es.buf, binary.BigEndian, uint32(total))
+	binary.Write(&es.buf, binary.BigEndian, uint32(hdrs.Len()))
+	binary.Write(&es.buf, binary.BigEndian, crc32.ChecksumIEEE(es.buf.Bytes()))
+	es.buf.Write(hdrs.Bytes())
+	es.buf.Write(payload)
+	binary.Write(&es.buf, binary.BigEndian, crc32.ChecksumIEEE(es.buf.Bytes()))
+
+	_, err := es.w.Write(es.buf.Bytes())
+	return err
+}
+
+func (es *eventStreamWriter) event(eventType, contentType string, payload []byte) error {
+	headers := []eventStreamHeader{
+		{":message-type", "event"},
+		{":event-type", eventType},
+	}
+	if contentType != "" {
+		headers = append(headers, eventStreamHeader{":content-type", contentType})
+	}
+	return es.writeMessage(headers, payload)
+}
+
+// Records sends a chunk of the query results. Chunks need not align with
+// records.
+func (es *eventStreamWriter) Records(payload []byte) error {
+	return es.event("Records", "application/octet-stream", payload)
+}
+
+func (es *eventStreamWriter) Stats(stats SelectStats) error {
+	payload, err := xml.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	return es.event("Stats", "text/xml", payload)
+}
+
+func (es *eventStreamWriter) End() error {
+	return es.event("End", "", nil)
+}
+
+// Error reports a failure that happens after the response has started, when
+// it is too late to send an error status.
+func (es *eventStreamWriter) Error(code ErrorCode, message string) error {
+	return es.writeMessage([]eventStreamHeader{
+		{":message-type", "error"},
+		{":error-code", string(code)},
+		{":error-message", message},
+	}, nil)
+}
@@ -2,16 +2,21 @@ package gofakes3
 
 import (
 	"bytes"
+	"crypto/md5"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"math"
+	"net"
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -23,18 +28,52 @@ import (
 //
 // Logic is delegated to other components, like Backend or uploader.
 type GoFakeS3 struct {
-	storage   Backend
-	versioned VersionedBackend
+	storage      Backend
+	versioned    VersionedBackend
+	partsBackend ObjectPartsBackend
 
 	timeSource              TimeSource
 	timeSkew                time.Duration
 	metadataSizeLimit       int
-	integrityCheck          bool
+	metadataEntryLimit      int
+	metadataValueSizeLimit  int
+	integrityCheck          int32 // Accessed atomically; see SetIntegrityCheck
 	failOnUnimplementedPage bool
 	hostBucket              bool
+	hostBucketBase          string
+	strictHeaders           bool
+	strictMode              bool
+	strictLimits            bool
+	responseChecksums       bool
+	responseCompression     bool
+	policyEnforcement       bool
+	readOnly                bool
+	preserveMetadataCase    bool
+	keyTransform            *keyTransform
+	latencyProfile          *LatencyProfile
+	latency                 *latencyInjector
+	owner                   UserInfo
+	region                  string
+	mfaSerial               string
+	mfaToken                func() string
+	credentials             map[string]string
+	selector                Selector
+	maxObjectSize           int64
+	maxBuckets              int
+	autoBucket              bool
+	xmlns                   string
+	serverHeader            string
+	compactXML              bool
+	eventSink               func(ev Event)
+	serverTimeouts          ServerTimeouts
 	uploader                *uploader
+	subresources            *subresourceStore
 	requestID               uint64
+	requestIDGenerator      func() string
+	maintenance             int32 // Accessed atomically; see SetMaintenance
+	routes                  *http.ServeMux
 	log                     Logger
+	logLevel                LogLevel
 }
 
 // New creates a new GoFakeS3 using the supplied Backend. Backends are pluggable.
@@ -45,23 +84,48 @@ func New(backend Backend, options ...Option) *GoFakeS3 {
 		storage:           backend,
 		timeSkew:          DefaultSkewLimit,
 		metadataSizeLimit: DefaultMetadataSizeLimit,
-		integrityCheck:    true,
+		integrityCheck:    1,
+		owner:             defaultOwner,
 		uploader:          newUploader(),
+		subresources:      newSubresourceStore(),
 		requestID:         0,
+		xmlns:             XMLNamespace,
+		serverHeader:      DefaultServerHeader,
 	}
 
 	// versioned MUST be set before options as one of the options disables it:
 	s3.versioned, _ = backend.(VersionedBackend)
+	s3.partsBackend, _ = backend.(ObjectPartsBackend)
 
 	for _, opt := range options {
 		opt(s3)
 	}
 	if s3.log == nil {
 		s3.log = DiscardLog()
+	} else if s3.logLevel != "" {
+		// Applied here so WithLogLevel works whether it comes before or
+		// after WithLogger:
+		s3.log = LevelLog(s3.log, s3.logLevel)
 	}
 	if s3.timeSource == nil {
 		s3.timeSource = DefaultTimeSource()
 	}
+	if s3.keyTransform != nil {
+		// This must be done after the options have been applied, as
+		// WithoutVersioning may have removed the VersionedBackend:
+		s3.storage = &keyTransformBackend{Backend: s3.storage, kt: s3.keyTransform}
+		if s3.versioned != nil {
+			s3.versioned = &keyTransformVersionedBackend{VersionedBackend: s3.versioned, kt: s3.keyTransform}
+		}
+		if s3.partsBackend != nil {
+			s3.partsBackend = &keyTransformPartsBackend{ObjectPartsBackend: s3.partsBackend, kt: s3.keyTransform}
+		}
+	}
+	if s3.latencyProfile != nil {
+		// The injector may be seeded by the time source, so this must be
+		// done after the options have been applied:
+		s3.latency = newLatencyInjector(*s3.latencyProfile, s3.timeSource)
+	}
 
 	return s3
 }
@@ -70,21 +134,202 @@ func (g *GoFakeS3) nextRequestID() uint64 {
 	return atomic.AddUint64(&g.requestID, 1)
 }
 
+// newRequestID returns the ID for the "x-amz-request-id" header of a new
+// request. See WithRequestID and WithRequestIDGenerator.
+func (g *GoFakeS3) newRequestID() string {
+	if g.requestIDGenerator != nil {
+		return g.requestIDGenerator()
+	}
+	return fmt.Sprintf("%016X", g.nextRequestID())
+}
+
+// SetMaintenance toggles a simulated service outage. While enabled, every
+// request to the Server() handler fails with a '503 ServiceUnavailable'
+// response and a Retry-After header of MaintenanceRetryAfter. It is safe to
+// call from multiple goroutines while the server is handling requests.
+func (g *GoFakeS3) SetMaintenance(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&g.maintenance, v)
+}
+
+func (g *GoFakeS3) inMaintenance() bool {
+	return atomic.LoadInt32(&g.maintenance) != 0
+}
+
+// SetIntegrityCheck enables or disables Content-MD5 and x-amz-content-sha256
+// validation on a running server, so the mismatch path can be tested for one
+// request without affecting the others. It has the same effect as
+// WithIntegrityCheck, and is safe to call from multiple goroutines while the
+// server is handling requests. Requests already in progress are not affected.
+func (g *GoFakeS3) SetIntegrityCheck(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&g.integrityCheck, v)
+}
+
+func (g *GoFakeS3) integrityCheckEnabled() bool {
+	return atomic.LoadInt32(&g.integrityCheck) != 0
+}
+
+// Close aborts any multipart uploads that are still in progress, discarding
+// their parts, then closes the Backend if it implements io.Closer. Backends
+// that hold resources like open files or database handles should implement
+// io.Closer so they can be released here.
+//
+// Close does not stop the http.Handler returned by Server(); shut down the
+// http.Server first. The GoFakeS3 should not be used after it is closed.
+func (g *GoFakeS3) Close() error {
+	g.uploader.AbortAll()
+
+	if closer, ok := g.storage.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Stats is a snapshot of what a GoFakeS3 is storing, returned by
+// GoFakeS3.Stats.
+type Stats struct {
+	Buckets int
+
+	// Objects and Bytes only include the current version of each object;
+	// noncurrent versions and delete markers are not counted.
+	Objects int
+	Bytes   int64
+
+	// Multipart uploads that have been created, but not yet completed or
+	// aborted.
+	Uploads int
+}
+
+// Stats counts the buckets and objects in the Backend, and the multipart
+// uploads in progress. Every bucket is listed in full, so this is not
+// intended for use on large Backends.
+//
+// The counts are not taken atomically, so they may not add up if the
+// GoFakeS3 is in use at the same time.
+func (g *GoFakeS3) Stats() (stats Stats, err error) {
+	buckets, err := g.storage.ListBuckets()
+	if err != nil {
+		return stats, err
+	}
+	stats.Buckets = len(buckets)
+
+	for _, bucket := range buckets {
+		objects, err := g.storage.ListBucket(bucket.Name, &Prefix{}, ListBucketPage{})
+		if err != nil {
+			return stats, err
+		}
+		stats.Objects += len(objects.Contents)
+		for _, item := range objects.Contents {
+			stats.Bytes += item.Size
+		}
+	}
+
+	stats.Uploads = g.uploader.Count()
+	return stats, nil
+}
+
 // Create the AWS S3 API
 func (g *GoFakeS3) Server() http.Handler {
-	var handler http.Handler = &withCORS{r: http.HandlerFunc(g.routeBase), log: g.log}
+	var handler http.Handler = http.HandlerFunc(g.routeBase)
+
+	if g.policyEnforcement {
+		// This must be inside withCORS so preflight requests, which never
+		// carry credentials, are not denied:
+		handler = g.policyMiddleware(handler)
+	}
+
+	if g.readOnly {
+		// Like policyMiddleware, this is inside withCORS so preflight
+		// requests for mutating operations still succeed:
+		handler = g.readOnlyMiddleware(handler)
+	}
+
+	if g.latency != nil {
+		handler = g.latencyMiddleware(handler)
+	}
+
+	handler = &withCORS{r: handler, log: g.log, rules: g.corsRules, httpError: g.httpError}
 
 	if g.timeSkew != 0 {
 		handler = g.timeSkewMiddleware(handler)
 	}
 
+	if g.strictHeaders {
+		handler = g.strictHeadersMiddleware(handler)
+	}
+
+	if g.strictMode {
+		handler = g.strictModeMiddleware(handler)
+	}
+
+	if g.responseCompression {
+		// This must be inside hostBucketMiddleware, which rewrites the URL
+		// that the operation is worked out from:
+		handler = g.compressionMiddleware(handler)
+	}
+
 	if g.hostBucket {
 		handler = g.hostBucketMiddleware(handler)
 	}
 
+	// Maintenance can be toggled at any time, so this middleware is always
+	// installed. It must be the outermost S3 handler so nothing else responds
+	// while the server is "down":
+	handler = g.maintenanceMiddleware(handler)
+
+	if g.serverHeader != "" {
+		// Outside maintenance, so every S3 response carries the header,
+		// including errors from the middleware:
+		handler = g.serverHeaderMiddleware(handler)
+	}
+
+	if g.routes != nil {
+		// Routes added using WithRoute are outside maintenance, so an admin
+		// endpoint can still be used to bring the server back up:
+		handler = g.routesMiddleware(handler)
+	}
+
 	return handler
 }
 
+// routesMiddleware serves requests that match a route added using WithRoute,
+// and passes everything else to the S3 handler.
+func (g *GoFakeS3) routesMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if _, pattern := g.routes.Handler(rq); pattern != "" {
+			g.routes.ServeHTTP(w, rq)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+// serverHeaderMiddleware sets the Server header. See WithServerHeader.
+func (g *GoFakeS3) serverHeaderMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		w.Header().Set("Server", g.serverHeader)
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+func (g *GoFakeS3) maintenanceMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if g.inMaintenance() {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", MaintenanceRetryAfter/time.Second))
+			g.httpError(w, rq, ErrServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
 func (g *GoFakeS3) timeSkewMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
 		timeHdr := rq.Header.Get("x-amz-date")
@@ -104,12 +349,153 @@ func (g *GoFakeS3) timeSkewMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
-// hostBucketMiddleware forces the server to use VirtualHost-style bucket URLs:
-// https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html
+// strictHeadersMiddleware rejects requests that are missing headers S3 would
+// require before it even attempts to verify the request's signature. The
+// signature itself is not checked.
+func (g *GoFakeS3) strictHeadersMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if err := validateRequiredHeaders(rq); err != nil {
+			g.httpError(w, rq, err)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+// strictModeMiddleware rejects requests with query string parameters their
+// operation does not accept. See WithStrictMode.
+func (g *GoFakeS3) strictModeMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if err := checkQueryParams(rq); err != nil {
+			g.httpError(w, rq, err)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+// policyMiddleware denies requests sent without credentials unless the bucket
+// policy allows them. See WithPolicyEnforcement.
+func (g *GoFakeS3) policyMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if isAnonymousRequest(rq) && !g.policyAllowsAnonymous(rq) {
+			g.httpError(w, rq, ErrAccessDenied)
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+// readOnlyMiddleware denies every request that is not for a read operation.
+// See WithReadOnly.
+func (g *GoFakeS3) readOnlyMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		op := requestOperation(rq)
+		if op == "" && rq.Method != "GET" && rq.Method != "HEAD" {
+			g.httpError(w, rq, ErrorMessage(ErrAccessDenied, "This server is read-only"))
+			return
+		} else if op != "" && !op.IsRead() {
+			g.httpError(w, rq, ErrorMessagef(ErrAccessDenied, "This server is read-only; %s is not allowed", op))
+			return
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
+
+// clampMode returns how limits like 'max-keys' are handled when they are out
+// of range. See WithStrictLimits.
+func (g *GoFakeS3) clampMode() clampMode {
+	if g.strictLimits {
+		return rejectOutOfRange
+	}
+	return clampToRange
+}
+
+// checkMFA returns ErrAccessDenied unless the request carries an 'x-amz-mfa'
+// header made of the serial number and the current token, separated by a
+// space. See WithMFA.
+func (g *GoFakeS3) checkMFA(rq *http.Request) error {
+	mfa := rq.Header.Get("x-amz-mfa")
+	if mfa == "" {
+		return ErrorMessage(ErrAccessDenied, "Mfa Authentication must be used for this request")
+	}
+	fields := strings.Fields(mfa)
+	if len(fields) != 2 || fields[0] != g.mfaSerial || fields[1] != g.mfaToken() {
+		return ErrorMessage(ErrAccessDenied, "The x-amz-mfa header is invalid")
+	}
+	return nil
+}
+
+// checkExpectedBucketOwner returns ErrAccessDenied if the request carries an
+// 'x-amz-expected-bucket-owner' (or, for copies, an
+// 'x-amz-source-expected-bucket-owner') header that does not match the owner
+// ID. GoFakeS3 has only one owner, so every bucket belongs to it. See
+// WithOwner.
+func (g *GoFakeS3) checkExpectedBucketOwner(rq *http.Request) error {
+	for _, hdr := range []string{"x-amz-expected-bucket-owner", "x-amz-source-expected-bucket-owner"} {
+		if _, ok := rq.Header[textproto.CanonicalMIMEHeaderKey(hdr)]; !ok {
+			continue
+		}
+		if rq.Header.Get(hdr) != g.owner.ID {
+			return ErrAccessDenied
+		}
+	}
+	return nil
+}
+
+func (g *GoFakeS3) policyAllowsAnonymous(rq *http.Request) bool {
+	bucket, object := splitBucketObject(rq.URL.Path)
+	if bucket == "" {
+		return false
+	}
+
+	action, resource := policyAction(bucket, object, rq)
+	if action == "" || !g.bucketPolicyAllowsAnonymous(bucket, action, resource) {
+		return false
+	}
+
+	// A copy reads the source object, so the source bucket's policy must
+	// allow that too, otherwise a private object could be copied somewhere
+	// public:
+	if hdr := rq.Header.Get("x-amz-copy-source"); hdr != "" {
+		src, err := parseCopySource(hdr)
+		if err != nil {
+			return false
+		}
+		action := "s3:GetObject"
+		if src.versionID != "" {
+			action = "s3:GetObjectVersion"
+		}
+		return g.bucketPolicyAllowsAnonymous(src.bucket, action, "arn:aws:s3:::"+src.bucket+"/"+src.object)
+	}
+	return true
+}
+
+func (g *GoFakeS3) bucketPolicyAllowsAnonymous(bucket, action, resource string) bool {
+	raw := g.subresources.BucketPolicy(bucket)
+	if raw == nil {
+		return false
+	}
+	policy, err := parseBucketPolicy(raw)
+	if err != nil {
+		g.log.Print(LogWarn, "could not evaluate policy for bucket", bucket, err)
+		return false
+	}
+	return policy.allowsAnonymous(action, resource)
+}
+
+// hostBucketMiddleware allows the server to accept VirtualHost-style bucket
+// URLs: https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html
+//
+// Requests whose Host does not encode a bucket, like 'localhost:9000' or an
+// IP address, are left alone and routed as path-style requests.
 func (g *GoFakeS3) hostBucketMiddleware(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
-		parts := strings.SplitN(rq.Host, ".", 2)
-		bucket := parts[0]
+		bucket, ok := g.hostBucketName(rq.Host)
+		if !ok {
+			handler.ServeHTTP(w, rq)
+			return
+		}
 
 		p := rq.URL.Path
 		rq.URL.Path = "/" + bucket
@@ -122,22 +508,70 @@ func (g *GoFakeS3) hostBucketMiddleware(handler http.Handler) http.Handler {
 	})
 }
 
+// hostBucketName returns the bucket encoded in host, if there is one.
+//
+// If a base domain was configured using WithHostBucketBase, the bucket is
+// everything to the left of it, so bucket names containing dots are
+// supported. Otherwise, it is the first label of host; a host with only one
+// label, like 'localhost', or an IP address does not encode a bucket.
+func (g *GoFakeS3) hostBucketName(host string) (bucket string, ok bool) {
+	host = stripHostPort(host)
+	if net.ParseIP(host) != nil {
+		return "", false
+	}
+
+	if g.hostBucketBase != "" {
+		host = strings.TrimSuffix(strings.ToLower(host), ".")
+		bucket = strings.TrimSuffix(host, "."+g.hostBucketBase)
+		if bucket == host || bucket == "" {
+			return "", false
+		}
+		return bucket, true
+	}
+
+	parts := strings.SplitN(host, ".", 2)
+	if len(parts) < 2 || parts[0] == "" {
+		return "", false
+	}
+	return parts[0], true
+}
+
+// stripHostPort removes the port, if present, from a Host header.
+func stripHostPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
 func (g *GoFakeS3) httpError(w http.ResponseWriter, r *http.Request, err error) {
-	resp := ensureErrorResponse(err, "") // FIXME: request id
+	resp := ensureErrorResponse(err, w.Header().Get("x-amz-request-id"))
 	if resp.ErrorCode() == ErrInternal {
 		g.log.Print(LogErr, err)
 	}
 
-	w.WriteHeader(resp.ErrorCode().Status())
+	if r.Method == http.MethodHead {
+		// HEAD responses never have a body, even when they fail:
+		w.Header().Set("Content-Length", "0")
+		w.WriteHeader(resp.ErrorCode().Status())
+		return
+	}
 
-	if r.Method != http.MethodHead {
-		if err := g.xmlEncoder(w).Encode(resp); err != nil {
-			g.log.Print(LogErr, err)
-			return
-		}
+	// The body is buffered by xmlResponseStatus so that the response has a
+	// Content-Length; some clients and proxies can't handle chunked errors:
+	if err := g.xmlResponseStatus(w, resp.ErrorCode().Status(), resp); err != nil {
+		g.log.Print(LogErr, err)
 	}
 }
 
+// ownerInfo returns a copy of the owner configured with WithOwner, suitable
+// for attaching to a response. All buckets, objects and uploads in GoFakeS3
+// belong to this owner.
+func (g *GoFakeS3) ownerInfo() *UserInfo {
+	owner := g.owner
+	return &owner
+}
+
 func (g *GoFakeS3) listBuckets(w http.ResponseWriter, r *http.Request) error {
 	buckets, err := g.storage.ListBuckets()
 	if err != nil {
@@ -145,15 +579,12 @@ func (g *GoFakeS3) listBuckets(w http.ResponseWriter, r *http.Request) error {
 	}
 
 	s := &Storage{
-		Xmlns:   "http://s3.amazonaws.com/doc/2006-03-01/",
+		Xmlns:   g.xmlns,
 		Buckets: buckets,
-		Owner: &UserInfo{
-			ID:          "fe7272ea58be830e56fe1663b10fafef",
-			DisplayName: "GoFakeS3",
-		},
+		Owner:   g.ownerInfo(),
 	}
 
-	return g.xmlEncoder(w).Encode(s)
+	return g.xmlResponse(w, s)
 }
 
 // S3 has two versions of this API, both of which are close to identical. We manage that
@@ -173,7 +604,11 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 
 	q := r.URL.Query()
 	prefix := prefixFromQuery(q)
-	page, err := listBucketPageFromQuery(q)
+	page, err := listBucketPageFromQuery(q, g.clampMode())
+	if err != nil {
+		return err
+	}
+	encodingType, err := listEncodingTypeFromQuery(q)
 	if err != nil {
 		return err
 	}
@@ -184,19 +619,28 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 	g.log.Print(LogInfo, "prefix    :", prefix)
 	g.log.Print(LogInfo, "page      :", fmt.Sprintf("%+v", page))
 
+	// 'max-keys=0' asks for no keys at all, but a Backend treats a MaxKeys
+	// of 0 as "no limit". A page of one key is enough to tell whether the
+	// listing would have been truncated:
+	probe := page.MaxKeys == 0
+	if probe {
+		page.MaxKeys = 1
+	}
+
 	objects, err := g.storage.ListBucket(bucketName, &prefix, page)
 
 	if err != nil {
 		if err == ErrInternalPageNotImplemented && !g.failOnUnimplementedPage {
-			// We have observed (though not yet confirmed) that simple clients
-			// tend to work fine if you simply ignore pagination, so the
-			// default if this is not implemented is to retry without it. If
-			// you care about this performance impact for some weird reason,
-			// you'll need to handle it yourself.
+			// The default if the backend does not implement pagination is
+			// to retry without it, and cut the page out of the full listing
+			// here. This reads the whole bucket for every page; if you care
+			// about this performance impact, implement paging in your
+			// Backend.
 			objects, err = g.storage.ListBucket(bucketName, &prefix, ListBucketPage{})
 			if err != nil {
 				return err
 			}
+			objects = objects.applyPage(page)
 
 		} else if err == ErrInternalPageNotImplemented && g.failOnUnimplementedPage {
 			return ErrNotImplemented
@@ -205,8 +649,19 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 		}
 	}
 
+	if probe {
+		objects = &ObjectList{IsTruncated: len(objects.Contents)+len(objects.CommonPrefixes) > 0}
+		page.MaxKeys = 0
+	}
+
+	// Every object has the same owner, so they can share the same copy:
+	owner := g.ownerInfo()
+	for _, v := range objects.Contents {
+		v.Owner = owner
+	}
+
 	base := ListBucketResultBase{
-		Xmlns:          "http://s3.amazonaws.com/doc/2006-03-01/",
+		Xmlns:          g.xmlns,
 		Name:           bucketName,
 		CommonPrefixes: objects.CommonPrefixes,
 		Contents:       objects.Contents,
@@ -216,6 +671,23 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 		MaxKeys:        page.MaxKeys,
 	}
 
+	if encodingType == "url" {
+		// The Contents are copied so the backend's objects aren't modified:
+		base.EncodingType = encodingType
+		base.Delimiter = listURLEncode(base.Delimiter)
+		base.Prefix = listURLEncode(base.Prefix)
+		base.CommonPrefixes = make([]CommonPrefix, len(objects.CommonPrefixes))
+		for i, cp := range objects.CommonPrefixes {
+			base.CommonPrefixes[i] = CommonPrefix{Prefix: listURLEncode(cp.Prefix)}
+		}
+		base.Contents = make([]*Content, len(objects.Contents))
+		for i, v := range objects.Contents {
+			c := *v
+			c.Key = listURLEncode(c.Key)
+			base.Contents[i] = &c
+		}
+	}
+
 	if !isVersion2 {
 		var result = &ListBucketResult{
 			ListBucketResultBase: base,
@@ -227,7 +699,11 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 			// into GoFakeS3 to spare backend implementers the trouble.
 			result.NextMarker = objects.NextMarker
 		}
-		return g.xmlEncoder(w).Encode(result)
+		if encodingType == "url" {
+			result.Marker = listURLEncode(result.Marker)
+			result.NextMarker = listURLEncode(result.NextMarker)
+		}
+		return g.xmlStreamResponse(w, result)
 
 	} else {
 		var result = &ListBucketResultV2{
@@ -236,6 +712,9 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 			StartAfter:           q.Get("start-after"),
 			ContinuationToken:    q.Get("continuation-token"),
 		}
+		if encodingType == "url" {
+			result.StartAfter = listURLEncode(result.StartAfter)
+		}
 		if objects.NextMarker != "" {
 			// We are just cheating with these continuation tokens; they're just the NextMarker
 			// from v1 in disguise! That may change at any time and should not be relied upon
@@ -243,26 +722,28 @@ func (g *GoFakeS3) listBucket(bucketName string, w http.ResponseWriter, r *http.
 			result.NextContinuationToken = base64.URLEncoding.EncodeToString([]byte(objects.NextMarker))
 		}
 
-		// On the topic of "fetch-owner", the AWS docs say, in typically vague style:
-		// "If you want the owner information in the response, you can specify
-		// this parameter with the value set to true."
-		//
-		// What does the bare word 'true' mean when we're talking about a query
-		// string parameter, which can only be a string? Does it mean the word
-		// 'true'? Does it mean 'any truthy string'? Does it mean only the key
-		// needs to be present (i.e. '?fetch-owner'), which we are assuming
-		// for now? This is why you need proper technical writers.
-		//
-		// Probably need to hit up the s3assumer at some point, but until then, here's
-		// another FIXME!
-		if _, ok := q["fetch-owner"]; !ok {
+		// "The owner field is not present in ListObjectsV2 by default. If you
+		// want to return the owner field with each key in the result, then
+		// set the FetchOwner field to true." The SDKs send 'fetch-owner=true'
+		// or 'fetch-owner=false'; a bare '?fetch-owner' is also accepted.
+		if !fetchOwnerFromQuery(q) {
 			for _, v := range result.Contents {
 				v.Owner = nil
 			}
 		}
 
-		return g.xmlEncoder(w).Encode(result)
+		return g.xmlStreamResponse(w, result)
+	}
+}
+
+// fetchOwnerFromQuery reports whether a ListObjectsV2 request asked for the
+// owner of each object with the 'fetch-owner' parameter.
+func fetchOwnerFromQuery(q url.Values) bool {
+	vals, ok := q["fetch-owner"]
+	if !ok {
+		return false
 	}
+	return vals[0] == "" || strings.EqualFold(vals[0], "true")
 }
 
 func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter, r *http.Request) error {
@@ -272,7 +753,7 @@ func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter,
 
 	q := r.URL.Query()
 	prefix := prefixFromQuery(q)
-	page, err := listBucketVersionsPageFromQuery(q)
+	page, err := listBucketVersionsPageFromQuery(q, g.clampMode())
 	if err != nil {
 		return err
 	}
@@ -297,6 +778,8 @@ func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter,
 	}
 
 	for _, ver := range bucket.Versions {
+		ver.setOwner(g.ownerInfo())
+
 		// S300005: S3 returns the _string_ 'null' for the version ID if the
 		// bucket has never had versioning enabled. GoFakeS3 backend
 		// implementers should be able to simply return the empty string;
@@ -307,7 +790,11 @@ func (g *GoFakeS3) listBucketVersions(bucketName string, w http.ResponseWriter,
 		}
 	}
 
-	return g.xmlEncoder(w).Encode(bucket)
+	// Backends create the result with NewListBucketVersionsResult, which
+	// can't know about WithXMLNamespace:
+	bucket.Xmlns = g.xmlns
+
+	return g.xmlStreamResponse(w, bucket)
 }
 
 // CreateBucket creates a new S3 bucket in the BoltDB storage.
@@ -317,26 +804,162 @@ func (g *GoFakeS3) createBucket(bucket string, w http.ResponseWriter, r *http.Re
 	if err := ValidateBucketName(bucket); err != nil {
 		return err
 	}
+	acl, err := g.cannedACLFromHeader(r)
+	if err != nil {
+		return err
+	}
+	location, err := g.locationConstraintFromBody(r)
+	if err != nil {
+		return err
+	}
+	if err := g.checkMaxBuckets(bucket); err != nil {
+		return err
+	}
 	if err := g.storage.CreateBucket(bucket); err != nil {
+		// Every bucket in GoFakeS3 belongs to the same owner, so if the
+		// bucket exists, it must be owned by whoever is trying to create it.
+		// Real S3 returns a 200 instead of this error in us-east-1, but
+		// GoFakeS3 does not (yet) distinguish between regions.
+		if HasErrorCode(err, ErrBucketAlreadyExists) {
+			return ResourceError(ErrBucketAlreadyOwnedByYou, bucket)
+		}
 		return err
 	}
+	if acl != nil {
+		g.subresources.SetBucketACL(bucket, acl)
+	}
+	g.subresources.SetBucketLocation(bucket, location)
 
 	w.Header().Set("Location", "/"+bucket)
-	w.Write([]byte{})
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+// locationConstraintFromBody returns the region given in the
+// CreateBucketConfiguration body of a CreateBucket request, or the region set
+// with WithRegion if the body is empty. If a region was set, any other region
+// is rejected with ErrInvalidLocationConstraint.
+func (g *GoFakeS3) locationConstraintFromBody(r *http.Request) (string, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return g.region, nil
+	}
+
+	var in CreateBucketConfiguration
+	if err := xml.Unmarshal(body, &in); err != nil {
+		return "", ErrorMessage(ErrMalformedXML, err.Error())
+	}
+	if in.LocationConstraint == "" {
+		return g.region, nil
+	}
+	if g.region != "" && in.LocationConstraint != g.region {
+		return "", ErrorMessagef(ErrInvalidLocationConstraint,
+			"The %s location constraint is incompatible for the region specific endpoint this request was sent to.", in.LocationConstraint)
+	}
+	return in.LocationConstraint, nil
+}
+
+// checkMaxBuckets fails with ErrTooManyBuckets if creating the bucket would
+// exceed the limit set by WithMaxBuckets. Recreating a bucket that already
+// exists does not count towards the limit, so that the backend can report
+// that instead.
+func (g *GoFakeS3) checkMaxBuckets(bucket string) error {
+	if g.maxBuckets <= 0 {
+		return nil
+	}
+
+	buckets, err := g.storage.ListBuckets()
+	if err != nil {
+		return err
+	}
+	if len(buckets) < g.maxBuckets {
+		return nil
+	}
+	for _, b := range buckets {
+		if b.Name == bucket {
+			return nil
+		}
+	}
+	return ErrorMessage(ErrTooManyBuckets, "You have attempted to create more buckets than allowed")
+}
+
+// autoCreateBucket creates the bucket if it does not exist and WithAutoBucket
+// is enabled.
+func (g *GoFakeS3) autoCreateBucket(bucket string) error {
+	if !g.autoBucket {
+		return nil
+	}
+	exists, err := g.storage.BucketExists(bucket)
+	if err != nil || exists {
+		return err
+	}
+
+	g.log.Print(LogInfo, "AUTO CREATE BUCKET:", bucket)
+	if err := ValidateBucketName(bucket); err != nil {
+		return err
+	}
+	if err := g.checkMaxBuckets(bucket); err != nil {
+		return err
+	}
+	if err := g.storage.CreateBucket(bucket); err != nil && !IsAlreadyExists(err) {
+		return err
+	}
 	return nil
 }
 
 // DeleteBucket deletes the bucket in the underlying backend, if and only if it
 // contains no items.
+//
+// As in S3, multipart uploads that are still in progress do not prevent the
+// bucket from being deleted; they are aborted along with it.
 func (g *GoFakeS3) deleteBucket(bucket string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "DELETE BUCKET:", bucket)
 	if err := g.storage.DeleteBucket(bucket); err != nil {
+		if HasErrorCode(err, ErrBucketNotEmpty) {
+			return g.bucketNotEmpty(bucket, err)
+		}
 		return err
 	}
-	w.WriteHeader(http.StatusNoContent)
+	g.subresources.RemoveBucket(bucket)
+	g.uploader.AbortBucket(bucket)
+	emptyResponse(w, http.StatusNoContent)
 	return nil
 }
 
+// bucketNotEmpty adds the number of objects left in the bucket, and a few of
+// their keys, to the ErrBucketNotEmpty returned by the backend, to make it
+// easier to find out what was left behind. If they can't be listed, err is
+// returned as-is.
+func (g *GoFakeS3) bucketNotEmpty(bucket string, err error) error {
+	const maxSampleKeys = 5
+
+	objects, lerr := g.storage.ListBucket(bucket, &Prefix{}, ListBucketPage{})
+	if lerr != nil {
+		g.log.Print(LogWarn, "could not list non-empty bucket:", bucket, lerr)
+		return err
+	}
+
+	msg := ErrBucketNotEmpty.Message()
+	if len(objects.Contents) == 0 {
+		// The backend may still hold versions or delete markers:
+		msg += " (only object versions or delete markers remain)"
+	} else {
+		keys := make([]string, 0, maxSampleKeys)
+		for _, item := range objects.Contents {
+			if len(keys) == maxSampleKeys {
+				break
+			}
+			keys = append(keys, fmt.Sprintf("%q", item.Key))
+		}
+		msg += fmt.Sprintf(" (%d object(s) remain, including %s)", len(objects.Contents), strings.Join(keys, ", "))
+	}
+
+	return &resourceErrorResponse{ErrorResponse{Code: ErrBucketNotEmpty, Message: msg}, bucket}
+}
+
 // HeadBucket checks whether a bucket exists.
 func (g *GoFakeS3) headBucket(bucket string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "HEAD BUCKET", bucket)
@@ -346,7 +969,7 @@ func (g *GoFakeS3) headBucket(bucket string, w http.ResponseWriter, r *http.Requ
 		return err
 	}
 
-	w.Write([]byte{})
+	emptyResponse(w, http.StatusOK)
 	return nil
 }
 
@@ -367,22 +990,32 @@ func (g *GoFakeS3) getObject(
 		return err
 	}
 
+	partsCount := 0
+	if _, ok := r.URL.Query()["partNumber"]; ok {
+		if rnge != nil {
+			return ErrorMessage(ErrInvalidRequest, "Cannot specify both Range header and partNumber query parameter")
+		}
+		if rnge, partsCount, err = g.partRange(bucket, object, versionID, r.URL.Query().Get("partNumber")); err != nil {
+			return err
+		}
+	}
+
 	var obj *Object
 
 	{ // get object from backend
 		if versionID == "" {
 			obj, err = g.storage.GetObject(bucket, object, rnge)
-			if err != nil {
-				return err
-			}
 		} else {
 			if g.versioned == nil {
 				return ErrNotImplemented
 			}
 			obj, err = g.versioned.GetObjectVersion(bucket, object, versionID, rnge)
-			if err != nil {
-				return err
-			}
+		}
+		if HasErrorCode(err, ErrInvalidRange) {
+			g.writeUnsatisfiableRange(bucket, object, versionID, w)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
@@ -392,9 +1025,17 @@ func (g *GoFakeS3) getObject(
 	}
 	defer obj.Contents.Close()
 
+	if obj.Range != nil && obj.Range.Length <= 0 {
+		w.Header().Set("Content-Range", obj.Range.contentRange(obj.Size))
+		return ErrInvalidRange
+	}
+
 	if err := g.writeGetOrHeadObjectResponse(obj, w, r); err != nil {
 		return err
 	}
+	if partsCount > 0 {
+		w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(partsCount))
+	}
 
 	// Writes Content-Length, and Content-Range if applicable:
 	obj.Range.writeHeader(obj.Size, w)
@@ -406,22 +1047,126 @@ func (g *GoFakeS3) getObject(
 	return nil
 }
 
-// writeGetOrHeadObjectResponse contains shared logic for constructing headers for
-// a HEAD and a GET request for a /bucket/object URL.
-func (g *GoFakeS3) writeGetOrHeadObjectResponse(obj *Object, w http.ResponseWriter, r *http.Request) error {
-	// "If the current version of the object is a delete marker, Amazon S3
-	// behaves as if the object was deleted and includes x-amz-delete-marker:
-	// true in the response."
-	if obj.IsDeleteMarker {
+// partRange returns the range of an object covered by the part requested with
+// the 'partNumber' parameter of GetObject or HeadObject, and the number of
+// parts in the object.
+//
+// Objects that were not created by CompleteMultipartUpload have a single
+// part, which is the whole object, so the range is nil and the count is 0.
+// Parts are only known for the current version of an object.
+func (g *GoFakeS3) partRange(bucket, object string, versionID VersionID, rawPartNumber string) (rnge *ObjectRangeRequest, partsCount int, err error) {
+	partNumber, err := strconv.ParseInt(rawPartNumber, 10, 0)
+	if err != nil || partNumber <= 0 || partNumber > int64(g.uploader.maxPartNumber) {
+		return nil, 0, invalidPartNumber(rawPartNumber, g.uploader.maxPartNumber)
+	}
+
+	manifest, err := g.objectParts(bucket, object, versionID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if manifest == nil {
+		if partNumber != 1 {
+			return nil, 0, ErrorMessage(ErrInvalidPartNumber, "The requested partnumber is not satisfiable")
+		}
+		return nil, 0, nil
+	}
+
+	// Parts are numbered from 1 in the order they make up the object, which
+	// is not necessarily the number they were uploaded with:
+	parts := manifest.Parts
+	if partNumber > int64(len(parts)) {
+		return nil, 0, ErrorMessage(ErrInvalidPartNumber, "The requested partnumber is not satisfiable")
+	}
+	var start int64
+	for _, part := range parts[:partNumber-1] {
+		start += part.Size
+	}
+	size := parts[partNumber-1].Size
+	return &ObjectRangeRequest{Start: start, End: start + size - 1}, len(parts), nil
+}
+
+// objectParts returns the parts manifest of an object, or of the given
+// version of it, or nil if it was not created by CompleteMultipartUpload.
+// The manifest is kept by the Backend if it implements ObjectPartsBackend;
+// otherwise, GoFakeS3 only knows the manifest of the current version.
+func (g *GoFakeS3) objectParts(bucket, object string, versionID VersionID) (*PartsManifest, error) {
+	if g.partsBackend != nil {
+		manifest, err := g.partsBackend.ObjectParts(bucket, object, versionID)
+		if HasErrorCode(err, ErrNoSuchKey) || HasErrorCode(err, ErrNoSuchVersion) {
+			// The object itself is looked up separately, which reports
+			// these errors more precisely:
+			return nil, nil
+		}
+		return manifest, err
+	}
+
+	manifest := g.subresources.ObjectParts(bucket, object)
+	if manifest != nil && versionID != "" {
+		current, err := g.storage.HeadObject(bucket, object)
+		if err != nil && !HasErrorCode(err, ErrNoSuchKey) {
+			return nil, err
+		}
+		if current == nil || current.VersionID != versionID {
+			manifest = nil
+		}
+		if current != nil {
+			current.Contents.Close()
+		}
+	}
+	return manifest, nil
+}
+
+// writeUnsatisfiableRange adds the 'Content-Range: bytes */<size>' header that
+// accompanies a 416 response, if the size of the object can be found.
+func (g *GoFakeS3) writeUnsatisfiableRange(bucket, object string, versionID VersionID, w http.ResponseWriter) {
+	var obj *Object
+	var err error
+	if versionID == "" {
+		obj, err = g.storage.HeadObject(bucket, object)
+	} else {
+		obj, err = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
+	if err != nil || obj == nil {
+		return
+	}
+	obj.Contents.Close()
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+}
+
+// writeGetOrHeadObjectResponse contains shared logic for constructing headers for
+// a HEAD and a GET request for a /bucket/object URL.
+func (g *GoFakeS3) writeGetOrHeadObjectResponse(obj *Object, w http.ResponseWriter, r *http.Request) error {
+	// "If the current version of the object is a delete marker, Amazon S3
+	// behaves as if the object was deleted and includes x-amz-delete-marker:
+	// true in the response."
+	if obj.IsDeleteMarker {
 		w.Header().Set("x-amz-version-id", string(obj.VersionID))
 		w.Header().Set("x-amz-delete-marker", "true")
 		return KeyNotFound(obj.Name)
 	}
 
 	for mk, mv := range obj.Metadata {
-		w.Header().Set(mk, mv)
+		if g.preserveMetadataCase && strings.HasPrefix(textproto.CanonicalMIMEHeaderKey(mk), "X-Amz-Meta-") {
+			// Assigning directly skips canonicalization; the name is
+			// written to the response as it is:
+			w.Header()[mk] = []string{mv}
+		} else {
+			w.Header().Set(mk, mv)
+		}
+	}
+
+	// Last-Modified is stored with the object when it is written through
+	// GoFakeS3, but objects written directly to the Backend may not have it:
+	if w.Header().Get("Last-Modified") == "" {
+		w.Header().Set("Last-Modified", formatHeaderTime(g.timeSource.Now()))
+	}
+
+	// Zero-byte keys ending in '/' are used as folder markers by tools like
+	// s3fs, which expect this content type if none was given:
+	if w.Header().Get("Content-Type") == "" && isFolderMarker(obj) {
+		w.Header().Set("Content-Type", folderMarkerContentType)
 	}
-	w.Header().Set("Last-Modified", formatHeaderTime(g.timeSource.Now()))
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("ETag", `"`+hex.EncodeToString(obj.Hash)+`"`)
 
@@ -431,6 +1176,12 @@ func (g *GoFakeS3) writeGetOrHeadObjectResponse(obj *Object, w http.ResponseWrit
 	return nil
 }
 
+const folderMarkerContentType = "application/x-directory"
+
+func isFolderMarker(obj *Object) bool {
+	return obj.Size == 0 && strings.HasSuffix(obj.Name, "/")
+}
+
 // headObject retrieves only meta information of an object and not the whole.
 func (g *GoFakeS3) headObject(
 	bucket, object string,
@@ -443,13 +1194,37 @@ func (g *GoFakeS3) headObject(
 	g.log.Print(LogInfo, "Bucket:", bucket)
 	g.log.Print(LogInfo, "└── Object:", object)
 
-	obj, err := g.storage.HeadObject(bucket, object)
+	var rnge *ObjectRangeRequest
+	var partsCount int
+	var err error
+	if _, ok := r.URL.Query()["partNumber"]; ok {
+		if rnge, partsCount, err = g.partRange(bucket, object, versionID, r.URL.Query().Get("partNumber")); err != nil {
+			return err
+		}
+	}
+
+	var obj *Object
+
+	if versionID == "" {
+		obj, err = g.storage.HeadObject(bucket, object)
+	} else {
+		if g.versioned == nil {
+			return ErrNotImplemented
+		}
+		obj, err = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
 	if err != nil {
+		// SDKs use HEAD to check whether an object exists, so a backend that
+		// reports a missing object with a plain filesystem error must still
+		// produce a 404 rather than an opaque 500:
+		if os.IsNotExist(err) {
+			return KeyNotFound(object)
+		}
 		return err
 	}
 	if obj == nil {
-		g.log.Print(LogErr, "unexpected nil object for key", bucket, object)
-		return ErrInternal
+		g.log.Print(LogWarn, "nil object returned by HeadObject for key; treating as missing", bucket, object)
+		return KeyNotFound(object)
 	}
 	defer obj.Contents.Close()
 
@@ -457,7 +1232,17 @@ func (g *GoFakeS3) headObject(
 		return err
 	}
 
-	w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+	if rnge == nil {
+		w.Header().Set("Content-Length", fmt.Sprintf("%d", obj.Size))
+		return nil
+	}
+
+	objRange, err := rnge.Range(obj.Size)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(partsCount))
+	objRange.writeHeader(obj.Size, w)
 
 	return nil
 }
@@ -467,8 +1252,24 @@ func (g *GoFakeS3) headObject(
 func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWriter, r *http.Request) error {
 	g.log.Print(LogInfo, "CREATE OBJECT THROUGH BROWSER UPLOAD")
 
+	var limited *sizeLimitReader
+	if g.maxObjectSize > 0 {
+		// The form fields are not part of the object, so the body itself is
+		// allowed to be a little larger than the limit:
+		const formFieldAllowance = 1 << 20
+		limit := g.maxObjectSize + formFieldAllowance
+		if r.ContentLength > limit {
+			return entityTooLarge(0, g.maxObjectSize)
+		}
+		limited = &sizeLimitReader{inner: r.Body, limit: limit, remaining: limit}
+		r.Body = ioutil.NopCloser(limited)
+	}
+
 	const _24MB = (1 << 20) * 24 // maximum amount of memory before temp files are used
 	if err := r.ParseMultipartForm(_24MB); nil != err {
+		if limited != nil && limited.remaining < 0 {
+			return entityTooLarge(0, g.maxObjectSize)
+		}
 		return ErrMalformedPOSTRequest
 	}
 
@@ -486,6 +1287,15 @@ func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWrite
 		return ErrIncorrectNumberOfFilesInPostRequest
 	}
 	fileHeader := fileValues[0]
+	if g.maxObjectSize > 0 && fileHeader.Size > g.maxObjectSize {
+		return entityTooLarge(fileHeader.Size, g.maxObjectSize)
+	}
+
+	if len(g.credentials) > 0 {
+		if err := g.verifyPostPolicy(bucket, r.MultipartForm, fileHeader.Size); err != nil {
+			return err
+		}
+	}
 
 	infile, err := fileHeader.Open()
 	if err != nil {
@@ -493,7 +1303,7 @@ func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWrite
 	}
 	defer infile.Close()
 
-	meta, err := metadataHeaders(r.MultipartForm.Value, g.timeSource.Now(), g.metadataSizeLimit)
+	meta, err := metadataHeaders(r.MultipartForm.Value, g.timeSource.Now(), g.metadataLimits(), g.preserveMetadataCase)
 	if err != nil {
 		return err
 	}
@@ -502,8 +1312,15 @@ func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWrite
 		return ResourceError(ErrKeyTooLong, key)
 	}
 
-	// FIXME: how does Content-MD5 get sent when using the browser? does it?
-	rdr, err := newHashingReader(infile, "")
+	// Browser uploads send Content-MD5 as a form field. A Content-MD5 header,
+	// if present, covers the entire multipart body rather than the file, so
+	// it is not used here.
+	var md5Base64 string
+	if g.integrityCheckEnabled() {
+		md5Base64 = firstFormValue(r.MultipartForm.Value, "Content-MD5")
+	}
+
+	rdr, err := newHashingReader(infile, md5Base64)
 	if err != nil {
 		return err
 	}
@@ -512,343 +1329,1398 @@ func (g *GoFakeS3) createObjectBrowserUpload(bucket string, w http.ResponseWrite
 	if err != nil {
 		return err
 	}
+	g.subresources.RemoveObject(bucket, key)
+
 	if result.VersionID != "" {
 		w.Header().Set("x-amz-version-id", string(result.VersionID))
 	}
 
-	w.Header().Set("ETag", `"`+hex.EncodeToString(rdr.Sum(nil))+`"`)
+	etag := `"` + hex.EncodeToString(rdr.Sum(nil)) + `"`
+	w.Header().Set("ETag", etag)
+
+	g.emitEvent(Event{Name: EventObjectCreatedPost, Bucket: bucket, Key: key, Size: fileHeader.Size, ETag: etag, VersionID: result.VersionID})
+
+	return g.browserUploadResponse(bucket, key, etag, w, r)
+}
+
+// browserUploadResponse responds to a successful browser upload as requested
+// by the 'success_action_redirect' or 'success_action_status' form fields:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectPOST.html
+//
+// If neither field is valid, S3 responds with '204 No Content', but GoFakeS3
+// has always responded with '200 OK', so it continues to do so.
+func (g *GoFakeS3) browserUploadResponse(bucket, key, etag string, w http.ResponseWriter, r *http.Request) error {
+	form := r.MultipartForm.Value
+
+	redirect := firstFormValue(form, "success_action_redirect")
+	if redirect == "" {
+		redirect = firstFormValue(form, "redirect") // Deprecated, but still accepted by S3
+	}
+	if redirect != "" {
+		if u, err := url.Parse(redirect); err == nil && u.IsAbs() {
+			query := u.Query()
+			query.Set("bucket", bucket)
+			query.Set("key", key)
+			query.Set("etag", etag)
+			u.RawQuery = query.Encode()
+
+			w.Header().Set("Location", u.String())
+			emptyResponse(w, http.StatusSeeOther)
+			return nil
+		}
+	}
+
+	switch firstFormValue(form, "success_action_status") {
+	case "201":
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		location := url.URL{Scheme: scheme, Host: r.Host, Path: "/" + bucket + "/" + key}
+		w.Header().Set("Location", location.String())
+
+		return g.xmlResponseStatus(w, http.StatusCreated, PostResponse{
+			Location: location.String(),
+			Bucket:   bucket,
+			Key:      key,
+			ETag:     etag,
+		})
+
+	case "204":
+		emptyResponse(w, http.StatusNoContent)
+
+	default:
+		emptyResponse(w, http.StatusOK)
+	}
 	return nil
 }
 
+// firstFormValue returns the first value of the form field; S3 treats form
+// field names as case insensitive.
+func firstFormValue(form map[string][]string, name string) string {
+	for k, v := range form {
+		if strings.EqualFold(k, name) && len(v) > 0 {
+			return v[0]
+		}
+	}
+	return ""
+}
+
 // CreateObject creates a new S3 object.
 func (g *GoFakeS3) createObject(bucket, object string, w http.ResponseWriter, r *http.Request) (err error) {
 	g.log.Print(LogInfo, "CREATE OBJECT:", bucket, object)
 
-	meta, err := metadataHeaders(r.Header, g.timeSource.Now(), g.metadataSizeLimit)
+	meta, err := metadataHeaders(r.Header, g.timeSource.Now(), g.metadataLimits(), g.preserveMetadataCase)
 	if err != nil {
 		return err
 	}
 
-	size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
-	if err != nil || size <= 0 {
-		return ErrMissingContentLength
+	// A chunked body has no Content-Length, so its size can only be found by
+	// reading it all. This is put off until after the other headers have
+	// been checked. 'Content-Length: 0' is a valid empty object (often a
+	// "folder" marker ending in '/'):
+	size, sizeKnown, err := requestBodySize(r)
+	if err != nil {
+		return err
 	}
 
 	if len(object) > KeySizeLimit {
 		return ResourceError(ErrKeyTooLong, object)
 	}
 
+	acl, err := g.cannedACLFromHeader(r)
+	if err != nil {
+		return err
+	}
+
+	cond, err := putConditionFromHeader(r.Header)
+	if err != nil {
+		return err
+	}
+
 	var md5Base64 string
-	if g.integrityCheck {
+	var contentSHA256 []byte
+	if g.integrityCheckEnabled() {
 		md5Base64 = r.Header.Get("Content-MD5")
 
 		if _, ok := r.Header[textproto.CanonicalMIMEHeaderKey("Content-MD5")]; ok && md5Base64 == "" {
 			return ErrInvalidDigest // Satisfies s3tests
 		}
+
+		if contentSHA256, err = contentSHA256FromHeader(r.Header); err != nil {
+			return err
+		}
+	}
+
+	if err := g.autoCreateBucket(bucket); err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+	body, err := g.limitObjectSize(r.Body, size)
+	if err != nil {
+		return err
+	}
+
+	if !sizeKnown {
+		const _24MB = (1 << 20) * 24 // maximum amount of memory before temp files are used
+		spooled, spooledSize, err := spoolBody(body, g.uploader.tempDir, _24MB)
+		if err != nil {
+			return err
+		}
+		defer spooled.Close()
+		body, size = spooled, spooledSize
 	}
 
 	// hashingReader is still needed to get the ETag even if integrityCheck
 	// is set to false:
-	rdr, err := newHashingReader(r.Body, md5Base64)
-	defer r.Body.Close()
+	rdr, err := newHashingReader(body, md5Base64)
 	if err != nil {
 		return err
 	}
+	if contentSHA256 != nil {
+		rdr.expectSHA256(contentSHA256)
+	}
 
-	result, err := g.storage.PutObject(bucket, object, meta, rdr, size)
+	result, err := g.putObjectIf(bucket, object, cond, meta, rdr, size)
 	if err != nil {
 		return err
 	}
 
+	// A new object does not inherit the tags or ACL of the one it replaces:
+	g.subresources.RemoveObject(bucket, object)
+	if acl != nil {
+		g.subresources.SetObjectACL(bucket, object, acl)
+	}
+
 	if result.VersionID != "" {
 		g.log.Print(LogInfo, "CREATED VERSION:", bucket, object, result.VersionID)
 		w.Header().Set("x-amz-version-id", string(result.VersionID))
 	}
-	w.Header().Set("ETag", `"`+hex.EncodeToString(rdr.Sum(nil))+`"`)
+	writeServerSideEncryptionHeaders(w.Header(), meta)
+	etag := `"` + hex.EncodeToString(rdr.Sum(nil)) + `"`
+	w.Header().Set("ETag", etag)
+
+	g.emitEvent(Event{Name: EventObjectCreatedPut, Bucket: bucket, Key: object, Size: size, ETag: etag, VersionID: result.VersionID})
+	emptyResponse(w, http.StatusOK)
 
 	return nil
 }
 
-func (g *GoFakeS3) deleteObject(bucket, object string, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "DELETE:", bucket, object)
-	result, err := g.storage.DeleteObject(bucket, object)
-	if err != nil {
-		return err
+// limitObjectSize rejects an object whose size exceeds the limit set by
+// WithMaxObjectSize, before any of it is read. Otherwise, it returns a reader
+// that will not read past the limit even if the body is larger than size.
+func (g *GoFakeS3) limitObjectSize(rdr io.Reader, size int64) (io.Reader, error) {
+	if g.maxObjectSize <= 0 {
+		return rdr, nil
 	}
+	if size > g.maxObjectSize {
+		return nil, entityTooLarge(size, g.maxObjectSize)
+	}
+	return &sizeLimitReader{inner: rdr, limit: g.maxObjectSize, remaining: g.maxObjectSize}, nil
+}
 
-	if result.IsDeleteMarker {
-		w.Header().Set("x-amz-delete-marker", "true")
-	} else {
-		w.Header().Set("x-amz-delete-marker", "false")
+// putConditionFromHeader reads the conditions for a conditional write from the
+// If-None-Match and If-Match headers. As in S3, '*' is the only value
+// supported for If-None-Match.
+func putConditionFromHeader(header http.Header) (cond PutCondition, err error) {
+	if v := header.Get("If-None-Match"); v == "*" {
+		cond.IfAbsent = true
+	} else if v != "" {
+		return cond, ErrorMessage(ErrNotImplemented, "A header you provided implies functionality that is not implemented: If-None-Match")
 	}
 
-	if result.VersionID != "" {
-		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	if v := header.Get("If-Match"); v != "" {
+		if !strings.HasPrefix(v, `"`) {
+			v = `"` + v + `"`
+		}
+		cond.IfMatch = v
 	}
+	return cond, nil
+}
 
-	w.WriteHeader(http.StatusNoContent)
-	return nil
+// copySourceCondition restricts a copy to the state of its source object, as
+// requested by the x-amz-copy-source-if-* headers. Empty or zero fields have
+// no condition.
+type copySourceCondition struct {
+	ifMatch           string
+	ifNoneMatch       string
+	ifModifiedSince   time.Time
+	ifUnmodifiedSince time.Time
 }
 
-func (g *GoFakeS3) deleteObjectVersion(bucket, object string, version VersionID, w http.ResponseWriter, r *http.Request) error {
-	if g.versioned == nil {
-		return ErrNotImplemented
+// copySourceConditionFromHeader reads the copySourceCondition from the request
+// headers. As with the equivalent HTTP headers, dates that can't be parsed are
+// ignored.
+func copySourceConditionFromHeader(header http.Header) (cond copySourceCondition) {
+	cond.ifMatch = header.Get("x-amz-copy-source-if-match")
+	cond.ifNoneMatch = header.Get("x-amz-copy-source-if-none-match")
+	if t, err := parseHeaderTime(header.Get("x-amz-copy-source-if-modified-since")); err == nil {
+		cond.ifModifiedSince = t
 	}
-
-	g.log.Print(LogInfo, "DELETE VERSION:", bucket, object, version)
-	result, err := g.versioned.DeleteObjectVersion(bucket, object, version)
-	if err != nil {
-		return err
+	if t, err := parseHeaderTime(header.Get("x-amz-copy-source-if-unmodified-since")); err == nil {
+		cond.ifUnmodifiedSince = t
 	}
-	g.log.Print(LogInfo, "DELETED VERSION:", bucket, object, version)
+	return cond
+}
 
-	if result.IsDeleteMarker {
-		w.Header().Set("x-amz-delete-marker", "true")
-	} else {
-		w.Header().Set("x-amz-delete-marker", "false")
+// check returns ErrPreconditionFailed if the source object, with the given
+// ETag (including the surrounding quotes) and Last-Modified header, does not
+// meet the condition.
+//
+// S3 documents how the headers interact: if the ETag matches
+// x-amz-copy-source-if-match, x-amz-copy-source-if-unmodified-since is
+// ignored, and if the ETag does not match x-amz-copy-source-if-none-match,
+// x-amz-copy-source-if-modified-since is ignored.
+func (c copySourceCondition) check(etag string, lastModifiedHeader string) error {
+	lastModified, err := parseHeaderTime(lastModifiedHeader)
+	hasLastModified := err == nil
+
+	if c.ifMatch != "" {
+		if !etagMatches(c.ifMatch, etag) {
+			return ErrPreconditionFailed
+		}
+	} else if !c.ifUnmodifiedSince.IsZero() && hasLastModified && lastModified.After(c.ifUnmodifiedSince) {
+		return ErrPreconditionFailed
 	}
 
-	if result.VersionID != "" {
-		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	if c.ifNoneMatch != "" {
+		if etagMatches(c.ifNoneMatch, etag) {
+			return ErrPreconditionFailed
+		}
+	} else if !c.ifModifiedSince.IsZero() && hasLastModified && !lastModified.After(c.ifModifiedSince) {
+		return ErrPreconditionFailed
 	}
 
-	w.WriteHeader(http.StatusNoContent)
 	return nil
 }
 
-// deleteMulti deletes multiple S3 objects from the bucket.
-// https://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
-func (g *GoFakeS3) deleteMulti(bucket string, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "delete multi", bucket)
-
-	var in DeleteRequest
-
-	defer r.Body.Close()
-	dc := xml.NewDecoder(r.Body)
-	if err := dc.Decode(&in); err != nil {
-		return ErrorMessage(ErrMalformedXML, err.Error())
+// etagMatches reports whether etag, including the surrounding quotes, is in the
+// comma-separated list of ETags in an If-Match style header. The quotes are
+// optional in the header, and '*' matches any ETag.
+func etagMatches(header string, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		v = strings.TrimSpace(v)
+		if v == "*" {
+			return true
+		}
+		if !strings.HasPrefix(v, `"`) {
+			v = `"` + v + `"`
+		}
+		if v == etag {
+			return true
+		}
 	}
+	return false
+}
 
-	keys := make([]string, len(in.Objects))
-	for i, o := range in.Objects {
-		keys[i] = o.Key
+// putObjectIf stores an object if cond is met. The write is only atomic if
+// the Backend implements ConditionalPutBackend; otherwise the condition is
+// checked separately using HeadObject.
+func (g *GoFakeS3) putObjectIf(bucket, object string, cond PutCondition, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error) {
+	if cond.IsEmpty() {
+		return g.storage.PutObject(bucket, object, meta, input, size)
 	}
 
-	out, err := g.storage.DeleteMulti(bucket, keys...)
-	if err != nil {
-		return err
+	if cpb, ok := g.storage.(ConditionalPutBackend); ok {
+		result, err := cpb.PutObjectIf(bucket, object, cond, meta, input, size)
+		if !HasErrorCode(err, ErrNotImplemented) {
+			return result, err
+		}
 	}
 
-	if in.Quiet {
-		out.Deleted = nil
+	g.log.Print(LogWarn, "backend does not implement ConditionalPutBackend; conditional write is not atomic:", bucket, object)
+
+	var etag string
+	obj, err := g.storage.HeadObject(bucket, object)
+	if err != nil && !HasErrorCode(err, ErrNoSuchKey) {
+		return PutObjectResult{}, err
+	} else if obj != nil {
+		obj.Contents.Close()
+		if !obj.IsDeleteMarker {
+			etag = `"` + hex.EncodeToString(obj.Hash) + `"`
+		}
+	}
+	if err := cond.Check(object, etag); err != nil {
+		return PutObjectResult{}, err
 	}
 
-	return g.xmlEncoder(w).Encode(out)
+	return g.storage.PutObject(bucket, object, meta, input, size)
 }
 
-func (g *GoFakeS3) initiateMultipartUpload(bucket, object string, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "initiate multipart upload", bucket, object)
-
-	meta, err := metadataHeaders(r.Header, g.timeSource.Now(), g.metadataSizeLimit)
+// copyObject creates an object from an existing object (or a specific
+// version of one) named by the x-amz-copy-source header:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/RESTObjectCOPY.html
+func (g *GoFakeS3) copyObject(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	src, err := parseCopySource(r.Header.Get("x-amz-copy-source"))
 	if err != nil {
 		return err
 	}
-	if err := g.ensureBucketExists(bucket); err != nil {
-		return err
-	}
-
-	upload := g.uploader.Begin(bucket, object, meta, g.timeSource.Now())
-	out := InitiateMultipartUpload{
-		UploadID: upload.ID,
-		Bucket:   bucket,
-		Key:      object,
-	}
-	return g.xmlEncoder(w).Encode(out)
-}
-
-// From the docs:
-//	A part number uniquely identifies a part and also defines its position
-// 	within the object being created. If you upload a new part using the same
-// 	part number that was used with a previous part, the previously uploaded part
-// 	is overwritten. Each part must be at least 5 MB in size, except the last
-// 	part. There is no size limit on the last part of your multipart upload.
-//
-func (g *GoFakeS3) putMultipartUploadPart(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "put multipart upload", bucket, object, uploadID)
 
-	partNumber, err := strconv.ParseInt(r.URL.Query().Get("partNumber"), 10, 0)
-	if err != nil || partNumber <= 0 || partNumber > MaxUploadPartNumber {
-		return ErrInvalidPart
-	}
+	g.log.Print(LogInfo, "COPY OBJECT:", src.bucket, src.object, src.versionID, "=>", bucket, object)
 
-	size, err := strconv.ParseInt(r.Header.Get("Content-Length"), 10, 64)
-	if err != nil || size <= 0 {
-		return ErrMissingContentLength
+	if len(object) > KeySizeLimit {
+		return ResourceError(ErrKeyTooLong, object)
 	}
 
-	upload, err := g.uploader.Get(bucket, object, uploadID)
+	acl, err := g.cannedACLFromHeader(r)
 	if err != nil {
-		// FIXME: What happens with S3 when you abort a multipart upload while
-		// part uploads are still in progress? In this case, we will retain the
-		// reference to the part even though another request goroutine may
-		// delete it; it will be available for GC when this function finishes.
 		return err
 	}
 
-	defer r.Body.Close()
-	var rdr io.Reader = r.Body
+	cond := copySourceConditionFromHeader(r.Header)
 
-	if g.integrityCheck {
-		md5Base64 := r.Header.Get("Content-MD5")
-		if _, ok := r.Header[textproto.CanonicalMIMEHeaderKey("Content-MD5")]; ok && md5Base64 == "" {
-			return ErrInvalidDigest // Satisfies s3tests
+	now := g.timeSource.Now()
+	var replaced map[string]string
+	switch directive := r.Header.Get("x-amz-metadata-directive"); directive {
+	case "", "COPY":
+	case "REPLACE":
+		replaced, err = metadataHeaders(r.Header, now, g.metadataLimits(), g.preserveMetadataCase)
+		if err != nil {
+			return err
 		}
-
-		if md5Base64 != "" {
-			var err error
-			rdr, err = newHashingReader(rdr, md5Base64)
-			if err != nil {
-				return err
+		for k := range replaced {
+			if strings.HasPrefix(k, "X-Amz-Copy-Source") || k == "X-Amz-Metadata-Directive" {
+				delete(replaced, k)
 			}
 		}
+	default:
+		return ErrorInvalidArgument("x-amz-metadata-directive", directive, "Unknown metadata directive.")
 	}
 
-	body, err := ReadAll(rdr, size)
-	if err != nil {
-		return err
+	if replaced == nil && src.bucket == bucket && src.object == object {
+		if err := g.checkCopyOntoItself(src); err != nil {
+			return err
+		}
 	}
 
-	if int64(len(body)) != r.ContentLength {
-		return ErrIncompleteBody
+	var obj *Object
+	if src.versionID == "" {
+		obj, err = g.storage.GetObject(src.bucket, src.object, nil)
+	} else {
+		if g.versioned == nil {
+			return ErrNotImplemented
+		}
+		obj, err = g.versioned.GetObjectVersion(src.bucket, src.object, src.versionID, nil)
 	}
-
-	etag, err := upload.AddPart(int(partNumber), g.timeSource.Now(), body)
 	if err != nil {
 		return err
 	}
-
-	w.Header().Add("ETag", etag)
-	return nil
-}
-
-func (g *GoFakeS3) abortMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "abort multipart upload", bucket, object, uploadID)
-	if _, err := g.uploader.Complete(bucket, object, uploadID); err != nil {
-		return err
+	if obj == nil {
+		g.log.Print(LogErr, "unexpected nil object for key", src.bucket, src.object)
+		return ErrInternal
 	}
-	w.WriteHeader(http.StatusNoContent)
-	return nil
-}
+	defer obj.Contents.Close()
 
-func (g *GoFakeS3) completeMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	g.log.Print(LogInfo, "complete multipart upload", bucket, object, uploadID)
+	if obj.IsDeleteMarker {
+		if src.versionID != "" {
+			return ErrorMessage(ErrInvalidRequest, "The source of a copy request may not specifically refer to a delete marker by version id.")
+		}
+		return KeyNotFound(src.object)
+	}
 
-	var in CompleteMultipartUploadRequest
-	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+	if err := cond.check(`"`+hex.EncodeToString(obj.Hash)+`"`, obj.Metadata["Last-Modified"]); err != nil {
 		return err
 	}
 
-	upload, err := g.uploader.Complete(bucket, object, uploadID)
+	// The source is read in full before the destination is written, in case
+	// they are the same object:
+	body, err := ReadAll(obj.Contents, obj.Size)
 	if err != nil {
 		return err
 	}
 
-	fileBody, etag, err := upload.Reassemble(&in)
-	if err != nil {
-		return err
+	meta := replaced
+	if meta == nil {
+		meta = make(map[string]string, len(obj.Metadata))
+		for k, v := range obj.Metadata {
+			meta[k] = v
+		}
+		meta["Last-Modified"] = formatHeaderTime(now)
 	}
 
-	result, err := g.storage.PutObject(bucket, object, upload.Meta, bytes.NewReader(fileBody), int64(len(fileBody)))
+	result, err := g.storage.PutObject(bucket, object, meta, bytes.NewReader(body), int64(len(body)))
 	if err != nil {
 		return err
 	}
-	if result.VersionID != "" {
+	g.subresources.RemoveObject(bucket, object)
+	if acl != nil {
+		g.subresources.SetObjectACL(bucket, object, acl)
+	}
+
+	if result.VersionID != "" {
+		g.log.Print(LogInfo, "CREATED VERSION:", bucket, object, result.VersionID)
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", string(obj.VersionID))
+	}
+
+	hash := md5.Sum(body)
+	etag := `"` + hex.EncodeToString(hash[:]) + `"`
+	g.emitEvent(Event{Name: EventObjectCreatedCopy, Bucket: bucket, Key: object, Size: int64(len(body)), ETag: etag, VersionID: result.VersionID})
+
+	return g.xmlResponse(w, CopyObjectResult{
+		ETag:         etag,
+		LastModified: NewContentTime(now),
+	})
+}
+
+// checkCopyOntoItself returns ErrInvalidRequest for a copy of an object onto
+// itself that keeps its metadata, as it would change nothing. Copying an
+// older version over the current one is allowed; it restores that version.
+func (g *GoFakeS3) checkCopyOntoItself(src copySource) error {
+	if src.versionID != "" {
+		current, err := g.storage.HeadObject(src.bucket, src.object)
+		if err != nil && !HasErrorCode(err, ErrNoSuchKey) {
+			return err
+		}
+		if current != nil {
+			current.Contents.Close()
+		}
+		if current == nil || current.VersionID != src.versionID {
+			return nil
+		}
+	}
+	return ErrorMessage(ErrInvalidRequest, "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata, storage class, website redirect location or encryption attributes.")
+}
+
+func (g *GoFakeS3) deleteObject(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE:", bucket, object)
+	result, err := g.storage.DeleteObject(bucket, object)
+	if err != nil {
+		return err
+	}
+	g.subresources.RemoveObject(bucket, object)
+
+	event := EventObjectRemovedDelete
+	if result.IsDeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		event = EventObjectRemovedDeleteMarkerCreated
+	}
+
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+
+	g.emitEvent(Event{Name: event, Bucket: bucket, Key: object, VersionID: result.VersionID})
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) deleteObjectVersion(bucket, object string, version VersionID, w http.ResponseWriter, r *http.Request) error {
+	if g.versioned == nil {
+		return ErrNotImplemented
+	}
+
+	if g.mfaToken != nil && g.subresources.BucketMFADelete(bucket).Enabled() {
+		if err := g.checkMFA(r); err != nil {
+			return err
+		}
+	}
+
+	g.log.Print(LogInfo, "DELETE VERSION:", bucket, object, version)
+	result, err := g.versioned.DeleteObjectVersion(bucket, object, version)
+	if err != nil {
+		return err
+	}
+	g.log.Print(LogInfo, "DELETED VERSION:", bucket, object, version)
+
+	event := EventObjectRemovedDelete
+	if result.IsDeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		event = EventObjectRemovedDeleteMarkerCreated
+	}
+
+	if result.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(result.VersionID))
+	}
+
+	g.emitEvent(Event{Name: event, Bucket: bucket, Key: object, VersionID: version})
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+// deleteMulti deletes multiple S3 objects from the bucket.
+// https://docs.aws.amazon.com/AmazonS3/latest/API/multiobjectdeleteapi.html
+func (g *GoFakeS3) deleteMulti(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "delete multi", bucket)
+
+	// Keys are sent as XML character data, so they may contain any character
+	// XML can represent, escaped or not (like '&amp;', '&#x26;' or a CDATA
+	// section). They are used exactly as decoded; in particular, they are
+	// not URL-decoded:
+	var in DeleteRequest
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+
+	keys := make([]string, len(in.Objects))
+	for i, o := range in.Objects {
+		keys[i] = o.Key
+	}
+
+	out, err := g.storage.DeleteMulti(bucket, keys...)
+	if err != nil {
+		return err
+	}
+	sortDeleteResults(keys, &out)
+	for _, deleted := range out.Deleted {
+		g.subresources.RemoveObject(bucket, deleted.Key)
+		if deleted.DeleteMarker {
+			g.emitEvent(Event{Name: EventObjectRemovedDeleteMarkerCreated, Bucket: bucket, Key: deleted.Key, VersionID: VersionID(deleted.DeleteMarkerVersionID)})
+		} else {
+			g.emitEvent(Event{Name: EventObjectRemovedDelete, Bucket: bucket, Key: deleted.Key, VersionID: VersionID(deleted.VersionID)})
+		}
+	}
+
+	if in.Quiet {
+		out.Deleted = nil
+	}
+
+	return g.xmlResponse(w, out)
+}
+
+// sortDeleteResults puts the results of a DeleteObjects request in the order
+// the keys were requested in, whatever order the Backend returned them in.
+func sortDeleteResults(keys []string, out *MultiDeleteResult) {
+	order := make(map[string]int, len(keys))
+	for i := len(keys) - 1; i >= 0; i-- {
+		order[keys[i]] = i
+	}
+	sort.SliceStable(out.Deleted, func(i, j int) bool {
+		return order[out.Deleted[i].Key] < order[out.Deleted[j].Key]
+	})
+	sort.SliceStable(out.Error, func(i, j int) bool {
+		return order[out.Error[i].Key] < order[out.Error[j].Key]
+	})
+}
+
+func (g *GoFakeS3) initiateMultipartUpload(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "initiate multipart upload", bucket, object)
+
+	meta, err := metadataHeaders(r.Header, g.timeSource.Now(), g.metadataLimits(), g.preserveMetadataCase)
+	if err != nil {
+		return err
+	}
+	if err := g.autoCreateBucket(bucket); err != nil {
+		return err
+	}
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	var checksumAlgorithm ChecksumAlgorithm
+	if hdr := r.Header.Get("x-amz-checksum-algorithm"); hdr != "" {
+		var ok bool
+		if checksumAlgorithm, ok = parseChecksumAlgorithm(hdr); !ok {
+			return ErrorMessage(ErrInvalidRequest, "Checksum algorithm provided is unsupported. Please try again with any of the valid types: [CRC32, CRC32C, SHA1, SHA256]")
+		}
+		w.Header().Set("x-amz-checksum-algorithm", string(checksumAlgorithm))
+	}
+
+	upload := g.uploader.Begin(bucket, object, meta, g.timeSource.Now(), checksumAlgorithm)
+	writeServerSideEncryptionHeaders(w.Header(), meta)
+	out := InitiateMultipartUpload{
+		UploadID: upload.ID,
+		Bucket:   bucket,
+		Key:      object,
+	}
+	return g.xmlResponse(w, out)
+}
+
+// From the docs:
+//	A part number uniquely identifies a part and also defines its position
+// 	within the object being created. If you upload a new part using the same
+// 	part number that was used with a previous part, the previously uploaded part
+// 	is overwritten. Each part must be at least 5 MB in size, except the last
+// 	part. There is no size limit on the last part of your multipart upload.
+//
+func (g *GoFakeS3) putMultipartUploadPart(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "put multipart upload", bucket, object, uploadID)
+
+	// A malformed or out-of-range part number is an InvalidArgument; S3
+	// reserves InvalidPart for parts that are missing or do not match when
+	// the upload is completed:
+	rawPartNumber := r.URL.Query().Get("partNumber")
+	partNumber, err := strconv.ParseInt(rawPartNumber, 10, 0)
+	if err != nil || partNumber <= 0 || partNumber > int64(g.uploader.maxPartNumber) {
+		return invalidPartNumber(rawPartNumber, g.uploader.maxPartNumber)
+	}
+
+	size, sizeKnown, err := requestBodySize(r)
+	if err != nil {
+		return err
+	}
+
+	checksum, err := checksumFromHeader(r.Header)
+	if err != nil {
+		return err
+	}
+
+	// If the upload is aborted while this part is still being received,
+	// upload.AddPart will return ErrNoSuchUpload and the part will be
+	// discarded:
+	upload, err := g.uploader.Get(bucket, object, uploadID)
+	if err != nil {
+		return err
+	}
+
+	defer r.Body.Close()
+	rdr, err := g.limitObjectSize(r.Body, size)
+	if err != nil {
+		return err
+	}
+
+	if !sizeKnown {
+		const _24MB = (1 << 20) * 24 // maximum amount of memory before temp files are used
+		spooled, spooledSize, err := spoolBody(rdr, g.uploader.tempDir, _24MB)
+		if err != nil {
+			return err
+		}
+		defer spooled.Close()
+		rdr, size = spooled, spooledSize
+	}
+
+	if g.integrityCheckEnabled() {
+		md5Base64 := r.Header.Get("Content-MD5")
+		if _, ok := r.Header[textproto.CanonicalMIMEHeaderKey("Content-MD5")]; ok && md5Base64 == "" {
+			return ErrInvalidDigest // Satisfies s3tests
+		}
+
+		if md5Base64 != "" {
+			var err error
+			rdr, err = newHashingReader(rdr, md5Base64)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	etag, checksums, err := upload.AddPart(int(partNumber), g.timeSource.Now(), rdr, size, checksum)
+	if err != nil {
+		return err
+	}
+
+	// The part records its own size and time for ListParts; the encryption
+	// settings were given when the upload was created:
+	w.Header().Add("ETag", etag)
+	checksums.writeHeaders(w.Header())
+	writeServerSideEncryptionHeaders(w.Header(), upload.Meta)
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+func (g *GoFakeS3) abortMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "abort multipart upload", bucket, object, uploadID)
+	if err := g.uploader.Abort(bucket, object, uploadID); err != nil {
+		return err
+	}
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) completeMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "complete multipart upload", bucket, object, uploadID)
+
+	var in CompleteMultipartUploadRequest
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+
+	upload, body, size, parts, err := g.uploader.Complete(bucket, object, uploadID, &in, g.maxObjectSize)
+	if err != nil {
+		return err
+	}
+
+	// The upload has been removed from the uploader, so its parts can be
+	// released once they have been written to the backend:
+	defer upload.close(true)
+	defer body.Close()
+
+	manifest := PartsManifest{Parts: parts}
+	if alg := upload.ChecksumAlgorithm; alg != "" {
+		manifest.Checksum = newChecksums(alg, compositeChecksum(alg, parts))
+	}
+
+	// The parts are streamed to the backend, so the ETag is calculated as
+	// the backend reads them:
+	hash := md5.New()
+	var result PutObjectResult
+	if g.partsBackend != nil {
+		result, err = g.partsBackend.PutObjectWithParts(bucket, object, upload.Meta, io.TeeReader(body, hash), size, manifest)
+	} else {
+		result, err = g.storage.PutObject(bucket, object, upload.Meta, io.TeeReader(body, hash), size)
+	}
+	if err != nil {
+		return err
+	}
+	etag := hex.EncodeToString(hash.Sum(nil))
+	g.subresources.RemoveObject(bucket, object)
+	if g.partsBackend == nil {
+		g.subresources.SetObjectParts(bucket, object, manifest)
+	}
+
+	if result.VersionID != "" {
 		w.Header().Set("x-amz-version-id", string(result.VersionID))
 	}
+	writeServerSideEncryptionHeaders(w.Header(), upload.Meta)
+
+	g.emitEvent(Event{Name: EventObjectCreatedCompleteMultipartUpload, Bucket: bucket, Key: object, Size: size, ETag: `"` + etag + `"`, VersionID: result.VersionID})
+
+	return g.xmlResponse(w, &CompleteMultipartUploadResult{
+		ETag:      etag,
+		Bucket:    bucket,
+		Key:       object,
+		Checksums: manifest.Checksum,
+	})
+}
+
+func (g *GoFakeS3) listMultipartUploads(bucket string, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+	prefix := prefixFromQuery(query)
+	marker := uploadListMarkerFromQuery(query)
+
+	maxUploads, err := parseLimit("max-uploads", query.Get("max-uploads"), DefaultMaxUploads, MaxUploadsLimit, g.clampMode())
+	if err == ErrInvalidArgument {
+		return ErrInvalidURI
+	} else if err != nil {
+		return err
+	}
+	if maxUploads == 0 {
+		maxUploads = DefaultMaxUploads
+	}
+
+	out, err := g.uploader.List(bucket, marker, prefix, maxUploads)
+	if err != nil {
+		return err
+	}
+	for i := range out.Uploads {
+		out.Uploads[i].Initiator = g.ownerInfo()
+		out.Uploads[i].Owner = g.ownerInfo()
+	}
+
+	return g.xmlResponse(w, out)
+}
+
+func (g *GoFakeS3) listMultipartUploadParts(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
+	query := r.URL.Query()
+
+	marker, err := parseLimit("part-number-marker", query.Get("part-number-marker"), 0, math.MaxInt64, g.clampMode())
+	if err == ErrInvalidArgument {
+		return ErrInvalidURI
+	} else if err != nil {
+		return err
+	}
+
+	maxParts, err := parseLimit("max-parts", query.Get("max-parts"), DefaultMaxUploadParts, MaxUploadPartsLimit, g.clampMode())
+	if err == ErrInvalidArgument {
+		return ErrInvalidURI
+	} else if err != nil {
+		return err
+	}
+
+	out, err := g.uploader.ListParts(bucket, object, uploadID, int(marker), maxParts)
+	if err != nil {
+		return err
+	}
+	out.Xmlns = g.xmlns
+	out.Initiator = g.ownerInfo()
+	out.Owner = g.ownerInfo()
+
+	return g.xmlResponse(w, out)
+}
+
+func (g *GoFakeS3) getBucketVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
+	var config VersioningConfiguration
+
+	if g.versioned != nil {
+		var err error
+		config, err = g.versioned.VersioningConfiguration(bucket)
+		if err != nil {
+			return err
+		}
+	}
+
+	if g.mfaToken != nil {
+		config.MFADelete = g.subresources.BucketMFADelete(bucket)
+	}
+
+	config.Xmlns = g.xmlns
+	return g.xmlResponse(w, config)
+}
+
+func (g *GoFakeS3) putBucketVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
+	var in VersioningConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+
+	if g.versioned == nil {
+		if in.MFADelete == MFADeleteEnabled || in.Status == VersioningEnabled {
+			// We only need to respond that this is not implemented if there's an
+			// attempt to enable it. If we receive a request to disable it, or an
+			// empty request, that matches the current state and has no effect so
+			// we can accept it.
+			return ErrNotImplemented
+		} else {
+			return nil
+		}
+	}
+
+	if g.mfaToken == nil {
+		g.log.Print(LogInfo, "PUT VERSIONING:", in.Status)
+		return g.versioned.SetVersioningConfiguration(bucket, in)
+	}
+
+	// With WithMFA, the MFA Delete state is kept by GoFakeS3 rather than the
+	// Backend. Once MFA Delete is enabled, changing the versioning state
+	// needs MFA as well as changing MFA Delete itself:
+	mfaDelete := in.MFADelete
+	if mfaDelete != MFADeleteNone || g.subresources.BucketMFADelete(bucket).Enabled() {
+		if err := g.checkMFA(r); err != nil {
+			return err
+		}
+	}
+
+	g.log.Print(LogInfo, "PUT VERSIONING:", in.Status, mfaDelete)
+	in.MFADelete = MFADeleteNone
+	if err := g.versioned.SetVersioningConfiguration(bucket, in); err != nil {
+		return err
+	}
+	if mfaDelete != MFADeleteNone {
+		g.subresources.SetBucketMFADelete(bucket, mfaDelete)
+	}
+	return nil
+}
+
+func (g *GoFakeS3) getBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET POLICY:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	policy := g.subresources.BucketPolicy(bucket)
+	if policy == nil {
+		return ResourceError(ErrNoSuchBucketPolicy, bucket)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(policy)))
+	_, err := w.Write(policy)
+	return err
+}
+
+// putBucketPolicy stores the policy document verbatim. Only the JSON itself
+// is validated, not the policy language.
+func (g *GoFakeS3) putBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT POLICY:", bucket)
+
+	policy, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	trimmed := bytes.TrimSpace(policy)
+	if len(trimmed) == 0 || trimmed[0] != '{' || !json.Valid(trimmed) {
+		return ErrorMessage(ErrMalformedPolicy, "Policies must be valid JSON and the first byte must be '{'")
+	}
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	g.subresources.SetBucketPolicy(bucket, policy)
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) deleteBucketPolicy(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE POLICY:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	g.subresources.SetBucketPolicy(bucket, nil)
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) getBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET CORS:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	rules := g.subresources.BucketCORS(bucket)
+	if rules == nil {
+		return ResourceError(ErrNoSuchCORSConfiguration, bucket)
+	}
+
+	return g.xmlResponse(w, CORSConfiguration{
+		Xmlns: g.xmlns,
+		Rules: rules,
+	})
+}
+
+func (g *GoFakeS3) putBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT CORS:", bucket)
+
+	var in CORSConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if err := validateCORSRules(in.Rules); err != nil {
+		return err
+	}
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	g.subresources.SetBucketCORS(bucket, in.Rules)
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+func (g *GoFakeS3) deleteBucketCORS(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE CORS:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	g.subresources.SetBucketCORS(bucket, nil)
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) getBucketNotification(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET NOTIFICATION:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	// Unlike most other bucket subresources, an unconfigured bucket has an
+	// empty configuration rather than an error:
+	config := g.subresources.BucketNotification(bucket)
+	config.Xmlns = g.xmlns
+	return g.xmlResponse(w, config)
+}
+
+func (g *GoFakeS3) putBucketNotification(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT NOTIFICATION:", bucket)
+
+	var in NotificationConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if err := validateNotificationConfiguration(in); err != nil {
+		return err
+	}
 
-	return g.xmlEncoder(w).Encode(&CompleteMultipartUploadResult{
-		ETag:   etag,
-		Bucket: bucket,
-		Key:    object,
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	in.XMLName, in.Xmlns = xml.Name{}, ""
+	g.subresources.SetBucketNotification(bucket, in)
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+func (g *GoFakeS3) getBucketLocation(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET LOCATION:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	// Buckets created directly in the Backend have no stored location:
+	location := g.subresources.BucketLocation(bucket)
+	if location == "" {
+		location = g.region
+	}
+
+	// S3 reports buckets in us-east-1 with an empty LocationConstraint:
+	if location == "us-east-1" {
+		location = ""
+	}
+
+	return g.xmlResponse(w, LocationConstraint{
+		Xmlns:    g.xmlns,
+		Location: location,
 	})
 }
 
-func (g *GoFakeS3) listMultipartUploads(bucket string, w http.ResponseWriter, r *http.Request) error {
-	query := r.URL.Query()
-	prefix := prefixFromQuery(query)
-	marker := uploadListMarkerFromQuery(query)
+func (g *GoFakeS3) getBucketRequestPayment(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET REQUEST PAYMENT:", bucket)
 
-	maxUploads, err := parseClampedInt(query.Get("max-uploads"), DefaultMaxUploads, 0, MaxUploadsLimit)
-	if err != nil {
-		return ErrInvalidURI
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
 	}
-	if maxUploads == 0 {
-		maxUploads = DefaultMaxUploads
+
+	return g.xmlResponse(w, RequestPaymentConfiguration{
+		Xmlns: g.xmlns,
+		Payer: g.subresources.BucketRequestPayer(bucket),
+	})
+}
+
+func (g *GoFakeS3) putBucketRequestPayment(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT REQUEST PAYMENT:", bucket)
+
+	var in RequestPaymentConfiguration
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if in.Payer != PayerBucketOwner && in.Payer != PayerRequester {
+		return ErrMalformedXML
 	}
 
-	out, err := g.uploader.List(bucket, marker, prefix, maxUploads)
-	if err != nil {
+	if err := g.ensureBucketExists(bucket); err != nil {
 		return err
 	}
 
-	return g.xmlEncoder(w).Encode(out)
+	g.subresources.SetBucketRequestPayer(bucket, in.Payer)
+	emptyResponse(w, http.StatusOK)
+	return nil
 }
 
-func (g *GoFakeS3) listMultipartUploadParts(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	query := r.URL.Query()
+// corsRules returns the CORS rules that apply to preflight requests for the
+// bucket. Buckets without a CORS configuration, or requests that do not
+// refer to a bucket, fall back to defaultCORSRules.
+func (g *GoFakeS3) corsRules(bucket string) []CORSRule {
+	if bucket != "" {
+		if rules := g.subresources.BucketCORS(bucket); rules != nil {
+			return rules
+		}
+	}
+	return defaultCORSRules
+}
+
+func (g *GoFakeS3) getObjectTagging(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET TAGGING:", bucket, object)
+
+	if err := g.ensureObjectExists(bucket, object); err != nil {
+		return err
+	}
+
+	out := Tagging{
+		Xmlns:  g.xmlns,
+		TagSet: g.subresources.ObjectTags(bucket, object),
+	}
+	return g.xmlResponse(w, out)
+}
 
-	marker, err := parseClampedInt(query.Get("part-number-marker"), 0, 0, math.MaxInt64)
+// getObjectAttributes returns the attributes named in the
+// 'x-amz-object-attributes' header. ObjectParts and Checksum are only
+// available for objects created by a multipart upload.
+func (g *GoFakeS3) getObjectAttributes(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET ATTRIBUTES:", bucket, object)
+
+	attrs, err := objectAttributesFromHeader(r.Header)
 	if err != nil {
-		return ErrInvalidURI
+		return err
 	}
 
-	maxParts, err := parseClampedInt(query.Get("max-parts"), DefaultMaxUploadParts, 0, MaxUploadPartsLimit)
+	marker, err := parseClampedInt(r.Header.Get("x-amz-part-number-marker"), 0, 0, math.MaxInt64, g.clampMode())
 	if err != nil {
-		return ErrInvalidURI
+		return ErrorInvalidArgument("x-amz-part-number-marker", r.Header.Get("x-amz-part-number-marker"), "Invalid part number marker")
+	}
+	maxParts, err := parseLimit("x-amz-max-parts", r.Header.Get("x-amz-max-parts"), DefaultMaxUploadParts, MaxUploadPartsLimit, g.clampMode())
+	if err == ErrInvalidArgument {
+		return ErrorInvalidArgument("x-amz-max-parts", r.Header.Get("x-amz-max-parts"), "Invalid max parts")
+	} else if err != nil {
+		return err
 	}
 
-	out, err := g.uploader.ListParts(bucket, object, uploadID, int(marker), maxParts)
+	var obj *Object
+	if versionID == "" {
+		obj, err = g.storage.HeadObject(bucket, object)
+	} else {
+		if g.versioned == nil {
+			return ErrNotImplemented
+		}
+		obj, err = g.versioned.HeadObjectVersion(bucket, object, versionID)
+	}
+	if err != nil {
+		// As in headObject, a plain filesystem error for a missing object
+		// must still produce a 404:
+		if os.IsNotExist(err) {
+			return KeyNotFound(object)
+		}
+		return err
+	}
+	if obj == nil {
+		g.log.Print(LogWarn, "nil object returned by HeadObject for key; treating as missing", bucket, object)
+		return KeyNotFound(object)
+	}
+	defer obj.Contents.Close()
+
+	if obj.IsDeleteMarker {
+		w.Header().Set("x-amz-version-id", string(obj.VersionID))
+		w.Header().Set("x-amz-delete-marker", "true")
+		return KeyNotFound(object)
+	}
+	if obj.VersionID != "" {
+		w.Header().Set("x-amz-version-id", string(obj.VersionID))
+	}
+	if lastModified := obj.Metadata["Last-Modified"]; lastModified != "" {
+		w.Header().Set("Last-Modified", lastModified)
+	}
+
+	manifest, err := g.objectParts(bucket, object, versionID)
 	if err != nil {
 		return err
 	}
 
-	return g.xmlEncoder(w).Encode(out)
+	out := GetObjectAttributesResult{Xmlns: g.xmlns}
+	if attrs["ETag"] {
+		out.ETag = hex.EncodeToString(obj.Hash)
+	}
+	if attrs["Checksum"] && manifest != nil && manifest.Checksum != (Checksums{}) {
+		out.Checksum = &manifest.Checksum
+	}
+	if attrs["ObjectParts"] && manifest != nil {
+		out.ObjectParts = objectPartsPage(manifest.Parts, int(marker), int(maxParts))
+	}
+	if attrs["StorageClass"] {
+		out.StorageClass = "STANDARD"
+	}
+	if attrs["ObjectSize"] {
+		size := obj.Size
+		out.ObjectSize = &size
+	}
+	return g.xmlResponse(w, out)
 }
 
-func (g *GoFakeS3) getBucketVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
-	var config VersioningConfiguration
-
-	if g.versioned != nil {
-		var err error
-		config, err = g.versioned.VersioningConfiguration(bucket)
-		if err != nil {
-			return err
+// objectPartsPage returns the parts after the part number marker, up to
+// maxParts of them.
+func objectPartsPage(parts []ObjectPart, marker, maxParts int) *GetObjectAttributesParts {
+	out := &GetObjectAttributesParts{
+		TotalPartsCount:  len(parts),
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, part := range parts {
+		if part.PartNumber <= marker {
+			continue
 		}
+		if len(out.Parts) >= maxParts {
+			out.IsTruncated = true
+			break
+		}
+		out.Parts = append(out.Parts, part)
+		out.NextPartNumberMarker = part.PartNumber
 	}
+	return out
+}
 
-	return g.xmlEncoder(w).Encode(config)
+// objectAttributes are the attributes GetObjectAttributes can return.
+var objectAttributes = []string{"ETag", "Checksum", "ObjectParts", "StorageClass", "ObjectSize"}
+
+// objectAttributesFromHeader returns the set of attributes listed in the
+// 'x-amz-object-attributes' header, which may be given more than once.
+func objectAttributesFromHeader(hdr http.Header) (map[string]bool, error) {
+	const name = "x-amz-object-attributes"
+	values := hdr.Values(name)
+	if len(values) == 0 {
+		return nil, ErrorInvalidArgument(name, "", "Empty value provided for input HTTP header x-amz-object-attributes.")
+	}
+
+	attrs := make(map[string]bool)
+	for _, value := range values {
+		for _, attr := range strings.Split(value, ",") {
+			attr = strings.TrimSpace(attr)
+			valid := false
+			for _, known := range objectAttributes {
+				if attr == known {
+					valid = true
+					break
+				}
+			}
+			if !valid {
+				return nil, ErrorInvalidArgument(name, attr, "Invalid attribute name specified.")
+			}
+			attrs[attr] = true
+		}
+	}
+	return attrs, nil
 }
 
-func (g *GoFakeS3) putBucketVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
-	var in VersioningConfiguration
+func (g *GoFakeS3) putObjectTagging(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT TAGGING:", bucket, object)
+
+	var in Tagging
 	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
 		return err
 	}
+	if err := validateTagSet(in.TagSet); err != nil {
+		return err
+	}
+	if err := g.ensureObjectExists(bucket, object); err != nil {
+		return err
+	}
 
-	if g.versioned == nil {
-		if in.MFADelete == MFADeleteEnabled || in.Status == VersioningEnabled {
-			// We only need to respond that this is not implemented if there's an
-			// attempt to enable it. If we receive a request to disable it, or an
-			// empty request, that matches the current state and has no effect so
-			// we can accept it.
-			return ErrNotImplemented
-		} else {
-			return nil
+	g.subresources.SetObjectTags(bucket, object, in.TagSet)
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+func (g *GoFakeS3) deleteObjectTagging(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "DELETE TAGGING:", bucket, object)
+
+	if err := g.ensureObjectExists(bucket, object); err != nil {
+		return err
+	}
+
+	g.subresources.SetObjectTags(bucket, object, nil)
+	emptyResponse(w, http.StatusNoContent)
+	return nil
+}
+
+func (g *GoFakeS3) getObjectACL(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET ACL:", bucket, object)
+
+	if err := g.ensureObjectExists(bucket, object); err != nil {
+		return err
+	}
+
+	grants := g.subresources.ObjectACL(bucket, object)
+	if grants == nil {
+		grants, _ = ACLPrivate.Grants(g.owner)
+	}
+
+	out := AccessControlPolicy{
+		Xmlns:             g.xmlns,
+		Owner:             g.ownerInfo(),
+		AccessControlList: grants,
+	}
+	return g.xmlResponse(w, out)
+}
+
+func (g *GoFakeS3) putObjectACL(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT ACL:", bucket, object)
+
+	grants, err := g.aclFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := g.ensureObjectExists(bucket, object); err != nil {
+		return err
+	}
+
+	g.subresources.SetObjectACL(bucket, object, grants)
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+// getBucketACL returns the bucket's grants. The owner always has
+// FULL_CONTROL of a bucket, so that grant is included even if the ACL that
+// was set did not contain it.
+func (g *GoFakeS3) getBucketACL(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "GET BUCKET ACL:", bucket)
+
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	grants := g.subresources.BucketACL(bucket)
+	if grants == nil {
+		grants, _ = ACLPrivate.Grants(g.owner)
+	}
+
+	out := AccessControlPolicy{
+		Xmlns:             g.xmlns,
+		Owner:             g.ownerInfo(),
+		AccessControlList: withOwnerFullControl(grants, g.owner),
+	}
+	return g.xmlResponse(w, out)
+}
+
+func (g *GoFakeS3) putBucketACL(bucket string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "PUT BUCKET ACL:", bucket)
+
+	grants, err := g.aclFromRequest(r)
+	if err != nil {
+		return err
+	}
+	if err := g.ensureBucketExists(bucket); err != nil {
+		return err
+	}
+
+	g.subresources.SetBucketACL(bucket, grants)
+	emptyResponse(w, http.StatusOK)
+	return nil
+}
+
+// cannedACLFromHeader returns the grants for the canned ACL in the 'x-amz-acl'
+// header, or nil if the header was not passed.
+func (g *GoFakeS3) cannedACLFromHeader(r *http.Request) ([]Grant, error) {
+	canned := r.Header.Get("x-amz-acl")
+	if canned == "" {
+		return nil, nil
+	}
+	return CannedACL(canned).Grants(g.owner)
+}
+
+// aclFromRequest reads the grants for a PUT to the '?acl' subresource, which
+// come from either the 'x-amz-acl' header or an AccessControlPolicy body, but
+// not both.
+func (g *GoFakeS3) aclFromRequest(r *http.Request) ([]Grant, error) {
+	body, err := ioutil.ReadAll(r.Body)
+	defer r.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+
+	grants, err := g.cannedACLFromHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if grants != nil {
+		if len(body) > 0 {
+			return nil, ErrorMessage(ErrInvalidRequest, "Specifying both a canned ACL and an AccessControlPolicy body is not allowed")
 		}
+		return grants, nil
+	}
+
+	if len(body) == 0 {
+		return nil, ErrorMessage(ErrInvalidRequest, "An x-amz-acl header or an AccessControlPolicy body is required")
 	}
 
-	g.log.Print(LogInfo, "PUT VERSIONING:", in.Status)
-	return g.versioned.SetVersioningConfiguration(bucket, in)
+	var in AccessControlPolicy
+	if err := xml.Unmarshal(body, &in); err != nil {
+		return nil, ErrorMessage(ErrMalformedACLError, err.Error())
+	}
+	if err := validateGrants(in.AccessControlList); err != nil {
+		return nil, err
+	}
+	if in.AccessControlList == nil {
+		in.AccessControlList = []Grant{}
+	}
+	return in.AccessControlList, nil
+}
+
+func (g *GoFakeS3) ensureObjectExists(bucket, object string) error {
+	obj, err := g.storage.HeadObject(bucket, object)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		return KeyNotFound(object)
+	}
+	if obj.Contents != nil {
+		obj.Contents.Close()
+	}
+	return nil
 }
 
 func (g *GoFakeS3) ensureBucketExists(bucket string) error {
@@ -862,12 +2734,76 @@ func (g *GoFakeS3) ensureBucketExists(bucket string) error {
 	return nil
 }
 
-func (g *GoFakeS3) xmlEncoder(w http.ResponseWriter) *xml.Encoder {
-	w.Write([]byte(xml.Header))
+// xmlResponse writes v to the response as an XML document. The document is
+// encoded in full before anything is written, so headers that depend on the
+// body can be sent, and encoding errors can still be reported to the client.
+func (g *GoFakeS3) xmlResponse(w http.ResponseWriter, v interface{}) error {
+	return g.xmlResponseStatus(w, http.StatusOK, v)
+}
+
+// xmlResponseStatus is xmlResponse with a status other than '200 OK'.
+func (g *GoFakeS3) xmlResponseStatus(w http.ResponseWriter, status int, v interface{}) error {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	if err := g.newXMLEncoder(&buf).Encode(v); err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if g.responseChecksums {
+		sum := md5.Sum(buf.Bytes())
+		w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	w.WriteHeader(status)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// xmlStreamResponse writes v to the response as an XML document, like
+// xmlResponse, but encodes it straight into the response rather than into a
+// buffer first. This is used for listings, which can be large.
+//
+// As the status has already been sent by the time v is encoded, an encoding
+// error can't be reported to the client; it is logged instead, and the
+// client is left with a truncated document. If WithResponseChecksums is set,
+// the document must be buffered to work out its checksum, so xmlResponse is
+// used instead.
+func (g *GoFakeS3) xmlStreamResponse(w http.ResponseWriter, v interface{}) error {
+	if g.responseChecksums {
+		return g.xmlResponse(w, v)
+	}
+
 	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil // The client has gone away; there's no one to tell.
+	}
+	if err := g.newXMLEncoder(w).Encode(v); err != nil {
+		g.log.Print(LogErr, "xml encoding failed after the response started:", err)
+	}
+	return nil
+}
+
+// emptyResponse completes a response that has no body. Content-Length is
+// sent explicitly, as some clients and proxies refuse an empty response
+// without one; it is left out of 204 responses, where RFC 7230 forbids it.
+func emptyResponse(w http.ResponseWriter, status int) {
+	if status != http.StatusNoContent {
+		w.Header().Set("Content-Length", "0")
+	}
+	w.WriteHeader(status)
+}
 
+// newXMLEncoder returns an encoder for the body of an XML response, which is
+// indented unless WithCompactXML is set.
+func (g *GoFakeS3) newXMLEncoder(w io.Writer) *xml.Encoder {
 	xe := xml.NewEncoder(w)
-	xe.Indent("", "  ")
+	if !g.compactXML {
+		xe.Indent("", "  ")
+	}
 	return xe
 }
 
@@ -894,6 +2830,19 @@ func formatHeaderTime(t time.Time) string {
 	return tc.Format("Mon, 02 Jan 2006 15:04:05") + " GMT"
 }
 
+// parseHeaderTime parses a date from a request header. As well as the formats
+// accepted by http.ParseTime, it accepts the unpadded day of the month that
+// some SDKs send (see formatHeaderTime).
+func parseHeaderTime(v string) (time.Time, error) {
+	t, err := http.ParseTime(v)
+	if err != nil {
+		if t, uerr := time.Parse("Mon, 2 Jan 2006 15:04:05 GMT", v); uerr == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
 func metadataSize(meta map[string]string) int {
 	total := 0
 	for k, v := range meta {
@@ -902,24 +2851,144 @@ func metadataSize(meta map[string]string) int {
 	return total
 }
 
-func metadataHeaders(headers map[string][]string, at time.Time, sizeLimit int) (map[string]string, error) {
+// systemMetadataHeaders are the standard HTTP headers that S3 stores with an
+// object when it is created, and returns unchanged when it is retrieved. In
+// particular, a 'Content-Encoding: gzip' object is stored and served as the
+// compressed bytes it was uploaded as.
+//
+// Cache-Control and Expires are only there for the benefit of caches in front
+// of S3; as in S3, an object is still served after its Expires date.
+var systemMetadataHeaders = []string{
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Content-Type",
+	"Expires",
+}
+
+// serverSideEncryptionHeaders are the encryption settings S3 echoes back
+// when an object is written. GoFakeS3 does not encrypt anything, but clients
+// may check that the settings they asked for were applied. The customer key
+// itself is never echoed, only its MD5.
+var serverSideEncryptionHeaders = []string{
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+	"X-Amz-Server-Side-Encryption-Bucket-Key-Enabled",
+	"X-Amz-Server-Side-Encryption-Context",
+	"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+	"X-Amz-Server-Side-Encryption-Customer-Key-Md5",
+}
+
+func writeServerSideEncryptionHeaders(hdr http.Header, meta map[string]string) {
+	for _, k := range serverSideEncryptionHeaders {
+		if v, ok := meta[k]; ok {
+			hdr.Set(k, v)
+		}
+	}
+}
+
+// metadataLimits are the limits applied to the metadata of a new object. A
+// limit of 0 disables it. See WithMetadataSizeLimit, WithMetadataEntryLimit
+// and WithMetadataValueSizeLimit.
+type metadataLimits struct {
+	size      int
+	entries   int
+	valueSize int
+}
+
+func (g *GoFakeS3) metadataLimits() metadataLimits {
+	return metadataLimits{
+		size:      g.metadataSizeLimit,
+		entries:   g.metadataEntryLimit,
+		valueSize: g.metadataValueSizeLimit,
+	}
+}
+
+// metadataHeaders extracts the metadata to store with a new object from the
+// request headers, or from the form fields of a browser upload. Header names
+// are canonicalized, unless preserveCase is set (see
+// WithPreserveMetadataCase), in which case the names of 'x-amz-meta-*'
+// entries are kept as they were given.
+func metadataHeaders(headers map[string][]string, at time.Time, limits metadataLimits, preserveCase bool) (map[string]string, error) {
 	meta := make(map[string]string)
+	entries := 0
 	for hk, hv := range headers {
-		if strings.HasPrefix(hk, "X-Amz-") {
-			meta[hk] = hv[0]
+		ck := textproto.CanonicalMIMEHeaderKey(hk)
+		if strings.HasPrefix(ck, "X-Amz-") && len(hv) > 0 {
+			key := ck
+			if strings.HasPrefix(ck, "X-Amz-Meta-") {
+				if preserveCase {
+					key = hk
+				}
+				entries++
+				if limits.valueSize > 0 && len(hv[0]) > limits.valueSize {
+					return meta, ErrorMessagef(ErrMetadataTooLarge, "Your metadata header %s exceeds the maximum allowed size of %d bytes.", key, limits.valueSize)
+				}
+			}
+			meta[key] = hv[0]
 		}
 	}
 	meta["Last-Modified"] = formatHeaderTime(at)
 
-	if sizeLimit > 0 && metadataSize(meta) > sizeLimit {
+	if limits.size > 0 && metadataSize(meta) > limits.size {
 		return meta, ErrMetadataTooLarge
 	}
+	if limits.entries > 0 && entries > limits.entries {
+		return meta, ErrorMessagef(ErrMetadataTooLarge, "Your metadata headers contain %d entries, which exceeds the maximum allowed number of entries: %d.", entries, limits.entries)
+	}
+
+	// System metadata does not count towards the size limit. Browser uploads
+	// pass form fields here, which are not in canonical form:
+	for hk, hv := range headers {
+		hk = textproto.CanonicalMIMEHeaderKey(hk)
+		for _, sys := range systemMetadataHeaders {
+			if hk == sys && len(hv) > 0 {
+				meta[hk] = hv[0]
+			}
+		}
+	}
 
 	return meta, nil
 }
 
-func listBucketPageFromQuery(query url.Values) (page ListBucketPage, rerr error) {
-	maxKeys, err := parseClampedInt(query.Get("max-keys"), DefaultMaxBucketKeys, 0, MaxBucketKeys)
+type copySource struct {
+	bucket    string
+	object    string
+	versionID VersionID
+}
+
+// parseCopySource parses the x-amz-copy-source header, which is in the form
+// '/bucket/object', optionally followed by '?versionId=<id>'. The leading
+// slash is optional and the path may be URL-encoded.
+func parseCopySource(hdr string) (src copySource, err error) {
+	path, rawQuery := hdr, ""
+	if idx := strings.IndexByte(hdr, '?'); idx >= 0 {
+		path, rawQuery = hdr[:idx], hdr[idx+1:]
+	}
+
+	path, err = url.PathUnescape(path)
+	if err != nil {
+		return src, ErrorInvalidArgument("x-amz-copy-source", hdr, "Invalid copy source encoding")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return src, ErrorInvalidArgument("x-amz-copy-source", hdr, "Copy Source must mention the source bucket and key: sourcebucket/sourcekey")
+	}
+	src.bucket, src.object = parts[0], parts[1]
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return src, ErrorInvalidArgument("x-amz-copy-source", hdr, "Invalid copy source encoding")
+	}
+	src.versionID = VersionID(versionFromQuery(query["versionId"]))
+
+	return src, nil
+}
+
+func listBucketPageFromQuery(query url.Values, mode clampMode) (page ListBucketPage, rerr error) {
+	maxKeys, err := parseLimit("max-keys", query.Get("max-keys"), DefaultMaxBucketKeys, MaxBucketKeys, mode)
 	if err != nil {
 		return page, err
 	}
@@ -951,8 +3020,25 @@ func listBucketPageFromQuery(query url.Values) (page ListBucketPage, rerr error)
 	return page, nil
 }
 
-func listBucketVersionsPageFromQuery(query url.Values) (page ListBucketVersionsPage, rerr error) {
-	maxKeys, err := parseClampedInt(query.Get("max-keys"), DefaultMaxBucketVersionKeys, 0, MaxBucketVersionKeys)
+// listEncodingTypeFromQuery returns the 'encoding-type' requested for a bucket
+// listing, which S3 only allows to be "url" (or missing).
+func listEncodingTypeFromQuery(query url.Values) (string, error) {
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		return "", ErrorInvalidArgument("encoding-type", encodingType, "Invalid Encoding Method specified in Request")
+	}
+	return encodingType, nil
+}
+
+// listURLEncode encodes a value in a bucket listing requested with
+// 'encoding-type=url'. S3 form-encodes these values (so a space becomes a
+// '+'), but leaves the '/' path separators alone.
+func listURLEncode(v string) string {
+	return strings.Replace(url.QueryEscape(v), "%2F", "/", -1)
+}
+
+func listBucketVersionsPageFromQuery(query url.Values, mode clampMode) (page ListBucketVersionsPage, rerr error) {
+	maxKeys, err := parseLimit("max-keys", query.Get("max-keys"), DefaultMaxBucketVersionKeys, MaxBucketVersionKeys, mode)
 	if err != nil {
 		return page, err
 	}
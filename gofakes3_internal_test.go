@@ -55,17 +55,36 @@ func TestHttpErrorWriteFailure(t *testing.T) {
 
 func TestHostBucketMiddleware(t *testing.T) {
 	for _, tc := range []struct {
+		base string
 		in   string
 		host string
 		out  string
 	}{
-		{"/", "foo", "/foo"},
-		{"/", "mybucket.localhost", "/mybucket"},
-		{"/object", "mybucket.localhost", "/mybucket/object"},
+		{"", "/", "mybucket.localhost", "/mybucket"},
+		{"", "/object", "mybucket.localhost", "/mybucket/object"},
+		{"", "/object", "mybucket.localhost:9000", "/mybucket/object"},
+
+		// Hosts that don't encode a bucket are routed path-style:
+		{"", "/", "localhost", "/"},
+		{"", "/mybucket/object", "localhost", "/mybucket/object"},
+		{"", "/mybucket/object", "localhost:9000", "/mybucket/object"},
+		{"", "/mybucket/object", "127.0.0.1:9000", "/mybucket/object"},
+		{"", "/mybucket/object", "[::1]:9000", "/mybucket/object"},
+		{"", "/mybucket/object", "::1", "/mybucket/object"},
+
+		// With a base domain, the bucket is everything to its left:
+		{"s3.example.com", "/object", "mybucket.s3.example.com", "/mybucket/object"},
+		{"s3.example.com", "/object", "my.dotted.bucket.s3.example.com:9000", "/my.dotted.bucket/object"},
+		{"s3.example.com", "/object", "MyBucket.S3.Example.com.", "/mybucket/object"},
+		{"s3.example.com", "/mybucket/object", "s3.example.com", "/mybucket/object"},
+		{"s3.example.com", "/mybucket/object", "s3.example.com:9000", "/mybucket/object"},
+		{"s3.example.com", "/mybucket/object", "mybucket.localhost", "/mybucket/object"},
+		{"s3.example.com", "/mybucket/object", "evils3.example.com", "/mybucket/object"},
 	} {
 		t.Run("", func(t *testing.T) {
 			var g GoFakeS3
 			g.log = DiscardLog()
+			g.hostBucketBase = tc.base
 
 			inner := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 				if r.URL.Path != tc.out {
@@ -89,3 +108,34 @@ type failingResponseWriter struct {
 func (w *failingResponseWriter) Write(buf []byte) (n int, err error) {
 	return 0, fmt.Errorf("nope")
 }
+
+func TestParseCopySource(t *testing.T) {
+	for idx, tc := range []struct {
+		in      string
+		out     copySource
+		errCode ErrorCode
+	}{
+		{"bucket/object", copySource{"bucket", "object", ""}, ErrNone},
+		{"/bucket/object", copySource{"bucket", "object", ""}, ErrNone},
+		{"/bucket/dir/object", copySource{"bucket", "dir/object", ""}, ErrNone},
+		{"/bucket/my%20object%3Fyep", copySource{"bucket", "my object?yep", ""}, ErrNone},
+		{"/bucket/object?versionId=abc", copySource{"bucket", "object", "abc"}, ErrNone},
+		{"/bucket/object?versionId=a%2Bb", copySource{"bucket", "object", "a+b"}, ErrNone},
+		{"/bucket/object?versionId=null", copySource{"bucket", "object", ""}, ErrNone},
+
+		{"", copySource{}, ErrInvalidArgument},
+		{"/bucket", copySource{}, ErrInvalidArgument},
+		{"/bucket/", copySource{}, ErrInvalidArgument},
+		{"/bucket/%zz", copySource{}, ErrInvalidArgument},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			src, err := parseCopySource(tc.in)
+			if !HasErrorCode(err, tc.errCode) {
+				t.Fatal("expected error code", tc.errCode, "found", err)
+			}
+			if err == nil && src != tc.out {
+				t.Fatalf("unexpected copy source %+v, expected %+v", src, tc.out)
+			}
+		})
+	}
+}
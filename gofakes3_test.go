@@ -2,21 +2,35 @@ package gofakes3_test
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
 	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"net/http/httputil"
+	"net/url"
+	"os"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/johannesboyne/gofakes3"
 	"github.com/johannesboyne/gofakes3/backend/s3mem"
@@ -50,6 +64,183 @@ func TestCreateBucket(t *testing.T) {
 	}))
 }
 
+func TestCreateBucketAlreadyOwnedByYou(t *testing.T) {
+	ts := newTestServer(t, withoutInitialBuckets())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String("testbucket"),
+	}))
+
+	_, err := svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String("testbucket"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrBucketAlreadyOwnedByYou) {
+		t.Fatal("expected ErrBucketAlreadyOwnedByYou, found", err)
+	}
+}
+
+func TestCreateBucketMaxBuckets(t *testing.T) {
+	ts := newTestServer(t, withoutInitialBuckets(), withFakerOptions(gofakes3.WithMaxBuckets(2)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	create := func(bucket string) error {
+		_, err := svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String(bucket)})
+		return err
+	}
+
+	ts.OK(create("bucket1"))
+	ts.OK(create("bucket2"))
+	if err := create("bucket3"); !hasErrorCode(err, gofakes3.ErrTooManyBuckets) {
+		t.Fatal("expected ErrTooManyBuckets, found", err)
+	}
+
+	// An existing bucket is reported as such, even at the limit:
+	if err := create("bucket1"); !hasErrorCode(err, gofakes3.ErrBucketAlreadyOwnedByYou) {
+		t.Fatal("expected ErrBucketAlreadyOwnedByYou, found", err)
+	}
+
+	// Deleting a bucket frees up space for another:
+	ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("bucket2")}))
+	ts.OK(create("bucket3"))
+}
+
+func TestCreateBucketLocationConstraint(t *testing.T) {
+	ts := newTestServer(t, withoutInitialBuckets(), withFakerOptions(gofakes3.WithRegion("eu-west-2")))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	location := func(bucket string) string {
+		t.Helper()
+		out, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+		ts.OK(err)
+		return aws.StringValue(out.LocationConstraint)
+	}
+
+	ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String("constrained"),
+		CreateBucketConfiguration: &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String("eu-west-2"),
+		},
+	}))
+	if loc := location("constrained"); loc != "eu-west-2" {
+		t.Fatal("unexpected location", loc)
+	}
+
+	// An empty body defaults to the server's region. The SDK always sends
+	// its own region, so this has to be done by hand:
+	rq, err := http.NewRequest("PUT", ts.url("/unconstrained"), nil)
+	ts.OK(err)
+	rs, err := httpClient().Do(rq)
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+	if loc := location("unconstrained"); loc != "eu-west-2" {
+		t.Fatal("unexpected location", loc)
+	}
+
+	_, err = svc.CreateBucket(&s3.CreateBucketInput{
+		Bucket: aws.String("elsewhere"),
+		CreateBucketConfiguration: &s3.CreateBucketConfiguration{
+			LocationConstraint: aws.String("ap-southeast-2"),
+		},
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidLocationConstraint) {
+		t.Fatal("expected InvalidLocationConstraint, found", err)
+	}
+	if _, err := svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("elsewhere")}); err == nil {
+		t.Fatal("bucket should not have been created")
+	}
+
+	t.Run("malformed", func(t *testing.T) {
+		rq, err := http.NewRequest("PUT", ts.url("/malformed"), strings.NewReader("<CreateBucketConfiguration>"))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusBadRequest {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+	})
+
+	t.Run("without-region", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		// The SDK sends its own region as the LocationConstraint, which is
+		// accepted as-is if the server's region has not been set:
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("bucket")}))
+		ts.backendCreateBucket("backend")
+		for bucket, expected := range map[string]string{"bucket": "region", "backend": ""} {
+			out, err := svc.GetBucketLocation(&s3.GetBucketLocationInput{Bucket: aws.String(bucket)})
+			ts.OK(err)
+			if aws.StringValue(out.LocationConstraint) != expected {
+				t.Fatal("unexpected location", bucket, aws.StringValue(out.LocationConstraint))
+			}
+		}
+	})
+}
+
+func TestAutoBucket(t *testing.T) {
+	t.Run("put", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets(), withFakerOptions(gofakes3.WithAutoBucket()))
+		defer ts.Close()
+
+		ts.OKAll(ts.s3Client().PutObject(&s3.PutObjectInput{
+			Bucket: aws.String("auto"),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		}))
+		ts.assertObject("auto", "object", nil, "hello")
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets(), withFakerOptions(gofakes3.WithAutoBucket()))
+		defer ts.Close()
+
+		id := ts.createMultipartUpload("auto", "object", nil)
+		ts.uploadPart("auto", "object", id, 1, []byte("hello"))
+		exists, err := ts.backend.BucketExists("auto")
+		ts.OK(err)
+		if !exists {
+			t.Fatal("bucket was not created")
+		}
+	})
+
+	t.Run("invalid-name", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets(), withFakerOptions(gofakes3.WithAutoBucket()))
+		defer ts.Close()
+
+		_, err := ts.s3Client().PutObject(&s3.PutObjectInput{
+			Bucket: aws.String("no_underscores"),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidBucketName) {
+			t.Fatal("expected ErrInvalidBucketName, found", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+
+		_, err := ts.s3Client().PutObject(&s3.PutObjectInput{
+			Bucket: aws.String("auto"),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte("hello")),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+			t.Fatal("expected ErrNoSuchBucket, found", err)
+		}
+	})
+}
+
 func TestListBuckets(t *testing.T) {
 	ts := newTestServer(t, withoutInitialBuckets())
 	defer ts.Close()
@@ -108,730 +299,5238 @@ func TestListBuckets(t *testing.T) {
 	assertBucketTime("test3", defaultDate.Add(1*time.Minute))
 }
 
-func TestCreateObject(t *testing.T) {
-	ts := newTestServer(t)
+func TestListBucketsOwnerAndCreationDate(t *testing.T) {
+	// Use a non-UTC zone to ensure the creation date is converted before it is
+	// formatted:
+	created := time.Date(2018, 1, 1, 22, 0, 0, 0, time.FixedZone("AEST", 10*60*60))
+	ts := newTestServer(t,
+		withoutInitialBuckets(),
+		withBackend(s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(created)))),
+		withFakerOptions(gofakes3.WithOwner("owner-id", "owner-name")),
+	)
 	defer ts.Close()
-	svc := ts.s3Client()
+	ts.backendCreateBucket("test")
 
-	out, err := svc.PutObject(&s3.PutObjectInput{
-		Bucket: aws.String(defaultBucket),
-		Key:    aws.String("object"),
-		Body:   bytes.NewReader([]byte("hello")),
-	})
+	rs, err := httpClient().Get(ts.url("/"))
 	ts.OK(err)
+	defer rs.Body.Close()
 
-	if *out.ETag != `"5d41402abc4b2a76b9719d911017c592"` { // md5("hello")
-		ts.Fatal("bad etag", out.ETag)
+	var result struct {
+		Owner struct {
+			ID          string `xml:"ID"`
+			DisplayName string `xml:"DisplayName"`
+		} `xml:"Owner"`
+		Buckets []struct {
+			Name         string `xml:"Name"`
+			CreationDate string `xml:"CreationDate"`
+		} `xml:"Buckets>Bucket"`
 	}
+	ts.OK(xml.NewDecoder(rs.Body).Decode(&result))
 
-	obj := ts.backendGetString(defaultBucket, "object", nil)
-	if obj != "hello" {
-		t.Fatal("object creation failed")
+	if result.Owner.ID != "owner-id" || result.Owner.DisplayName != "owner-name" {
+		t.Fatal("unexpected owner", result.Owner)
+	}
+	if len(result.Buckets) != 1 {
+		t.Fatal("unexpected buckets", result.Buckets)
+	}
+	if result.Buckets[0].CreationDate != "2018-01-01T12:00:00.000Z" {
+		t.Fatal("unexpected creation date", result.Buckets[0].CreationDate)
 	}
-}
-
-func TestCreateObjectMetadataSizeLimit(t *testing.T) {
-	ts := newTestServer(t, withFakerOptions(
-		gofakes3.WithMetadataSizeLimit(1),
-	))
-	defer ts.Close()
-	svc := ts.s3Client()
 
-	_, err := svc.PutObject(&s3.PutObjectInput{
-		Bucket:   aws.String(defaultBucket),
-		Key:      aws.String("object"),
-		Metadata: map[string]*string{"too": aws.String("big")},
-		Body:     bytes.NewReader([]byte("hello")),
-	})
-	if !hasErrorCode(err, gofakes3.ErrMetadataTooLarge) {
-		t.Fatal(err)
+	// The SDK must also be able to parse it:
+	out, err := ts.s3Client().ListBuckets(&s3.ListBucketsInput{})
+	ts.OK(err)
+	if !out.Buckets[0].CreationDate.Equal(created) {
+		t.Fatal("creation date mismatch", *out.Buckets[0].CreationDate, "!=", created)
+	}
+	if aws.StringValue(out.Owner.ID) != "owner-id" {
+		t.Fatal("unexpected owner", out.Owner)
 	}
 }
 
-func TestCreateObjectMD5(t *testing.T) {
+func TestTimestampFormats(t *testing.T) {
 	ts := newTestServer(t)
 	defer ts.Close()
 	svc := ts.s3Client()
 
-	{ // md5 is valid base64 but does not match content:
-		_, err := svc.PutObject(&s3.PutObjectInput{
-			Bucket:     aws.String(defaultBucket),
-			Key:        aws.String("invalid"),
-			Body:       bytes.NewReader([]byte("hello")),
-			ContentMD5: aws.String("bnVwCg=="),
-		})
-		if !s3HasErrorCode(err, gofakes3.ErrInvalidDigest) {
-			t.Fatal("expected InvalidDigest error, found", err)
-		}
+	// Milliseconds make it possible to tell the header format (which has
+	// none) from the XML format (which always has them):
+	ts.Advance(789 * time.Millisecond)
+	created := defaultDate.Add(789 * time.Millisecond)
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	ts.Advance(time.Hour)
+	now := created.Add(time.Hour)
+
+	getRaw := func(path string) (http.Header, string) {
+		t.Helper()
+		rs, err := httpClient().Get(ts.url(path))
+		ts.OK(err)
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs.Header, string(body)
 	}
 
-	{ // md5 is invalid base64:
-		_, err := svc.PutObject(&s3.PutObjectInput{
-			Bucket:     aws.String(defaultBucket),
-			Key:        aws.String("invalid"),
-			Body:       bytes.NewReader([]byte("hello")),
-			ContentMD5: aws.String("!*@&(*$&"),
+	t.Run("header", func(t *testing.T) {
+		// The object's Last-Modified is when it was written, not when it was
+		// read, and is RFC1123 in GMT:
+		hdr, _ := getRaw("/" + defaultBucket + "/object")
+		if lm := hdr.Get("Last-Modified"); lm != "Mon, 01 Jan 2018 12:00:00 GMT" {
+			t.Fatal("unexpected Last-Modified", lm)
+		}
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
 		})
-		if !s3HasErrorCode(err, gofakes3.ErrInvalidDigest) {
-			t.Fatal("expected InvalidDigest error, found", err)
+		ts.OK(err)
+		if lm := aws.TimeValue(head.LastModified); !lm.Equal(created.Truncate(time.Second)) {
+			t.Fatal("unexpected LastModified", lm)
 		}
-	}
-
-	if ts.backendObjectExists(defaultBucket, "invalid") {
-		t.Fatal("unexpected object")
-	}
-}
+	})
 
-func TestDeleteBucket(t *testing.T) {
-	t.Run("delete-empty", func(t *testing.T) {
-		ts := newTestServer(t, withoutInitialBuckets())
-		defer ts.Close()
-		svc := ts.s3Client()
+	t.Run("listing", func(t *testing.T) {
+		_, body := getRaw("/" + defaultBucket + "?list-type=2")
+		if !strings.Contains(body, "<LastModified>2018-01-01T12:00:00.789Z</LastModified>") {
+			t.Fatal("unexpected listing", body)
+		}
+		out, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if lm := aws.TimeValue(out.Contents[0].LastModified); !lm.Equal(created) {
+			t.Fatal("unexpected LastModified", lm)
+		}
+	})
 
-		ts.backendCreateBucket("test")
-		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{
-			Bucket: aws.String("test"),
-		}))
+	t.Run("copy", func(t *testing.T) {
+		out, err := svc.CopyObject(&s3.CopyObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("copy"),
+			CopySource: aws.String(defaultBucket + "/object"),
+		})
+		ts.OK(err)
+		if lm := aws.TimeValue(out.CopyObjectResult.LastModified); !lm.Equal(now) {
+			t.Fatal("unexpected LastModified", lm)
+		}
 	})
 
-	t.Run("delete-fails-if-not-empty", func(t *testing.T) {
-		ts := newTestServer(t, withoutInitialBuckets())
-		defer ts.Close()
-		svc := ts.s3Client()
+	t.Run("multipart", func(t *testing.T) {
+		id := ts.createMultipartUpload(defaultBucket, "multi", nil)
+		ts.uploadPart(defaultBucket, "multi", id, 1, []byte("hello"))
 
-		ts.backendCreateBucket("test")
-		ts.backendPutString("test", "test", nil, "test")
-		_, err := svc.DeleteBucket(&s3.DeleteBucketInput{
-			Bucket: aws.String("test"),
+		uploads, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if initiated := aws.TimeValue(uploads.Uploads[0].Initiated); !initiated.Equal(now) {
+			t.Fatal("unexpected Initiated", initiated)
+		}
+
+		parts, err := svc.ListParts(&s3.ListPartsInput{
+			Bucket:   aws.String(defaultBucket),
+			Key:      aws.String("multi"),
+			UploadId: aws.String(id),
 		})
-		if !hasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
-			t.Fatal("expected ErrBucketNotEmpty, found", err)
+		ts.OK(err)
+		if lm := aws.TimeValue(parts.Parts[0].LastModified); !lm.Equal(now) {
+			t.Fatal("unexpected LastModified", lm)
 		}
 	})
 }
 
-func TestDeleteMulti(t *testing.T) {
-	deletedKeys := func(rs *s3.DeleteObjectsOutput) []string {
-		deleted := make([]string, len(rs.Deleted))
-		for idx, del := range rs.Deleted {
-			deleted[idx] = *del.Key
+func TestOwnerInListings(t *testing.T) {
+	ts := newTestServer(t,
+		withVersioning(),
+		withFakerOptions(gofakes3.WithOwner("owner-id", "owner-name")),
+	)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+	ts.backendPutString(defaultBucket, "object2", nil, "hello")
+
+	assertOwner := func(owner *s3.Owner) {
+		t.Helper()
+		if owner == nil || aws.StringValue(owner.ID) != "owner-id" || aws.StringValue(owner.DisplayName) != "owner-name" {
+			t.Fatal("unexpected owner", owner)
 		}
-		sort.Strings(deleted)
-		return deleted
 	}
 
-	assertDeletedKeys := func(t *testing.T, rs *s3.DeleteObjectsOutput, expected ...string) {
-		t.Helper()
-		found := deletedKeys(rs)
-		if !reflect.DeepEqual(found, expected) {
-			t.Fatal("multi deletion failed", found, "!=", expected)
+	{ // ListObjects always includes the owner:
+		out, err := svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		assertOwner(out.Contents[0].Owner)
+	}
+
+	{ // ListObjectsV2 only includes the owner if requested:
+		out, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if out.Contents[0].Owner != nil {
+			t.Fatal("unexpected owner", out.Contents[0].Owner)
+		}
+
+		out, err = svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket), FetchOwner: aws.Bool(false)})
+		ts.OK(err)
+		if out.Contents[0].Owner != nil {
+			t.Fatal("unexpected owner", out.Contents[0].Owner)
+		}
+
+		out, err = svc.ListObjectsV2(&s3.ListObjectsV2Input{Bucket: aws.String(defaultBucket), FetchOwner: aws.Bool(true)})
+		ts.OK(err)
+		for _, c := range out.Contents {
+			assertOwner(c.Owner)
 		}
 	}
 
-	t.Run("one-file", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		svc := ts.s3Client()
+	{
+		out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		assertOwner(out.Versions[0].Owner)
+	}
 
-		ts.backendPutString(defaultBucket, "foo", nil, "one")
-		ts.backendPutString(defaultBucket, "bar", nil, "two")
-		ts.backendPutString(defaultBucket, "baz", nil, "three")
+	{
+		uploadID := ts.createMultipartUpload(defaultBucket, "upload", nil)
+		ts.uploadPart(defaultBucket, "upload", uploadID, 1, []byte("part"))
 
-		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(defaultBucket),
-			Delete: &s3.Delete{
-				Objects: []*s3.ObjectIdentifier{
-					{Key: aws.String("foo")},
-				},
-			},
+		uploads, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		assertOwner(uploads.Uploads[0].Owner)
+		if aws.StringValue(uploads.Uploads[0].Initiator.ID) != "owner-id" {
+			t.Fatal("unexpected initiator", uploads.Uploads[0].Initiator)
+		}
+
+		parts, err := svc.ListParts(&s3.ListPartsInput{
+			Bucket:   aws.String(defaultBucket),
+			Key:      aws.String("upload"),
+			UploadId: aws.String(uploadID),
 		})
 		ts.OK(err)
-		assertDeletedKeys(t, rs, "foo")
-		ts.assertLs(defaultBucket, "", nil, []string{"bar", "baz"})
-	})
+		assertOwner(parts.Owner)
+	}
+}
 
-	t.Run("multiple-files", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		svc := ts.s3Client()
+func TestExpectedBucketOwner(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithOwner("owner-id", "owner-name")))
+	defer ts.Close()
+	svc := ts.s3Client()
+	ctx := aws.BackgroundContext()
 
-		ts.backendPutString(defaultBucket, "foo", nil, "one")
-		ts.backendPutString(defaultBucket, "bar", nil, "two")
-		ts.backendPutString(defaultBucket, "baz", nil, "three")
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
 
-		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
-			Bucket: aws.String(defaultBucket),
-			Delete: &s3.Delete{
-				Objects: []*s3.ObjectIdentifier{
-					{Key: aws.String("bar")},
-					{Key: aws.String("foo")},
-				},
+	// This version of the SDK predates the ExpectedBucketOwner fields:
+	expect := func(hdr, owner string) request.Option {
+		return func(rq *request.Request) { rq.HTTPRequest.Header.Set(hdr, owner) }
+	}
+	getInput := &s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	}
+
+	// Without the header, requests behave as usual:
+	ts.OKAll(svc.GetObject(getInput))
+	ts.OKAll(svc.GetObjectWithContext(ctx, getInput, expect("x-amz-expected-bucket-owner", "owner-id")))
+
+	_, err := svc.GetObjectWithContext(ctx, getInput, expect("x-amz-expected-bucket-owner", "someone-else"))
+	if !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
+
+	_, err = svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("denied"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}, expect("x-amz-expected-bucket-owner", "someone-else"))
+	if !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
+	if ts.backendObjectExists(defaultBucket, "denied") {
+		t.Fatal("object should not exist")
+	}
+
+	copyInput := &s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	}
+	_, err = svc.CopyObjectWithContext(ctx, copyInput, expect("x-amz-source-expected-bucket-owner", "someone-else"))
+	if !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected AccessDenied, found", err)
+	}
+	ts.OKAll(svc.CopyObjectWithContext(ctx, copyInput,
+		expect("x-amz-expected-bucket-owner", "owner-id"),
+		expect("x-amz-source-expected-bucket-owner", "owner-id")))
+}
+
+func TestStrictMode(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithStrictMode()))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// The SDK's requests must all be accepted:
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dir/object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	ts.OKAll(svc.GetObject(&s3.GetObjectInput{
+		Bucket:              aws.String(defaultBucket),
+		Key:                 aws.String("dir/object"),
+		ResponseContentType: aws.String("text/plain"),
+	}))
+	ts.OKAll(svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:     aws.String(defaultBucket),
+		Prefix:     aws.String("dir/"),
+		Delimiter:  aws.String("/"),
+		MaxKeys:    aws.Int64(10),
+		StartAfter: aws.String("a"),
+		FetchOwner: aws.Bool(true),
+	}))
+	id := ts.createMultipartUpload(defaultBucket, "multi", nil)
+	part := ts.uploadPart(defaultBucket, "multi", id, 1, []byte("hello"))
+	ts.OKAll(svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("multi"),
+		UploadId:        aws.String(id),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{part}},
+	}))
+
+	rq, _ := svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dir/object"),
+	})
+	presigned, err := rq.Presign(time.Minute)
+	ts.OK(err)
+	rs, err := httpClient().Get(presigned)
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status for presigned URL", rs.StatusCode)
+	}
+
+	rs, err = httpClient().Get(ts.url("/" + defaultBucket + "?delimeter=/"))
+	ts.OK(err)
+	body, err := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	ts.OK(err)
+	if rs.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), "<Code>InvalidArgument</Code>") ||
+		!strings.Contains(string(body), "<ArgumentName>delimeter</ArgumentName>") {
+		t.Fatal("unexpected response", rs.StatusCode, string(body))
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		rs, err := httpClient().Get(ts.url("/" + defaultBucket + "?delimeter=/"))
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+	})
+}
+
+func TestStrictLimits(t *testing.T) {
+	get := func(ts *testServer, path string) (status int, body string) {
+		ts.Helper()
+		rs, err := httpClient().Get(ts.url(path))
+		ts.OK(err)
+		defer rs.Body.Close()
+		bts, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs.StatusCode, string(bts)
+	}
+
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithStrictLimits()))
+	defer ts.Close()
+	id := ts.createMultipartUpload(defaultBucket, "multi", nil)
+
+	for _, tc := range []struct {
+		path string
+		arg  string
+	}{
+		{"/" + defaultBucket + "?uploads&max-uploads=-1", "max-uploads"},
+		{"/" + defaultBucket + "?uploads&max-uploads=1001", "max-uploads"},
+		{"/" + defaultBucket + "?max-keys=-1", "max-keys"},
+		{"/" + defaultBucket + "?list-type=2&max-keys=1001", "max-keys"},
+		{"/" + defaultBucket + "?versions&max-keys=1001", "max-keys"},
+		{"/" + defaultBucket + "/multi?uploadId=" + id + "&max-parts=1001", "max-parts"},
+		{"/" + defaultBucket + "/multi?uploadId=" + id + "&part-number-marker=-1", "part-number-marker"},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			status, body := get(ts, tc.path)
+			if status != http.StatusBadRequest || !strings.Contains(body, "<Code>InvalidArgument</Code>") ||
+				!strings.Contains(body, "<ArgumentName>"+tc.arg+"</ArgumentName>") {
+				t.Fatal("unexpected response", status, body)
+			}
+		})
+	}
+
+	// Values within the limits are still accepted:
+	for _, path := range []string{
+		"/" + defaultBucket + "?uploads&max-uploads=1000",
+		"/" + defaultBucket + "?max-keys=0",
+		"/" + defaultBucket + "/multi?uploadId=" + id + "&max-parts=1000",
+	} {
+		if status, body := get(ts, path); status != http.StatusOK {
+			t.Fatal("unexpected response", path, status, body)
+		}
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		ts.createMultipartUpload(defaultBucket, "multi", nil)
+		if status, body := get(ts, "/"+defaultBucket+"?uploads&max-uploads=-1"); status != http.StatusOK {
+			t.Fatal("unexpected response", status, body)
+		}
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithReadOnly()))
+	defer ts.Close()
+	svc := ts.s3Client()
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	// Reads proceed normally:
+	ts.assertObject(defaultBucket, "object", nil, "hello")
+	ts.OKAll(svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	}))
+	ts.OKAll(svc.ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)}))
+	ts.OKAll(svc.ListBuckets(&s3.ListBucketsInput{}))
+	ts.OKAll(svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)}))
+
+	denied := func(err error) {
+		t.Helper()
+		if !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+			t.Fatal("expected AccessDenied, found", err)
+		}
+	}
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("goodbye")),
+	})
+	denied(err)
+	_, err = svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	denied(err)
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	})
+	denied(err)
+	_, err = svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("newbucket")})
+	denied(err)
+	_, err = svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String(defaultBucket)})
+	denied(err)
+	_, err = svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("multi"),
+	})
+	denied(err)
+	_, err = svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+		Bucket:  aws.String(defaultBucket),
+		Key:     aws.String("object"),
+		Tagging: &s3.Tagging{TagSet: []*s3.Tag{{Key: aws.String("k"), Value: aws.String("v")}}},
+	})
+	denied(err)
+
+	// Requests that don't map to a known operation are denied unless they
+	// are reads:
+	rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"?logging"), nil)
+	ts.OK(err)
+	rs, err := httpClient().Do(rq)
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusForbidden {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
+
+	ts.assertObject(defaultBucket, "object", nil, "hello")
+	if ts.backendObjectExists(defaultBucket, "copy") {
+		t.Fatal("unexpected object")
+	}
+}
+
+func TestStrictHeaders(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithStrictHeaders()))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// Requests signed by the SDK must pass:
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	ts.OKAll(svc.ListBuckets(&s3.ListBucketsInput{}))
+
+	assertFails := func(rq *http.Request, code gofakes3.ErrorCode) {
+		t.Helper()
+		rs := httptest.NewRecorder()
+		ts.Server().ServeHTTP(rs, rq)
+		if rs.Code != code.Status() {
+			t.Fatal("bad status", rs.Code, "!=", code.Status())
+		}
+		var errResp gofakes3.ErrorResponse
+		ts.OK(xml.Unmarshal(rs.Body.Bytes(), &errResp))
+		if errResp.Code != code {
+			t.Fatal("bad code", errResp.Code, "!=", code)
+		}
+	}
+
+	{ // missing host:
+		rq := httptest.NewRequest("GET", "/"+defaultBucket+"/object", nil)
+		rq.Host = ""
+		assertFails(rq, gofakes3.ErrMissingSecurityHeader)
+	}
+
+	{ // signed, but missing date:
+		rq := httptest.NewRequest("GET", "/"+defaultBucket+"/object", nil)
+		rq.Header.Set("Authorization", "AWS AKID:c2lnbmF0dXJl")
+		assertFails(rq, gofakes3.ErrMissingSecurityHeader)
+	}
+
+	{ // malformed authorization:
+		rq := httptest.NewRequest("GET", "/"+defaultBucket+"/object", nil)
+		rq.Header.Set("Authorization", "AWS4-HMAC-SHA256 garbage")
+		rq.Header.Set("x-amz-date", "20180101T120000Z")
+		assertFails(rq, gofakes3.ErrInvalidRequest)
+	}
+}
+
+func TestWithRoute(t *testing.T) {
+	var faker *gofakes3.GoFakeS3
+	healthz := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+	admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		faker.SetMaintenance(r.URL.Query().Get("maintenance") == "on")
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithRoute("/healthz", healthz),
+		gofakes3.WithRoute("/__admin/", admin),
+	))
+	defer ts.Close()
+	faker = ts.GoFakeS3
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	do := func(method, path string) (int, string) {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(path), nil)
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs.StatusCode, string(body)
+	}
+
+	if status, body := do("GET", "/healthz"); status != http.StatusOK || body != "ok" {
+		t.Fatal("unexpected healthz response", status, body)
+	}
+	if status, _ := do("GET", "/"+defaultBucket+"/object"); status != http.StatusOK {
+		t.Fatal("S3 request failed", status)
+	}
+
+	// Routes still respond during maintenance:
+	if status, _ := do("POST", "/__admin/?maintenance=on"); status != http.StatusNoContent {
+		t.Fatal("admin request failed", status)
+	}
+	if status, _ := do("GET", "/"+defaultBucket+"/object"); status != http.StatusServiceUnavailable {
+		t.Fatal("expected maintenance", status)
+	}
+	if status, _ := do("GET", "/healthz"); status != http.StatusOK {
+		t.Fatal("healthz failed during maintenance", status)
+	}
+	if status, _ := do("POST", "/__admin/?maintenance=off"); status != http.StatusNoContent {
+		t.Fatal("admin request failed", status)
+	}
+	if status, _ := do("GET", "/"+defaultBucket+"/object"); status != http.StatusOK {
+		t.Fatal("S3 request failed", status)
+	}
+}
+
+func TestMaintenance(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+	client := httpClient()
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	get := func() *http.Response {
+		t.Helper()
+		rs, err := client.Get(ts.url("/" + defaultBucket + "/object"))
+		ts.OK(err)
+		defer rs.Body.Close()
+		_, err = ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs
+	}
+
+	ts.SetMaintenance(true)
+	for i := 0; i < 3; i++ {
+		rs := get()
+		if rs.StatusCode != http.StatusServiceUnavailable {
+			t.Fatal("bad status", rs.StatusCode)
+		}
+		if rs.Header.Get("Retry-After") != "1" {
+			t.Fatal("bad Retry-After", rs.Header.Get("Retry-After"))
+		}
+	}
+
+	ts.SetMaintenance(false)
+	if rs := get(); rs.StatusCode != http.StatusOK {
+		t.Fatal("bad status", rs.StatusCode)
+	}
+	ts.OKAll(svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	}))
+}
+
+func TestServerHeader(t *testing.T) {
+	get := func(ts *testServer, path string) *http.Response {
+		t.Helper()
+		rs, err := httpClient().Get(ts.url(path))
+		ts.OK(err)
+		rs.Body.Close()
+		return rs
+	}
+
+	t.Run("default", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+		if rs := get(ts, "/"+defaultBucket+"/object"); rs.Header.Get("Server") != gofakes3.DefaultServerHeader {
+			t.Fatal("unexpected Server header", rs.Header.Get("Server"))
+		}
+
+		// Responses from outside routeBase have the header too:
+		ts.SetMaintenance(true)
+		if rs := get(ts, "/"+defaultBucket+"/object"); rs.Header.Get("Server") != gofakes3.DefaultServerHeader {
+			t.Fatal("unexpected Server header", rs.Header.Get("Server"))
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithServerHeader("gofakes3")))
+		defer ts.Close()
+
+		if rs := get(ts, "/"+defaultBucket+"/missing"); rs.Header.Get("Server") != "gofakes3" {
+			t.Fatal("unexpected Server header", rs.Header.Get("Server"))
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithServerHeader("")))
+		defer ts.Close()
+
+		if rs := get(ts, "/"); rs.Header.Get("Server") != "" {
+			t.Fatal("unexpected Server header", rs.Header.Get("Server"))
+		}
+	})
+}
+
+func TestBucketPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	const policy = `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::test/*"}]}`
+
+	assertNoPolicy := func(bucket string) {
+		t.Helper()
+		_, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(bucket)})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchBucketPolicy) {
+			t.Fatal("expected NoSuchBucketPolicy, found", err)
+		}
+	}
+
+	assertNoPolicy(defaultBucket)
+
+	ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(defaultBucket),
+		Policy: aws.String(policy),
+	}))
+	out, err := svc.GetBucketPolicy(&s3.GetBucketPolicyInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if aws.StringValue(out.Policy) != policy {
+		t.Fatal("policy mismatch", aws.StringValue(out.Policy), "!=", policy)
+	}
+
+	ts.OKAll(svc.DeleteBucketPolicy(&s3.DeleteBucketPolicyInput{Bucket: aws.String(defaultBucket)}))
+	assertNoPolicy(defaultBucket)
+
+	for _, bad := range []string{"nope", "[]", `{"Version":`} {
+		_, err := svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+			Bucket: aws.String(defaultBucket),
+			Policy: aws.String(bad),
+		})
+		if !hasErrorCode(err, gofakes3.ErrMalformedPolicy) {
+			t.Fatal("expected MalformedPolicy for", bad, "found", err)
+		}
+	}
+
+	_, err = svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String("nope"),
+		Policy: aws.String(policy),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+
+	{ // The policy must not survive the bucket being deleted and re-created:
+		ts.backendCreateBucket("recreated")
+		ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+			Bucket: aws.String("recreated"),
+			Policy: aws.String(policy),
+		}))
+		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("recreated")}))
+		ts.backendCreateBucket("recreated")
+		assertNoPolicy("recreated")
+	}
+}
+
+func TestBucketNotification(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	get := func() *s3.NotificationConfiguration {
+		t.Helper()
+		out, err := svc.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+			Bucket: aws.String(defaultBucket),
+		})
+		ts.OK(err)
+		return out
+	}
+	put := func(config *s3.NotificationConfiguration) error {
+		_, err := svc.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+			Bucket:                    aws.String(defaultBucket),
+			NotificationConfiguration: config,
+		})
+		return err
+	}
+
+	// An unconfigured bucket has an empty configuration:
+	if out := get(); len(out.TopicConfigurations) != 0 || len(out.QueueConfigurations) != 0 || len(out.LambdaFunctionConfigurations) != 0 {
+		t.Fatal("unexpected configuration", out)
+	}
+
+	config := &s3.NotificationConfiguration{
+		QueueConfigurations: []*s3.QueueConfiguration{{
+			Id:       aws.String("queue"),
+			QueueArn: aws.String("arn:aws:sqs:us-east-1:123456789012:queue"),
+			Events:   aws.StringSlice([]string{"s3:ObjectCreated:*", "s3:ObjectRemoved:Delete"}),
+			Filter: &s3.NotificationConfigurationFilter{
+				Key: &s3.KeyFilter{FilterRules: []*s3.FilterRule{
+					{Name: aws.String("prefix"), Value: aws.String("images/")},
+					{Name: aws.String("suffix"), Value: aws.String(".jpg")},
+				}},
 			},
+		}},
+		TopicConfigurations: []*s3.TopicConfiguration{{
+			TopicArn: aws.String("arn:aws:sns:us-east-1:123456789012:topic"),
+			Events:   aws.StringSlice([]string{"s3:ObjectCreated:Put"}),
+		}},
+		LambdaFunctionConfigurations: []*s3.LambdaFunctionConfiguration{{
+			LambdaFunctionArn: aws.String("arn:aws:lambda:us-east-1:123456789012:function:fn"),
+			Events:            aws.StringSlice([]string{"s3:ObjectRemoved:*"}),
+		}},
+	}
+	ts.OK(put(config))
+	if out := get(); !reflect.DeepEqual(out, config) {
+		t.Fatal("configuration mismatch", out, "!=", config)
+	}
+
+	err := put(&s3.NotificationConfiguration{
+		TopicConfigurations: []*s3.TopicConfiguration{{
+			TopicArn: aws.String("arn:aws:sns:us-east-1:123456789012:topic"),
+			Events:   aws.StringSlice([]string{"ObjectCreated:Put"}),
+		}},
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected InvalidArgument, found", err)
+	}
+	if out := get(); !reflect.DeepEqual(out, config) {
+		t.Fatal("configuration should not have changed", out)
+	}
+
+	// An empty configuration turns notifications off:
+	ts.OK(put(&s3.NotificationConfiguration{}))
+	if out := get(); len(out.TopicConfigurations) != 0 || len(out.QueueConfigurations) != 0 || len(out.LambdaFunctionConfigurations) != 0 {
+		t.Fatal("unexpected configuration", out)
+	}
+
+	_, err = svc.GetBucketNotificationConfiguration(&s3.GetBucketNotificationConfigurationRequest{
+		Bucket: aws.String("nope"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+}
+
+func TestEventSink(t *testing.T) {
+	var (
+		events []gofakes3.Event
+		mu     sync.Mutex
+	)
+	sink := func(ev gofakes3.Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, ev)
+	}
+	takeEvents := func() []gofakes3.Event {
+		mu.Lock()
+		defer mu.Unlock()
+		out := events
+		events = nil
+		return out
+	}
+
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithEventSink(sink)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	assertEvents := func(expected ...gofakes3.Event) {
+		t.Helper()
+		found := takeEvents()
+		for i := range found {
+			if !found[i].Time.Equal(defaultDate) {
+				t.Fatal("unexpected time", found[i].Time)
+			}
+			found[i].Time = time.Time{}
+		}
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatalf("unexpected events\nfound:    %+v\nexpected: %+v", found, expected)
+		}
+	}
+
+	const helloETag = `"5d41402abc4b2a76b9719d911017c592"` // md5("hello")
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	}))
+	assertEvents(gofakes3.Event{Name: gofakes3.EventObjectCreatedPut, Bucket: defaultBucket, Key: "object", Size: 5, ETag: helloETag})
+
+	ts.OKAll(svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	}))
+	assertEvents(gofakes3.Event{Name: gofakes3.EventObjectCreatedCopy, Bucket: defaultBucket, Key: "copy", Size: 5, ETag: helloETag})
+
+	id := ts.createMultipartUpload(defaultBucket, "multi", nil)
+	part := ts.uploadPart(defaultBucket, "multi", id, 1, []byte("hello"))
+	ts.OKAll(svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("multi"),
+		UploadId:        aws.String(id),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{part}},
+	}))
+	assertEvents(gofakes3.Event{Name: gofakes3.EventObjectCreatedCompleteMultipartUpload, Bucket: defaultBucket, Key: "multi", Size: 5, ETag: helloETag})
+
+	ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	}))
+	assertEvents(gofakes3.Event{Name: gofakes3.EventObjectRemovedDelete, Bucket: defaultBucket, Key: "object"})
+
+	ts.OKAll(svc.DeleteObjects(&s3.DeleteObjectsInput{
+		Bucket: aws.String(defaultBucket),
+		Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("copy")}, {Key: aws.String("multi")}}},
+	}))
+	assertEvents(
+		gofakes3.Event{Name: gofakes3.EventObjectRemovedDelete, Bucket: defaultBucket, Key: "copy"},
+		gofakes3.Event{Name: gofakes3.EventObjectRemovedDelete, Bucket: defaultBucket, Key: "multi"},
+	)
+
+	// Failed requests do not produce events:
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("copy"),
+		CopySource: aws.String(defaultBucket + "/missing"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected NoSuchKey, found", err)
+	}
+	assertEvents()
+
+	t.Run("filtered", func(t *testing.T) {
+		ts.OKAll(svc.PutBucketNotificationConfiguration(&s3.PutBucketNotificationConfigurationInput{
+			Bucket: aws.String(defaultBucket),
+			NotificationConfiguration: &s3.NotificationConfiguration{
+				QueueConfigurations: []*s3.QueueConfiguration{{
+					QueueArn: aws.String("arn:aws:sqs:us-east-1:123456789012:queue"),
+					Events:   aws.StringSlice([]string{"s3:ObjectCreated:*"}),
+					Filter: &s3.NotificationConfigurationFilter{
+						Key: &s3.KeyFilter{FilterRules: []*s3.FilterRule{
+							{Name: aws.String("prefix"), Value: aws.String("images/")},
+							{Name: aws.String("suffix"), Value: aws.String(".jpg")},
+						}},
+					},
+				}},
+			},
+		}))
+
+		for _, key := range []string{"images/a.jpg", "images/a.png", "other/a.jpg"} {
+			ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String(key),
+				Body:   bytes.NewReader([]byte("hello")),
+			}))
+		}
+		ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("images/a.jpg"),
+		}))
+		assertEvents(gofakes3.Event{Name: gofakes3.EventObjectCreatedPut, Bucket: defaultBucket, Key: "images/a.jpg", Size: 5, ETag: helloETag})
+	})
+
+	t.Run("versioned", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithEventSink(sink)))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket:                  aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String("Enabled")},
+		}))
+		for _, key := range []string{"a", "b"} {
+			ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String(key),
+				Body:   bytes.NewReader([]byte("hello")),
+			}))
+		}
+		takeEvents()
+
+		out, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{Objects: []*s3.ObjectIdentifier{{Key: aws.String("a")}, {Key: aws.String("b")}}},
+		})
+		ts.OK(err)
+		if len(out.Deleted) != 2 {
+			t.Fatal("unexpected deleted", out.Deleted)
+		}
+		var expected []gofakes3.Event
+		for _, deleted := range out.Deleted {
+			if !aws.BoolValue(deleted.DeleteMarker) || aws.StringValue(deleted.DeleteMarkerVersionId) == "" {
+				t.Fatal("expected delete marker", deleted)
+			}
+			expected = append(expected, gofakes3.Event{
+				Name:      gofakes3.EventObjectRemovedDeleteMarkerCreated,
+				Bucket:    defaultBucket,
+				Key:       aws.StringValue(deleted.Key),
+				VersionID: gofakes3.VersionID(aws.StringValue(deleted.DeleteMarkerVersionId)),
+			})
+		}
+		assertEvents(expected...)
+	})
+}
+
+func TestBucketRequestPayment(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	assertPayer := func(bucket, expected string) {
+		t.Helper()
+		out, err := svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{Bucket: aws.String(bucket)})
+		ts.OK(err)
+		if payer := aws.StringValue(out.Payer); payer != expected {
+			t.Fatal("unexpected payer", payer, "!=", expected)
+		}
+	}
+	put := func(bucket, payer string) error {
+		_, err := svc.PutBucketRequestPayment(&s3.PutBucketRequestPaymentInput{
+			Bucket:                      aws.String(bucket),
+			RequestPaymentConfiguration: &s3.RequestPaymentConfiguration{Payer: aws.String(payer)},
+		})
+		return err
+	}
+
+	assertPayer(defaultBucket, "BucketOwner")
+	ts.OK(put(defaultBucket, "Requester"))
+	assertPayer(defaultBucket, "Requester")
+	ts.OK(put(defaultBucket, "BucketOwner"))
+	assertPayer(defaultBucket, "BucketOwner")
+
+	if err := put(defaultBucket, "Nobody"); !hasErrorCode(err, gofakes3.ErrMalformedXML) {
+		t.Fatal("expected MalformedXML, found", err)
+	}
+	if err := put("nope", "Requester"); !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+	_, err := svc.GetBucketRequestPayment(&s3.GetBucketRequestPaymentInput{Bucket: aws.String("nope")})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+		t.Fatal("expected NoSuchBucket, found", err)
+	}
+
+	{ // The configuration must not survive the bucket being deleted and re-created:
+		ts.backendCreateBucket("recreated")
+		ts.OK(put("recreated", "Requester"))
+		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("recreated")}))
+		ts.backendCreateBucket("recreated")
+		assertPayer("recreated", "BucketOwner")
+	}
+}
+
+func TestPolicyEnforcement(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithPolicyEnforcement()))
+	defer ts.Close()
+	svc := ts.s3Client()
+	client := httpClient()
+
+	ts.backendPutString(defaultBucket, "public/object", nil, "hello")
+	ts.backendPutString(defaultBucket, "private/object", nil, "hello")
+
+	anonymous := func(method, path string) int {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(path), strings.NewReader(""))
+		ts.OK(err)
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+	assertStatus := func(method, path string, expected int) {
+		t.Helper()
+		if status := anonymous(method, path); status != expected {
+			t.Fatal("bad status for", method, path, status, "!=", expected)
+		}
+	}
+
+	// Without a policy, anonymous requests are denied, but signed requests
+	// still work:
+	assertStatus("GET", "/"+defaultBucket+"/public/object", http.StatusForbidden)
+	ts.OKAll(svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("public/object"),
+	}))
+
+	ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(defaultBucket),
+		Policy: aws.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": "*",
+				"Action": "s3:GetObject",
+				"Resource": "arn:aws:s3:::` + defaultBucket + `/public/*"
+			}]
+		}`),
+	}))
+
+	assertStatus("GET", "/"+defaultBucket+"/public/object", http.StatusOK)
+	assertStatus("HEAD", "/"+defaultBucket+"/public/object", http.StatusOK)
+	assertStatus("GET", "/"+defaultBucket+"/private/object", http.StatusForbidden)
+	assertStatus("PUT", "/"+defaultBucket+"/public/object", http.StatusForbidden)
+	assertStatus("DELETE", "/"+defaultBucket+"/public/object", http.StatusForbidden)
+	assertStatus("GET", "/"+defaultBucket, http.StatusForbidden)
+	assertStatus("GET", "/", http.StatusForbidden)
+
+	ts.OKAll(svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("private/object"),
+	}))
+
+	// Listing the bucket does not allow its subresources, nor does getting an
+	// object allow its attributes:
+	ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(defaultBucket),
+		Policy: aws.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": "*",
+				"Action": "s3:ListBucket",
+				"Resource": "arn:aws:s3:::` + defaultBucket + `"
+			}, {
+				"Effect": "Allow",
+				"Principal": "*",
+				"Action": "s3:GetObject",
+				"Resource": "arn:aws:s3:::` + defaultBucket + `/public/*"
+			}]
+		}`),
+	}))
+
+	assertStatus("GET", "/"+defaultBucket, http.StatusOK)
+	assertStatus("HEAD", "/"+defaultBucket, http.StatusOK)
+	assertStatus("GET", "/"+defaultBucket+"?list-type=2", http.StatusOK)
+	for _, sub := range []string{"acl", "cors", "location", "notification", "policy", "requestPayment", "uploads", "versioning", "versions"} {
+		assertStatus("GET", "/"+defaultBucket+"?"+sub, http.StatusForbidden)
+	}
+	assertStatus("GET", "/"+defaultBucket+"/public/object?attributes", http.StatusForbidden)
+	assertStatus("GET", "/"+defaultBucket+"/public/object?tagging", http.StatusForbidden)
+}
+
+func TestPolicyEnforcementCopy(t *testing.T) {
+	const privateBucket = "private"
+	ts := newTestServer(t,
+		withFakerOptions(gofakes3.WithPolicyEnforcement()),
+		withInitialBuckets(defaultBucket, privateBucket))
+	defer ts.Close()
+	svc := ts.s3Client()
+	client := httpClient()
+
+	ts.backendPutString(privateBucket, "secret", nil, "hello")
+	ts.backendPutString(defaultBucket, "public", nil, "hello")
+
+	ts.OKAll(svc.PutBucketPolicy(&s3.PutBucketPolicyInput{
+		Bucket: aws.String(defaultBucket),
+		Policy: aws.String(`{
+			"Version": "2012-10-17",
+			"Statement": [{
+				"Effect": "Allow",
+				"Principal": "*",
+				"Action": ["s3:GetObject", "s3:PutObject"],
+				"Resource": "arn:aws:s3:::` + defaultBucket + `/*"
+			}]
+		}`),
+	}))
+
+	anonymousCopy := func(src string) int {
+		t.Helper()
+		rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/copy"), nil)
+		ts.OK(err)
+		rq.Header.Set("x-amz-copy-source", src)
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
+
+	if status := anonymousCopy("/" + privateBucket + "/secret"); status != http.StatusForbidden {
+		t.Fatal("bad status for copy from private bucket", status)
+	}
+	if ts.backendObjectExists(defaultBucket, "copy") {
+		t.Fatal("unexpected copy of private object")
+	}
+
+	if status := anonymousCopy("/" + defaultBucket + "/public"); status != http.StatusOK {
+		t.Fatal("bad status for copy from public bucket", status)
+	}
+}
+
+func TestLatencyProfile(t *testing.T) {
+	// The distribution itself is tested in latency_test.go; over HTTP, only
+	// the lower bound of a constant delay can be checked reliably:
+	const delay = 5 * time.Millisecond
+	profile := gofakes3.LatencyProfile{
+		Operations: map[gofakes3.Operation]gofakes3.LatencyDistribution{
+			gofakes3.OpHeadObject: {P50: delay},
+		},
+	}
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithLatencyProfile(profile)))
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+	client := httpClient()
+
+	for i := 0; i < 5; i++ {
+		start := time.Now()
+		rs, err := client.Head(ts.url("/" + defaultBucket + "/object"))
+		ts.OK(err)
+		rs.Body.Close()
+		if took := time.Since(start); took < delay {
+			t.Fatal("request was not delayed", took)
+		}
+	}
+}
+
+func TestCreateObject(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	out, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader([]byte("hello")),
+	})
+	ts.OK(err)
+
+	if *out.ETag != `"5d41402abc4b2a76b9719d911017c592"` { // md5("hello")
+		ts.Fatal("bad etag", out.ETag)
+	}
+
+	obj := ts.backendGetString(defaultBucket, "object", nil)
+	if obj != "hello" {
+		t.Fatal("object creation failed")
+	}
+}
+
+func TestCreateObjectEmpty(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	for _, key := range []string{"empty", "folder/"} {
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(nil),
+		})
+		ts.OK(err)
+		if aws.StringValue(out.ETag) != `"d41d8cd98f00b204e9800998ecf8427e"` { // md5("")
+			t.Fatal("bad etag", aws.StringValue(out.ETag))
+		}
+
+		obj, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+		})
+		ts.OK(err)
+		body, err := ioutil.ReadAll(obj.Body)
+		obj.Body.Close()
+		ts.OK(err)
+		if len(body) != 0 || aws.Int64Value(obj.ContentLength) != 0 {
+			t.Fatal("unexpected body", key, len(body), aws.Int64Value(obj.ContentLength))
+		}
+	}
+
+	t.Run("missing-content-length", func(t *testing.T) {
+		rs := httptest.NewRecorder()
+		rq := httptest.NewRequest("PUT", "/"+defaultBucket+"/missing", nil)
+		rq.Header.Del("Content-Length")
+		ts.Server().ServeHTTP(rs, rq)
+		if rs.Code != http.StatusLengthRequired {
+			t.Fatal("unexpected status", rs.Code)
+		}
+		if ts.backendObjectExists(defaultBucket, "missing") {
+			t.Fatal("object should not exist")
+		}
+	})
+}
+
+func TestGetObjectFolderMarker(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "folder/", nil, "")
+	ts.backendPutString(defaultBucket, "folder/file", nil, "hello")
+	ts.backendPutString(defaultBucket, "typed/", map[string]string{"Content-Type": "text/plain"}, "")
+	ts.backendPutString(defaultBucket, "notempty/", map[string]string{"Content-Type": "binary/octet-stream"}, "hello")
+	ts.backendPutString(defaultBucket, "folder", map[string]string{"Content-Type": "binary/octet-stream"}, "")
+
+	for key, expected := range map[string]string{
+		"folder/":   "application/x-directory",
+		"typed/":    "text/plain",
+		"notempty/": "binary/octet-stream",
+		"folder":    "binary/octet-stream",
+	} {
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+		})
+		ts.OK(err)
+		if aws.StringValue(head.ContentType) != expected {
+			t.Fatal("unexpected HEAD content type", key, aws.StringValue(head.ContentType))
+		}
+
+		obj, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+		})
+		ts.OK(err)
+		obj.Body.Close()
+		if aws.StringValue(obj.ContentType) != expected {
+			t.Fatal("unexpected GET content type", key, aws.StringValue(obj.ContentType))
+		}
+	}
+
+	t.Run("listed-with-prefix", func(t *testing.T) {
+		out, err := svc.ListObjects(&s3.ListObjectsInput{
+			Bucket:    aws.String(defaultBucket),
+			Prefix:    aws.String("folder/"),
+			Delimiter: aws.String("/"),
+		})
+		ts.OK(err)
+		var keys []string
+		for _, c := range out.Contents {
+			keys = append(keys, aws.StringValue(c.Key))
+		}
+		if !reflect.DeepEqual(keys, []string{"folder/", "folder/file"}) {
+			t.Fatal("unexpected keys", keys)
+		}
+	})
+}
+
+func TestCreateObjectResponseHeaders(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+	ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(defaultBucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String("Enabled")},
+	}))
+
+	out, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("object"),
+		Body:                 bytes.NewReader([]byte("hello")),
+		ServerSideEncryption: aws.String("aws:kms"),
+		SSEKMSKeyId:          aws.String("key-id"),
+	})
+	ts.OK(err)
+
+	if aws.StringValue(out.ETag) != `"5d41402abc4b2a76b9719d911017c592"` {
+		t.Fatal("bad etag", aws.StringValue(out.ETag))
+	}
+	if aws.StringValue(out.ServerSideEncryption) != "aws:kms" || aws.StringValue(out.SSEKMSKeyId) != "key-id" {
+		t.Fatal("encryption settings not echoed", out)
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	if out.VersionId == nil || aws.StringValue(out.VersionId) != aws.StringValue(head.VersionId) {
+		t.Fatal("version mismatch", aws.StringValue(out.VersionId), "!=", aws.StringValue(head.VersionId))
+	}
+
+	out, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("plain"),
+		Body:   bytes.NewReader([]byte("hello")),
+	})
+	ts.OK(err)
+	if out.ServerSideEncryption != nil {
+		t.Fatal("unexpected encryption", aws.StringValue(out.ServerSideEncryption))
+	}
+}
+
+func TestCreateObjectMetadataSizeLimit(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithMetadataSizeLimit(1),
+	))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	_, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("object"),
+		Metadata: map[string]*string{"too": aws.String("big")},
+		Body:     bytes.NewReader([]byte("hello")),
+	})
+	if !hasErrorCode(err, gofakes3.ErrMetadataTooLarge) {
+		t.Fatal(err)
+	}
+}
+
+func TestCreateObjectMetadataLimits(t *testing.T) {
+	for idx, tc := range []struct {
+		opts []gofakes3.Option
+		meta map[string]*string
+		fail bool
+	}{
+		{nil, map[string]*string{"a": aws.String("1"), "b": aws.String("2")}, false},
+		{[]gofakes3.Option{gofakes3.WithMetadataEntryLimit(2)}, map[string]*string{"a": aws.String("1"), "b": aws.String("2")}, false},
+		{[]gofakes3.Option{gofakes3.WithMetadataEntryLimit(1)}, map[string]*string{"a": aws.String("1"), "b": aws.String("2")}, true},
+		{[]gofakes3.Option{gofakes3.WithMetadataValueSizeLimit(3)}, map[string]*string{"a": aws.String("123")}, false},
+		{[]gofakes3.Option{gofakes3.WithMetadataValueSizeLimit(3)}, map[string]*string{"a": aws.String("1234")}, true},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ts := newTestServer(t, withFakerOptions(tc.opts...))
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			_, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket:   aws.String(defaultBucket),
+				Key:      aws.String("object"),
+				Metadata: tc.meta,
+				Body:     bytes.NewReader([]byte("hello")),
+			})
+			if tc.fail != hasErrorCode(err, gofakes3.ErrMetadataTooLarge) {
+				t.Fatal("failure expected:", tc.fail, "found:", err)
+			} else if !tc.fail {
+				ts.OK(err)
+			}
+
+			_, err = svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+				Bucket:   aws.String(defaultBucket),
+				Key:      aws.String("upload"),
+				Metadata: tc.meta,
+			})
+			if tc.fail != hasErrorCode(err, gofakes3.ErrMetadataTooLarge) {
+				t.Fatal("upload failure expected:", tc.fail, "found:", err)
+			}
+		})
+	}
+}
+
+func TestCreateObjectContentSHA256(t *testing.T) {
+	helloSHA256 := sha256.Sum256([]byte("hello"))
+
+	put := func(ts *testServer, key, contentSHA256 string) (*http.Response, string) {
+		t.Helper()
+		rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/"+key), strings.NewReader("hello"))
+		ts.OK(err)
+		rq.Header.Set("x-amz-content-sha256", contentSHA256)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs, string(body)
+	}
+
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	for _, v := range []string{hex.EncodeToString(helloSHA256[:]), "UNSIGNED-PAYLOAD", "STREAMING-UNSIGNED-PAYLOAD-TRAILER"} {
+		if rs, body := put(ts, "valid", v); rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status for", v, rs.StatusCode, body)
+		}
+	}
+
+	wrong := sha256.Sum256([]byte("goodbye"))
+	rs, body := put(ts, "mismatch", hex.EncodeToString(wrong[:]))
+	if rs.StatusCode != http.StatusBadRequest || !strings.Contains(body, "<Code>XAmzContentSHA256Mismatch</Code>") {
+		t.Fatal("unexpected response", rs.StatusCode, body)
+	}
+	if ts.backendObjectExists(defaultBucket, "mismatch") {
+		t.Fatal("unexpected object")
+	}
+
+	rs, body = put(ts, "malformed", "nope")
+	if rs.StatusCode != http.StatusBadRequest || !strings.Contains(body, "<Code>InvalidArgument</Code>") {
+		t.Fatal("unexpected response", rs.StatusCode, body)
+	}
+
+	t.Run("without-integrity-check", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithIntegrityCheck(false)))
+		defer ts.Close()
+		if rs, body := put(ts, "mismatch", hex.EncodeToString(wrong[:])); rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode, body)
+		}
+	})
+}
+
+func TestSetIntegrityCheck(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(key string) error {
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String(key),
+			Body:       bytes.NewReader([]byte("hello")),
+			ContentMD5: aws.String("afqrYmg1ApVVDefVh7wyPQ=="), // md5("goodbye")
+		})
+		return err
+	}
+
+	if err := put("checked"); !hasErrorCode(err, gofakes3.ErrBadDigest) {
+		t.Fatal("expected BadDigest, found", err)
+	}
+
+	ts.SetIntegrityCheck(false)
+	ts.OK(put("unchecked"))
+	ts.assertObject(defaultBucket, "unchecked", nil, "hello")
+
+	ts.SetIntegrityCheck(true)
+	if err := put("checked"); !hasErrorCode(err, gofakes3.ErrBadDigest) {
+		t.Fatal("expected BadDigest, found", err)
+	}
+	if ts.backendObjectExists(defaultBucket, "checked") {
+		t.Fatal("unexpected object")
+	}
+}
+
+func TestCreateObjectMD5(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	{ // md5 is valid base64 but does not match content:
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("invalid"),
+			Body:       bytes.NewReader([]byte("hello")),
+			ContentMD5: aws.String("bnVwCg=="),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidDigest) {
+			t.Fatal("expected InvalidDigest error, found", err)
+		}
+	}
+
+	{ // md5 is invalid base64:
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("invalid"),
+			Body:       bytes.NewReader([]byte("hello")),
+			ContentMD5: aws.String("!*@&(*$&"),
+		})
+		if !s3HasErrorCode(err, gofakes3.ErrInvalidDigest) {
+			t.Fatal("expected InvalidDigest error, found", err)
+		}
+	}
+
+	if ts.backendObjectExists(defaultBucket, "invalid") {
+		t.Fatal("unexpected object")
+	}
+
+	{ // md5 is valid; the ETag everywhere is the hex of the supplied md5:
+		const contentMD5 = "XUFAKrxLKna5cZ2REBfFkg==" // md5("hello")
+		sum, err := base64.StdEncoding.DecodeString(contentMD5)
+		ts.OK(err)
+		expected := `"` + hex.EncodeToString(sum) + `"`
+
+		put, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("valid"),
+			Body:       bytes.NewReader([]byte("hello")),
+			ContentMD5: aws.String(contentMD5),
+		})
+		ts.OK(err)
+		if aws.StringValue(put.ETag) != expected {
+			t.Fatal("bad PUT etag", aws.StringValue(put.ETag), "!=", expected)
+		}
+
+		get, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("valid"),
+		})
+		ts.OK(err)
+		get.Body.Close()
+		if aws.StringValue(get.ETag) != expected {
+			t.Fatal("bad GET etag", aws.StringValue(get.ETag), "!=", expected)
+		}
+
+		head, err := svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("valid"),
+		})
+		ts.OK(err)
+		if aws.StringValue(head.ETag) != expected {
+			t.Fatal("bad HEAD etag", aws.StringValue(head.ETag), "!=", expected)
+		}
+	}
+}
+
+func TestObjectTagging(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	putTags := func(tags ...*s3.Tag) error {
+		_, err := svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+			Bucket:  aws.String(defaultBucket),
+			Key:     aws.String("object"),
+			Tagging: &s3.Tagging{TagSet: tags},
+		})
+		return err
+	}
+	tag := func(k, v string) *s3.Tag {
+		return &s3.Tag{Key: aws.String(k), Value: aws.String(v)}
+	}
+
+	t.Run("roundtrip", func(t *testing.T) {
+		ts.OK(putTags(tag("foo", "bar"), tag("baz", "qux")))
+
+		out, err := svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		ts.OK(err)
+		if len(out.TagSet) != 2 || *out.TagSet[0].Key != "foo" || *out.TagSet[1].Value != "qux" {
+			t.Fatal("unexpected tag set", out.TagSet)
+		}
+
+		ts.OKAll(svc.DeleteObjectTagging(&s3.DeleteObjectTaggingInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		}))
+		out, err = svc.GetObjectTagging(&s3.GetObjectTaggingInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		ts.OK(err)
+		if len(out.TagSet) != 0 {
+			t.Fatal("unexpected tag set", out.TagSet)
+		}
+	})
+
+	t.Run("too-many-tags", func(t *testing.T) {
+		var tags []*s3.Tag
+		for i := 0; i <= gofakes3.MaxObjectTags; i++ {
+			tags = append(tags, tag(fmt.Sprintf("key%d", i), "value"))
+		}
+		if err := putTags(tags...); !hasErrorCode(err, gofakes3.ErrBadRequest) {
+			t.Fatal("expected BadRequest, found", err)
+		}
+	})
+
+	t.Run("key-too-long", func(t *testing.T) {
+		err := putTags(tag(strings.Repeat("k", gofakes3.MaxTagKeyLength+1), "value"))
+		if !hasErrorCode(err, gofakes3.ErrInvalidTag) {
+			t.Fatal("expected InvalidTag, found", err)
+		}
+	})
+
+	t.Run("value-too-long", func(t *testing.T) {
+		err := putTags(tag("key", strings.Repeat("v", gofakes3.MaxTagValueLength+1)))
+		if !hasErrorCode(err, gofakes3.ErrInvalidTag) {
+			t.Fatal("expected InvalidTag, found", err)
+		}
+	})
+
+	t.Run("duplicate-keys", func(t *testing.T) {
+		err := putTags(tag("key", "a"), tag("key", "b"))
+		if !hasErrorCode(err, gofakes3.ErrInvalidTag) {
+			t.Fatal("expected InvalidTag, found", err)
+		}
+	})
+
+	t.Run("missing-object", func(t *testing.T) {
+		_, err := svc.PutObjectTagging(&s3.PutObjectTaggingInput{
+			Bucket:  aws.String(defaultBucket),
+			Key:     aws.String("nope"),
+			Tagging: &s3.Tagging{TagSet: []*s3.Tag{tag("key", "value")}},
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			t.Fatal("expected NoSuchKey, found", err)
+		}
+	})
+}
+
+func TestObjectACL(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithOwner("owner-id", "owner-name")))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	getGrants := func(object string) map[string]string {
+		t.Helper()
+		out, err := svc.GetObjectAcl(&s3.GetObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(object),
+		})
+		ts.OK(err)
+		if aws.StringValue(out.Owner.ID) != "owner-id" {
+			t.Fatal("unexpected owner", out.Owner)
+		}
+		grants := map[string]string{}
+		for _, grant := range out.Grants {
+			grantee := aws.StringValue(grant.Grantee.ID) + aws.StringValue(grant.Grantee.URI)
+			grants[grantee] += aws.StringValue(grant.Permission)
+		}
+		return grants
+	}
+	assertGrants := func(object string, expected map[string]string) {
+		t.Helper()
+		if grants := getGrants(object); !reflect.DeepEqual(grants, expected) {
+			t.Fatal("unexpected grants", grants, "!=", expected)
+		}
+	}
+
+	private := map[string]string{"owner-id": "FULL_CONTROL"}
+	publicRead := map[string]string{"owner-id": "FULL_CONTROL", gofakes3.GroupAllUsers: "READ"}
+
+	{ // Objects are private by default:
+		ts.backendPutString(defaultBucket, "private", nil, "hello")
+		assertGrants("private", private)
+	}
+
+	{ // Canned ACL passed on upload:
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("public"),
+			ACL:    aws.String("public-read"),
+			Body:   bytes.NewReader([]byte("hello")),
+		}))
+		assertGrants("public", publicRead)
+
+		// Replacing the object replaces the ACL:
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("public"),
+			Body:   bytes.NewReader([]byte("hello")),
+		}))
+		assertGrants("public", private)
+	}
+
+	{ // Canned ACL passed to PutObjectAcl:
+		ts.OKAll(svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("private"),
+			ACL:    aws.String("authenticated-read"),
+		}))
+		assertGrants("private", map[string]string{"owner-id": "FULL_CONTROL", gofakes3.GroupAuthenticatedUsers: "READ"})
+	}
+
+	{ // AccessControlPolicy passed to PutObjectAcl:
+		ts.OKAll(svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("private"),
+			AccessControlPolicy: &s3.AccessControlPolicy{
+				Owner: &s3.Owner{ID: aws.String("owner-id")},
+				Grants: []*s3.Grant{
+					{Grantee: &s3.Grantee{Type: aws.String("CanonicalUser"), ID: aws.String("owner-id")}, Permission: aws.String("READ")},
+					{Grantee: &s3.Grantee{Type: aws.String("Group"), URI: aws.String(gofakes3.GroupAllUsers)}, Permission: aws.String("WRITE")},
+				},
+			},
+		}))
+		assertGrants("private", map[string]string{"owner-id": "READ", gofakes3.GroupAllUsers: "WRITE"})
+	}
+
+	{ // Unknown canned ACL:
+		_, err := svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("private"),
+			ACL:    aws.String("nope"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+	}
+
+	{ // Invalid permission:
+		_, err := svc.PutObjectAcl(&s3.PutObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("private"),
+			AccessControlPolicy: &s3.AccessControlPolicy{
+				Grants: []*s3.Grant{
+					{Grantee: &s3.Grantee{Type: aws.String("CanonicalUser"), ID: aws.String("owner-id")}, Permission: aws.String("NOPE")},
+				},
+			},
+		})
+		if !hasErrorCode(err, gofakes3.ErrMalformedACLError) {
+			t.Fatal("expected MalformedACLError, found", err)
+		}
+	}
+
+	{ // Missing object:
+		_, err := svc.GetObjectAcl(&s3.GetObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("nope"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			t.Fatal("expected NoSuchKey, found", err)
+		}
+	}
+}
+
+func TestBucketACL(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithOwner("owner-id", "owner-name")))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	assertGrants := func(bucket string, expected map[string]string) {
+		t.Helper()
+		out, err := svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String(bucket)})
+		ts.OK(err)
+		if aws.StringValue(out.Owner.ID) != "owner-id" {
+			t.Fatal("unexpected owner", out.Owner)
+		}
+		grants := map[string]string{}
+		for _, grant := range out.Grants {
+			grantee := aws.StringValue(grant.Grantee.ID) + aws.StringValue(grant.Grantee.URI)
+			grants[grantee] += aws.StringValue(grant.Permission)
+		}
+		if !reflect.DeepEqual(grants, expected) {
+			t.Fatal("unexpected grants", grants, "!=", expected)
+		}
+	}
+
+	private := map[string]string{"owner-id": "FULL_CONTROL"}
+	publicRead := map[string]string{"owner-id": "FULL_CONTROL", gofakes3.GroupAllUsers: "READ"}
+
+	{ // Buckets are private by default:
+		assertGrants(defaultBucket, private)
+	}
+
+	{ // Canned ACL passed to PutBucketAcl:
+		ts.OKAll(svc.PutBucketAcl(&s3.PutBucketAclInput{
+			Bucket: aws.String(defaultBucket),
+			ACL:    aws.String("public-read"),
+		}))
+		assertGrants(defaultBucket, publicRead)
+
+		// The bucket ACL is independent of the ACLs of its objects:
+		ts.backendPutString(defaultBucket, "object", nil, "hello")
+		out, err := svc.GetObjectAcl(&s3.GetObjectAclInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		ts.OK(err)
+		if len(out.Grants) != 1 {
+			t.Fatal("unexpected object grants", out.Grants)
+		}
+	}
+
+	{ // Canned ACL passed on creation:
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{
+			Bucket: aws.String("public"),
+			ACL:    aws.String("public-read"),
+		}))
+		assertGrants("public", publicRead)
+
+		// Recreating the bucket resets the ACL:
+		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{Bucket: aws.String("public")}))
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("public")}))
+		assertGrants("public", private)
+	}
+
+	{ // AccessControlPolicy passed to PutBucketAcl; the owner keeps FULL_CONTROL:
+		ts.OKAll(svc.PutBucketAcl(&s3.PutBucketAclInput{
+			Bucket: aws.String(defaultBucket),
+			AccessControlPolicy: &s3.AccessControlPolicy{
+				Owner: &s3.Owner{ID: aws.String("owner-id")},
+				Grants: []*s3.Grant{
+					{Grantee: &s3.Grantee{Type: aws.String("Group"), URI: aws.String(gofakes3.GroupAllUsers)}, Permission: aws.String("WRITE")},
+				},
+			},
+		}))
+		assertGrants(defaultBucket, map[string]string{"owner-id": "FULL_CONTROL", gofakes3.GroupAllUsers: "WRITE"})
+	}
+
+	{ // Unknown canned ACL:
+		_, err := svc.PutBucketAcl(&s3.PutBucketAclInput{
+			Bucket: aws.String(defaultBucket),
+			ACL:    aws.String("nope"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+	}
+
+	{ // Missing bucket:
+		_, err := svc.GetBucketAcl(&s3.GetBucketAclInput{Bucket: aws.String("nope")})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchBucket) {
+			t.Fatal("expected NoSuchBucket, found", err)
+		}
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("src object"),
+		Body:     bytes.NewReader([]byte("hello")),
+		Metadata: map[string]*string{"Test": aws.String("yep")},
+	}))
+
+	out, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("dst"),
+		CopySource: aws.String(defaultBucket + "/src%20object"),
+	})
+	ts.OK(err)
+	if aws.StringValue(out.CopyObjectResult.ETag) != `"5d41402abc4b2a76b9719d911017c592"` { // md5("hello")
+		t.Fatal("bad etag", out.CopyObjectResult.ETag)
+	}
+	if out.CopySourceVersionId != nil || out.VersionId != nil {
+		t.Fatal("unexpected version ids for unversioned bucket", out.CopySourceVersionId, out.VersionId)
+	}
+
+	if obj := ts.backendGetString(defaultBucket, "dst", nil); obj != "hello" {
+		t.Fatal("unexpected object", obj)
+	}
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("dst"),
+	})
+	ts.OK(err)
+	if aws.StringValue(head.Metadata["Test"]) != "yep" {
+		t.Fatal("metadata not copied", head.Metadata)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("dst"),
+		CopySource: aws.String(defaultBucket + "/nope"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected NoSuchKey, found", err)
+	}
+}
+
+func TestCopyObjectOntoItself(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(defaultBucket),
+		Key:         aws.String("object"),
+		Body:        bytes.NewReader([]byte("hello")),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]*string{"Old": aws.String("yep")},
+	}))
+
+	_, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("object"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(defaultBucket),
+		Key:               aws.String("object"),
+		CopySource:        aws.String(defaultBucket + "/object"),
+		MetadataDirective: aws.String("NOPE"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected InvalidArgument, found", err)
+	}
+
+	ts.Advance(time.Minute)
+	out, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:            aws.String(defaultBucket),
+		Key:               aws.String("object"),
+		CopySource:        aws.String(defaultBucket + "/object"),
+		MetadataDirective: aws.String("REPLACE"),
+		ContentType:       aws.String("application/json"),
+		Metadata:          map[string]*string{"New": aws.String("yep")},
+	})
+	ts.OK(err)
+	if aws.StringValue(out.CopyObjectResult.ETag) != `"5d41402abc4b2a76b9719d911017c592"` { // md5("hello")
+		t.Fatal("bad etag", out.CopyObjectResult.ETag)
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	if ct := aws.StringValue(head.ContentType); ct != "application/json" {
+		t.Fatal("unexpected Content-Type", ct)
+	}
+	if len(head.Metadata) != 1 || aws.StringValue(head.Metadata["New"]) != "yep" {
+		t.Fatal("metadata not replaced", head.Metadata)
+	}
+	if lm := aws.TimeValue(head.LastModified); !lm.Equal(defaultDate.Add(time.Minute)) {
+		t.Fatal("unexpected LastModified", lm)
+	}
+	if aws.Int64Value(head.ContentLength) != 5 {
+		t.Fatal("unexpected size", aws.Int64Value(head.ContentLength))
+	}
+	ts.assertObject(defaultBucket, "object", nil, "hello")
+}
+
+func TestCopyObjectConditional(t *testing.T) {
+	const etag = `"5d41402abc4b2a76b9719d911017c592"` // md5("hello")
+	before, after := defaultDate.Add(-time.Hour), defaultDate.Add(time.Hour)
+
+	for idx, tc := range []struct {
+		in   s3.CopyObjectInput
+		fail bool
+	}{
+		{in: s3.CopyObjectInput{CopySourceIfMatch: aws.String(etag)}},
+		{in: s3.CopyObjectInput{CopySourceIfMatch: aws.String("5d41402abc4b2a76b9719d911017c592")}},
+		{in: s3.CopyObjectInput{CopySourceIfMatch: aws.String(`"nope", ` + etag)}},
+		{in: s3.CopyObjectInput{CopySourceIfMatch: aws.String("*")}},
+		{in: s3.CopyObjectInput{CopySourceIfMatch: aws.String(`"nope"`)}, fail: true},
+
+		{in: s3.CopyObjectInput{CopySourceIfNoneMatch: aws.String(`"nope"`)}},
+		{in: s3.CopyObjectInput{CopySourceIfNoneMatch: aws.String(etag)}, fail: true},
+
+		{in: s3.CopyObjectInput{CopySourceIfModifiedSince: aws.Time(before)}},
+		{in: s3.CopyObjectInput{CopySourceIfModifiedSince: aws.Time(defaultDate)}, fail: true},
+		{in: s3.CopyObjectInput{CopySourceIfModifiedSince: aws.Time(after)}, fail: true},
+
+		{in: s3.CopyObjectInput{CopySourceIfUnmodifiedSince: aws.Time(defaultDate)}},
+		{in: s3.CopyObjectInput{CopySourceIfUnmodifiedSince: aws.Time(after)}},
+		{in: s3.CopyObjectInput{CopySourceIfUnmodifiedSince: aws.Time(before)}, fail: true},
+
+		// If the ETag matches, the source may have been modified since:
+		{in: s3.CopyObjectInput{CopySourceIfMatch: aws.String(etag), CopySourceIfUnmodifiedSince: aws.Time(before)}},
+
+		// If the ETag does not match, the source need not have been modified since:
+		{in: s3.CopyObjectInput{CopySourceIfNoneMatch: aws.String(`"nope"`), CopySourceIfModifiedSince: aws.Time(after)}},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+			svc := ts.s3Client()
+
+			ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String("src"),
+				Body:   bytes.NewReader([]byte("hello")),
+			}))
+
+			in := tc.in
+			in.Bucket = aws.String(defaultBucket)
+			in.Key = aws.String("dst")
+			in.CopySource = aws.String(defaultBucket + "/src")
+			_, err := svc.CopyObject(&in)
+			if tc.fail {
+				if !hasErrorCode(err, gofakes3.ErrPreconditionFailed) {
+					t.Fatal("expected PreconditionFailed, found", err)
+				}
+				if ts.backendObjectExists(defaultBucket, "dst") {
+					t.Fatal("object should not have been copied")
+				}
+			} else {
+				ts.OK(err)
+				ts.assertObject(defaultBucket, "dst", nil, "hello")
+			}
+		})
+	}
+}
+
+func TestCopyObjectVersion(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(body string) string {
+		t.Helper()
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader([]byte(body)),
+		})
+		ts.OK(err)
+		return aws.StringValue(out.VersionId)
+	}
+
+	v1 := put("one")
+	v2 := put("two")
+
+	out, err := svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("restored"),
+		CopySource: aws.String(defaultBucket + "/object?versionId=" + v1),
+	})
+	ts.OK(err)
+	if aws.StringValue(out.VersionId) == "" {
+		t.Fatal("missing version id")
+	}
+	if v := aws.StringValue(out.CopySourceVersionId); v != v1 {
+		t.Fatal("unexpected copy source version id", v, "!=", v1)
+	}
+	if obj := ts.backendGetString(defaultBucket, "restored", nil); obj != "one" {
+		t.Fatal("unexpected object", obj)
+	}
+
+	// Without a versionId, the latest version is copied:
+	out, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("latest"),
+		CopySource: aws.String(defaultBucket + "/object"),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(out.CopySourceVersionId); v != v2 {
+		t.Fatal("unexpected copy source version id", v, "!=", v2)
+	}
+	if v := aws.StringValue(out.VersionId); v == "" || v == v2 {
+		t.Fatal("unexpected destination version id", v)
+	}
+	if obj := ts.backendGetString(defaultBucket, "latest", nil); obj != "two" {
+		t.Fatal("unexpected object", obj)
+	}
+
+	_, err = svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("restored"),
+		CopySource: aws.String(defaultBucket + "/object?versionId=nope"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchVersion) {
+		t.Fatal("expected NoSuchVersion, found", err)
+	}
+}
+
+func TestDeleteBucket(t *testing.T) {
+	t.Run("delete-empty", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendCreateBucket("test")
+		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String("test"),
+		}))
+	})
+
+	t.Run("delete-fails-if-not-empty", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendCreateBucket("test")
+		ts.backendPutString("test", "test", nil, "test")
+		_, err := svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String("test"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
+			t.Fatal("expected ErrBucketNotEmpty, found", err)
+		}
+	})
+
+	t.Run("not-empty-message", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendCreateBucket("test")
+		for i := 0; i < 7; i++ {
+			ts.backendPutString("test", fmt.Sprintf("key%d", i), nil, "test")
+		}
+		_, err := svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String("test"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrBucketNotEmpty) {
+			t.Fatal("expected ErrBucketNotEmpty, found", err)
+		}
+		const expected = `The bucket you tried to delete is not empty (7 object(s) remain, including "key0", "key1", "key2", "key3", "key4")`
+		if msg := err.(awserr.Error).Message(); msg != expected {
+			t.Fatal("unexpected message", msg)
+		}
+	})
+
+	t.Run("pending-uploads-are-aborted", func(t *testing.T) {
+		ts := newTestServer(t, withoutInitialBuckets())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendCreateBucket("test")
+		id := ts.createMultipartUpload("test", "object", nil)
+		ts.uploadPart("test", "object", id, 1, []byte("part"))
+
+		// An upload in progress does not stop the bucket from being deleted:
+		ts.OKAll(svc.DeleteBucket(&s3.DeleteBucketInput{
+			Bucket: aws.String("test"),
+		}))
+
+		// ...but it doesn't survive it either, even if the bucket comes back:
+		ts.backendCreateBucket("test")
+		_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String("test"),
+			Key:      aws.String("object"),
+			UploadId: aws.String(id),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+			t.Fatal("expected ErrNoSuchUpload, found", err)
+		}
+	})
+}
+
+func TestDeleteMulti(t *testing.T) {
+	deletedKeys := func(rs *s3.DeleteObjectsOutput) []string {
+		deleted := make([]string, len(rs.Deleted))
+		for idx, del := range rs.Deleted {
+			deleted[idx] = *del.Key
+		}
+		sort.Strings(deleted)
+		return deleted
+	}
+
+	assertDeletedKeys := func(t *testing.T, rs *s3.DeleteObjectsOutput, expected ...string) {
+		t.Helper()
+		found := deletedKeys(rs)
+		if !reflect.DeepEqual(found, expected) {
+			t.Fatal("multi deletion failed", found, "!=", expected)
+		}
+	}
+
+	t.Run("one-file", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+		ts.backendPutString(defaultBucket, "bar", nil, "two")
+		ts.backendPutString(defaultBucket, "baz", nil, "three")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("foo")},
+				},
+			},
+		})
+		ts.OK(err)
+		assertDeletedKeys(t, rs, "foo")
+		ts.assertLs(defaultBucket, "", nil, []string{"bar", "baz"})
+	})
+
+	t.Run("multiple-files", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+		ts.backendPutString(defaultBucket, "bar", nil, "two")
+		ts.backendPutString(defaultBucket, "baz", nil, "three")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{
+					{Key: aws.String("bar")},
+					{Key: aws.String("foo")},
+				},
+			},
+		})
+		ts.OK(err)
+		assertDeletedKeys(t, rs, "bar", "foo")
+		ts.assertLs(defaultBucket, "", nil, []string{"baz"})
+	})
+}
+
+func TestDeleteMultiTrickyKeys(t *testing.T) {
+	t.Run("sdk", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		// Listed out of order, to check the results follow the request:
+		keys := []string{"z&z", "<tag>", `quote"'`, "日本語", "pct%20key", "plus+key", "cr\rlf\n", "tab\tkey", " lead", "trail ", "a&amp;b"}
+		var objects []*s3.ObjectIdentifier
+		for _, key := range keys {
+			ts.backendPutString(defaultBucket, key, nil, "x")
+			objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(key)})
+		}
+		ts.backendPutString(defaultBucket, "a&b", nil, "x")
+		ts.backendPutString(defaultBucket, "pct key", nil, "x")
+
+		rs, err := svc.DeleteObjects(&s3.DeleteObjectsInput{
+			Bucket: aws.String(defaultBucket),
+			Delete: &s3.Delete{Objects: objects},
+		})
+		ts.OK(err)
+
+		var deleted []string
+		for _, del := range rs.Deleted {
+			deleted = append(deleted, *del.Key)
+		}
+		if !reflect.DeepEqual(deleted, keys) {
+			t.Fatalf("unexpected deleted keys %q", deleted)
+		}
+		ts.assertLs(defaultBucket, "", nil, []string{"a&b", "pct key"})
+	})
+
+	t.Run("raw", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		for _, key := range []string{"a&b", "<c>", "日本", "d]]>e"} {
+			ts.backendPutString(defaultBucket, key, nil, "x")
+		}
+
+		post := func(body string) (*http.Response, []byte) {
+			t.Helper()
+			rs, err := httpClient().Post(ts.url("/"+defaultBucket+"?delete"), "application/xml", strings.NewReader(body))
+			ts.OK(err)
+			defer rs.Body.Close()
+			out, err := ioutil.ReadAll(rs.Body)
+			ts.OK(err)
+			return rs, out
+		}
+
+		rs, body := post(`<Delete>` +
+			`<Object><Key>a&#x26;b</Key></Object>` +
+			`<Object><Key><![CDATA[<c>]]></Key></Object>` +
+			`<Object><Key>&#x65E5;本</Key></Object>` +
+			`<Object><Key>d]]&gt;e</Key></Object>` +
+			`</Delete>`)
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode, string(body))
+		}
+		var result gofakes3.MultiDeleteResult
+		ts.OK(xml.Unmarshal(body, &result))
+		var deleted []string
+		for _, del := range result.Deleted {
+			deleted = append(deleted, del.Key)
+		}
+		if !reflect.DeepEqual(deleted, []string{"a&b", "<c>", "日本", "d]]>e"}) {
+			t.Fatalf("unexpected deleted keys %q", deleted)
+		}
+		ts.assertLs(defaultBucket, "", nil, nil)
+
+		// An unescaped '&' is not XML:
+		rs, body = post(`<Delete><Object><Key>a&b</Key></Object></Delete>`)
+		if rs.StatusCode != http.StatusBadRequest || !strings.Contains(string(body), string(gofakes3.ErrMalformedXML)) {
+			t.Fatal("expected MalformedXML, found", rs.StatusCode, string(body))
+		}
+	})
+}
+
+func TestResponseChecksums(t *testing.T) {
+	const deleteBody = `<Delete><Object><Key>foo</Key></Object></Delete>`
+
+	do := func(ts *testServer, method, path, body string) (*http.Response, []byte) {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(path), strings.NewReader(body))
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		out, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs, out
+	}
+
+	t.Run("enabled", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithResponseChecksums()))
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+
+		for _, rq := range []struct{ method, path, body string }{
+			{"POST", "/" + defaultBucket + "?delete", deleteBody},
+			{"GET", "/", ""},
+			{"GET", "/" + defaultBucket, ""}, // Listings are streamed unless checksums are enabled
+		} {
+			rs, body := do(ts, rq.method, rq.path, rq.body)
+			if rs.StatusCode != http.StatusOK {
+				t.Fatal("bad status", rs.StatusCode, string(body))
+			}
+			sum := md5.Sum(body)
+			if expected := base64.StdEncoding.EncodeToString(sum[:]); rs.Header.Get("Content-MD5") != expected {
+				t.Fatal("bad Content-MD5", rs.Header.Get("Content-MD5"), "!=", expected)
+			}
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		ts.backendPutString(defaultBucket, "foo", nil, "one")
+
+		rs, _ := do(ts, "POST", "/"+defaultBucket+"?delete", deleteBody)
+		if rs.Header.Get("Content-MD5") != "" {
+			t.Fatal("unexpected Content-MD5", rs.Header.Get("Content-MD5"))
+		}
+	})
+}
+
+func TestResponseCompression(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithResponseCompression()))
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "doc.xml", map[string]string{"Content-Type": "application/xml"}, "<doc/>")
+
+	// The default transport decompresses gzip itself, which would hide
+	// whether the response was compressed:
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(path, accept string) (*http.Response, []byte) {
+		t.Helper()
+		rq, err := http.NewRequest("GET", ts.url(path), nil)
+		ts.OK(err)
+		if accept != "" {
+			rq.Header.Set("Accept-Encoding", accept)
+		}
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs, body
+	}
+	gunzip := func(body []byte) []byte {
+		t.Helper()
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		ts.OK(err)
+		out, err := ioutil.ReadAll(gz)
+		ts.OK(err)
+		return out
+	}
+
+	t.Run("listing", func(t *testing.T) {
+		rs, body := get("/"+defaultBucket, "gzip")
+		if rs.Header.Get("Content-Encoding") != "gzip" {
+			t.Fatal("expected a compressed response", rs.Header)
+		}
+		var result gofakes3.ListBucketResult
+		ts.OK(xml.Unmarshal(gunzip(body), &result))
+		if len(result.Contents) != 1 || result.Contents[0].Key != "doc.xml" {
+			t.Fatal("unexpected listing", result.Contents)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		rs, body := get("/missing", "deflate, gzip;q=0.5")
+		if rs.StatusCode != http.StatusNotFound || rs.Header.Get("Content-Encoding") != "gzip" {
+			t.Fatal("expected a compressed error", rs.StatusCode, rs.Header)
+		}
+		var errResp gofakes3.ErrorResponse
+		ts.OK(xml.Unmarshal(gunzip(body), &errResp))
+		if errResp.Code != gofakes3.ErrNoSuchBucket {
+			t.Fatal("unexpected error", errResp.Code)
+		}
+	})
+
+	t.Run("object", func(t *testing.T) {
+		rs, body := get("/"+defaultBucket+"/doc.xml", "gzip")
+		if rs.Header.Get("Content-Encoding") != "" || string(body) != "<doc/>" {
+			t.Fatal("object body should not be compressed", rs.Header, string(body))
+		}
+	})
+
+	t.Run("not-accepted", func(t *testing.T) {
+		for _, accept := range []string{"", "identity", "gzip;q=0"} {
+			rs, body := get("/"+defaultBucket, accept)
+			if rs.Header.Get("Content-Encoding") != "" || !bytes.HasPrefix(body, []byte("<?xml")) {
+				t.Fatal("unexpected compression", accept, rs.Header)
+			}
+		}
+	})
+
+	t.Run("sdk", func(t *testing.T) {
+		out, err := ts.s3Client().ListObjects(&s3.ListObjectsInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if len(out.Contents) != 1 {
+			t.Fatal("unexpected listing", out.Contents)
+		}
+	})
+}
+
+func TestGetObjectRange(t *testing.T) {
+	assertRange := func(ts *testServer, key string, hdr string, expected []byte, fail bool) {
+		ts.Helper()
+		svc := ts.s3Client()
+		obj, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Range:  aws.String(hdr),
+		})
+		if fail != (err != nil) {
+			ts.Fatal("failure expected:", fail, "found:", err)
+		}
+		if !fail {
+			ts.OK(err)
+			defer obj.Body.Close()
+
+			out, err := ioutil.ReadAll(obj.Body)
+			ts.OK(err)
+			if !bytes.Equal(expected, out) {
+				ts.Fatal("range failed", hdr, err)
+			}
+		}
+	}
+
+	in := randomFileBody(1024)
+
+	for idx, tc := range []struct {
+		hdr      string
+		expected []byte
+		fail     bool
+	}{
+		{"bytes=0-", in, false},
+		{"bytes=1-", in[1:], false},
+		{"bytes=0-0", in[:1], false},
+		{"bytes=0-1", in[:2], false},
+		{"bytes=1023-1023", in[1023:1024], false},
+
+		// if the requested end is beyond the real end, returns "remainder of the representation"
+		{"bytes=1023-1025", in[1023:1024], false},
+
+		// if the requested start is beyond the real end, it should fail
+		{"bytes=1024-1024", []byte{}, true},
+		{"bytes=1024-", []byte{}, true},
+		{"bytes=1025-", []byte{}, true},
+
+		// suffix-byte-range-spec:
+		{"bytes=-0", []byte{}, true},
+		{"bytes=-1", in[1023:1024], false},
+		{"bytes=-1024", in, false},
+		{"bytes=-1025", in, true},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			ts.backendPutBytes(defaultBucket, "foo", nil, in)
+			assertRange(ts, "foo", tc.hdr, tc.expected, tc.fail)
+		})
+	}
+}
+
+func TestGetObjectRangeInvalid(t *testing.T) {
+	assertRangeInvalid := func(ts *testServer, key string, hdr string) {
+		svc := ts.s3Client()
+		_, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String(key),
+			Range:  aws.String(hdr),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidRange) {
+			ts.Fatal("expected ErrInvalidRange, found", err)
+		}
+	}
+
+	in := randomFileBody(1024)
+
+	for idx, tc := range []struct {
+		hdr string
+	}{
+		{"boats=0-0"},
+		{"bytes="},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			ts.backendPutBytes(defaultBucket, "foo", nil, in)
+			assertRangeInvalid(ts, "foo", tc.hdr)
+		})
+	}
+}
+
+// emptyRangeBackend returns an empty range for any range request, which
+// GoFakeS3 must not turn into a malformed Content-Range.
+type emptyRangeBackend struct {
+	gofakes3.Backend
+}
+
+func (b emptyRangeBackend) GetObject(bucketName, objectName string, rangeRequest *gofakes3.ObjectRangeRequest) (*gofakes3.Object, error) {
+	obj, err := b.Backend.GetObject(bucketName, objectName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if rangeRequest != nil {
+		obj.Range = &gofakes3.ObjectRange{Start: obj.Size, Length: 0}
+	}
+	return obj, nil
+}
+
+func TestGetObjectRangeEmpty(t *testing.T) {
+	for idx, tc := range []struct {
+		hdr     string
+		backend func(gofakes3.Backend) gofakes3.Backend
+		fail    bool
+		rng     string
+	}{
+		{"bytes=0-0", nil, false, "bytes 0-0/1024"},
+		{"bytes=1023-", nil, false, "bytes 1023-1023/1024"},
+		{"bytes=1024-1024", nil, true, "bytes */1024"},
+		{"bytes=1024-", nil, true, "bytes */1024"},
+		{"bytes=1025-", nil, true, "bytes */1024"},
+		{"bytes=-0", nil, true, "bytes */1024"},
+		{"bytes=0-0", func(b gofakes3.Backend) gofakes3.Backend { return emptyRangeBackend{b} }, true, "bytes */1024"},
+	} {
+		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
+			var opts []testServerOption
+			if tc.backend != nil {
+				opts = append(opts, withBackend(tc.backend(s3mem.New())))
+			}
+			ts := newTestServer(t, opts...)
+			defer ts.Close()
+			ts.backendPutBytes(defaultBucket, "foo", nil, randomFileBody(1024))
+
+			rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/foo"), nil)
+			ts.OK(err)
+			rq.Header.Set("Range", tc.hdr)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			rs.Body.Close()
+
+			if tc.fail != (rs.StatusCode == http.StatusRequestedRangeNotSatisfiable) {
+				t.Fatal("failure expected:", tc.fail, "found status:", rs.StatusCode)
+			}
+			if rng := rs.Header.Get("Content-Range"); rng != tc.rng {
+				t.Fatalf("bad Content-Range %q, expected %q", rng, tc.rng)
+			}
+		})
+	}
+}
+
+func TestCreateObjectBrowserUpload(t *testing.T) {
+	addFile := func(tt gofakes3.TT, w *multipart.Writer, object string, b []byte) {
+		tt.Helper()
+		tt.OK(w.WriteField("key", object))
+
+		mw, err := w.CreateFormFile("file", "upload")
+		tt.OK(err)
+		n, err := mw.Write(b)
+		if n != len(b) {
+			tt.Fatal("len mismatch", n, "!=", len(b))
+		}
+		tt.OK(err)
+	}
+
+	upload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader) (*http.Response, error) {
+		w.Close()
+		req, err := http.NewRequest("POST", ts.url("/"+bucket), body)
+		ts.OK(err)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		return httpClient().Do(req)
+	}
+
+	assertUpload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, etag string) {
+		res, err := upload(ts, bucket, w, body)
+		ts.OK(err)
+		if res.StatusCode != http.StatusOK {
+			ts.Fatal("bad status", res.StatusCode, tryDumpResponse(res, true))
+		}
+		if etag != "" && res.Header.Get("ETag") != etag {
+			ts.Fatal("bad etag", res.Header.Get("ETag"), etag)
+		}
+	}
+
+	assertUploadFails := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, expectedCode gofakes3.ErrorCode) {
+		res, err := upload(ts, bucket, w, body)
+		ts.OK(err)
+		if res.StatusCode != expectedCode.Status() {
+			ts.Fatal("bad status", res.StatusCode, "!=", expectedCode.Status())
+		}
+		defer res.Body.Close()
+		var errResp gofakes3.ErrorResponse
+		dec := xml.NewDecoder(res.Body)
+		ts.OK(dec.Decode(&errResp))
+
+		if errResp.Code != expectedCode {
+			ts.Fatal("bad code", errResp.Code, "!=", expectedCode)
+		}
+	}
+
+	t.Run("single-upload", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		addFile(ts.TT, w, "yep", []byte("stuff"))
+		assertUpload(ts, defaultBucket, w, &b, `"c13d88cb4cb02003daedb8a84e5d272a"`)
+		ts.assertObject(defaultBucket, "yep", nil, "stuff")
+	})
+
+	t.Run("multiple-files-fails", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		addFile(ts.TT, w, "yep", []byte("stuff"))
+		addFile(ts.TT, w, "nup", []byte("bork"))
+		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrIncorrectNumberOfFilesInPostRequest)
+	})
+
+	t.Run("key-too-large", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		addFile(ts.TT, w, strings.Repeat("a", gofakes3.KeySizeLimit+1), []byte("yep"))
+		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrKeyTooLong)
+	})
+
+	t.Run("content-md5", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		uploadWithMD5 := func(object, contentMD5 string) *http.Response {
+			var b bytes.Buffer
+			w := multipart.NewWriter(&b)
+			ts.OK(w.WriteField("Content-MD5", contentMD5))
+			addFile(ts.TT, w, object, []byte("stuff"))
+			res, err := upload(ts, defaultBucket, w, &b)
+			ts.OK(err)
+			return res
+		}
+
+		sum := md5.Sum([]byte("stuff"))
+		res := uploadWithMD5("good", base64.StdEncoding.EncodeToString(sum[:]))
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Fatal("bad status", res.StatusCode)
+		}
+		if etag := res.Header.Get("ETag"); etag != `"`+hex.EncodeToString(sum[:])+`"` {
+			t.Fatal("bad etag", etag)
+		}
+		ts.assertObject(defaultBucket, "good", nil, "stuff")
+
+		head, err := ts.s3Client().HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("good"),
+		})
+		ts.OK(err)
+		if aws.StringValue(head.ETag) != res.Header.Get("ETag") {
+			t.Fatal("etag mismatch", aws.StringValue(head.ETag), "!=", res.Header.Get("ETag"))
+		}
+
+		wrong := md5.Sum([]byte("other stuff"))
+		res = uploadWithMD5("bad", base64.StdEncoding.EncodeToString(wrong[:]))
+		res.Body.Close()
+		if res.StatusCode != gofakes3.ErrBadDigest.Status() {
+			t.Fatal("bad status", res.StatusCode)
+		}
+		if ts.backendObjectExists(defaultBucket, "bad") {
+			t.Fatal("object should not exist")
+		}
+	})
+
+	t.Run("success-action-status", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		for idx, tc := range []struct {
+			status   string
+			expected int
+		}{
+			{"200", http.StatusOK},
+			{"201", http.StatusCreated},
+			{"204", http.StatusNoContent},
+			{"999", http.StatusOK},
+		} {
+			t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+				var b bytes.Buffer
+				w := multipart.NewWriter(&b)
+				ts.OK(w.WriteField("success_action_status", tc.status))
+				addFile(ts.TT, w, "yep", []byte("stuff"))
+
+				res, err := upload(ts, defaultBucket, w, &b)
+				ts.OK(err)
+				defer res.Body.Close()
+				if res.StatusCode != tc.expected {
+					t.Fatal("bad status", res.StatusCode, "!=", tc.expected)
+				}
+
+				if tc.expected == http.StatusCreated {
+					var out gofakes3.PostResponse
+					ts.OK(xml.NewDecoder(res.Body).Decode(&out))
+					if out.Bucket != defaultBucket || out.Key != "yep" || out.ETag != `"c13d88cb4cb02003daedb8a84e5d272a"` {
+						t.Fatal("unexpected response", out)
+					}
+					if out.Location != ts.url("/"+defaultBucket+"/yep") {
+						t.Fatal("unexpected location", out.Location)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("success-action-redirect", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		ts.OK(w.WriteField("success_action_redirect", "http://example.com/done?from=form"))
+		addFile(ts.TT, w, "yep", []byte("stuff"))
+		w.Close()
+
+		req, err := http.NewRequest("POST", ts.url("/"+defaultBucket), &b)
+		ts.OK(err)
+		req.Header.Set("Content-Type", w.FormDataContentType())
+		client := httpClient()
+		client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+		res, err := client.Do(req)
+		ts.OK(err)
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusSeeOther {
+			t.Fatal("bad status", res.StatusCode)
+		}
+		expected := "http://example.com/done?bucket=" + defaultBucket + "&etag=%22c13d88cb4cb02003daedb8a84e5d272a%22&from=form&key=yep"
+		if loc := res.Header.Get("Location"); loc != expected {
+			t.Fatal("bad location", loc, "!=", expected)
+		}
+		ts.assertObject(defaultBucket, "yep", nil, "stuff")
+	})
+}
+
+func TestPreserveMetadataCase(t *testing.T) {
+	browserUpload := func(ts *testServer, key string) {
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		ts.OK(w.WriteField("key", key))
+		ts.OK(w.WriteField("x-amz-meta-MyKey", "value"))
+		mw, err := w.CreateFormFile("file", "upload")
+		ts.OK(err)
+		_, err = mw.Write([]byte("stuff"))
+		ts.OK(err)
+		ts.OK(w.Close())
+
+		rq, err := http.NewRequest("POST", ts.url("/"+defaultBucket), &b)
+		ts.OK(err)
+		rq.Header.Set("Content-Type", w.FormDataContentType())
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+	}
+
+	// net/http clients canonicalize the names of response headers, so the
+	// handler is called directly to see them as they were written:
+	headerNames := func(ts *testServer, key string) (names []string) {
+		rs := httptest.NewRecorder()
+		ts.Server().ServeHTTP(rs, httptest.NewRequest("HEAD", "/"+defaultBucket+"/"+key, nil))
+		if rs.Code != http.StatusOK {
+			t.Fatal("unexpected status", rs.Code)
+		}
+		for name := range rs.Header() {
+			if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	t.Run("preserved", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithPreserveMetadataCase()))
+		defer ts.Close()
+
+		browserUpload(ts, "upload")
+		if names := headerNames(ts, "upload"); !reflect.DeepEqual(names, []string{"x-amz-meta-MyKey"}) {
+			t.Fatal("unexpected metadata", names)
+		}
+
+		ts.backendPutString(defaultBucket, "backend", map[string]string{"X-AMZ-META-Shouty": "value"}, "stuff")
+		if names := headerNames(ts, "backend"); !reflect.DeepEqual(names, []string{"X-AMZ-META-Shouty"}) {
+			t.Fatal("unexpected metadata", names)
+		}
+	})
+
+	t.Run("canonical", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		browserUpload(ts, "upload")
+		if names := headerNames(ts, "upload"); !reflect.DeepEqual(names, []string{"X-Amz-Meta-Mykey"}) {
+			t.Fatal("unexpected metadata", names)
+		}
+	})
+}
+
+func TestVersioning(t *testing.T) {
+	assertVersioning := func(ts *testServer, mfa string, status string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		bv, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)})
+		ts.OK(err)
+		if aws.StringValue(bv.MFADelete) != mfa {
+			ts.Fatal("unexpected MFADelete")
+		}
+		if aws.StringValue(bv.Status) != status {
+			ts.Fatalf("unexpected Status %q, expected %q", aws.StringValue(bv.Status), status)
+		}
+	}
+
+	setVersioning := func(ts *testServer, status gofakes3.VersioningStatus) {
+		ts.Helper()
+		svc := ts.s3Client()
+		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String(string(status)),
+			},
+		}))
+	}
+
+	t.Run("", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		// Bucket that has never been versioned should return empty strings:
+		assertVersioning(ts, "", "")
+	})
+
+	t.Run("enable", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		setVersioning(ts, "Enabled")
+		assertVersioning(ts, "", "Enabled")
+	})
+
+	t.Run("suspend", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		assertVersioning(ts, "", "")
+
+		setVersioning(ts, gofakes3.VersioningEnabled)
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		assertVersioning(ts, "", "Suspended")
+	})
+
+	t.Run("suspend-version-ids", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		put := func(key string) string {
+			t.Helper()
+			out, err := svc.PutObject(&s3.PutObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String(key),
+				Body:   bytes.NewReader([]byte("hello")),
+			})
+			ts.OK(err)
+			return aws.StringValue(out.VersionId)
+		}
+		assertVersionID := func(key, expected string) {
+			t.Helper()
+			head, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)})
+			ts.OK(err)
+			if id := aws.StringValue(head.VersionId); id != expected {
+				t.Fatalf("unexpected HEAD version ID for %s: %q != %q", key, id, expected)
+			}
+			get, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(key)})
+			ts.OK(err)
+			get.Body.Close()
+			if id := aws.StringValue(get.VersionId); id != expected {
+				t.Fatalf("unexpected GET version ID for %s: %q != %q", key, id, expected)
+			}
+		}
+
+		put("before")
+		setVersioning(ts, gofakes3.VersioningEnabled)
+		versioned := put("versioned")
+		if versioned == "" {
+			t.Fatal("expected version ID")
+		}
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		put("suspended")
+
+		assertVersionID("before", "")
+		assertVersionID("versioned", versioned)
+		assertVersionID("suspended", "")
+	})
+
+	t.Run("no-versioning-suspend", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(
+			gofakes3.WithoutVersioning(),
+		))
+		defer ts.Close()
+
+		setVersioning(ts, "Suspended")
+		assertVersioning(ts, "", "")
+	})
+
+	t.Run("no-versioning-enable", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(
+			gofakes3.WithoutVersioning(),
+		))
+		defer ts.Close()
+
+		svc := ts.s3Client()
+		_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status: aws.String("Enabled"),
+			},
+		})
+		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+			ts.Fatal("expected ErrNotImplemented, found", err)
+		}
+	})
+
+	t.Run("response-shape", func(t *testing.T) {
+		// The SDK can't tell a missing Status from an empty one, so the XML
+		// is checked directly:
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		assertShape := func(expected string) {
+			t.Helper()
+			rs, err := httpClient().Get(ts.url("/" + defaultBucket + "?versioning"))
+			ts.OK(err)
+			body, err := ioutil.ReadAll(rs.Body)
+			rs.Body.Close()
+			ts.OK(err)
+			if found, expected := xmlShape(t, string(body)), xmlShape(t, expected); !reflect.DeepEqual(expected, found) {
+				t.Fatalf("unexpected XML:\nexp: %v\ngot: %v", expected, found)
+			}
+		}
+
+		// Never configured:
+		assertShape(`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/"/>`)
+
+		setVersioning(ts, gofakes3.VersioningEnabled)
+		assertShape(`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+			<Status>Enabled</Status>
+		</VersioningConfiguration>`)
+
+		setVersioning(ts, gofakes3.VersioningSuspended)
+		assertShape(`<VersioningConfiguration xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+			<Status>Suspended</Status>
+		</VersioningConfiguration>`)
+	})
+}
+
+func TestVersioningMFADelete(t *testing.T) {
+	const serial = "arn:aws:iam::123456789012:mfa/user"
+	token := "123456"
+
+	ts := newTestServer(t, withFakerOptions(
+		gofakes3.WithMFA(serial, func() string { return token }),
+	))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	putVersioning := func(mfa string) error {
+		input := &s3.PutBucketVersioningInput{
+			Bucket: aws.String(defaultBucket),
+			VersioningConfiguration: &s3.VersioningConfiguration{
+				Status:    aws.String("Enabled"),
+				MFADelete: aws.String("Enabled"),
+			},
+		}
+		if mfa != "" {
+			input.MFA = aws.String(mfa)
+		}
+		_, err := svc.PutBucketVersioning(input)
+		return err
+	}
+
+	deleteVersion := func(version, mfa string) error {
+		input := &s3.DeleteObjectInput{
+			Bucket:    aws.String(defaultBucket),
+			Key:       aws.String("object"),
+			VersionId: aws.String(version),
+		}
+		if mfa != "" {
+			input.MFA = aws.String(mfa)
+		}
+		_, err := svc.DeleteObject(input)
+		return err
+	}
+
+	if err := putVersioning(""); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	ts.OK(putVersioning(serial + " " + token))
+
+	bv, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if aws.StringValue(bv.MFADelete) != "Enabled" || aws.StringValue(bv.Status) != "Enabled" {
+		t.Fatal("unexpected versioning", bv)
+	}
+
+	out, err := svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   strings.NewReader("hello"),
+	})
+	ts.OK(err)
+	version := aws.StringValue(out.VersionId)
+
+	// Creating a delete marker isn't a permanent delete, so it doesn't need
+	// MFA:
+	ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	}))
+
+	for _, mfa := range []string{"", serial, serial + " 000000", "other " + token} {
+		if err := deleteVersion(version, mfa); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+			t.Fatalf("expected ErrAccessDenied for %q, found %v", mfa, err)
+		}
+	}
+
+	// The token function is called for each request:
+	token = "654321"
+	if err := deleteVersion(version, serial+" 123456"); !hasErrorCode(err, gofakes3.ErrAccessDenied) {
+		t.Fatal("expected ErrAccessDenied, found", err)
+	}
+	ts.OK(deleteVersion(version, serial+" "+token))
+
+	versions, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if len(versions.Versions) != 0 {
+		t.Fatal("version was not deleted", versions)
+	}
+}
+
+func TestObjectVersions(t *testing.T) {
+	create := func(ts *testServer, bucket, key string, contents []byte, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		out, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(contents),
+		})
+		ts.OK(err)
+		if aws.StringValue(out.VersionId) != version {
+			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		}
+	}
+
+	get := func(ts *testServer, bucket, key string, contents []byte, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		input := &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if version != "" {
+			input.VersionId = aws.String(version)
+		}
+		out, err := svc.GetObject(input)
+		ts.OK(err)
+		defer out.Body.Close()
+		bts, err := ioutil.ReadAll(out.Body)
+		ts.OK(err)
+		if !bytes.Equal(bts, contents) {
+			ts.Fatal("body mismatch. found:", string(bts), "expected:", string(contents))
+		}
+	}
+
+	deleteVersion := func(ts *testServer, bucket, key, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		if version != "" {
+			input.VersionId = aws.String(version)
+		}
+		ts.OKAll(svc.DeleteObject(input))
+	}
+
+	deleteDirect := func(ts *testServer, bucket, key, version string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		input := &s3.DeleteObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}
+		out, err := svc.DeleteObject(input)
+		ts.OK(err)
+		if aws.StringValue(out.VersionId) != version {
+			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		}
+	}
+
+	list := func(ts *testServer, bucket string, versions ...string) {
+		ts.Helper()
+		svc := ts.s3Client()
+		out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+		ts.OK(err)
+
+		var found []string
+		for _, ver := range out.Versions {
+			found = append(found, aws.StringValue(ver.VersionId))
+		}
+		for _, ver := range out.DeleteMarkers {
+			found = append(found, aws.StringValue(ver.VersionId))
+		}
+
+		// Unfortunately, the S3 client API destroys the order of Versions and
+		// DeleteMarkers, which are sibling elements in the XML body but separated
+		// into different lists by the client:
+		sort.Strings(found)
+		sort.Strings(versions)
+		if !reflect.DeepEqual(found, versions) {
+			ts.Fatal("versions mismatch. found:", found, "expected:", versions)
+		}
+	}
+
+	// XXX: version IDs are brittle; we control the seed, but the format may
+	// change at any time.
+	const v1 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1H03F9QN5V72K21OG="
+	const v2 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1I00G5II3TDAF7GRG="
+	const v3 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1J01VFV0CD31ES81G="
+
+	t.Run("put-list-delete-versions", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+		get(ts, defaultBucket, "object", []byte("body 1"), "")
+		list(ts, defaultBucket, v1)
+
+		create(ts, defaultBucket, "object", []byte("body 2"), v2)
+		get(ts, defaultBucket, "object", []byte("body 2"), "")
+		list(ts, defaultBucket, v1, v2)
+
+		create(ts, defaultBucket, "object", []byte("body 3"), v3)
+		get(ts, defaultBucket, "object", []byte("body 3"), "")
+		list(ts, defaultBucket, v1, v2, v3)
+
+		get(ts, defaultBucket, "object", []byte("body 1"), v1)
+		get(ts, defaultBucket, "object", []byte("body 2"), v2)
+		get(ts, defaultBucket, "object", []byte("body 3"), v3)
+		get(ts, defaultBucket, "object", []byte("body 3"), "")
+
+		deleteVersion(ts, defaultBucket, "object", v1)
+		list(ts, defaultBucket, v2, v3)
+		deleteVersion(ts, defaultBucket, "object", v2)
+		list(ts, defaultBucket, v3)
+		deleteVersion(ts, defaultBucket, "object", v3)
+		list(ts, defaultBucket)
+	})
+
+	t.Run("delete-direct", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+		list(ts, defaultBucket, v1)
+		create(ts, defaultBucket, "object", []byte("body 2"), v2)
+		list(ts, defaultBucket, v1, v2)
+
+		get(ts, defaultBucket, "object", []byte("body 2"), "")
+
+		deleteDirect(ts, defaultBucket, "object", v3)
+		list(ts, defaultBucket, v1, v2, v3)
+
+		svc := ts.s3Client()
+		_, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+			ts.Fatal("expected ErrNoSuchKey, found", err)
+		}
+	})
+
+	t.Run("delete-marker", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		assertDelete := func(version string, expectedVersion string, marker bool) {
+			t.Helper()
+			input := &s3.DeleteObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String("object"),
+			}
+			if version != "" {
+				input.VersionId = aws.String(version)
+			}
+			out, err := svc.DeleteObject(input)
+			ts.OK(err)
+			if aws.StringValue(out.VersionId) != expectedVersion {
+				t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", expectedVersion)
+			}
+			if aws.BoolValue(out.DeleteMarker) != marker {
+				t.Fatal("delete marker mismatch. found:", aws.BoolValue(out.DeleteMarker), "expected:", marker)
+			}
+		}
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+		create(ts, defaultBucket, "object", []byte("body 2"), v2)
+
+		// Deleting without a version creates a delete marker, but the
+		// versions remain retrievable:
+		assertDelete("", v3, true)
+		get(ts, defaultBucket, "object", []byte("body 1"), v1)
+		get(ts, defaultBucket, "object", []byte("body 2"), v2)
+
+		// Removing the delete marker makes the previous version current:
+		assertDelete(v3, v3, true)
+		get(ts, defaultBucket, "object", []byte("body 2"), "")
+		list(ts, defaultBucket, v1, v2)
+
+		// Removing a version permanently is not a delete marker:
+		assertDelete(v2, v2, false)
+		get(ts, defaultBucket, "object", []byte("body 1"), "")
+		list(ts, defaultBucket, v1)
+	})
+
+	t.Run("get-returns-version", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		create(ts, defaultBucket, "object", []byte("body 1"), v1)
+		create(ts, defaultBucket, "object", []byte("body 2"), v2)
+		create(ts, defaultBucket, "object", []byte("body 3"), v3)
+
+		assertVersion := func(version, expected string) {
+			t.Helper()
+			getInput := &s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")}
+			headInput := &s3.HeadObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("object")}
+			if version != "" {
+				getInput.VersionId = aws.String(version)
+				headInput.VersionId = aws.String(version)
+			}
+
+			getOut, err := svc.GetObject(getInput)
+			ts.OK(err)
+			getOut.Body.Close()
+			if aws.StringValue(getOut.VersionId) != expected {
+				t.Fatal("GET version ID mismatch. found:", aws.StringValue(getOut.VersionId), "expected:", expected)
+			}
+
+			headOut, err := svc.HeadObject(headInput)
+			ts.OK(err)
+			if aws.StringValue(headOut.VersionId) != expected {
+				t.Fatal("HEAD version ID mismatch. found:", aws.StringValue(headOut.VersionId), "expected:", expected)
+			}
+		}
+
+		assertVersion("", v3)
+		assertVersion(v1, v1)
+		assertVersion(v2, v2)
+	})
+
+	t.Run("get-never-versioned", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		const neverVerBucket = "neverver"
+		ts.backendCreateBucket(neverVerBucket)
+		ts.backendPutString(neverVerBucket, "object", nil, "body 1")
+
+		headOut, err := svc.HeadObject(&s3.HeadObjectInput{Bucket: aws.String(neverVerBucket), Key: aws.String("object")})
+		ts.OK(err)
+		if headOut.VersionId != nil {
+			t.Fatal("unexpected version ID", aws.StringValue(headOut.VersionId))
+		}
+	})
+
+	t.Run("list-never-versioned", func(t *testing.T) {
+		ts := newTestServer(t, withVersioning())
+		defer ts.Close()
+
+		const neverVerBucket = "neverver"
+		ts.backendCreateBucket(neverVerBucket)
+
+		ts.backendPutString(neverVerBucket, "object", nil, "body 1")
+		list(ts, neverVerBucket, "null") // S300005
+	})
+}
+
+func TestListBucketPages(t *testing.T) {
+	createData := func(ts *testServer, prefix string, n int64) []string {
+		keys := make([]string, n)
+		for i := int64(0); i < n; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
+			keys[i] = key
+		}
+		return keys
+	}
+
+	assertKeys := func(ts *testServer, rs *listBucketResult, keys ...string) {
+		found := make([]string, len(rs.Contents))
+		for i := 0; i < len(rs.Contents); i++ {
+			found[i] = aws.StringValue(rs.Contents[i].Key)
+		}
+		if !reflect.DeepEqual(found, keys) {
+			t.Fatal("key mismatch:", keys, "!=", found)
+		}
+	}
+
+	for idx, tc := range []struct {
+		keys, pageKeys int64
+	}{
+		{9, 2},
+		{8, 3},
+		{7, 4},
+		{6, 5},
+		{5, 6},
+	} {
+		t.Run(fmt.Sprintf("list-page-basic/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+			keys := createData(ts, "", tc.keys)
+
+			rs := ts.mustListBucketV1Pages(nil, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+
+			rs = ts.mustListBucketV2Pages(nil, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+		})
+
+		t.Run(fmt.Sprintf("list-page-prefix/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			// junk keys with no prefix to ensure that we are actually limiting the output.
+			// these should not show up in the output.
+			createData(ts, "", tc.keys)
+
+			// these are the actual keys we expect to see:
+			keys := createData(ts, "test", tc.keys)
+
+			prefix := gofakes3.NewPrefix(aws.String("test"), nil)
+
+			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+
+			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
+			if len(rs.CommonPrefixes) > 0 {
+				t.Fatal()
+			}
+			assertKeys(ts, rs, keys...)
+		})
+
+		t.Run(fmt.Sprintf("list-page-prefix-delim/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+
+			// junk keys with no prefix to ensure that we are actually limiting the output.
+			// these should not show up in the output.
+			createData(ts, "", tc.keys)
+
+			// these are the actual keys we expect to see:
+			keys := createData(ts, "test/", tc.keys)
+
+			// add some common prefixes:
+			createData(ts, "test/prefix1/", 2)
+			createData(ts, "test/prefix2/", 2)
+
+			prefix := gofakes3.NewFolderPrefix("test/")
+
+			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
+			assertKeys(ts, rs, keys...)
+
+			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
+			assertKeys(ts, rs, keys...)
+
+			// FIXME: there are some unanswered questions for the assumer about
+			// how CommonPrefixes interacts with paging; CommonPrefixes should be
+			// checked once we've established how S3 actually behaves.
+		})
+	}
+}
+
+// Ensure that a backend that does not support pagination can use the fallback if enabled:
+func TestListBucketPagesFallback(t *testing.T) {
+	createData := func(ts *testServer, prefix string, n int64) []string {
+		keys := make([]string, n)
+		for i := int64(0); i < n; i++ {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
+			keys[i] = key
+		}
+		return keys
+	}
+
+	t.Run("fallback-disabled", func(t *testing.T) {
+		ts := newTestServer(t,
+			withBackend(&backendWithUnimplementedPaging{s3mem.New()}),
+			withFakerOptions(gofakes3.WithUnimplementedPageError()),
+		)
+		defer ts.Close()
+		createData(ts, "", 5)
+		_, err := ts.listBucketV1Pages(nil, 2, "")
+		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("fallback-enabled", func(t *testing.T) {
+		ts := newTestServer(t, withBackend(&backendWithUnimplementedPaging{s3mem.New()}))
+		defer ts.Close()
+		createData(ts, "", 5)
+		r := ts.mustListBucketV1Pages(nil, 2, "")
+
+		// The pages should be cut out of the full listing by GoFakeS3:
+		if len(r.Contents) != 5 {
+			t.Fatal()
+		}
+
+		out, err := ts.s3Client().ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:  aws.String(defaultBucket),
+			MaxKeys: aws.Int64(2),
 		})
 		ts.OK(err)
-		assertDeletedKeys(t, rs, "bar", "foo")
-		ts.assertLs(defaultBucket, "", nil, []string{"baz"})
+		if len(out.Contents) != 2 || !aws.BoolValue(out.IsTruncated) {
+			t.Fatal("expected a truncated page of 2 keys, found", len(out.Contents), aws.BoolValue(out.IsTruncated))
+		}
+	})
+
+	t.Run("fallback-common-prefixes", func(t *testing.T) {
+		ts := newTestServer(t, withBackend(&backendWithUnimplementedPaging{s3mem.New()}))
+		defer ts.Close()
+		createData(ts, "a/", 3)
+		createData(ts, "b", 1)
+		createData(ts, "c/", 3)
+		createData(ts, "d", 1)
+
+		prefix := gofakes3.NewPrefix(nil, aws.String("/"))
+		for _, pageKeys := range []int64{1, 2, 3, 1000} {
+			r := ts.mustListBucketV2Pages(&prefix, pageKeys, "")
+			var found []string
+			for _, cp := range r.CommonPrefixes {
+				found = append(found, aws.StringValue(cp.Prefix))
+			}
+			for _, c := range r.Contents {
+				found = append(found, aws.StringValue(c.Key))
+			}
+			if !reflect.DeepEqual(found, []string{"a/", "c/", "b0", "d0"}) {
+				t.Fatal("unexpected listing with page size", pageKeys, found)
+			}
+		}
 	})
 }
 
-func TestGetObjectRange(t *testing.T) {
-	assertRange := func(ts *testServer, key string, hdr string, expected []byte, fail bool) {
-		ts.Helper()
-		svc := ts.s3Client()
-		obj, err := svc.GetObject(&s3.GetObjectInput{
+func s3HasErrorCode(err error, code gofakes3.ErrorCode) bool {
+	if err, ok := err.(awserr.Error); ok {
+		return code == gofakes3.ErrorCode(err.Code())
+	}
+	return false
+}
+
+func tryDumpResponse(rs *http.Response, body bool) string {
+	b, _ := httputil.DumpResponse(rs, body)
+	return string(b)
+}
+
+func TestCORSPreflight(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	preflight := func(bucket, origin, method, headers string) *httptest.ResponseRecorder {
+		t.Helper()
+		rq := httptest.NewRequest("OPTIONS", "/"+bucket+"/object", nil)
+		rq.Header.Set("Origin", origin)
+		rq.Header.Set("Access-Control-Request-Method", method)
+		if headers != "" {
+			rq.Header.Set("Access-Control-Request-Headers", headers)
+		}
+		rs := httptest.NewRecorder()
+		ts.Server().ServeHTTP(rs, rq)
+		return rs
+	}
+
+	{ // Default rules allow the advertised headers, and echo back only those requested:
+		rs := preflight(defaultBucket, "http://example.com", "PUT", "Content-Type, X-Amz-Meta-From")
+		if rs.Code != http.StatusOK {
+			t.Fatal("bad status", rs.Code)
+		}
+		if v := rs.Header().Get("Access-Control-Allow-Origin"); v != "*" {
+			t.Fatal("bad origin", v)
+		}
+		if v := rs.Header().Get("Access-Control-Allow-Methods"); v != "PUT" {
+			t.Fatal("bad methods", v)
+		}
+		if v := rs.Header().Get("Access-Control-Allow-Headers"); v != "content-type, x-amz-meta-from" {
+			t.Fatal("bad headers", v)
+		}
+	}
+
+	{ // Default rules do not allow arbitrary headers:
+		rs := preflight(defaultBucket, "http://example.com", "PUT", "Content-Type, X-Custom")
+		if rs.Code != http.StatusForbidden {
+			t.Fatal("bad status", rs.Code)
+		}
+		if v := rs.Header().Get("Access-Control-Allow-Headers"); v != "" {
+			t.Fatal("unexpected headers", v)
+		}
+	}
+
+	ts.OKAll(svc.PutBucketCors(&s3.PutBucketCorsInput{
+		Bucket: aws.String(defaultBucket),
+		CORSConfiguration: &s3.CORSConfiguration{
+			CORSRules: []*s3.CORSRule{{
+				AllowedOrigins: aws.StringSlice([]string{"https://*.example.com"}),
+				AllowedMethods: aws.StringSlice([]string{"PUT"}),
+				AllowedHeaders: aws.StringSlice([]string{"Content-Type", "x-amz-meta-*"}),
+				ExposeHeaders:  aws.StringSlice([]string{"ETag"}),
+				MaxAgeSeconds:  aws.Int64(300),
+			}},
+		},
+	}))
+
+	{ // Bucket rules replace the defaults:
+		rs := preflight(defaultBucket, "https://app.example.com", "PUT", "content-type,x-amz-meta-custom")
+		if rs.Code != http.StatusOK {
+			t.Fatal("bad status", rs.Code)
+		}
+		if v := rs.Header().Get("Access-Control-Allow-Origin"); v != "https://app.example.com" {
+			t.Fatal("bad origin", v)
+		}
+		if v := rs.Header().Get("Access-Control-Allow-Headers"); v != "content-type, x-amz-meta-custom" {
+			t.Fatal("bad headers", v)
+		}
+		if v := rs.Header().Get("Access-Control-Expose-Headers"); v != "ETag" {
+			t.Fatal("bad expose headers", v)
+		}
+		if v := rs.Header().Get("Access-Control-Max-Age"); v != "300" {
+			t.Fatal("bad max age", v)
+		}
+	}
+
+	for idx, tc := range []struct {
+		origin, method, headers string
+	}{
+		{"https://app.example.com", "PUT", "content-type, x-amz-acl"},
+		{"https://app.example.com", "DELETE", ""},
+		{"https://example.org", "PUT", "content-type"},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			rs := preflight(defaultBucket, tc.origin, tc.method, tc.headers)
+			if rs.Code != http.StatusForbidden {
+				t.Fatal("bad status", rs.Code)
+			}
+			var errResp gofakes3.ErrorResponse
+			ts.OK(xml.Unmarshal(rs.Body.Bytes(), &errResp))
+			if errResp.Code != gofakes3.ErrAccessDenied {
+				t.Fatal("bad code", errResp.Code)
+			}
+		})
+	}
+
+	out, err := svc.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(defaultBucket)})
+	ts.OK(err)
+	if len(out.CORSRules) != 1 || aws.Int64Value(out.CORSRules[0].MaxAgeSeconds) != 300 {
+		t.Fatal("unexpected rules", out.CORSRules)
+	}
+
+	ts.OKAll(svc.DeleteBucketCors(&s3.DeleteBucketCorsInput{Bucket: aws.String(defaultBucket)}))
+	_, err = svc.GetBucketCors(&s3.GetBucketCorsInput{Bucket: aws.String(defaultBucket)})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchCORSConfiguration) {
+		t.Fatal("expected NoSuchCORSConfiguration, found", err)
+	}
+}
+
+func TestEmptyResponses(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	do := func(method, path string) *http.Response {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(path), nil)
+		ts.OK(err)
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		body, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		if len(body) != 0 {
+			t.Fatal("unexpected body", string(body))
+		}
+		return rs
+	}
+
+	for idx, tc := range []struct {
+		method, path string
+		status       int
+	}{
+		{"HEAD", "/" + defaultBucket, http.StatusOK},
+		{"HEAD", "/" + defaultBucket + "/missing", http.StatusNotFound},
+		{"HEAD", "/missing", http.StatusNotFound},
+		{"PUT", "/newbucket", http.StatusOK},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			rs := do(tc.method, tc.path)
+			if rs.StatusCode != tc.status {
+				t.Fatal("bad status", rs.StatusCode, "!=", tc.status)
+			}
+			if rs.Header.Get("Content-Length") != "0" {
+				t.Fatalf("expected Content-Length: 0, found %q", rs.Header.Get("Content-Length"))
+			}
+		})
+	}
+
+	for idx, path := range []string{"/" + defaultBucket + "/object", "/newbucket"} {
+		t.Run(fmt.Sprintf("delete/%d", idx), func(t *testing.T) {
+			rs := do("DELETE", path)
+			if rs.StatusCode != http.StatusNoContent {
+				t.Fatal("bad status", rs.StatusCode)
+			}
+		})
+	}
+}
+
+func TestErrorResponseContentLength(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	for idx, tc := range []struct {
+		method, path string
+		code         gofakes3.ErrorCode
+	}{
+		{"GET", "/" + defaultBucket + "/missing", gofakes3.ErrNoSuchKey},
+		{"GET", "/missing", gofakes3.ErrNoSuchBucket},
+		{"PUT", "/" + defaultBucket + "?requestPayment", gofakes3.ErrMalformedXML},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			rq, err := http.NewRequest(tc.method, ts.url(tc.path), nil)
+			ts.OK(err)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+			body, err := ioutil.ReadAll(rs.Body)
+			ts.OK(err)
+
+			if len(rs.TransferEncoding) != 0 {
+				t.Fatal("unexpected transfer encoding", rs.TransferEncoding)
+			}
+			if rs.ContentLength != int64(len(body)) {
+				t.Fatal("bad content length", rs.ContentLength, "!=", len(body))
+			}
+			if rs.Header.Get("Content-Type") != "application/xml" {
+				t.Fatal("bad content type", rs.Header.Get("Content-Type"))
+			}
+
+			var errResp gofakes3.ErrorResponse
+			ts.OK(xml.Unmarshal(body, &errResp))
+			if errResp.Code != tc.code || rs.StatusCode != tc.code.Status() {
+				t.Fatal("unexpected error", rs.StatusCode, errResp.Code)
+			}
+		})
+	}
+}
+
+func TestErrorResponseHeaders(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	// ResponseRecorder.Result() only has the headers that were set by the
+	// time the status was written, so anything set afterwards is missing:
+	for _, method := range []string{"GET", "HEAD"} {
+		t.Run(method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ts.Server().ServeHTTP(rec, httptest.NewRequest(method, "/"+defaultBucket+"/missing", nil))
+			rs := rec.Result()
+			if rs.StatusCode != http.StatusNotFound {
+				t.Fatal("unexpected status", rs.StatusCode)
+			}
+			if rs.Header.Get("x-amz-request-id") == "" {
+				t.Fatal("missing x-amz-request-id")
+			}
+			if method == "HEAD" {
+				if rs.Header.Get("Content-Length") != "0" {
+					t.Fatal("bad content length", rs.Header.Get("Content-Length"))
+				}
+				return
+			}
+			if rs.Header.Get("Content-Type") != "application/xml" {
+				t.Fatalf("bad content type %q", rs.Header.Get("Content-Type"))
+			}
+			if rs.Header.Get("Content-Length") != fmt.Sprint(rec.Body.Len()) {
+				t.Fatal("bad content length", rs.Header.Get("Content-Length"), rec.Body.Len())
+			}
+		})
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	shard := func(key string) string {
+		sum := sha1.Sum([]byte(key))
+		return hex.EncodeToString(sum[:]) + "/" + key
+	}
+	unshard := func(key string) string {
+		return key[strings.IndexByte(key, '/')+1:]
+	}
+
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithKeyTransform(shard, unshard)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	keys := []string{"d", "a/c", "a/b/2", "a/b/1"}
+	for _, key := range keys {
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
 			Bucket: aws.String(defaultBucket),
 			Key:    aws.String(key),
-			Range:  aws.String(hdr),
+			Body:   bytes.NewReader([]byte("body " + key)),
+		}))
+
+		if !ts.backendObjectExists(defaultBucket, shard(key)) {
+			t.Fatal("expected sharded key in backend for", key)
+		}
+		if ts.backendObjectExists(defaultBucket, key) {
+			t.Fatal("unexpected unsharded key in backend for", key)
+		}
+	}
+
+	out, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("a/b/1"),
+	})
+	ts.OK(err)
+	body, err := ioutil.ReadAll(out.Body)
+	out.Body.Close()
+	ts.OK(err)
+	if string(body) != "body a/b/1" {
+		t.Fatal("unexpected body", string(body))
+	}
+
+	ts.assertLs(defaultBucket, "", []string{"a/"}, []string{"d"})
+	ts.assertLs(defaultBucket, "a/", []string{"a/b/"}, []string{"a/c"})
+	ts.assertLs(defaultBucket, "a/b/", nil, []string{"a/b/1", "a/b/2"})
+
+	rs := ts.mustListBucketV2Pages(nil, 1, "")
+	var found []string
+	for _, item := range rs.Contents {
+		found = append(found, aws.StringValue(item.Key))
+	}
+	if expected := []string{"a/b/1", "a/b/2", "a/c", "d"}; !reflect.DeepEqual(found, expected) {
+		t.Fatal("keys mismatch. found:", found, "expected:", expected)
+	}
+
+	// The page is full, but no keys after it match the prefix:
+	exact, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:  aws.String(defaultBucket),
+		Prefix:  aws.String("a/b/"),
+		MaxKeys: aws.Int64(2),
+	})
+	ts.OK(err)
+	if aws.BoolValue(exact.IsTruncated) || len(exact.Contents) != 2 {
+		t.Fatal("unexpected truncated listing", exact)
+	}
+
+	ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("d"),
+	}))
+	if ts.backendObjectExists(defaultBucket, shard("d")) {
+		t.Fatal("expected sharded key to be deleted")
+	}
+}
+
+func TestBrowserUploadPolicy(t *testing.T) {
+	const (
+		accessKey = "AKIDEXAMPLE"
+		secretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+	)
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+
+	// sign returns the form fields for a policy with the conditions, signed
+	// using Signature Version 4:
+	sign := func(secret string, expiration time.Time, conditions ...interface{}) map[string]string {
+		date := defaultDate.Format("20060102")
+		credential := accessKey + "/" + date + "/us-east-1/s3/aws4_request"
+		conditions = append(conditions,
+			map[string]string{"x-amz-algorithm": "AWS4-HMAC-SHA256"},
+			map[string]string{"x-amz-credential": credential},
+			map[string]string{"x-amz-date": defaultDate.Format("20060102T150405Z")},
+		)
+
+		raw, err := json.Marshal(map[string]interface{}{
+			"expiration": expiration.Format(time.RFC3339),
+			"conditions": conditions,
 		})
-		if fail != (err != nil) {
-			ts.Fatal("failure expected:", fail, "found:", err)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy := base64.StdEncoding.EncodeToString(raw)
+
+		key := hmacSHA256([]byte("AWS4"+secret), date)
+		key = hmacSHA256(key, "us-east-1")
+		key = hmacSHA256(key, "s3")
+		key = hmacSHA256(key, "aws4_request")
+
+		return map[string]string{
+			"policy":           policy,
+			"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+			"x-amz-credential": credential,
+			"x-amz-date":       defaultDate.Format("20060102T150405Z"),
+			"x-amz-signature":  hex.EncodeToString(hmacSHA256(key, policy)),
 		}
-		if !fail {
-			ts.OK(err)
-			defer obj.Body.Close()
+	}
 
-			out, err := ioutil.ReadAll(obj.Body)
-			ts.OK(err)
-			if !bytes.Equal(expected, out) {
-				ts.Fatal("range failed", hdr, err)
-			}
+	upload := func(ts *testServer, key string, fields map[string]string, body []byte) *http.Response {
+		t.Helper()
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		ts.OK(w.WriteField("key", key))
+		for k, v := range fields {
+			ts.OK(w.WriteField(k, v))
 		}
+		mw, err := w.CreateFormFile("file", "upload")
+		ts.OK(err)
+		ts.OKAll(mw.Write(body))
+		ts.OK(w.Close())
+
+		rq, err := http.NewRequest("POST", ts.url("/"+defaultBucket), &b)
+		ts.OK(err)
+		rq.Header.Set("Content-Type", w.FormDataContentType())
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		return rs
 	}
 
-	in := randomFileBody(1024)
+	expires := defaultDate.Add(time.Hour)
+	conditions := []interface{}{
+		map[string]string{"bucket": defaultBucket},
+		[]interface{}{"starts-with", "$key", "uploads/"},
+		[]interface{}{"content-length-range", 1, 10},
+	}
+
+	t.Run("no-credentials", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+
+		rs := upload(ts, "anything", nil, []byte("hello"))
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("bad status", rs.StatusCode)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithCredentials(map[string]string{accessKey: secretKey})))
+		defer ts.Close()
+
+		rs := upload(ts, "uploads/yep", sign(secretKey, expires, conditions...), []byte("hello"))
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("bad status", rs.StatusCode)
+		}
+		ts.assertObject(defaultBucket, "uploads/yep", nil, "hello")
+	})
+
+	withField := func(fields map[string]string, k, v string) map[string]string {
+		fields[k] = v
+		return fields
+	}
 
 	for idx, tc := range []struct {
-		hdr      string
-		expected []byte
-		fail     bool
+		key     string
+		fields  map[string]string
+		body    string
+		advance time.Duration
 	}{
-		{"bytes=0-", in, false},
-		{"bytes=1-", in[1:], false},
-		{"bytes=0-0", in[:1], false},
-		{"bytes=0-1", in[:2], false},
-		{"bytes=1023-1023", in[1023:1024], false},
+		// No policy:
+		{key: "uploads/yep", body: "hello"},
 
-		// if the requested end is beyond the real end, returns "remainder of the representation"
-		{"bytes=1023-1025", in[1023:1024], false},
+		// Bad signature:
+		{key: "uploads/yep", fields: sign("wrong", expires, conditions...), body: "hello"},
 
-		// if the requested start is beyond the real end, it should fail
-		{"bytes=1024-1024", []byte{}, true},
+		// Expired:
+		{key: "uploads/yep", fields: sign(secretKey, expires, conditions...), body: "hello", advance: 2 * time.Hour},
 
-		// suffix-byte-range-spec:
-		{"bytes=-0", []byte{}, true},
-		{"bytes=-1", in[1023:1024], false},
-		{"bytes=-1024", in, false},
-		{"bytes=-1025", in, true},
+		// Key does not start with "uploads/":
+		{key: "elsewhere/yep", fields: sign(secretKey, expires, conditions...), body: "hello"},
+
+		// Outside the content-length-range:
+		{key: "uploads/yep", fields: sign(secretKey, expires, conditions...), body: "too long for the range"},
+		{key: "uploads/yep", fields: sign(secretKey, expires, conditions...), body: ""},
+
+		// Field not covered by any condition:
+		{key: "uploads/yep", fields: withField(sign(secretKey, expires, conditions...), "x-amz-meta-foo", "bar"), body: "hello"},
 	} {
-		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
-			ts := newTestServer(t)
+		t.Run(fmt.Sprintf("fails/%d", idx), func(t *testing.T) {
+			ts := newTestServer(t, withFakerOptions(gofakes3.WithCredentials(map[string]string{accessKey: secretKey})))
 			defer ts.Close()
+			ts.Advance(tc.advance)
 
-			ts.backendPutBytes(defaultBucket, "foo", nil, in)
-			assertRange(ts, "foo", tc.hdr, tc.expected, tc.fail)
+			rs := upload(ts, tc.key, tc.fields, []byte(tc.body))
+			defer rs.Body.Close()
+			if rs.StatusCode != http.StatusForbidden {
+				t.Fatal("bad status", rs.StatusCode)
+			}
+			var errResp gofakes3.ErrorResponse
+			ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+			if errResp.Code != gofakes3.ErrAccessDenied {
+				t.Fatal("bad code", errResp.Code)
+			}
+			if ts.backendObjectExists(defaultBucket, tc.key) {
+				t.Fatal("object should not exist")
+			}
 		})
 	}
 }
 
-func TestGetObjectRangeInvalid(t *testing.T) {
-	assertRangeInvalid := func(ts *testServer, key string, hdr string) {
-		svc := ts.s3Client()
-		_, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: aws.String(defaultBucket),
-			Key:    aws.String(key),
-			Range:  aws.String(hdr),
-		})
-		if !hasErrorCode(err, gofakes3.ErrInvalidRange) {
-			ts.Fatal("expected ErrInvalidRange, found", err)
-		}
-	}
+func TestContentEncodingRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
 
-	in := randomFileBody(1024)
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	ts.OKAll(gz.Write([]byte(`{"hello": "world"}`)))
+	ts.OK(gz.Close())
 
-	for idx, tc := range []struct {
-		hdr string
-	}{
-		{"boats=0-0"},
-		{"bytes="},
-	} {
-		t.Run(fmt.Sprintf("%d/%s", idx, tc.hdr), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("object.json.gz"),
+		Body:            bytes.NewReader(compressed.Bytes()),
+		ContentEncoding: aws.String("gzip"),
+		ContentType:     aws.String("application/json"),
+	}))
 
-			ts.backendPutBytes(defaultBucket, "foo", nil, in)
-			assertRangeInvalid(ts, "foo", tc.hdr)
-		})
+	// The transport must not decompress the body for us, otherwise we
+	// couldn't tell whether GoFakeS3 did:
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	rs, err := client.Get(ts.url("/" + defaultBucket + "/object.json.gz"))
+	ts.OK(err)
+	defer rs.Body.Close()
+
+	if v := rs.Header.Get("Content-Encoding"); v != "gzip" {
+		t.Fatal("bad Content-Encoding", v)
+	}
+	if v := rs.Header.Get("Content-Type"); v != "application/json" {
+		t.Fatal("bad Content-Type", v)
+	}
+	body, err := ioutil.ReadAll(rs.Body)
+	ts.OK(err)
+	if !bytes.Equal(body, compressed.Bytes()) {
+		t.Fatal("body was not returned as stored")
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object.json.gz"),
+	})
+	ts.OK(err)
+	if aws.StringValue(head.ContentEncoding) != "gzip" {
+		t.Fatal("bad Content-Encoding", aws.StringValue(head.ContentEncoding))
 	}
 }
 
-func TestCreateObjectBrowserUpload(t *testing.T) {
-	addFile := func(tt gofakes3.TT, w *multipart.Writer, object string, b []byte) {
-		tt.Helper()
-		tt.OK(w.WriteField("key", object))
+func TestCacheHeadersRoundTrip(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
 
-		mw, err := w.CreateFormFile("file", "upload")
-		tt.OK(err)
-		n, err := mw.Write(b)
-		if n != len(b) {
-			tt.Fatal("len mismatch", n, "!=", len(b))
-		}
-		tt.OK(err)
+	// Already in the past, which should make no difference to GoFakeS3:
+	expires := time.Date(2019, 1, 12, 3, 4, 5, 0, time.UTC)
+
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket:       aws.String(defaultBucket),
+		Key:          aws.String("object"),
+		Body:         bytes.NewReader([]byte("hello")),
+		CacheControl: aws.String("max-age=3600, public"),
+		Expires:      aws.Time(expires),
+		Metadata:     map[string]*string{"Foo": aws.String("bar")},
+	}))
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	obj.Body.Close()
+	if v := aws.StringValue(obj.CacheControl); v != "max-age=3600, public" {
+		t.Fatal("bad Cache-Control", v)
+	}
+	if v := aws.StringValue(obj.Expires); v != "Sat, 12 Jan 2019 03:04:05 GMT" {
+		t.Fatal("bad Expires", v)
 	}
 
-	upload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader) (*http.Response, error) {
-		w.Close()
-		req, err := http.NewRequest("POST", ts.url("/"+bucket), body)
-		ts.OK(err)
-		req.Header.Set("Content-Type", w.FormDataContentType())
-		return httpClient().Do(req)
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	if v := aws.StringValue(head.CacheControl); v != "max-age=3600, public" {
+		t.Fatal("bad Cache-Control", v)
+	}
+	if v := aws.StringValue(head.Expires); v != "Sat, 12 Jan 2019 03:04:05 GMT" {
+		t.Fatal("bad Expires", v)
 	}
 
-	assertUpload := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, etag string) {
-		res, err := upload(ts, bucket, w, body)
-		ts.OK(err)
-		if res.StatusCode != http.StatusOK {
-			ts.Fatal("bad status", res.StatusCode, tryDumpResponse(res, true))
-		}
-		if etag != "" && res.Header.Get("ETag") != etag {
-			ts.Fatal("bad etag", res.Header.Get("ETag"), etag)
+	// These are system metadata, not user metadata:
+	if len(head.Metadata) != 1 || aws.StringValue(head.Metadata["Foo"]) != "bar" {
+		t.Fatal("unexpected user metadata", head.Metadata)
+	}
+}
+
+// reverseLinesSelector is a stand-in for a query engine: it ignores the
+// expression and returns the lines of the object in reverse order.
+type reverseLinesSelector struct{}
+
+func (reverseLinesSelector) Select(rq *gofakes3.SelectObjectContentRequest, from io.Reader, out io.Writer) error {
+	data, err := ioutil.ReadAll(from)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if _, err := io.WriteString(out, lines[i]+"\n"); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	assertUploadFails := func(ts *testServer, bucket string, w *multipart.Writer, body io.Reader, expectedCode gofakes3.ErrorCode) {
-		res, err := upload(ts, bucket, w, body)
-		ts.OK(err)
-		if res.StatusCode != expectedCode.Status() {
-			ts.Fatal("bad status", res.StatusCode, "!=", expectedCode.Status())
+// csvSelector is a stand-in for a query engine that understands CSV: it
+// ignores the expression and returns every record of the object.
+type csvSelector struct{}
+
+func (csvSelector) Select(rq *gofakes3.SelectObjectContentRequest, from io.Reader, out io.Writer) error {
+	records, err := csv.NewReader(from).ReadAll()
+	if err != nil {
+		return err
+	}
+	cw := gofakes3.NewSelectCSVWriter(out, rq.OutputSerialization.CSV)
+	for _, record := range records {
+		if err := cw.Write(record); err != nil {
+			return err
 		}
-		defer res.Body.Close()
-		var errResp gofakes3.ErrorResponse
-		dec := xml.NewDecoder(res.Body)
-		ts.OK(dec.Decode(&errResp))
+	}
+	return cw.Flush()
+}
 
-		if errResp.Code != expectedCode {
-			ts.Fatal("bad code", errResp.Code, "!=", expectedCode)
+func TestSelectObjectContent(t *testing.T) {
+	selectInput := func(compression string) *s3.SelectObjectContentInput {
+		return &s3.SelectObjectContentInput{
+			Bucket:         aws.String(defaultBucket),
+			Key:            aws.String("object"),
+			Expression:     aws.String("SELECT * FROM S3Object"),
+			ExpressionType: aws.String(s3.ExpressionTypeSql),
+			InputSerialization: &s3.InputSerialization{
+				CSV:             &s3.CSVInput{},
+				CompressionType: aws.String(compression),
+			},
+			OutputSerialization: &s3.OutputSerialization{CSV: &s3.CSVOutput{}},
 		}
 	}
 
-	t.Run("single-upload", func(t *testing.T) {
-		ts := newTestServer(t)
+	t.Run("selector", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithSelector(reverseLinesSelector{})))
 		defer ts.Close()
-		var b bytes.Buffer
-		w := multipart.NewWriter(&b)
-		addFile(ts.TT, w, "yep", []byte("stuff"))
-		assertUpload(ts, defaultBucket, w, &b, `"c13d88cb4cb02003daedb8a84e5d272a"`)
-		ts.assertObject(defaultBucket, "yep", nil, "stuff")
-	})
+		svc := ts.s3Client()
 
-	t.Run("multiple-files-fails", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		var b bytes.Buffer
-		w := multipart.NewWriter(&b)
-		addFile(ts.TT, w, "yep", []byte("stuff"))
-		addFile(ts.TT, w, "nup", []byte("bork"))
-		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrIncorrectNumberOfFilesInPostRequest)
-	})
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		ts.OKAll(gz.Write([]byte("a,b\nc,d\ne,f\n")))
+		ts.OK(gz.Close())
+		ts.backendPutBytes(defaultBucket, "object", nil, compressed.Bytes())
 
-	t.Run("key-too-large", func(t *testing.T) {
-		ts := newTestServer(t)
-		defer ts.Close()
-		var b bytes.Buffer
-		w := multipart.NewWriter(&b)
-		addFile(ts.TT, w, strings.Repeat("a", gofakes3.KeySizeLimit+1), []byte("yep"))
-		assertUploadFails(ts, defaultBucket, w, &b, gofakes3.ErrKeyTooLong)
+		out, err := svc.SelectObjectContent(selectInput(s3.CompressionTypeGzip))
+		ts.OK(err)
+		defer out.EventStream.Close()
+
+		var records bytes.Buffer
+		var stats *s3.Stats
+		var ended bool
+		for event := range out.EventStream.Events() {
+			switch event := event.(type) {
+			case *s3.RecordsEvent:
+				records.Write(event.Payload)
+			case *s3.StatsEvent:
+				stats = event.Details
+			case *s3.EndEvent:
+				ended = true
+			}
+		}
+		ts.OK(out.EventStream.Err())
+
+		if records.String() != "e,f\nc,d\na,b\n" {
+			t.Fatalf("unexpected records %q", records.String())
+		}
+		if !ended {
+			t.Fatal("missing End event")
+		}
+		if stats == nil ||
+			aws.Int64Value(stats.BytesScanned) != int64(compressed.Len()) ||
+			aws.Int64Value(stats.BytesProcessed) != 12 ||
+			aws.Int64Value(stats.BytesReturned) != 12 {
+			t.Fatal("unexpected stats", stats)
+		}
 	})
-}
 
-func TestVersioning(t *testing.T) {
-	assertVersioning := func(ts *testServer, mfa string, status string) {
-		ts.Helper()
+	t.Run("csv-round-trip", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithSelector(csvSelector{})))
+		defer ts.Close()
 		svc := ts.s3Client()
-		bv, err := svc.GetBucketVersioning(&s3.GetBucketVersioningInput{Bucket: aws.String(defaultBucket)})
-		ts.OK(err)
-		if aws.StringValue(bv.MFADelete) != mfa {
-			ts.Fatal("unexpected MFADelete")
+
+		expected := [][]string{
+			{"id", "quote"},
+			{"1", `say "hi"`},
+			{"2", "a;b\nc"},
+			{"3", ""},
 		}
-		if aws.StringValue(bv.Status) != status {
-			ts.Fatalf("unexpected Status %q, expected %q", aws.StringValue(bv.Status), status)
+		var in bytes.Buffer
+		ts.OK(csv.NewWriter(&in).WriteAll(expected))
+		ts.backendPutBytes(defaultBucket, "object", nil, in.Bytes())
+
+		input := selectInput(s3.CompressionTypeNone)
+		input.OutputSerialization.CSV = &s3.CSVOutput{
+			FieldDelimiter: aws.String(";"),
+			QuoteFields:    aws.String(s3.QuoteFieldsAlways),
 		}
-	}
+		out, err := svc.SelectObjectContent(input)
+		ts.OK(err)
+		defer out.EventStream.Close()
 
-	setVersioning := func(ts *testServer, status gofakes3.VersioningStatus) {
-		ts.Helper()
-		svc := ts.s3Client()
-		ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
-			Bucket: aws.String(defaultBucket),
-			VersioningConfiguration: &s3.VersioningConfiguration{
-				Status: aws.String(string(status)),
-			},
-		}))
-	}
+		var records bytes.Buffer
+		for event := range out.EventStream.Events() {
+			if event, ok := event.(*s3.RecordsEvent); ok {
+				records.Write(event.Payload)
+			}
+		}
+		ts.OK(out.EventStream.Err())
 
-	t.Run("", func(t *testing.T) {
+		if !strings.HasPrefix(records.String(), `"id";"quote"`+"\n") {
+			t.Fatalf("fields were not quoted: %q", records.String())
+		}
+		rdr := csv.NewReader(&records)
+		rdr.Comma = ';'
+		found, err := rdr.ReadAll()
+		ts.OK(err)
+		if !reflect.DeepEqual(expected, found) {
+			t.Fatal("records did not round-trip", found)
+		}
+	})
+
+	t.Run("no-selector", func(t *testing.T) {
 		ts := newTestServer(t)
 		defer ts.Close()
+		svc := ts.s3Client()
+		ts.backendPutString(defaultBucket, "object", nil, "a,b\n")
 
-		// Bucket that has never been versioned should return empty strings:
-		assertVersioning(ts, "", "")
+		_, err := svc.SelectObjectContent(selectInput(s3.CompressionTypeNone))
+		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
+			t.Fatal("expected ErrNotImplemented, found", err)
+		}
 	})
+}
+
+// unconditionalBackend hides the ConditionalPutBackend implementation of the
+// backend it wraps.
+type unconditionalBackend struct {
+	gofakes3.Backend
+}
+
+func TestConditionalPut(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend gofakes3.Backend
+	}{
+		{"conditional", nil},
+		{"fallback", unconditionalBackend{s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate)))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []testServerOption
+			if tc.backend != nil {
+				opts = append(opts, withBackend(tc.backend))
+			}
+			ts := newTestServer(t, opts...)
+			defer ts.Close()
+
+			put := func(key, body string, header ...string) *http.Response {
+				t.Helper()
+				rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/"+key), strings.NewReader(body))
+				ts.OK(err)
+				for i := 0; i < len(header); i += 2 {
+					rq.Header.Set(header[i], header[i+1])
+				}
+				rs, err := httpClient().Do(rq)
+				ts.OK(err)
+				rs.Body.Close()
+				return rs
+			}
+			expectStatus := func(rs *http.Response, status int) {
+				t.Helper()
+				if rs.StatusCode != status {
+					t.Fatal("expected status", status, "found", rs.StatusCode)
+				}
+			}
+
+			rs := put("object", "hello", "If-None-Match", "*")
+			expectStatus(rs, 200)
+			etag := rs.Header.Get("ETag")
+
+			expectStatus(put("object", "again", "If-None-Match", "*"), 412)
+			ts.assertObject(defaultBucket, "object", nil, "hello")
+
+			expectStatus(put("object", "wrong", "If-Match", `"0123456789abcdef0123456789abcdef"`), 412)
+			expectStatus(put("object", "world", "If-Match", etag), 200)
+			ts.assertObject(defaultBucket, "object", nil, "world")
+
+			expectStatus(put("missing", "body", "If-Match", etag), 404)
+			if ts.backendObjectExists(defaultBucket, "missing") {
+				t.Fatal("object should not have been created")
+			}
+
+			expectStatus(put("object", "body", "If-None-Match", etag), 501)
+		})
+	}
+}
+
+func TestMaxObjectSize(t *testing.T) {
+	const limit = 10
 
-	t.Run("enable", func(t *testing.T) {
-		ts := newTestServer(t)
+	t.Run("put", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxObjectSize(limit)))
 		defer ts.Close()
+		svc := ts.s3Client()
 
-		setVersioning(ts, "Enabled")
-		assertVersioning(ts, "", "Enabled")
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader(bytes.Repeat([]byte("a"), limit)),
+		}))
+
+		_, err := svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   bytes.NewReader(bytes.Repeat([]byte("b"), limit+1)),
+		})
+		if !hasErrorCode(err, gofakes3.ErrEntityTooLarge) {
+			t.Fatal("expected ErrEntityTooLarge, found", err)
+		}
+		ts.assertObject(defaultBucket, "object", nil, strings.Repeat("a", limit))
 	})
 
-	t.Run("suspend", func(t *testing.T) {
-		ts := newTestServer(t)
+	t.Run("multipart", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxObjectSize(limit)))
 		defer ts.Close()
+		svc := ts.s3Client()
 
-		setVersioning(ts, gofakes3.VersioningSuspended)
-		assertVersioning(ts, "", "")
+		id := ts.createMultipartUpload(defaultBucket, "object", nil)
+		_, err := svc.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("object"),
+			Body:       bytes.NewReader(bytes.Repeat([]byte("a"), limit+1)),
+			UploadId:   aws.String(id),
+			PartNumber: aws.Int64(1),
+		})
+		if !hasErrorCode(err, gofakes3.ErrEntityTooLarge) {
+			t.Fatal("expected ErrEntityTooLarge, found", err)
+		}
 
-		setVersioning(ts, gofakes3.VersioningEnabled)
-		setVersioning(ts, gofakes3.VersioningSuspended)
-		assertVersioning(ts, "", "Suspended")
+		// Each part is within the limit, but the object is not:
+		parts := []*s3.CompletedPart{
+			ts.uploadPart(defaultBucket, "object", id, 1, bytes.Repeat([]byte("a"), limit)),
+			ts.uploadPart(defaultBucket, "object", id, 2, []byte("b")),
+		}
+		_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(defaultBucket),
+			Key:             aws.String("object"),
+			UploadId:        aws.String(id),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		if !hasErrorCode(err, gofakes3.ErrEntityTooLarge) {
+			t.Fatal("expected ErrEntityTooLarge, found", err)
+		}
+		if ts.backendObjectExists(defaultBucket, "object") {
+			t.Fatal("object should not have been created")
+		}
+
+		// The upload is left open, so it can be completed without the part
+		// that took it over the limit:
+		ts.assertCompleteUpload(defaultBucket, "object", id, parts[:1], bytes.Repeat([]byte("a"), limit))
 	})
 
-	t.Run("no-versioning-suspend", func(t *testing.T) {
-		ts := newTestServer(t, withFakerOptions(
-			gofakes3.WithoutVersioning(),
-		))
+	t.Run("browser-upload", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxObjectSize(limit)))
 		defer ts.Close()
 
-		setVersioning(ts, "Suspended")
-		assertVersioning(ts, "", "")
-	})
+		upload := func(size int) *http.Response {
+			var buf bytes.Buffer
+			w := multipart.NewWriter(&buf)
+			ts.OK(w.WriteField("key", "object"))
+			fw, err := w.CreateFormFile("file", "upload")
+			ts.OK(err)
+			ts.OKAll(fw.Write(bytes.Repeat([]byte("a"), size)))
+			ts.OK(w.Close())
 
-	t.Run("no-versioning-enable", func(t *testing.T) {
-		ts := newTestServer(t, withFakerOptions(
-			gofakes3.WithoutVersioning(),
-		))
-		defer ts.Close()
+			rs, err := httpClient().Post(ts.url("/"+defaultBucket), w.FormDataContentType(), &buf)
+			ts.OK(err)
+			rs.Body.Close()
+			return rs
+		}
 
-		svc := ts.s3Client()
-		_, err := svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
-			Bucket: aws.String(defaultBucket),
-			VersioningConfiguration: &s3.VersioningConfiguration{
-				Status: aws.String("Enabled"),
-			},
-		})
-		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
-			ts.Fatal("expected ErrNotImplemented, found", err)
+		if rs := upload(limit); rs.StatusCode != 200 {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if rs := upload(limit + 1); rs.StatusCode != 400 {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if rs := upload(2 << 20); rs.StatusCode != 400 {
+			t.Fatal("unexpected status", rs.StatusCode)
 		}
+		ts.assertObject(defaultBucket, "object", nil, strings.Repeat("a", limit))
 	})
 }
 
-func TestObjectVersions(t *testing.T) {
-	create := func(ts *testServer, bucket, key string, contents []byte, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		out, err := svc.PutObject(&s3.PutObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-			Body:   bytes.NewReader(contents),
-		})
-		ts.OK(err)
-		if aws.StringValue(out.VersionId) != version {
-			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
-		}
-	}
+func TestRequestIDGenerator(t *testing.T) {
+	// Two servers with the same configuration must produce byte-identical
+	// responses:
+	var responses [2]string
+	for i := range responses {
+		var n int
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithRequestIDGenerator(func() string {
+			n++
+			return fmt.Sprintf("request-%d", n)
+		})))
 
-	get := func(ts *testServer, bucket, key string, contents []byte, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		input := &s3.GetObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+		ts.OKAll(ts.s3Client().PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   strings.NewReader("hello"),
+		}))
+
+		rs, err := httpClient().Get(ts.url("/" + defaultBucket + "/missing"))
+		ts.OK(err)
+		body, err := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		ts.OK(err)
+		if id := rs.Header.Get("x-amz-request-id"); id != "request-2" {
+			t.Fatal("unexpected request id", id)
 		}
-		if version != "" {
-			input.VersionId = aws.String(version)
+		if !bytes.Contains(body, []byte("<RequestId>request-2</RequestId>")) {
+			t.Fatal("request id missing from error response", string(body))
 		}
-		out, err := svc.GetObject(input)
+
+		rs, err = httpClient().Get(ts.url("/" + defaultBucket))
 		ts.OK(err)
-		defer out.Body.Close()
-		bts, err := ioutil.ReadAll(out.Body)
+		rs.Header.Del("Date") // Added by net/http using the real clock
+		dump, err := httputil.DumpResponse(rs, true)
+		rs.Body.Close()
 		ts.OK(err)
-		if !bytes.Equal(bts, contents) {
-			ts.Fatal("body mismatch. found:", string(bts), "expected:", string(contents))
-		}
+		responses[i] = string(dump)
+		ts.Close()
 	}
 
-	deleteVersion := func(ts *testServer, bucket, key, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		input := &s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
+	if responses[0] != responses[1] {
+		t.Fatalf("responses differ:\n%s\n\n%s", responses[0], responses[1])
+	}
+}
+
+// xmlShape flattens an XML document into a list of its elements, attributes
+// and text, ignoring whitespace between elements. The text of the elements
+// named in volatile is replaced with '*'.
+func xmlShape(t *testing.T, doc string, volatile ...string) []string {
+	t.Helper()
+	var shape []string
+	var path []string
+	dec := xml.NewDecoder(strings.NewReader(doc))
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
 		}
-		if version != "" {
-			input.VersionId = aws.String(version)
+		gofakes3.TT{t}.OK(err)
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			path = append(path, tok.Name.Local)
+			shape = append(shape, "<"+tok.Name.Local)
+			for _, attr := range tok.Attr {
+				shape = append(shape, "@"+attr.Name.Local+"="+attr.Value)
+			}
+		case xml.EndElement:
+			path = path[:len(path)-1]
+			shape = append(shape, ">")
+		case xml.CharData:
+			text := strings.TrimSpace(string(tok))
+			if text == "" {
+				continue
+			}
+			for _, v := range volatile {
+				if path[len(path)-1] == v {
+					text = "*"
+				}
+			}
+			shape = append(shape, text)
 		}
-		ts.OKAll(svc.DeleteObject(input))
 	}
+	return shape
+}
 
-	deleteDirect := func(ts *testServer, bucket, key, version string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		input := &s3.DeleteObjectInput{
-			Bucket: aws.String(bucket),
-			Key:    aws.String(key),
-		}
-		out, err := svc.DeleteObject(input)
+func TestXMLResponseShape(t *testing.T) {
+	// These are responses captured from S3, with the bucket names and keys
+	// replaced. The text of volatile elements, like timestamps and owner
+	// IDs, is not compared.
+	volatile := []string{"ID", "DisplayName", "CreationDate", "LastModified"}
+
+	for idx, tc := range []struct {
+		url      string
+		expected string
+	}{
+		{"/", `<?xml version="1.0" encoding="UTF-8"?>
+			<ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+				<Owner><ID>bcaf1ffd86f461ca5fb16fd081034f</ID><DisplayName>webfile</DisplayName></Owner>
+				<Buckets>
+					<Bucket><Name>mybucket</Name><CreationDate>2006-02-03T16:45:09.000Z</CreationDate></Bucket>
+				</Buckets>
+			</ListAllMyBucketsResult>`},
+
+		{"/mybucket", `<?xml version="1.0" encoding="UTF-8"?>
+			<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+				<Name>mybucket</Name>
+				<Prefix></Prefix>
+				<Marker></Marker>
+				<MaxKeys>1000</MaxKeys>
+				<IsTruncated>false</IsTruncated>
+				<Contents>
+					<Key>object</Key>
+					<LastModified>2009-10-12T17:50:30.000Z</LastModified>
+					<ETag>&quot;5d41402abc4b2a76b9719d911017c592&quot;</ETag>
+					<Size>5</Size>
+					<StorageClass>STANDARD</StorageClass>
+					<Owner><ID>75aa57f09aa0c8caeab4f8c24e99d10f8e7faeebf76c078efc7c6caea54ba06a</ID><DisplayName>mtd@amazon.com</DisplayName></Owner>
+				</Contents>
+			</ListBucketResult>`},
+
+		{"/mybucket?list-type=2", `<?xml version="1.0" encoding="UTF-8"?>
+			<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+				<Name>mybucket</Name>
+				<Prefix></Prefix>
+				<KeyCount>1</KeyCount>
+				<MaxKeys>1000</MaxKeys>
+				<IsTruncated>false</IsTruncated>
+				<Contents>
+					<Key>object</Key>
+					<LastModified>2009-10-12T17:50:30.000Z</LastModified>
+					<ETag>&quot;5d41402abc4b2a76b9719d911017c592&quot;</ETag>
+					<Size>5</Size>
+					<StorageClass>STANDARD</StorageClass>
+				</Contents>
+			</ListBucketResult>`},
+
+		{"/mybucket?list-type=2&delimiter=/&max-keys=1&prefix=dir/", `<?xml version="1.0" encoding="UTF-8"?>
+			<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+				<Name>mybucket</Name>
+				<Prefix>dir/</Prefix>
+				<NextContinuationToken>ZGlyL2E=</NextContinuationToken>
+				<KeyCount>1</KeyCount>
+				<MaxKeys>1</MaxKeys>
+				<Delimiter>/</Delimiter>
+				<IsTruncated>true</IsTruncated>
+				<Contents>
+					<Key>dir/a</Key>
+					<LastModified>2009-10-12T17:50:30.000Z</LastModified>
+					<ETag>&quot;0cc175b9c0f1b6a831c399e269772661&quot;</ETag>
+					<Size>1</Size>
+					<StorageClass>STANDARD</StorageClass>
+				</Contents>
+			</ListBucketResult>`},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			ts := newTestServer(t)
+			defer ts.Close()
+			ts.backendPutString(defaultBucket, "object", nil, "hello")
+			if strings.Contains(tc.url, "prefix=dir/") {
+				ts.backendPutString(defaultBucket, "dir/a", nil, "a")
+				ts.backendPutString(defaultBucket, "dir/b", nil, "b")
+			}
+
+			rs, err := httpClient().Get(ts.url(tc.url))
+			ts.OK(err)
+			body, err := ioutil.ReadAll(rs.Body)
+			rs.Body.Close()
+			ts.OK(err)
+
+			found := xmlShape(t, string(body), volatile...)
+			expected := xmlShape(t, tc.expected, volatile...)
+			if !reflect.DeepEqual(expected, found) {
+				t.Fatalf("unexpected XML:\nexp: %v\ngot: %v", expected, found)
+			}
+		})
+	}
+}
+
+func TestWithXMLNamespace(t *testing.T) {
+	const ns = "http://example.com/doc/2006-03-01/"
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithXMLNamespace(ns)))
+	defer ts.Close()
+
+	for _, u := range []string{"/", "/mybucket", "/mybucket?list-type=2", "/mybucket?versions"} {
+		rs, err := httpClient().Get(ts.url(u))
 		ts.OK(err)
-		if aws.StringValue(out.VersionId) != version {
-			t.Fatal("version ID mismatch. found:", aws.StringValue(out.VersionId), "expected:", version)
+		body, err := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		ts.OK(err)
+		if !strings.Contains(string(body), `xmlns="`+ns+`"`) {
+			t.Fatal("namespace not found for", u, string(body))
 		}
 	}
+}
 
-	list := func(ts *testServer, bucket string, versions ...string) {
-		ts.Helper()
-		svc := ts.s3Client()
-		out, err := svc.ListObjectVersions(&s3.ListObjectVersionsInput{Bucket: aws.String(bucket)})
+func TestWithCompactXML(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithCompactXML()))
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "object", nil, "hello")
+
+	for _, u := range []string{"/", "/" + defaultBucket, "/" + defaultBucket + "/nope"} {
+		rs, err := httpClient().Get(ts.url(u))
+		ts.OK(err)
+		body, err := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
 		ts.OK(err)
 
-		var found []string
-		for _, ver := range out.Versions {
-			found = append(found, aws.StringValue(ver.VersionId))
-		}
-		for _, ver := range out.DeleteMarkers {
-			found = append(found, aws.StringValue(ver.VersionId))
+		doc := strings.TrimPrefix(string(body), xml.Header)
+		if strings.Contains(doc, "\n") {
+			t.Fatal("response was indented for", u, doc)
 		}
+	}
+}
 
-		// Unfortunately, the S3 client API destroys the order of Versions and
-		// DeleteMarkers, which are sibling elements in the XML body but separated
-		// into different lists by the client:
-		sort.Strings(found)
-		sort.Strings(versions)
-		if !reflect.DeepEqual(found, versions) {
-			ts.Fatal("versions mismatch. found:", found, "expected:", versions)
+func BenchmarkListBucketXML(b *testing.B) {
+	const objects = 10000
+
+	for _, bc := range []struct {
+		name string
+		opts []gofakes3.Option
+	}{
+		{"indented", nil},
+		{"compact", []gofakes3.Option{gofakes3.WithCompactXML()}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			backend := s3mem.New()
+			if err := backend.CreateBucket(defaultBucket); err != nil {
+				b.Fatal(err)
+			}
+			faker := gofakes3.New(backend, bc.opts...)
+			client := faker.Client()
+			for i := 0; i < objects; i++ {
+				if _, err := client.PutObject(defaultBucket, fmt.Sprintf("dir/object%05d", i), nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+			handler := faker.Server()
+
+			// The whole bucket is listed in pages of MaxBucketKeys, using
+			// the last key of the previous page as the marker:
+			var size int
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				size = 0
+				for start := 0; start < objects; start += gofakes3.MaxBucketKeys {
+					u := "/" + defaultBucket
+					if start > 0 {
+						u += fmt.Sprintf("?marker=dir/object%05d", start-1)
+					}
+					rs := &discardResponseWriter{header: http.Header{}}
+					handler.ServeHTTP(rs, httptest.NewRequest("GET", u, nil))
+					if rs.status != http.StatusOK {
+						b.Fatal("unexpected status", rs.status)
+					}
+					size += rs.size
+				}
+			}
+			b.ReportMetric(float64(size), "resp-bytes")
+		})
+	}
+}
+
+// discardResponseWriter counts the bytes written to it without keeping them,
+// so benchmarks only measure the allocations made by GoFakeS3.
+type discardResponseWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *discardResponseWriter) Header() http.Header { return w.header }
+
+func (w *discardResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *discardResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.size += len(b)
+	return len(b), nil
+}
+
+// missingHeadBackend reports missing objects from HeadObject without using
+// gofakes3.KeyNotFound.
+type missingHeadBackend struct {
+	gofakes3.Backend
+	err error
+}
+
+func (b missingHeadBackend) HeadObject(bucketName, objectName string) (*gofakes3.Object, error) {
+	return nil, b.err
+}
+
+func TestHeadObjectMissing(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		backend func(gofakes3.Backend) gofakes3.Backend
+	}{
+		{"not-found", nil},
+		{"not-exist", func(b gofakes3.Backend) gofakes3.Backend { return missingHeadBackend{b, os.ErrNotExist} }},
+		{"nil-object", func(b gofakes3.Backend) gofakes3.Backend { return missingHeadBackend{b, nil} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var opts []testServerOption
+			if tc.backend != nil {
+				opts = append(opts, withBackend(tc.backend(s3mem.New())))
+			}
+			ts := newTestServer(t, opts...)
+			defer ts.Close()
+
+			rq, err := http.NewRequest("HEAD", ts.url("/"+defaultBucket+"/missing"), nil)
+			ts.OK(err)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			body, err := ioutil.ReadAll(rs.Body)
+			rs.Body.Close()
+			ts.OK(err)
+			if rs.StatusCode != http.StatusNotFound {
+				t.Fatal("bad status", rs.StatusCode)
+			}
+			if len(body) != 0 {
+				t.Fatal("unexpected body", string(body))
+			}
+
+			_, err = ts.s3Client().HeadObject(&s3.HeadObjectInput{
+				Bucket: aws.String(defaultBucket),
+				Key:    aws.String("missing"),
+			})
+			if rerr, ok := err.(awserr.RequestFailure); !ok || rerr.StatusCode() != http.StatusNotFound {
+				t.Fatal("expected 404 from the SDK, found", err)
+			}
+
+			// GetObjectAttributes looks the object up the same way:
+			rq, err = http.NewRequest("GET", ts.url("/"+defaultBucket+"/missing?attributes"), nil)
+			ts.OK(err)
+			rq.Header.Set("x-amz-object-attributes", "ETag")
+			rs, err = httpClient().Do(rq)
+			ts.OK(err)
+			body, err = ioutil.ReadAll(rs.Body)
+			rs.Body.Close()
+			ts.OK(err)
+			if rs.StatusCode != http.StatusNotFound || !strings.Contains(string(body), "<Code>NoSuchKey</Code>") {
+				t.Fatal("unexpected attributes response", rs.StatusCode, string(body))
+			}
+		})
+	}
+}
+
+type closingBackend struct {
+	gofakes3.Backend
+	closed int
+}
+
+func (b *closingBackend) Close() error {
+	b.closed++
+	return nil
+}
+
+func TestClose(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []gofakes3.Option
+	}{
+		{"plain", nil},
+		{"key-transform", []gofakes3.Option{gofakes3.WithKeyTransform(strings.ToUpper, strings.ToLower)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := &closingBackend{Backend: s3mem.New()}
+			ts := newTestServer(t, withBackend(backend), withFakerOptions(tc.opts...))
+			defer ts.server.Close()
+			svc := ts.s3Client()
+
+			uploadID := ts.createMultipartUpload(defaultBucket, "object", nil)
+			ts.uploadPart(defaultBucket, "object", uploadID, 1, []byte("hello"))
+
+			ts.OK(ts.GoFakeS3.Close())
+			if backend.closed != 1 {
+				t.Fatal("backend not closed")
+			}
+
+			// In-flight uploads are aborted:
+			_, err := svc.UploadPart(&s3.UploadPartInput{
+				Bucket:     aws.String(defaultBucket),
+				Key:        aws.String("object"),
+				Body:       bytes.NewReader([]byte("world")),
+				UploadId:   aws.String(uploadID),
+				PartNumber: aws.Int64(2),
+			})
+			if !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+				t.Fatal("expected NoSuchUpload, found", err)
+			}
+		})
+	}
+}
+
+func TestStats(t *testing.T) {
+	ts := newTestServer(t, withVersioning())
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	assertStats := func(expected gofakes3.Stats) {
+		t.Helper()
+		stats, err := ts.GoFakeS3.Stats()
+		ts.OK(err)
+		if stats != expected {
+			t.Fatalf("unexpected stats %+v, expected %+v", stats, expected)
 		}
 	}
 
-	// XXX: version IDs are brittle; we control the seed, but the format may
-	// change at any time.
-	const v1 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1H03F9QN5V72K21OG="
-	const v2 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1I00G5II3TDAF7GRG="
-	const v3 = "3/60O30C1G60O30C1G60O30C1G60O30C1G60O30C1G60O30C1J01VFV0CD31ES81G="
+	assertStats(gofakes3.Stats{Buckets: 1})
 
-	t.Run("put-list-delete-versions", func(t *testing.T) {
-		ts := newTestServer(t, withVersioning())
-		defer ts.Close()
+	ts.backendCreateBucket("other")
+	ts.backendPutString(defaultBucket, "a", nil, "hello")
+	ts.backendPutString("other", "b", nil, "world!")
+	assertStats(gofakes3.Stats{Buckets: 2, Objects: 2, Bytes: 11})
 
-		create(ts, defaultBucket, "object", []byte("body 1"), v1)
-		get(ts, defaultBucket, "object", []byte("body 1"), "")
-		list(ts, defaultBucket, v1)
+	// Only the current version of an object is counted:
+	ts.backendPutString(defaultBucket, "a", nil, "hi")
+	assertStats(gofakes3.Stats{Buckets: 2, Objects: 2, Bytes: 8})
 
-		create(ts, defaultBucket, "object", []byte("body 2"), v2)
-		get(ts, defaultBucket, "object", []byte("body 2"), "")
-		list(ts, defaultBucket, v1, v2)
+	id := ts.createMultipartUpload(defaultBucket, "upload", nil)
+	ts.uploadPart(defaultBucket, "upload", id, 1, []byte("part"))
+	assertStats(gofakes3.Stats{Buckets: 2, Objects: 2, Bytes: 8, Uploads: 1})
 
-		create(ts, defaultBucket, "object", []byte("body 3"), v3)
-		get(ts, defaultBucket, "object", []byte("body 3"), "")
-		list(ts, defaultBucket, v1, v2, v3)
+	ts.OKAll(svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("upload"),
+		UploadId: aws.String(id),
+	}))
+	assertStats(gofakes3.Stats{Buckets: 2, Objects: 2, Bytes: 8})
+}
+
+func TestAdminAPI(t *testing.T) {
+	ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithAdminAPI()))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	post := func(path, body string) int {
+		t.Helper()
+		rs, err := httpClient().Post(ts.url(path), "application/json", strings.NewReader(body))
+		ts.OK(err)
+		rs.Body.Close()
+		return rs.StatusCode
+	}
 
-		get(ts, defaultBucket, "object", []byte("body 1"), v1)
-		get(ts, defaultBucket, "object", []byte("body 2"), v2)
-		get(ts, defaultBucket, "object", []byte("body 3"), v3)
-		get(ts, defaultBucket, "object", []byte("body 3"), "")
+	if status := post("/__admin/seed", `{
+		"buckets": ["empty"],
+		"objects": [
+			{"bucket": "`+defaultBucket+`", "key": "a", "body": "hello", "metadata": {"content-type": "text/plain"}},
+			{"bucket": "other", "key": "dir/b", "bodyBase64": "d29ybGQ="}
+		]
+	}`); status != http.StatusNoContent {
+		t.Fatal("seed failed", status)
+	}
+	ts.assertObject(defaultBucket, "a", map[string]string{"Content-Type": "text/plain"}, "hello")
+	ts.assertObject("other", "dir/b", nil, "world")
+	ts.OKAll(svc.HeadBucket(&s3.HeadBucketInput{Bucket: aws.String("empty")}))
 
-		deleteVersion(ts, defaultBucket, "object", v1)
-		list(ts, defaultBucket, v2, v3)
-		deleteVersion(ts, defaultBucket, "object", v2)
-		list(ts, defaultBucket, v3)
-		deleteVersion(ts, defaultBucket, "object", v3)
-		list(ts, defaultBucket)
-	})
+	rs, err := httpClient().Get(ts.url("/__admin/reset"))
+	ts.OK(err)
+	body, err := ioutil.ReadAll(rs.Body)
+	rs.Body.Close()
+	ts.OK(err)
+	if !strings.Contains(string(body), string(gofakes3.ErrMethodNotAllowed)) {
+		t.Fatal("expected GET reset to be refused", rs.StatusCode, string(body))
+	}
 
-	t.Run("delete-direct", func(t *testing.T) {
-		ts := newTestServer(t, withVersioning())
-		defer ts.Close()
+	// Leave some versions, delete markers and an upload behind for the reset:
+	ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(defaultBucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String("Enabled")},
+	}))
+	ts.backendPutString(defaultBucket, "a", nil, "v2")
+	ts.backendPutString(defaultBucket, "deleted", nil, "v1")
+	ts.OKAll(svc.DeleteObject(&s3.DeleteObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String("deleted")}))
+	uploadID := ts.createMultipartUpload("other", "upload", nil)
 
-		create(ts, defaultBucket, "object", []byte("body 1"), v1)
-		list(ts, defaultBucket, v1)
-		create(ts, defaultBucket, "object", []byte("body 2"), v2)
-		list(ts, defaultBucket, v1, v2)
+	if status := post("/__admin/reset", ""); status != http.StatusNoContent {
+		t.Fatal("reset failed", status)
+	}
+	buckets, err := svc.ListBuckets(&s3.ListBucketsInput{})
+	ts.OK(err)
+	if len(buckets.Buckets) != 0 {
+		t.Fatal("buckets not deleted", buckets.Buckets)
+	}
 
-		get(ts, defaultBucket, "object", []byte("body 2"), "")
+	ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("other")}))
+	ts.assertLs("other", "", nil, nil)
+	_, err = svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String("other"),
+		Key:        aws.String("upload"),
+		Body:       bytes.NewReader([]byte("hello")),
+		UploadId:   aws.String(uploadID),
+		PartNumber: aws.Int64(1),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+		t.Fatal("expected NoSuchUpload, found", err)
+	}
 
-		deleteDirect(ts, defaultBucket, "object", v3)
-		list(ts, defaultBucket, v1, v2, v3)
+	{ // Delete everything under a prefix:
+		ts.OKAll(svc.CreateBucket(&s3.CreateBucketInput{Bucket: aws.String("prefixed")}))
+		ts.backendPutString("prefixed", "dir/a", nil, "a")
+		ts.backendPutString("prefixed", "dir/b", nil, "b")
+		ts.backendPutString("prefixed", "other", nil, "c")
 
-		svc := ts.s3Client()
-		_, err := svc.GetObject(&s3.GetObjectInput{
-			Bucket: aws.String(defaultBucket),
-			Key:    aws.String("object"),
-		})
-		if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
-			ts.Fatal("expected ErrNoSuchKey, found", err)
+		rs, err := httpClient().Post(ts.url("/__admin/delete-prefix?bucket=prefixed&prefix=dir/"), "", nil)
+		ts.OK(err)
+		body, err := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		ts.OK(err)
+		if rs.StatusCode != http.StatusOK || strings.TrimSpace(string(body)) != `{"deleted":2}` {
+			t.Fatal("unexpected delete-prefix response", rs.StatusCode, string(body))
 		}
-	})
+		ts.assertLs("prefixed", "", nil, []string{"other"})
+	}
 
-	t.Run("list-never-versioned", func(t *testing.T) {
-		ts := newTestServer(t, withVersioning())
-		defer ts.Close()
+	if status := post("/__admin/seed", `{nope`); status != http.StatusBadRequest {
+		t.Fatal("expected 400 for a bad seed, found", status)
+	}
+}
 
-		const neverVerBucket = "neverver"
-		ts.backendCreateBucket(neverVerBucket)
+// pagedVersionsBackend lists at most one version per page, so that callers
+// that don't page through the versions leave some behind.
+type pagedVersionsBackend struct {
+	*s3mem.Backend
+}
 
-		ts.backendPutString(neverVerBucket, "object", nil, "body 1")
-		list(ts, neverVerBucket, "null") // S300005
-	})
+func (b pagedVersionsBackend) ListBucketVersions(name string, prefix *gofakes3.Prefix, page *gofakes3.ListBucketVersionsPage) (*gofakes3.ListBucketVersionsResult, error) {
+	var capped gofakes3.ListBucketVersionsPage
+	if page != nil {
+		capped = *page
+	}
+	capped.MaxKeys = 1
+	return b.Backend.ListBucketVersions(name, prefix, &capped)
 }
 
-func TestListBucketPages(t *testing.T) {
-	createData := func(ts *testServer, prefix string, n int64) []string {
-		keys := make([]string, n)
-		for i := int64(0); i < n; i++ {
-			key := fmt.Sprintf("%s%d", prefix, i)
-			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
-			keys[i] = key
-		}
-		return keys
+func TestAdminAPIResetPagedVersions(t *testing.T) {
+	backend := pagedVersionsBackend{s3mem.New(s3mem.WithVersionSeed(0))}
+	ts := newTestServer(t, withBackend(backend), withFakerOptions(gofakes3.WithAdminAPI()))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.OKAll(svc.PutBucketVersioning(&s3.PutBucketVersioningInput{
+		Bucket:                  aws.String(defaultBucket),
+		VersioningConfiguration: &s3.VersioningConfiguration{Status: aws.String("Enabled")},
+	}))
+	for _, key := range []string{"a", "a", "b", "c"} {
+		ts.backendPutString(defaultBucket, key, nil, "hello")
 	}
 
-	assertKeys := func(ts *testServer, rs *listBucketResult, keys ...string) {
-		found := make([]string, len(rs.Contents))
-		for i := 0; i < len(rs.Contents); i++ {
-			found[i] = aws.StringValue(rs.Contents[i].Key)
-		}
-		if !reflect.DeepEqual(found, keys) {
-			t.Fatal("key mismatch:", keys, "!=", found)
-		}
+	rs, err := httpClient().Post(ts.url("/__admin/reset"), "application/json", nil)
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusNoContent {
+		t.Fatal("reset failed", rs.StatusCode)
+	}
+	buckets, err := svc.ListBuckets(&s3.ListBucketsInput{})
+	ts.OK(err)
+	if len(buckets.Buckets) != 0 {
+		t.Fatal("buckets not deleted", buckets.Buckets)
 	}
+}
 
-	for idx, tc := range []struct {
-		keys, pageKeys int64
+func TestListBucketMaxKeysZero(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	ts.backendPutString(defaultBucket, "dir/a", nil, "hello")
+	ts.backendPutString(defaultBucket, "dir/b", nil, "hello")
+
+	for _, tc := range []struct {
+		prefix    string
+		truncated bool
 	}{
-		{9, 2},
-		{8, 3},
-		{7, 4},
-		{6, 5},
-		{5, 6},
+		{"", true},
+		{"dir/", true},
+		{"empty/", false},
 	} {
-		t.Run(fmt.Sprintf("list-page-basic/%d", idx), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
-			keys := createData(ts, "", tc.keys)
-
-			rs := ts.mustListBucketV1Pages(nil, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
+		t.Run("v1/"+tc.prefix, func(t *testing.T) {
+			out, err := svc.ListObjects(&s3.ListObjectsInput{
+				Bucket:  aws.String(defaultBucket),
+				Prefix:  aws.String(tc.prefix),
+				MaxKeys: aws.Int64(0),
+			})
+			ts.OK(err)
+			if len(out.Contents) != 0 || len(out.CommonPrefixes) != 0 {
+				t.Fatal("unexpected contents", out.Contents, out.CommonPrefixes)
 			}
-			assertKeys(ts, rs, keys...)
-
-			rs = ts.mustListBucketV2Pages(nil, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
+			if aws.BoolValue(out.IsTruncated) != tc.truncated {
+				t.Fatal("unexpected truncation", aws.BoolValue(out.IsTruncated))
+			}
+			if out.MaxKeys == nil || *out.MaxKeys != 0 {
+				t.Fatal("unexpected max keys", out.MaxKeys)
 			}
-			assertKeys(ts, rs, keys...)
 		})
 
-		t.Run(fmt.Sprintf("list-page-prefix/%d", idx), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+		t.Run("v2/"+tc.prefix, func(t *testing.T) {
+			out, err := svc.ListObjectsV2(&s3.ListObjectsV2Input{
+				Bucket:    aws.String(defaultBucket),
+				Prefix:    aws.String(tc.prefix),
+				Delimiter: aws.String("/"),
+				MaxKeys:   aws.Int64(0),
+			})
+			ts.OK(err)
+			if len(out.Contents) != 0 || len(out.CommonPrefixes) != 0 {
+				t.Fatal("unexpected contents", out.Contents, out.CommonPrefixes)
+			}
+			if aws.BoolValue(out.IsTruncated) != tc.truncated {
+				t.Fatal("unexpected truncation", aws.BoolValue(out.IsTruncated))
+			}
+			if out.KeyCount == nil || *out.KeyCount != 0 {
+				t.Fatal("unexpected key count", out.KeyCount)
+			}
+		})
+	}
+}
 
-			// junk keys with no prefix to ensure that we are actually limiting the output.
-			// these should not show up in the output.
-			createData(ts, "", tc.keys)
+func TestListBucketV2StartAfter(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+	for _, key := range []string{"a", "b/1", "b/2", "b/3", "c"} {
+		ts.backendPutString(defaultBucket, key, nil, "")
+	}
 
-			// these are the actual keys we expect to see:
-			keys := createData(ts, "test", tc.keys)
+	list := func(in *s3.ListObjectsV2Input) (keys []string, out *s3.ListObjectsV2Output) {
+		t.Helper()
+		in.Bucket = aws.String(defaultBucket)
+		out, err := svc.ListObjectsV2(in)
+		ts.OK(err)
+		for _, item := range out.Contents {
+			keys = append(keys, aws.StringValue(item.Key))
+		}
+		return keys, out
+	}
 
-			prefix := gofakes3.NewPrefix(aws.String("test"), nil)
+	for idx, tc := range []struct {
+		prefix     string
+		startAfter string
+		keys       []string
+	}{
+		{"", "", []string{"a", "b/1", "b/2", "b/3", "c"}},
+		{"", "a", []string{"b/1", "b/2", "b/3", "c"}},
+		{"", "0", []string{"a", "b/1", "b/2", "b/3", "c"}},
+		{"", "b/15", []string{"b/2", "b/3", "c"}},
+		{"", "c", nil},
+		{"", "z", nil},
 
-			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
+		// start-after is applied within the prefix:
+		{"b/", "a", []string{"b/1", "b/2", "b/3"}},
+		{"b/", "b/1", []string{"b/2", "b/3"}},
+		{"b/", "b/15", []string{"b/2", "b/3"}},
+		{"b/", "b/3", nil},
+		{"b/", "c", nil},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			in := &s3.ListObjectsV2Input{}
+			if tc.prefix != "" {
+				in.Prefix = aws.String(tc.prefix)
 			}
-			assertKeys(ts, rs, keys...)
-
-			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
-			if len(rs.CommonPrefixes) > 0 {
-				t.Fatal()
+			if tc.startAfter != "" {
+				in.StartAfter = aws.String(tc.startAfter)
+			}
+			keys, out := list(in)
+			if !reflect.DeepEqual(keys, tc.keys) {
+				t.Fatal("unexpected keys", keys, "!=", tc.keys)
+			}
+			if aws.StringValue(out.StartAfter) != tc.startAfter {
+				t.Fatal("start-after not echoed", aws.StringValue(out.StartAfter))
 			}
-			assertKeys(ts, rs, keys...)
 		})
+	}
 
-		t.Run(fmt.Sprintf("list-page-prefix-delim/%d", idx), func(t *testing.T) {
-			ts := newTestServer(t)
-			defer ts.Close()
+	t.Run("continuation", func(t *testing.T) {
+		// start-after only applies to the first page; after that, the
+		// continuation token takes over even if start-after is still sent:
+		keys, out := list(&s3.ListObjectsV2Input{StartAfter: aws.String("a"), MaxKeys: aws.Int64(2)})
+		if !reflect.DeepEqual(keys, []string{"b/1", "b/2"}) || !aws.BoolValue(out.IsTruncated) {
+			t.Fatal("unexpected first page", keys)
+		}
+		keys, out = list(&s3.ListObjectsV2Input{
+			StartAfter:        aws.String("a"),
+			ContinuationToken: out.NextContinuationToken,
+			MaxKeys:           aws.Int64(2),
+		})
+		if !reflect.DeepEqual(keys, []string{"b/3", "c"}) {
+			t.Fatal("unexpected second page", keys)
+		}
+		if aws.StringValue(out.StartAfter) != "a" {
+			t.Fatal("start-after not echoed", aws.StringValue(out.StartAfter))
+		}
+	})
+}
 
-			// junk keys with no prefix to ensure that we are actually limiting the output.
-			// these should not show up in the output.
-			createData(ts, "", tc.keys)
+func TestListBucketEncodingTypeURL(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
 
-			// these are the actual keys we expect to see:
-			keys := createData(ts, "test/", tc.keys)
+	const key = "foo bar/baz\nqux"
+	ts.backendPutString(defaultBucket, key, nil, "")
 
-			// add some common prefixes:
-			createData(ts, "test/prefix1/", 2)
-			createData(ts, "test/prefix2/", 2)
+	type listResult struct {
+		EncodingType   string
+		Prefix         string
+		Delimiter      string
+		Marker         string
+		StartAfter     string
+		Contents       []struct{ Key string }
+		CommonPrefixes []struct{ Prefix string }
+	}
 
-			prefix := gofakes3.NewFolderPrefix("test/")
+	list := func(query string) (result listResult) {
+		t.Helper()
+		rs, err := httpClient().Get(ts.url("/" + defaultBucket + "?" + query))
+		ts.OK(err)
+		defer rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&result))
+		return result
+	}
 
-			rs := ts.mustListBucketV1Pages(&prefix, tc.pageKeys, "")
-			assertKeys(ts, rs, keys...)
+	t.Run("v1", func(t *testing.T) {
+		result := list("encoding-type=url&marker=" + url.QueryEscape("a b"))
+		if result.EncodingType != "url" {
+			t.Fatal("unexpected encoding type", result.EncodingType)
+		}
+		if len(result.Contents) != 1 || result.Contents[0].Key != "foo+bar/baz%0Aqux" {
+			t.Fatal("unexpected contents", result.Contents)
+		}
+		if result.Marker != "a+b" {
+			t.Fatal("unexpected marker", result.Marker)
+		}
+	})
 
-			rs = ts.mustListBucketV2Pages(&prefix, tc.pageKeys, "")
-			assertKeys(ts, rs, keys...)
+	t.Run("v2", func(t *testing.T) {
+		result := list("list-type=2&encoding-type=url&prefix=" + url.QueryEscape("foo ") +
+			"&delimiter=" + url.QueryEscape("\n") + "&start-after=" + url.QueryEscape("a b"))
+		if result.EncodingType != "url" {
+			t.Fatal("unexpected encoding type", result.EncodingType)
+		}
+		if result.Prefix != "foo+" || result.Delimiter != "%0A" || result.StartAfter != "a+b" {
+			t.Fatal("unexpected result", result.Prefix, result.Delimiter, result.StartAfter)
+		}
+		if len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "foo+bar/baz%0A" {
+			t.Fatal("unexpected common prefixes", result.CommonPrefixes)
+		}
+	})
 
-			// FIXME: there are some unanswered questions for the assumer about
-			// how CommonPrefixes interacts with paging; CommonPrefixes should be
-			// checked once we've established how S3 actually behaves.
+	t.Run("sdk-roundtrip", func(t *testing.T) {
+		out, err := ts.s3Client().ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:       aws.String(defaultBucket),
+			EncodingType: aws.String(s3.EncodingTypeUrl),
 		})
-	}
-}
+		ts.OK(err)
+		if len(out.Contents) != 1 {
+			t.Fatal("unexpected contents", out.Contents)
+		}
+		decoded, err := url.QueryUnescape(aws.StringValue(out.Contents[0].Key))
+		ts.OK(err)
+		if decoded != key {
+			t.Fatal("unexpected key", decoded)
+		}
+	})
 
-// Ensure that a backend that does not support pagination can use the fallback if enabled:
-func TestListBucketPagesFallback(t *testing.T) {
-	createData := func(ts *testServer, prefix string, n int64) []string {
-		keys := make([]string, n)
-		for i := int64(0); i < n; i++ {
-			key := fmt.Sprintf("%s%d", prefix, i)
-			ts.backendPutString(defaultBucket, key, nil, fmt.Sprintf("body-%d", i))
-			keys[i] = key
+	t.Run("unencoded", func(t *testing.T) {
+		result := list("list-type=2")
+		if result.EncodingType != "" {
+			t.Fatal("unexpected encoding type", result.EncodingType)
 		}
-		return keys
+		if len(result.Contents) != 1 || result.Contents[0].Key != key {
+			t.Fatal("unexpected contents", result.Contents)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := ts.s3Client().ListObjectsV2(&s3.ListObjectsV2Input{
+			Bucket:       aws.String(defaultBucket),
+			EncodingType: aws.String("nope"),
+		})
+		if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+			t.Fatal("expected InvalidArgument, found", err)
+		}
+	})
+}
+
+func TestCreateObjectChunked(t *testing.T) {
+	put := func(ts *testServer, key string, body []byte) *http.Response {
+		t.Helper()
+		rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/"+key), bytes.NewReader(body))
+		ts.OK(err)
+		rq.Body = ioutil.NopCloser(bytes.NewReader(body)) // Not http.NoBody if empty
+		rq.ContentLength = -1                             // Forces 'Transfer-Encoding: chunked'
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs
 	}
 
-	t.Run("fallback-disabled", func(t *testing.T) {
-		ts := newTestServer(t,
-			withBackend(&backendWithUnimplementedPaging{s3mem.New()}),
-			withFakerOptions(gofakes3.WithUnimplementedPageError()),
-		)
+	t.Run("ok", func(t *testing.T) {
+		ts := newTestServer(t)
 		defer ts.Close()
-		createData(ts, "", 5)
-		_, err := ts.listBucketV1Pages(nil, 2, "")
-		if !hasErrorCode(err, gofakes3.ErrNotImplemented) {
-			t.Fatal(err)
+
+		body := []byte("hello chunked world")
+		rs := put(ts, "object", body)
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		sum := md5.Sum(body)
+		if etag := rs.Header.Get("ETag"); etag != `"`+hex.EncodeToString(sum[:])+`"` {
+			t.Fatal("unexpected ETag", etag)
 		}
+		ts.assertObject(defaultBucket, "object", nil, body)
 	})
 
-	t.Run("fallback-enabled", func(t *testing.T) {
-		ts := newTestServer(t, withBackend(&backendWithUnimplementedPaging{s3mem.New()}))
+	t.Run("empty", func(t *testing.T) {
+		ts := newTestServer(t)
 		defer ts.Close()
-		createData(ts, "", 5)
-		r := ts.mustListBucketV1Pages(nil, 2, "")
 
-		// Without pagination, should just fall back to returning all keys:
-		if len(r.Contents) != 5 {
-			t.Fatal()
+		rs := put(ts, "object", nil)
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
 		}
+		ts.assertObject(defaultBucket, "object", nil, "")
 	})
-}
 
-func s3HasErrorCode(err error, code gofakes3.ErrorCode) bool {
-	if err, ok := err.(awserr.Error); ok {
-		return code == gofakes3.ErrorCode(err.Code())
-	}
-	return false
-}
+	t.Run("too-large", func(t *testing.T) {
+		const limit = 10
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxObjectSize(limit)))
+		defer ts.Close()
 
-func tryDumpResponse(rs *http.Response, body bool) string {
-	b, _ := httputil.DumpResponse(rs, body)
-	return string(b)
+		rs := put(ts, "object", bytes.Repeat([]byte("a"), limit+1))
+		if rs.StatusCode != http.StatusBadRequest {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if ts.backendObjectExists(defaultBucket, "object") {
+			t.Fatal("object should not have been created")
+		}
+	})
+
+	// http.Server rejects these headers itself, but they can reach the
+	// handler through the RoundTripper:
+	t.Run("content-length-header", func(t *testing.T) {
+		ts := newTestServer(t)
+		defer ts.Close()
+		client := &http.Client{Transport: ts.RoundTripper()}
+
+		for _, tc := range []struct {
+			header string
+			status int
+		}{
+			{"-1", http.StatusOK}, // Sent by some SDKs for a streamed body
+			{"abc", http.StatusBadRequest},
+			{"-5", http.StatusBadRequest},
+		} {
+			rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/object"), ioutil.NopCloser(strings.NewReader("streamed")))
+			ts.OK(err)
+			rq.ContentLength = -1
+			rq.Header.Set("Content-Length", tc.header)
+			rs, err := client.Do(rq)
+			ts.OK(err)
+			rs.Body.Close()
+			if rs.StatusCode != tc.status {
+				t.Fatal("unexpected status for", tc.header, rs.StatusCode)
+			}
+		}
+		ts.assertObject(defaultBucket, "object", nil, "streamed")
+	})
 }
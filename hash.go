@@ -3,10 +3,14 @@ package gofakes3
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
+	"net/http"
+	"strings"
 )
 
 // hashingReader proxies an existing io.Reader, passing each read block to the
@@ -19,6 +23,10 @@ type hashingReader struct {
 	expected []byte
 	hash     hash.Hash
 	sum      []byte
+
+	// sha256 is only calculated if expectSHA256 has been called.
+	sha256         hash.Hash
+	expectedSHA256 []byte
 }
 
 func newHashingReader(inner io.Reader, expectedMD5Base64 string) (*hashingReader, error) {
@@ -42,6 +50,13 @@ func newHashingReader(inner io.Reader, expectedMD5Base64 string) (*hashingReader
 	}, nil
 }
 
+// expectSHA256 causes the reader to also check the SHA256 of the data against
+// sum once the inner reader returns EOF. See contentSHA256FromHeader.
+func (h *hashingReader) expectSHA256(sum []byte) {
+	h.sha256 = sha256.New()
+	h.expectedSHA256 = sum
+}
+
 // Sum returns the hash of the data read from the inner reader so far.
 // If into is passed, it may be used if the hash needs to be computed.
 func (h *hashingReader) Sum(into []byte) []byte {
@@ -59,6 +74,9 @@ func (h *hashingReader) Read(p []byte) (n int, err error) {
 		if wn != n {
 			return n, fmt.Errorf("short write to hasher")
 		}
+		if h.sha256 != nil {
+			h.sha256.Write(p[:n])
+		}
 	}
 
 	if err != nil {
@@ -70,9 +88,30 @@ func (h *hashingReader) Read(p []byte) (n int, err error) {
 				// what S3 responds with in this case.
 				return n, ErrBadDigest
 			}
+			if h.sha256 != nil && !bytes.Equal(h.sha256.Sum(nil), h.expectedSHA256) {
+				return n, ErrXAmzContentSHA256Mismatch
+			}
 		}
 		return n, err
 	}
 
 	return n, nil
 }
+
+// contentSHA256FromHeader returns the SHA256 of the payload that was signed
+// with AWS Signature Version 4, from the 'x-amz-content-sha256' header. If
+// the header is missing, or the payload was not signed in full (i.e. it is
+// 'UNSIGNED-PAYLOAD' or one of the 'STREAMING-' values used for chunked
+// uploads), nil is returned.
+func contentSHA256FromHeader(hdr http.Header) ([]byte, error) {
+	v := hdr.Get("x-amz-content-sha256")
+	if v == "" || v == "UNSIGNED-PAYLOAD" || strings.HasPrefix(v, "STREAMING-") {
+		return nil, nil
+	}
+	sum, err := hex.DecodeString(v)
+	if err != nil || len(sum) != sha256.Size {
+		return nil, ErrorInvalidArgument("x-amz-content-sha256", v,
+			"x-amz-content-sha256 must be UNSIGNED-PAYLOAD, STREAMING-AWS4-HMAC-SHA256-PAYLOAD, or a valid sha256 value.")
+	}
+	return sum, nil
+}
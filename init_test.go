@@ -708,6 +708,7 @@ func (ts *testServer) listBucketV2Pages(prefix *gofakes3.Prefix, maxKeys int64,
 
 func (ts *testServer) Close() {
 	ts.server.Close()
+	ts.OK(ts.GoFakeS3.Close())
 }
 
 func hashMD5Bytes(body []byte) hashValue {
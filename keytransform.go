@@ -0,0 +1,263 @@
+package gofakes3
+
+import (
+	"io"
+	"sort"
+)
+
+// keyTransform converts object keys between the form used in requests and
+// responses and the form stored in the Backend. See WithKeyTransform.
+type keyTransform struct {
+	encode func(key string) string
+	decode func(key string) string
+}
+
+// err replaces the backend's key in a NoSuchKey error with the client's key,
+// so the transform does not leak into error responses.
+func (kt *keyTransform) err(err error, key string) error {
+	if HasErrorCode(err, ErrNoSuchKey) {
+		return KeyNotFound(key)
+	}
+	return err
+}
+
+func (kt *keyTransform) object(obj *Object, err error, key string) (*Object, error) {
+	if err != nil {
+		return obj, kt.err(err, key)
+	}
+	if obj != nil {
+		obj.Name = key
+	}
+	return obj, nil
+}
+
+// keyTransformBackend applies a keyTransform to every key passed to or
+// returned from the wrapped Backend.
+//
+// The transformed keys are unlikely to preserve the sort order or the
+// prefixes of the original keys, so listings fetch the entire bucket from the
+// wrapped Backend, then match and page through the decoded keys here.
+type keyTransformBackend struct {
+	Backend
+	kt *keyTransform
+}
+
+var _ Backend = &keyTransformBackend{}
+
+func (b *keyTransformBackend) ListBucket(name string, prefix *Prefix, page ListBucketPage) (*ObjectList, error) {
+	if prefix == nil {
+		prefix = &Prefix{}
+	}
+
+	all, err := b.Backend.ListBucket(name, nil, ListBucketPage{})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		response = NewObjectList()
+		match    PrefixMatch
+	)
+	for _, item := range all.Contents {
+		decoded := *item
+		decoded.Key = b.kt.decode(item.Key)
+
+		if !prefix.Match(decoded.Key, &match) {
+			continue
+		} else if match.CommonPrefix {
+			response.AddPrefix(match.MatchedPart)
+		} else {
+			response.Add(&decoded)
+		}
+	}
+
+	return response.applyPage(page), nil
+}
+
+func (b *keyTransformBackend) GetObject(bucketName, objectName string, rangeRequest *ObjectRangeRequest) (*Object, error) {
+	obj, err := b.Backend.GetObject(bucketName, b.kt.encode(objectName), rangeRequest)
+	return b.kt.object(obj, err, objectName)
+}
+
+func (b *keyTransformBackend) HeadObject(bucketName, objectName string) (*Object, error) {
+	obj, err := b.Backend.HeadObject(bucketName, b.kt.encode(objectName))
+	return b.kt.object(obj, err, objectName)
+}
+
+func (b *keyTransformBackend) DeleteObject(bucketName, objectName string) (ObjectDeleteResult, error) {
+	result, err := b.Backend.DeleteObject(bucketName, b.kt.encode(objectName))
+	return result, b.kt.err(err, objectName)
+}
+
+func (b *keyTransformBackend) PutObject(bucketName, key string, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error) {
+	return b.Backend.PutObject(bucketName, b.kt.encode(key), meta, input, size)
+}
+
+func (b *keyTransformBackend) PutObjectIf(bucketName, key string, cond PutCondition, meta map[string]string, input io.Reader, size int64) (PutObjectResult, error) {
+	cpb, ok := b.Backend.(ConditionalPutBackend)
+	if !ok {
+		return PutObjectResult{}, ErrNotImplemented
+	}
+	result, err := cpb.PutObjectIf(bucketName, b.kt.encode(key), cond, meta, input, size)
+	return result, b.kt.err(err, key)
+}
+
+// Close closes the wrapped Backend if it implements io.Closer.
+func (b *keyTransformBackend) Close() error {
+	if closer, ok := b.Backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (b *keyTransformBackend) DeleteMulti(bucketName string, objects ...string) (MultiDeleteResult, error) {
+	encoded := make([]string, len(objects))
+	for i, object := range objects {
+		encoded[i] = b.kt.encode(object)
+	}
+
+	result, err := b.Backend.DeleteMulti(bucketName, encoded...)
+	for i := range result.Deleted {
+		result.Deleted[i].Key = b.kt.decode(result.Deleted[i].Key)
+	}
+	for i := range result.Error {
+		result.Error[i].Key = b.kt.decode(result.Error[i].Key)
+	}
+	return result, err
+}
+
+// keyTransformPartsBackend is the ObjectPartsBackend counterpart to
+// keyTransformBackend.
+type keyTransformPartsBackend struct {
+	ObjectPartsBackend
+	kt *keyTransform
+}
+
+var _ ObjectPartsBackend = &keyTransformPartsBackend{}
+
+func (b *keyTransformPartsBackend) PutObjectWithParts(bucketName, key string, meta map[string]string, input io.Reader, size int64, manifest PartsManifest) (PutObjectResult, error) {
+	return b.ObjectPartsBackend.PutObjectWithParts(bucketName, b.kt.encode(key), meta, input, size, manifest)
+}
+
+func (b *keyTransformPartsBackend) ObjectParts(bucketName, key string, versionID VersionID) (*PartsManifest, error) {
+	manifest, err := b.ObjectPartsBackend.ObjectParts(bucketName, b.kt.encode(key), versionID)
+	return manifest, b.kt.err(err, key)
+}
+
+// keyTransformVersionedBackend is the VersionedBackend counterpart to
+// keyTransformBackend.
+type keyTransformVersionedBackend struct {
+	VersionedBackend
+	kt *keyTransform
+}
+
+var _ VersionedBackend = &keyTransformVersionedBackend{}
+
+func (b *keyTransformVersionedBackend) GetObjectVersion(bucketName, objectName string, versionID VersionID, rangeRequest *ObjectRangeRequest) (*Object, error) {
+	obj, err := b.VersionedBackend.GetObjectVersion(bucketName, b.kt.encode(objectName), versionID, rangeRequest)
+	return b.kt.object(obj, err, objectName)
+}
+
+func (b *keyTransformVersionedBackend) HeadObjectVersion(bucketName, objectName string, versionID VersionID) (*Object, error) {
+	obj, err := b.VersionedBackend.HeadObjectVersion(bucketName, b.kt.encode(objectName), versionID)
+	return b.kt.object(obj, err, objectName)
+}
+
+func (b *keyTransformVersionedBackend) DeleteObjectVersion(bucketName, objectName string, versionID VersionID) (ObjectDeleteResult, error) {
+	result, err := b.VersionedBackend.DeleteObjectVersion(bucketName, b.kt.encode(objectName), versionID)
+	return result, b.kt.err(err, objectName)
+}
+
+// ListBucketVersions lists the entire bucket from the wrapped backend for the
+// same reason as keyTransformBackend.ListBucket. The versions of each key are
+// kept in the order the wrapped backend returned them.
+func (b *keyTransformVersionedBackend) ListBucketVersions(bucketName string, prefix *Prefix, page *ListBucketVersionsPage) (*ListBucketVersionsResult, error) {
+	if prefix == nil {
+		prefix = &Prefix{}
+	}
+	if page == nil {
+		page = &ListBucketVersionsPage{}
+	}
+
+	all, err := b.VersionedBackend.ListBucketVersions(bucketName, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]VersionItem, len(all.Versions))
+	keys := make([]string, len(all.Versions))
+	for i, item := range all.Versions {
+		switch item := item.(type) {
+		case *Version:
+			decoded := *item
+			decoded.Key = b.kt.decode(item.Key)
+			versions[i], keys[i] = &decoded, decoded.Key
+		case *DeleteMarker:
+			decoded := *item
+			decoded.Key = b.kt.decode(item.Key)
+			versions[i], keys[i] = &decoded, decoded.Key
+		default:
+			versions[i] = item
+		}
+	}
+	sort.Stable(versionsByKey{versions, keys})
+
+	var (
+		response        = NewListBucketVersionsResult(bucketName, prefix, page)
+		match           PrefixMatch
+		cnt             int64
+		lastMatchedPart string
+		skipping        = page.HasKeyMarker
+	)
+
+	for idx, item := range versions {
+		key := keys[idx]
+		if skipping {
+			if key < page.KeyMarker {
+				continue
+			} else if key == page.KeyMarker {
+				if page.HasVersionIDMarker && item.GetVersionID() == page.VersionIDMarker {
+					skipping = false
+				}
+				continue
+			}
+			skipping = false
+		}
+
+		if !prefix.Match(key, &match) {
+			continue
+
+		} else if match.CommonPrefix {
+			if match.MatchedPart == lastMatchedPart {
+				continue // Should not count towards keys
+			}
+			response.AddPrefix(match.MatchedPart)
+			lastMatchedPart = match.MatchedPart
+
+		} else {
+			response.Versions = append(response.Versions, item)
+		}
+
+		cnt++
+		if page.MaxKeys > 0 && cnt >= page.MaxKeys {
+			response.IsTruncated = idx < len(versions)-1
+			response.NextKeyMarker = key
+			response.NextVersionIDMarker = item.GetVersionID()
+			break
+		}
+	}
+
+	return response, nil
+}
+
+type versionsByKey struct {
+	versions []VersionItem
+	keys     []string
+}
+
+func (v versionsByKey) Len() int           { return len(v.versions) }
+func (v versionsByKey) Less(i, j int) bool { return v.keys[i] < v.keys[j] }
+func (v versionsByKey) Swap(i, j int) {
+	v.versions[i], v.versions[j] = v.versions[j], v.versions[i]
+	v.keys[i], v.keys[j] = v.keys[j], v.keys[i]
+}
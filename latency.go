@@ -0,0 +1,105 @@
+package gofakes3
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// z99 is the standard score of the 99th percentile of the normal
+// distribution, used to fit a LatencyDistribution's P99.
+const z99 = 2.3263478740408408
+
+// LatencyProfile configures the artificial latency injected into each
+// request by WithLatencyProfile.
+type LatencyProfile struct {
+	// Default applies to any operation that is not listed in Operations. The
+	// zero value adds no latency.
+	Default LatencyDistribution
+
+	// Operations overrides Default for specific operations.
+	Operations map[Operation]LatencyDistribution
+
+	// Seed for the random number generator used to sample latencies. If zero,
+	// the generator is seeded from the GoFakeS3 TimeSource, so a
+	// FixedTimeSource will produce the same sequence of latencies every time.
+	Seed int64
+}
+
+func (p LatencyProfile) distribution(op Operation) LatencyDistribution {
+	if dist, ok := p.Operations[op]; ok {
+		return dist
+	}
+	return p.Default
+}
+
+// LatencyDistribution describes the latency of an operation by its median
+// (P50) and 99th percentile (P99). Latencies are sampled from a log-normal
+// distribution fitted to these two points, which gives the long tail
+// typically seen in real services.
+//
+// If P99 is not greater than P50, every request is delayed by exactly P50.
+type LatencyDistribution struct {
+	P50 time.Duration
+	P99 time.Duration
+}
+
+// sample returns a latency drawn from the distribution using rng.
+func (d LatencyDistribution) sample(rng *rand.Rand) time.Duration {
+	if d.P50 <= 0 {
+		return 0
+	}
+	if d.P99 <= d.P50 {
+		return d.P50
+	}
+
+	mu := math.Log(float64(d.P50))
+	sigma := math.Log(float64(d.P99)/float64(d.P50)) / z99
+	return time.Duration(math.Exp(mu + sigma*rng.NormFloat64()))
+}
+
+// latencyInjector samples delays from a LatencyProfile. rand.Rand is not safe
+// for concurrent use, so it is protected by mu.
+type latencyInjector struct {
+	profile LatencyProfile
+	rng     *rand.Rand
+	mu      sync.Mutex
+}
+
+func newLatencyInjector(profile LatencyProfile, timeSource TimeSource) *latencyInjector {
+	seed := profile.Seed
+	if seed == 0 {
+		seed = timeSource.Now().UnixNano()
+	}
+	return &latencyInjector{
+		profile: profile,
+		rng:     rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (li *latencyInjector) delay(op Operation) time.Duration {
+	dist := li.profile.distribution(op)
+	li.mu.Lock()
+	defer li.mu.Unlock()
+	return dist.sample(li.rng)
+}
+
+// latencyMiddleware delays each request by a latency sampled from the
+// configured LatencyProfile. If the request's context is cancelled during the
+// delay, the request is abandoned without a response.
+func (g *GoFakeS3) latencyMiddleware(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, rq *http.Request) {
+		if delay := g.latency.delay(requestOperation(rq)); delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-rq.Context().Done():
+				timer.Stop()
+				return
+			}
+		}
+		handler.ServeHTTP(w, rq)
+	})
+}
@@ -0,0 +1,115 @@
+package gofakes3
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLatencyDistributionSample(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	t.Run("zero", func(t *testing.T) {
+		if d := (LatencyDistribution{}).sample(rng); d != 0 {
+			t.Fatal("unexpected latency", d)
+		}
+	})
+
+	t.Run("constant", func(t *testing.T) {
+		dist := LatencyDistribution{P50: 5 * time.Millisecond}
+		if d := dist.sample(rng); d != 5*time.Millisecond {
+			t.Fatal("unexpected latency", d)
+		}
+	})
+
+	t.Run("percentiles", func(t *testing.T) {
+		dist := LatencyDistribution{P50: 5 * time.Millisecond, P99: 200 * time.Millisecond}
+
+		const n = 20000
+		samples := make([]time.Duration, n)
+		for i := range samples {
+			samples[i] = dist.sample(rng)
+		}
+		sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+		assertNear := func(name string, found, expected time.Duration, tolerance float64) {
+			t.Helper()
+			ratio := float64(found) / float64(expected)
+			if ratio < 1-tolerance || ratio > 1+tolerance {
+				t.Fatal(name, "was", found, "expected roughly", expected)
+			}
+		}
+		assertNear("p50", samples[n/2], dist.P50, 0.1)
+		assertNear("p99", samples[n*99/100], dist.P99, 0.2)
+	})
+}
+
+func TestLatencyInjectorOperations(t *testing.T) {
+	profile := LatencyProfile{
+		Default: LatencyDistribution{P50: time.Millisecond},
+		Operations: map[Operation]LatencyDistribution{
+			OpHeadObject: {P50: 5 * time.Millisecond, P99: 20 * time.Millisecond},
+		},
+		Seed: 1,
+	}
+
+	// The same seed produces the same delays:
+	li1 := newLatencyInjector(profile, DefaultTimeSource())
+	li2 := newLatencyInjector(profile, DefaultTimeSource())
+
+	const n = 20000
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		samples[i] = li1.delay(OpHeadObject)
+		if d := li2.delay(OpHeadObject); d != samples[i] {
+			t.Fatal("seeded injectors diverged at", i, samples[i], d)
+		}
+	}
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	if p50 := samples[n/2]; p50 < 4500*time.Microsecond || p50 > 5500*time.Microsecond {
+		t.Fatal("unexpected p50", p50)
+	}
+	if p99 := samples[n*99/100]; p99 < 16*time.Millisecond || p99 > 24*time.Millisecond {
+		t.Fatal("unexpected p99", p99)
+	}
+
+	// Operations without their own distribution use the default:
+	if d := li1.delay(OpGetObject); d != time.Millisecond {
+		t.Fatal("unexpected default latency", d)
+	}
+}
+
+func TestLatencyMiddlewareCancel(t *testing.T) {
+	g := New(nil, WithLatencyProfile(LatencyProfile{
+		Default: LatencyDistribution{P50: time.Hour},
+	}))
+
+	called := false
+	handler := g.latencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rq := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+	rs := httptest.NewRecorder()
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rs, rq)
+		close(done)
+	}()
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("request was not abandoned when the context was cancelled")
+	}
+	if called {
+		t.Fatal("handler should not be called")
+	}
+}
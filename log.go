@@ -10,6 +10,21 @@ const (
 	LogInfo LogLevel = "INFO"
 )
 
+// severity orders the levels from LogInfo (the least severe) to LogErr.
+// Unknown levels are treated as more severe than any known level.
+func (l LogLevel) severity() int {
+	switch l {
+	case LogInfo:
+		return 0
+	case LogWarn:
+		return 1
+	case LogErr:
+		return 2
+	default:
+		return 3
+	}
+}
+
 // Logger provides a very minimal target for logging implementations to hit to
 // allow arbitrary logging dependencies to be used with GoFakeS3.
 //
@@ -76,6 +91,31 @@ func StdLog(log *log.Logger, levels ...LogLevel) Logger {
 	return newStdLog(log.Println, levels...)
 }
 
+// LevelLog wraps a Logger so that messages less severe than min are dropped
+// before they reach it. From least to most severe, the levels are LogInfo,
+// LogWarn and LogErr, so LevelLog(logger, LogWarn) discards the
+// per-request LogInfo messages but keeps warnings and errors. Messages with
+// an unknown level are always passed on.
+//
+// If logger is nil, DiscardLog() is returned.
+func LevelLog(logger Logger, min LogLevel) Logger {
+	if logger == nil {
+		return DiscardLog()
+	}
+	return &levelLog{log: logger, min: min.severity()}
+}
+
+type levelLog struct {
+	log Logger
+	min int
+}
+
+func (l *levelLog) Print(level LogLevel, v ...interface{}) {
+	if level.severity() >= l.min {
+		l.log.Print(level, v...)
+	}
+}
+
 // DiscardLog creates a Logger that discards all messages.
 func DiscardLog() Logger {
 	return &discardLog{}
@@ -31,6 +31,38 @@ func TestStdLogLevels(t *testing.T) {
 	}
 }
 
+func TestLevelLog(t *testing.T) {
+	var buf bytes.Buffer
+	std := log.New(&buf, "", 0)
+	l := LevelLog(StdLog(std), LogWarn)
+
+	l.Print(LogErr, "yep1", 1)
+	l.Print(LogWarn, "yep2", 2)
+	l.Print(LogInfo, "nope", 3)
+	l.Print(LogLevel("CUSTOM"), "yep3", 4)
+	if buf.String() != "ERR yep1 1\nWARN yep2 2\nCUSTOM yep3 4\n" {
+		t.Fatal(buf.String())
+	}
+}
+
+func TestWithLogLevel(t *testing.T) {
+	// The level applies whichever order the options are given in:
+	for _, levelFirst := range []bool{false, true} {
+		var buf bytes.Buffer
+		opts := []Option{WithLogger(StdLog(log.New(&buf, "", 0))), WithLogLevel(LogErr)}
+		if levelFirst {
+			opts[0], opts[1] = opts[1], opts[0]
+		}
+
+		g := New(nil, opts...)
+		g.log.Print(LogInfo, "nope")
+		g.log.Print(LogErr, "yep")
+		if buf.String() != "ERR yep\n" {
+			t.Fatal(levelFirst, buf.String())
+		}
+	}
+}
+
 func TestDiscardLog(t *testing.T) {
 	d := DiscardLog()
 
@@ -20,6 +20,110 @@ type UserInfo struct {
 	DisplayName string `xml:"DisplayName"`
 }
 
+// defaultOwner is used by GoFakeS3 unless the WithOwner option is passed.
+var defaultOwner = UserInfo{
+	ID:          "fe7272ea58be830e56fe1663b10fafef",
+	DisplayName: "GoFakeS3",
+}
+
+// AccessControlPolicy is the request and response body used by the ACL
+// subresource (?acl).
+type AccessControlPolicy struct {
+	XMLName xml.Name  `xml:"AccessControlPolicy"`
+	Xmlns   string    `xml:"xmlns,attr,omitempty"`
+	Owner   *UserInfo `xml:"Owner,omitempty"`
+
+	AccessControlList []Grant `xml:"AccessControlList>Grant"`
+}
+
+type Grant struct {
+	Grantee    Grantee    `xml:"Grantee"`
+	Permission Permission `xml:"Permission"`
+}
+
+// Grantee identifies who a Grant applies to. Type is one of GranteeCanonicalUser,
+// GranteeGroup or GranteeEmail, which determines which of the other fields
+// is used.
+//
+// Type is sent by S3 as the 'xsi:type' attribute, which encoding/xml can not
+// produce by itself, so Grantee provides its own XML marshalling.
+type Grantee struct {
+	Type         GranteeType
+	ID           string
+	DisplayName  string
+	URI          string
+	EmailAddress string
+}
+
+const xmlSchemaInstanceNS = "http://www.w3.org/2001/XMLSchema-instance"
+
+type granteeXML struct {
+	ID           string `xml:"ID,omitempty"`
+	DisplayName  string `xml:"DisplayName,omitempty"`
+	URI          string `xml:"URI,omitempty"`
+	EmailAddress string `xml:"EmailAddress,omitempty"`
+}
+
+func (g Grantee) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Attr = append(start.Attr,
+		xml.Attr{Name: xml.Name{Local: "xmlns:xsi"}, Value: xmlSchemaInstanceNS},
+		xml.Attr{Name: xml.Name{Local: "xsi:type"}, Value: string(g.Type)},
+	)
+	return e.EncodeElement(granteeXML{
+		ID:           g.ID,
+		DisplayName:  g.DisplayName,
+		URI:          g.URI,
+		EmailAddress: g.EmailAddress,
+	}, start)
+}
+
+func (g *Grantee) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v granteeXML
+	if err := d.DecodeElement(&v, &start); err != nil {
+		return err
+	}
+	*g = Grantee{
+		ID:           v.ID,
+		DisplayName:  v.DisplayName,
+		URI:          v.URI,
+		EmailAddress: v.EmailAddress,
+	}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == xmlSchemaInstanceNS && attr.Name.Local == "type" {
+			g.Type = GranteeType(attr.Value)
+		}
+	}
+	return nil
+}
+
+type GranteeType string
+
+const (
+	GranteeCanonicalUser GranteeType = "CanonicalUser"
+	GranteeGroup         GranteeType = "Group"
+	GranteeEmail         GranteeType = "AmazonCustomerByEmail"
+)
+
+// Permission is the access granted by a Grant:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview.html#permissions
+type Permission string
+
+const (
+	PermissionFullControl Permission = "FULL_CONTROL"
+	PermissionRead        Permission = "READ"
+	PermissionReadACP     Permission = "READ_ACP"
+	PermissionWrite       Permission = "WRITE"
+	PermissionWriteACP    Permission = "WRITE_ACP"
+)
+
+func (p Permission) Valid() bool {
+	switch p {
+	case PermissionFullControl, PermissionRead, PermissionReadACP, PermissionWrite, PermissionWriteACP:
+		return true
+	}
+	return false
+}
+
 type Buckets []BucketInfo
 
 // Names is a deterministic convenience function returning a sorted list of bucket names.
@@ -50,6 +154,10 @@ type CommonPrefix struct {
 type CompletedPart struct {
 	PartNumber int    `xml:"PartNumber"`
 	ETag       string `xml:"ETag"`
+
+	// The checksum of the part, which must match the one calculated when it
+	// was uploaded, if it is given.
+	Checksums
 }
 
 type CompleteMultipartUploadRequest struct {
@@ -74,6 +182,19 @@ type CompleteMultipartUploadResult struct {
 	Bucket   string `xml:"Bucket"`
 	Key      string `xml:"Key"`
 	ETag     string `xml:"ETag"`
+
+	// The composite checksum of the object, if the upload was created with
+	// a checksum algorithm. See compositeChecksum.
+	Checksums
+}
+
+// PostResponse is returned by a browser upload if the 'success_action_status'
+// form field is '201'.
+type PostResponse struct {
+	Location string `xml:"Location"`
+	Bucket   string `xml:"Bucket"`
+	Key      string `xml:"Key"`
+	ETag     string `xml:"ETag"`
 }
 
 type Content struct {
@@ -81,7 +202,7 @@ type Content struct {
 	LastModified ContentTime  `xml:"LastModified"`
 	ETag         string       `xml:"ETag"`
 	Size         int64        `xml:"Size"`
-	StorageClass StorageClass `xml:"StorageClass,omitempty"`
+	StorageClass StorageClass `xml:"StorageClass"`
 	Owner        *UserInfo    `xml:"Owner,omitempty"`
 }
 
@@ -94,14 +215,107 @@ func NewContentTime(t time.Time) ContentTime {
 }
 
 func (c ContentTime) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
-	// This is the format expected by the aws xml code, not the default.
+	// This is the format expected by the aws xml code, not the default. The
+	// time must be converted to UTC first, otherwise the 'Z' suffix lies about
+	// the zone if a non-UTC TimeSource is in use. S3 always includes the
+	// milliseconds, even if they are zero.
 	if !c.IsZero() {
-		var s = c.Format("2006-01-02T15:04:05.999Z")
+		var s = c.In(time.UTC).Format("2006-01-02T15:04:05.000Z")
 		return e.EncodeElement(s, start)
 	}
 	return nil
 }
 
+// SelectObjectContentRequest is the body of a SelectObjectContent request:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_SelectObjectContent.html
+type SelectObjectContentRequest struct {
+	XMLName             xml.Name         `xml:"SelectObjectContentRequest"`
+	Expression          string           `xml:"Expression"`
+	ExpressionType      string           `xml:"ExpressionType"`
+	RequestProgress     *SelectProgress  `xml:"RequestProgress,omitempty"`
+	InputSerialization  SelectInput      `xml:"InputSerialization"`
+	OutputSerialization SelectOutput     `xml:"OutputSerialization"`
+	ScanRange           *SelectScanRange `xml:"ScanRange,omitempty"`
+}
+
+type SelectProgress struct {
+	Enabled bool `xml:"Enabled"`
+}
+
+// SelectInput describes the format of the object being queried. Exactly one
+// of CSV, JSON or Parquet should be set.
+type SelectInput struct {
+	// CompressionType is "NONE", "GZIP" or "BZIP2". An empty value is
+	// treated as "NONE".
+	CompressionType string `xml:"CompressionType,omitempty"`
+
+	CSV     *SelectCSVInput     `xml:"CSV,omitempty"`
+	JSON    *SelectJSONInput    `xml:"JSON,omitempty"`
+	Parquet *SelectParquetInput `xml:"Parquet,omitempty"`
+}
+
+type SelectCSVInput struct {
+	AllowQuotedRecordDelimiter bool   `xml:"AllowQuotedRecordDelimiter,omitempty"`
+	Comments                   string `xml:"Comments,omitempty"`
+	FieldDelimiter             string `xml:"FieldDelimiter,omitempty"`
+	FileHeaderInfo             string `xml:"FileHeaderInfo,omitempty"`
+	QuoteCharacter             string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter       string `xml:"QuoteEscapeCharacter,omitempty"`
+	RecordDelimiter            string `xml:"RecordDelimiter,omitempty"`
+}
+
+type SelectJSONInput struct {
+	// Type is "DOCUMENT" or "LINES".
+	Type string `xml:"Type,omitempty"`
+}
+
+type SelectParquetInput struct{}
+
+// SelectOutput describes the format of the query results. Exactly one of CSV
+// or JSON should be set.
+type SelectOutput struct {
+	CSV  *SelectCSVOutput  `xml:"CSV,omitempty"`
+	JSON *SelectJSONOutput `xml:"JSON,omitempty"`
+}
+
+type SelectCSVOutput struct {
+	FieldDelimiter       string `xml:"FieldDelimiter,omitempty"`
+	QuoteCharacter       string `xml:"QuoteCharacter,omitempty"`
+	QuoteEscapeCharacter string `xml:"QuoteEscapeCharacter,omitempty"`
+
+	// QuoteFields is "ALWAYS" or "ASNEEDED".
+	QuoteFields     string `xml:"QuoteFields,omitempty"`
+	RecordDelimiter string `xml:"RecordDelimiter,omitempty"`
+}
+
+type SelectJSONOutput struct {
+	RecordDelimiter string `xml:"RecordDelimiter,omitempty"`
+}
+
+// SelectScanRange limits the query to the records that start within the
+// byte range of the object.
+type SelectScanRange struct {
+	Start *int64 `xml:"Start,omitempty"`
+	End   *int64 `xml:"End,omitempty"`
+}
+
+// SelectStats is the payload of the Stats event sent at the end of a
+// SelectObjectContent response.
+type SelectStats struct {
+	XMLName        xml.Name `xml:"Stats"`
+	BytesScanned   int64    `xml:"BytesScanned"`
+	BytesProcessed int64    `xml:"BytesProcessed"`
+	BytesReturned  int64    `xml:"BytesReturned"`
+}
+
+// CopyObjectResult is the response body of a PUT with the x-amz-copy-source
+// header.
+type CopyObjectResult struct {
+	XMLName      xml.Name    `xml:"CopyObjectResult"`
+	ETag         string      `xml:"ETag"`
+	LastModified ContentTime `xml:"LastModified"`
+}
+
 type DeleteRequest struct {
 	Objects []ObjectID `xml:"Object"`
 
@@ -201,6 +415,10 @@ type ListBucketResultBase struct {
 
 	MaxKeys int64 `xml:"MaxKeys,omitempty"`
 
+	// EncodingType is "url" if the Key, Prefix, Delimiter and marker values
+	// in the response have been URL-encoded at the request of the client.
+	EncodingType string `xml:"EncodingType,omitempty"`
+
 	CommonPrefixes []CommonPrefix `xml:"CommonPrefixes,omitempty"`
 	Contents       []*Content     `xml:"Contents"`
 }
@@ -244,6 +462,54 @@ type ListBucketResultV2 struct {
 	StartAfter string `xml:"StartAfter,omitempty"`
 }
 
+// MarshalXML encodes the result with its elements in the same order as the
+// S3 schema. encoding/xml would otherwise encode the fields of the embedded
+// ListBucketResultBase before Marker and NextMarker.
+func (r ListBucketResult) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "ListBucketResult"}
+	return e.EncodeElement(struct {
+		Xmlns          string         `xml:"xmlns,attr,omitempty"`
+		Name           string         `xml:"Name"`
+		Prefix         string         `xml:"Prefix"`
+		Marker         string         `xml:"Marker"`
+		NextMarker     string         `xml:"NextMarker,omitempty"`
+		MaxKeys        int64          `xml:"MaxKeys"`
+		Delimiter      string         `xml:"Delimiter,omitempty"`
+		EncodingType   string         `xml:"EncodingType,omitempty"`
+		IsTruncated    bool           `xml:"IsTruncated"`
+		Contents       []*Content     `xml:"Contents"`
+		CommonPrefixes []CommonPrefix `xml:"CommonPrefixes,omitempty"`
+	}{
+		r.Xmlns, r.Name, r.Prefix, r.Marker, r.NextMarker, r.MaxKeys,
+		r.Delimiter, r.EncodingType, r.IsTruncated, r.Contents, r.CommonPrefixes,
+	}, start)
+}
+
+// MarshalXML encodes the result with its elements in the same order as the
+// S3 schema. See ListBucketResult.MarshalXML.
+func (r ListBucketResultV2) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "ListBucketResult"}
+	return e.EncodeElement(struct {
+		Xmlns                 string         `xml:"xmlns,attr,omitempty"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		KeyCount              int64          `xml:"KeyCount"`
+		MaxKeys               int64          `xml:"MaxKeys"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		EncodingType          string         `xml:"EncodingType,omitempty"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		Contents              []*Content     `xml:"Contents"`
+		CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes,omitempty"`
+	}{
+		r.Xmlns, r.Name, r.Prefix, r.ContinuationToken, r.NextContinuationToken,
+		r.KeyCount, r.MaxKeys, r.Delimiter, r.EncodingType, r.IsTruncated,
+		r.StartAfter, r.Contents, r.CommonPrefixes,
+	}, start)
+}
+
 type DeleteMarker struct {
 	XMLName      xml.Name    `xml:"DeleteMarker"`
 	Key          string      `xml:"Key"`
@@ -257,6 +523,7 @@ var _ VersionItem = &DeleteMarker{}
 
 func (d DeleteMarker) GetVersionID() VersionID   { return d.VersionID }
 func (d *DeleteMarker) setVersionID(i VersionID) { d.VersionID = i }
+func (d *DeleteMarker) setOwner(o *UserInfo)     { d.Owner = o }
 
 type Version struct {
 	XMLName      xml.Name    `xml:"Version"`
@@ -277,10 +544,12 @@ var _ VersionItem = &Version{}
 
 func (v Version) GetVersionID() VersionID   { return v.VersionID }
 func (v *Version) setVersionID(i VersionID) { v.VersionID = i }
+func (v *Version) setOwner(o *UserInfo)     { v.Owner = o }
 
 type VersionItem interface {
 	GetVersionID() VersionID
 	setVersionID(v VersionID)
+	setOwner(o *UserInfo)
 }
 
 type ListBucketVersionsResult struct {
@@ -334,7 +603,7 @@ func NewListBucketVersionsResult(
 ) *ListBucketVersionsResult {
 
 	result := &ListBucketVersionsResult{
-		Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/",
+		Xmlns: XMLNamespace,
 		Name:  bucketName,
 	}
 	if prefix != nil {
@@ -402,6 +671,7 @@ type ListMultipartUploadItem struct {
 
 type ListMultipartUploadPartsResult struct {
 	XMLName xml.Name `xml:"ListPartsResult"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
 
 	Bucket               string       `xml:"Bucket"`
 	Key                  string       `xml:"Key"`
@@ -414,6 +684,10 @@ type ListMultipartUploadPartsResult struct {
 	MaxParts             int64        `xml:"MaxParts"`
 	IsTruncated          bool         `xml:"IsTruncated,omitempty"`
 
+	// ChecksumAlgorithm is the algorithm given when the upload was created,
+	// if any.
+	ChecksumAlgorithm ChecksumAlgorithm `xml:"ChecksumAlgorithm,omitempty"`
+
 	Parts []ListMultipartUploadPartItem `xml:"Part"`
 }
 
@@ -422,6 +696,38 @@ type ListMultipartUploadPartItem struct {
 	LastModified ContentTime `xml:"LastModified,omitempty"`
 	ETag         string      `xml:"ETag,omitempty"`
 	Size         int64       `xml:"Size"`
+	Checksums
+}
+
+// GetObjectAttributesResult is returned by GetObjectAttributes. Only the
+// attributes requested in the 'x-amz-object-attributes' header are set.
+type GetObjectAttributesResult struct {
+	XMLName xml.Name `xml:"GetObjectAttributesResponse"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	ETag         string                    `xml:"ETag,omitempty"`
+	Checksum     *Checksums                `xml:"Checksum,omitempty"`
+	ObjectParts  *GetObjectAttributesParts `xml:"ObjectParts,omitempty"`
+	StorageClass StorageClass              `xml:"StorageClass,omitempty"`
+	ObjectSize   *int64                    `xml:"ObjectSize,omitempty"`
+}
+
+// GetObjectAttributesParts lists the parts of an object that was created by
+// a multipart upload.
+type GetObjectAttributesParts struct {
+	TotalPartsCount      int          `xml:"TotalPartsCount"`
+	PartNumberMarker     int          `xml:"PartNumberMarker"`
+	NextPartNumberMarker int          `xml:"NextPartNumberMarker"`
+	MaxParts             int          `xml:"MaxParts"`
+	IsTruncated          bool         `xml:"IsTruncated"`
+	Parts                []ObjectPart `xml:"Part"`
+}
+
+// ObjectPart is a part of an object that was created by a multipart upload.
+type ObjectPart struct {
+	PartNumber int   `xml:"PartNumber"`
+	Size       int64 `xml:"Size"`
+	Checksums
 }
 
 // MFADeleteStatus is used by VersioningConfiguration.
@@ -457,6 +763,11 @@ type ObjectID struct {
 
 	// Versions not supported in GoFakeS3 yet.
 	VersionID string `xml:"VersionId,omitempty" json:"VersionId,omitempty"`
+
+	// DeleteMarker and DeleteMarkerVersionID are only set in a
+	// MultiDeleteResult, if the delete created a delete marker.
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty" json:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty" json:"DeleteMarkerVersionId,omitempty"`
 }
 
 type StorageClass string
@@ -472,6 +783,129 @@ const (
 	StorageStandard StorageClass = "STANDARD"
 )
 
+// Tag is a single key-value pair in an object's tag set.
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
+
+// Tagging is the request and response body used by the object tagging
+// subresource (?tagging).
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	TagSet []Tag `xml:"TagSet>Tag"`
+}
+
+// CORSConfiguration is the body of the '?cors' bucket subresource:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutBucketCors.html
+type CORSConfiguration struct {
+	XMLName xml.Name `xml:"CORSConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Rules []CORSRule `xml:"CORSRule"`
+}
+
+// NotificationConfiguration is the body of the '?notification' bucket
+// subresource:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutBucketNotificationConfiguration.html
+//
+// GoFakeS3 does not deliver events to the destinations in the configuration,
+// but its filters choose the events passed to WithEventSink.
+type NotificationConfiguration struct {
+	XMLName xml.Name `xml:"NotificationConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	TopicConfigurations          []NotificationTarget      `xml:"TopicConfiguration"`
+	QueueConfigurations          []NotificationTarget      `xml:"QueueConfiguration"`
+	LambdaFunctionConfigurations []NotificationTarget      `xml:"CloudFunctionConfiguration"`
+	EventBridgeConfiguration     *EventBridgeConfiguration `xml:"EventBridgeConfiguration"`
+}
+
+// NotificationTarget is a TopicConfiguration, QueueConfiguration or
+// CloudFunctionConfiguration. Exactly one of Topic, Queue or CloudFunction
+// is set, depending on which.
+type NotificationTarget struct {
+	ID            string              `xml:"Id,omitempty"`
+	Topic         string              `xml:"Topic,omitempty"`
+	Queue         string              `xml:"Queue,omitempty"`
+	CloudFunction string              `xml:"CloudFunction,omitempty"`
+	Events        []string            `xml:"Event"`
+	Filter        *NotificationFilter `xml:"Filter,omitempty"`
+}
+
+// arn returns the ARN of the target, whichever kind it is.
+func (nt NotificationTarget) arn() string {
+	switch {
+	case nt.Topic != "":
+		return nt.Topic
+	case nt.Queue != "":
+		return nt.Queue
+	default:
+		return nt.CloudFunction
+	}
+}
+
+type NotificationFilter struct {
+	Rules []FilterRule `xml:"S3Key>FilterRule"`
+}
+
+type FilterRule struct {
+	Name  string `xml:"Name"`
+	Value string `xml:"Value"`
+}
+
+type EventBridgeConfiguration struct{}
+
+// CreateBucketConfiguration is the optional body of a CreateBucket request:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_CreateBucket.html
+type CreateBucketConfiguration struct {
+	XMLName xml.Name `xml:"CreateBucketConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	LocationConstraint string `xml:"LocationConstraint"`
+}
+
+// LocationConstraint is the response to the '?location' bucket subresource:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_GetBucketLocation.html
+//
+// Location is empty for buckets in us-east-1.
+type LocationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Location string `xml:",chardata"`
+}
+
+// RequestPaymentConfiguration is the body of the '?requestPayment' bucket
+// subresource:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/API_PutBucketRequestPayment.html
+type RequestPaymentConfiguration struct {
+	XMLName xml.Name `xml:"RequestPaymentConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+
+	Payer Payer `xml:"Payer"`
+}
+
+// Payer is the party that pays for requests to a bucket. GoFakeS3 stores it,
+// but does not otherwise act on it.
+type Payer string
+
+const (
+	PayerBucketOwner Payer = "BucketOwner"
+	PayerRequester   Payer = "Requester"
+)
+
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedHeaders []string `xml:"AllowedHeader"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	ExposeHeaders  []string `xml:"ExposeHeader"`
+	MaxAgeSeconds  int      `xml:"MaxAgeSeconds,omitempty"`
+}
+
 // UploadID uses a string as the underlying type, but the string should only
 // represent a decimal integer. See uploader.uploadID for details.
 type UploadID string
@@ -480,13 +914,17 @@ type VersionID string
 
 type VersioningConfiguration struct {
 	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
 
-	Status VersioningStatus `xml:"Status"`
+	// Status is VersioningNone if versioning has never been configured for
+	// the bucket, in which case the element is omitted from the response, as
+	// it is in S3. Once versioning has been enabled, it can only be suspended.
+	Status VersioningStatus `xml:"Status,omitempty"`
 
 	// When enabled, the bucket owner must include the x-amz-mfa request header
 	// in requests to change the versioning state of a bucket and to
 	// permanently delete a versioned object.
-	MFADelete MFADeleteStatus `xml:"MfaDelete"`
+	MFADelete MFADeleteStatus `xml:"MfaDelete,omitempty"`
 }
 
 func (v *VersioningConfiguration) Enabled() bool {
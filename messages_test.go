@@ -35,7 +35,7 @@ func TestContentTime(t *testing.T) {
 	const expected = "" +
 		"<testMsg>" +
 		"<Foo>bar</Foo>" +
-		"<Time>2019-01-01T12:00:00Z</Time>" +
+		"<Time>2019-01-01T12:00:00.000Z</Time>" +
 		"</testMsg>"
 
 	var v = testMsg{
@@ -134,3 +134,29 @@ func TestMFADeleteStatus(t *testing.T) {
 		t.Fatal()
 	}
 }
+
+func TestGranteeXML(t *testing.T) {
+	const expected = "" +
+		`<Grant>` +
+		`<Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="Group">` +
+		`<URI>http://acs.amazonaws.com/groups/global/AllUsers</URI>` +
+		`</Grantee>` +
+		`<Permission>READ</Permission>` +
+		`</Grant>`
+
+	out, err := xml.Marshal(groupGrant(GroupAllUsers, PermissionRead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != expected {
+		t.Fatalf("unexpected XML output: %s", string(out))
+	}
+
+	var grant Grant
+	if err := xml.Unmarshal(out, &grant); err != nil {
+		t.Fatal(err)
+	}
+	if grant != groupGrant(GroupAllUsers, PermissionRead) {
+		t.Fatalf("unexpected grant: %+v", grant)
+	}
+}
@@ -0,0 +1,100 @@
+package gofakes3
+
+import (
+	"strings"
+	"time"
+)
+
+// EventName is the type of an S3 event notification:
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/notification-how-to-event-types-and-destinations.html
+type EventName string
+
+const (
+	EventObjectCreatedPut                     EventName = "s3:ObjectCreated:Put"
+	EventObjectCreatedPost                    EventName = "s3:ObjectCreated:Post"
+	EventObjectCreatedCopy                    EventName = "s3:ObjectCreated:Copy"
+	EventObjectCreatedCompleteMultipartUpload EventName = "s3:ObjectCreated:CompleteMultipartUpload"
+	EventObjectRemovedDelete                  EventName = "s3:ObjectRemoved:Delete"
+	EventObjectRemovedDeleteMarkerCreated     EventName = "s3:ObjectRemoved:DeleteMarkerCreated"
+)
+
+// Event describes a change to an object, which is passed to the sink set with
+// WithEventSink.
+type Event struct {
+	Name   EventName
+	Time   time.Time
+	Bucket string
+	Key    string
+
+	// Size and ETag are only set for ObjectCreated events. The ETag is
+	// quoted, as it is in the ETag header.
+	Size int64
+	ETag string
+
+	// VersionID is set if the bucket has versioning enabled. For
+	// DeleteMarkerCreated events, it is the ID of the delete marker.
+	VersionID VersionID
+}
+
+// emitEvent passes the event to the sink set with WithEventSink, if there is
+// one, and the bucket's notification configuration allows it.
+func (g *GoFakeS3) emitEvent(ev Event) {
+	if g.eventSink == nil {
+		return
+	}
+	ev.Time = g.timeSource.Now()
+	if !g.subresources.BucketNotification(ev.Bucket).matches(ev) {
+		return
+	}
+	g.eventSink(ev)
+}
+
+// matches reports whether the event would be delivered to at least one of
+// the configuration's destinations. An empty configuration matches every
+// event, so that WithEventSink can be used without configuring
+// notifications.
+func (nc NotificationConfiguration) matches(ev Event) bool {
+	var targets []NotificationTarget
+	targets = append(targets, nc.TopicConfigurations...)
+	targets = append(targets, nc.QueueConfigurations...)
+	targets = append(targets, nc.LambdaFunctionConfigurations...)
+	if len(targets) == 0 {
+		return true
+	}
+
+	for _, target := range targets {
+		if target.matches(ev) {
+			return true
+		}
+	}
+	return false
+}
+
+func (nt NotificationTarget) matches(ev Event) bool {
+	if nt.Filter != nil {
+		for _, rule := range nt.Filter.Rules {
+			switch strings.ToLower(rule.Name) {
+			case "prefix":
+				if !strings.HasPrefix(ev.Key, rule.Value) {
+					return false
+				}
+			case "suffix":
+				if !strings.HasSuffix(ev.Key, rule.Value) {
+					return false
+				}
+			}
+		}
+	}
+
+	for _, name := range nt.Events {
+		// Event types may end with a wildcard, like 's3:ObjectCreated:*':
+		if strings.HasSuffix(name, "*") {
+			if strings.HasPrefix(string(ev.Name), strings.TrimSuffix(name, "*")) {
+				return true
+			}
+		} else if name == string(ev.Name) {
+			return true
+		}
+	}
+	return false
+}
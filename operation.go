@@ -0,0 +1,186 @@
+package gofakes3
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Operation is the name of an S3 API operation, as it appears in the S3 API
+// reference (https://docs.aws.amazon.com/AmazonS3/latest/API/API_Operations.html).
+type Operation string
+
+const (
+	OpAbortMultipartUpload    Operation = "AbortMultipartUpload"
+	OpCompleteMultipartUpload Operation = "CompleteMultipartUpload"
+	OpCopyObject              Operation = "CopyObject"
+	OpCreateBucket            Operation = "CreateBucket"
+	OpCreateMultipartUpload   Operation = "CreateMultipartUpload"
+	OpDeleteBucket            Operation = "DeleteBucket"
+	OpDeleteBucketCORS        Operation = "DeleteBucketCors"
+	OpDeleteBucketPolicy      Operation = "DeleteBucketPolicy"
+	OpDeleteObject            Operation = "DeleteObject"
+	OpDeleteObjectTagging     Operation = "DeleteObjectTagging"
+	OpDeleteObjects           Operation = "DeleteObjects"
+	OpGetBucketACL            Operation = "GetBucketAcl"
+	OpGetBucketCORS           Operation = "GetBucketCors"
+	OpGetBucketLocation       Operation = "GetBucketLocation"
+	OpGetBucketNotification   Operation = "GetBucketNotificationConfiguration"
+	OpGetBucketPolicy         Operation = "GetBucketPolicy"
+	OpGetBucketRequestPayment Operation = "GetBucketRequestPayment"
+	OpGetBucketVersioning     Operation = "GetBucketVersioning"
+	OpGetObject               Operation = "GetObject"
+	OpGetObjectACL            Operation = "GetObjectAcl"
+	OpGetObjectAttributes     Operation = "GetObjectAttributes"
+	OpGetObjectTagging        Operation = "GetObjectTagging"
+	OpHeadBucket              Operation = "HeadBucket"
+	OpHeadObject              Operation = "HeadObject"
+	OpListBuckets             Operation = "ListBuckets"
+	OpListMultipartUploads    Operation = "ListMultipartUploads"
+	OpListObjectVersions      Operation = "ListObjectVersions"
+	OpListObjects             Operation = "ListObjects"
+	OpListObjectsV2           Operation = "ListObjectsV2"
+	OpListParts               Operation = "ListParts"
+	OpPostObject              Operation = "PostObject"
+	OpPutBucketACL            Operation = "PutBucketAcl"
+	OpPutBucketCORS           Operation = "PutBucketCors"
+	OpPutBucketNotification   Operation = "PutBucketNotificationConfiguration"
+	OpPutBucketPolicy         Operation = "PutBucketPolicy"
+	OpPutBucketRequestPayment Operation = "PutBucketRequestPayment"
+	OpPutBucketVersioning     Operation = "PutBucketVersioning"
+	OpPutObject               Operation = "PutObject"
+	OpPutObjectACL            Operation = "PutObjectAcl"
+	OpPutObjectTagging        Operation = "PutObjectTagging"
+	OpSelectObjectContent     Operation = "SelectObjectContent"
+	OpUploadPart              Operation = "UploadPart"
+)
+
+// IsRead reports whether the operation only reads buckets and objects. See
+// WithReadOnly.
+func (op Operation) IsRead() bool {
+	s := string(op)
+	return strings.HasPrefix(s, "Get") ||
+		strings.HasPrefix(s, "Head") ||
+		strings.HasPrefix(s, "List") ||
+		op == OpSelectObjectContent
+}
+
+// requestOperation works out which Operation a request will be routed to,
+// using the same routes as routeBase. If the request does not map to a known
+// operation, the empty string is returned.
+//
+// The request URL must already be in path-style form (i.e. after
+// hostBucketMiddleware has run).
+func requestOperation(rq *http.Request) Operation {
+	bucket, object := splitBucketObject(rq.URL.Path)
+	op, _ := resolveOperation(rq, bucket, object, rq.URL.Query())
+	return op
+}
+
+// operationQueryParams lists the query string parameters each Operation
+// accepts, including the subresource that selects it. See WithStrictMode.
+var operationQueryParams = map[Operation][]string{
+	OpAbortMultipartUpload:    {"uploadId"},
+	OpCompleteMultipartUpload: {"uploadId"},
+	OpCopyObject:              {},
+	OpCreateBucket:            {},
+	OpCreateMultipartUpload:   {"uploads"},
+	OpDeleteBucket:            {},
+	OpDeleteBucketCORS:        {"cors"},
+	OpDeleteBucketPolicy:      {"policy"},
+	OpDeleteObject:            {"versionId"},
+	OpDeleteObjectTagging:     {"tagging", "versionId"},
+	OpDeleteObjects:           {"delete"},
+	OpGetBucketACL:            {"acl"},
+	OpGetBucketCORS:           {"cors"},
+	OpGetBucketLocation:       {"location"},
+	OpGetBucketNotification:   {"notification"},
+	OpGetBucketPolicy:         {"policy"},
+	OpGetBucketRequestPayment: {"requestPayment"},
+	OpGetBucketVersioning:     {"versioning"},
+	OpGetObject:               append([]string{"versionId", "partNumber"}, responseOverrideParams...),
+	OpGetObjectACL:            {"acl", "versionId"},
+	OpGetObjectAttributes:     {"attributes", "versionId"},
+	OpGetObjectTagging:        {"tagging", "versionId"},
+	OpHeadBucket:              {},
+	OpHeadObject:              append([]string{"versionId", "partNumber"}, responseOverrideParams...),
+	OpListBuckets:             {"bucket-region", "continuation-token", "max-buckets", "prefix"},
+	OpListMultipartUploads:    {"uploads", "delimiter", "encoding-type", "key-marker", "max-uploads", "prefix", "upload-id-marker"},
+	OpListObjectVersions:      {"versions", "delimiter", "encoding-type", "key-marker", "max-keys", "prefix", "version-id-marker"},
+	OpListObjects:             {"delimiter", "encoding-type", "marker", "max-keys", "prefix"},
+	OpListObjectsV2:           {"list-type", "continuation-token", "delimiter", "encoding-type", "fetch-owner", "max-keys", "prefix", "start-after"},
+	OpListParts:               {"uploadId", "max-parts", "part-number-marker"},
+	OpPostObject:              {},
+	OpPutBucketACL:            {"acl"},
+	OpPutBucketCORS:           {"cors"},
+	OpPutBucketNotification:   {"notification"},
+	OpPutBucketPolicy:         {"policy"},
+	OpPutBucketRequestPayment: {"requestPayment"},
+	OpPutBucketVersioning:     {"versioning"},
+	OpPutObject:               {},
+	OpPutObjectACL:            {"acl", "versionId"},
+	OpPutObjectTagging:        {"tagging", "versionId"},
+	OpSelectObjectContent:     {"select", "select-type"},
+	OpUploadPart:              {"uploadId", "partNumber"},
+}
+
+// responseOverrideParams are the parameters GetObject and HeadObject accept
+// to override the headers in the response.
+var responseOverrideParams = []string{
+	"response-cache-control",
+	"response-content-disposition",
+	"response-content-encoding",
+	"response-content-language",
+	"response-content-type",
+	"response-expires",
+}
+
+// signatureQueryParams are accepted by every operation, as they are used by
+// presigned URLs rather than by the operation itself. 'x-id' is added by
+// some SDKs to name the operation. They are compared case-insensitively.
+var signatureQueryParams = map[string]bool{
+	"awsaccesskeyid":       true,
+	"expires":              true,
+	"signature":            true,
+	"x-amz-algorithm":      true,
+	"x-amz-credential":     true,
+	"x-amz-date":           true,
+	"x-amz-expires":        true,
+	"x-amz-security-token": true,
+	"x-amz-signature":      true,
+	"x-amz-signedheaders":  true,
+	"x-id":                 true,
+}
+
+// checkQueryParams returns ErrInvalidArgument for the first query string
+// parameter (in alphabetical order) that the request's operation does not
+// accept. Requests for unknown operations are not checked.
+func checkQueryParams(rq *http.Request) error {
+	op := requestOperation(rq)
+	accepted, ok := operationQueryParams[op]
+	if !ok {
+		return nil
+	}
+
+	query := rq.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+next:
+	for _, key := range keys {
+		if signatureQueryParams[strings.ToLower(key)] {
+			continue
+		}
+		for _, param := range accepted {
+			if key == param {
+				continue next
+			}
+		}
+		return ErrorInvalidArgument(key, query.Get(key), fmt.Sprintf("%s does not accept the '%s' query parameter", op, key))
+	}
+	return nil
+}
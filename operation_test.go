@@ -0,0 +1,125 @@
+package gofakes3
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestOperation(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		url    string
+		header string
+		op     Operation
+	}{
+		{"GET", "/", "", OpListBuckets},
+		{"GET", "/bucket", "", OpListObjects},
+		{"GET", "/bucket?list-type=2", "", OpListObjectsV2},
+		{"GET", "/bucket?versions", "", OpListObjectVersions},
+		{"PUT", "/bucket", "", OpCreateBucket},
+		{"HEAD", "/bucket/", "", OpHeadBucket},
+		{"DELETE", "/bucket", "", OpDeleteBucket},
+		{"POST", "/bucket?delete", "", OpDeleteObjects},
+		{"POST", "/bucket", "", OpPostObject},
+		{"GET", "/bucket?versioning", "", OpGetBucketVersioning},
+		{"GET", "/bucket?policy", "", OpGetBucketPolicy},
+		{"GET", "/bucket?acl", "", OpGetBucketACL},
+		{"GET", "/bucket?notification", "", OpGetBucketNotification},
+		{"PUT", "/bucket?notification", "", OpPutBucketNotification},
+		{"GET", "/bucket?location", "", OpGetBucketLocation},
+		{"GET", "/bucket?requestPayment", "", OpGetBucketRequestPayment},
+		{"PUT", "/bucket?requestPayment", "", OpPutBucketRequestPayment},
+		{"PUT", "/bucket/?acl", "", OpPutBucketACL},
+		{"GET", "/bucket/object", "", OpGetObject},
+		{"GET", "/bucket/object?versionId=1", "", OpGetObject},
+		{"HEAD", "/bucket/object", "", OpHeadObject},
+		{"PUT", "/bucket/object", "", OpPutObject},
+		{"PUT", "/bucket/object", "/bucket/src", OpCopyObject},
+		{"DELETE", "/bucket/dir/object", "", OpDeleteObject},
+		{"PUT", "/bucket/object?tagging", "", OpPutObjectTagging},
+		{"GET", "/bucket/object?acl", "", OpGetObjectACL},
+		{"POST", "/bucket/object?select&select-type=2", "", OpSelectObjectContent},
+		{"GET", "/bucket/object?attributes", "", OpGetObjectAttributes},
+		{"POST", "/bucket/object?uploads", "", OpCreateMultipartUpload},
+		{"GET", "/bucket?uploads", "", OpListMultipartUploads},
+		{"PUT", "/bucket/object?uploadId=1&partNumber=1", "", OpUploadPart},
+		{"POST", "/bucket/object?uploadId=1", "", OpCompleteMultipartUpload},
+		{"GET", "/bucket?logging", "", ""},
+		{"PUT", "/bucket?tagging", "", ""},
+		{"GET", "/bucket/object?retention&versionId=1", "", ""},
+		{"PATCH", "/bucket/object", "", ""},
+		{"PUT", "/", "", ""},
+	} {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			rq := httptest.NewRequest(tc.method, tc.url, nil)
+			if tc.header != "" {
+				rq.Header.Set("x-amz-copy-source", tc.header)
+			}
+			if op := requestOperation(rq); op != tc.op {
+				t.Fatalf("expected %q, found %q", tc.op, op)
+			}
+		})
+	}
+}
+
+func TestOperationHandlers(t *testing.T) {
+	for op := range operationQueryParams {
+		if operationHandlers[op] == nil {
+			t.Errorf("no handler for %s", op)
+		}
+	}
+	if len(operationHandlers) != len(operationQueryParams) {
+		t.Fatalf("expected %d handlers, found %d", len(operationQueryParams), len(operationHandlers))
+	}
+}
+
+func TestCheckQueryParams(t *testing.T) {
+	for _, tc := range []struct {
+		method string
+		url    string
+		bad    string
+	}{
+		{"GET", "/bucket?prefix=a&delimiter=/&max-keys=1", ""},
+		{"GET", "/bucket?delimeter=/", "delimeter"},
+		{"GET", "/bucket?list-type=2&start-after=a&fetch-owner=true", ""},
+		{"GET", "/bucket?marker=a&start-after=a", "start-after"},
+		{"GET", "/bucket/object?versionId=1&response-content-type=text/plain", ""},
+		{"GET", "/bucket/object?X-Amz-Algorithm=AWS4-HMAC-SHA256&X-Amz-Signature=abc&x-id=GetObject", ""},
+		{"GET", "/bucket/object?AWSAccessKeyId=a&Signature=b&Expires=1", ""},
+		{"DELETE", "/bucket/object?versionId=1&nope", "nope"},
+		{"PUT", "/bucket/object?uploadId=1&partNumber=1", ""},
+		{"GET", "/bucket/object?uploadId=1&max-parts=1&bogus=1&also=1", "also"},
+		{"PUT", "/bucket?requestPayment", ""},
+
+		// Unknown operations are not checked:
+		{"GET", "/bucket?logging&nope", ""},
+		{"OPTIONS", "/bucket/object?nope", ""},
+	} {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			err := checkQueryParams(httptest.NewRequest(tc.method, tc.url, nil))
+			if tc.bad == "" {
+				if err != nil {
+					t.Fatal("unexpected error", err)
+				}
+				return
+			}
+			arg, ok := err.(*ErrorInvalidArgumentResponse)
+			if !ok || arg.ArgumentName != tc.bad {
+				t.Fatalf("expected InvalidArgument for %q, found %v", tc.bad, err)
+			}
+		})
+	}
+}
+
+func TestOperationIsRead(t *testing.T) {
+	for _, op := range []Operation{OpGetObject, OpHeadBucket, OpListObjectsV2, OpListParts, OpSelectObjectContent} {
+		if !op.IsRead() {
+			t.Fatal("expected read operation", op)
+		}
+	}
+	for _, op := range []Operation{OpPutObject, OpCopyObject, OpDeleteObjects, OpCreateBucket, OpUploadPart, OpPutBucketACL} {
+		if op.IsRead() {
+			t.Fatal("unexpected read operation", op)
+		}
+	}
+}
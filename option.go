@@ -1,6 +1,10 @@
 package gofakes3
 
-import "time"
+import (
+	"net/http"
+	"strings"
+	"time"
+)
 
 type Option func(g *GoFakeS3)
 
@@ -32,10 +36,45 @@ func WithMetadataSizeLimit(size int) Option {
 	return func(g *GoFakeS3) { g.metadataSizeLimit = size }
 }
 
-// WithIntegrityCheck enables or disables Content-MD5 validation when
-// putting an Object.
+// WithMetadataEntryLimit allows you to limit the number of user-defined
+// metadata entries ('x-amz-meta-*' headers) an object may have. Requests
+// with more entries fail with ErrMetadataTooLarge.
+//
+// S3 does not document a limit, so this is disabled by default; set it to
+// catch clients that attach more metadata than you expect.
+func WithMetadataEntryLimit(entries int) Option {
+	return func(g *GoFakeS3) { g.metadataEntryLimit = entries }
+}
+
+// WithMetadataValueSizeLimit allows you to limit the size, in bytes, of any
+// single user-defined metadata value. Requests with a larger value fail with
+// ErrMetadataTooLarge, even if the total size is within the limit set by
+// WithMetadataSizeLimit.
+//
+// This is disabled by default; set it to '0' to disable it again.
+func WithMetadataValueSizeLimit(size int) Option {
+	return func(g *GoFakeS3) { g.metadataValueSizeLimit = size }
+}
+
+// WithIntegrityCheck enables or disables Content-MD5 and x-amz-content-sha256
+// validation when putting an Object. It can be changed later using
+// GoFakeS3.SetIntegrityCheck.
 func WithIntegrityCheck(check bool) Option {
-	return func(g *GoFakeS3) { g.integrityCheck = check }
+	return func(g *GoFakeS3) { g.SetIntegrityCheck(check) }
+}
+
+// WithLatencyProfile delays every request by a latency sampled from the
+// distribution configured for its Operation in the profile. By default, no
+// latency is added.
+func WithLatencyProfile(profile LatencyProfile) Option {
+	return func(g *GoFakeS3) { g.latencyProfile = &profile }
+}
+
+// WithServerTimeouts allows you to set the timeouts of the http.Server
+// returned by NewServer. It has no effect on GoFakeS3.Server, which returns
+// only the http.Handler. By default, there are no timeouts.
+func WithServerTimeouts(timeouts ServerTimeouts) Option {
+	return func(g *GoFakeS3) { g.serverTimeouts = timeouts }
 }
 
 // WithLogger allows you to supply a logger to GoFakeS3 for debugging/tracing.
@@ -44,6 +83,12 @@ func WithLogger(logger Logger) Option {
 	return func(g *GoFakeS3) { g.log = logger }
 }
 
+// WithLogLevel drops log messages less severe than min, whichever Logger is
+// in use. For example, WithLogLevel(LogErr) logs only errors. See LevelLog.
+func WithLogLevel(min LogLevel) Option {
+	return func(g *GoFakeS3) { g.logLevel = min }
+}
+
 // WithGlobalLog configures gofakes3 to use GlobalLog() for logging, which uses
 // the standard library's log.Println() call to log messages.
 func WithGlobalLog() Option {
@@ -56,16 +101,287 @@ func WithRequestID(id uint64) Option {
 	return func(g *GoFakeS3) { g.requestID = id }
 }
 
+// WithRequestIDGenerator allows you to replace the function used to generate
+// the "x-amz-request-id" header, which is also reported in the RequestId
+// element of error responses. It overrides WithRequestID. The generator may be
+// called from multiple goroutines at once.
+//
+// The other values GoFakeS3 generates are already deterministic: object ETags
+// are derived from their contents, timestamps come from the TimeSource (see
+// WithTimeSource), and upload IDs are sequential. To make every response
+// byte-identical between runs, also use a FixedTimeSource, seed any
+// LatencyProfile, and use a Backend with deterministic version IDs, like
+// s3mem with s3mem.WithVersionSeed.
+func WithRequestIDGenerator(generate func() string) Option {
+	return func(g *GoFakeS3) { g.requestIDGenerator = generate }
+}
+
+// WithServerHeader replaces the value of the Server header sent with every
+// S3 response, which is DefaultServerHeader if this option is not passed. If
+// name is empty, the header is not sent. Routes added using WithRoute are not
+// affected.
+func WithServerHeader(name string) Option {
+	return func(g *GoFakeS3) { g.serverHeader = name }
+}
+
+// WithXMLNamespace allows you to replace the xmlns attribute sent on the root
+// element of XML responses. If this option is not passed, XMLNamespace is
+// used, which is the namespace real S3 responses use.
+func WithXMLNamespace(ns string) Option {
+	return func(g *GoFakeS3) { g.xmlns = ns }
+}
+
+// WithCredentials allows you to supply the secret access keys, indexed by
+// access key ID, that GoFakeS3 uses to verify the policy and signature sent
+// with a browser-based upload (a POST to a bucket). If no credentials are
+// supplied, browser-based uploads are accepted without verification.
+//
+// Other requests are not verified; their credentials are never checked.
+func WithCredentials(credentials map[string]string) Option {
+	return func(g *GoFakeS3) {
+		g.credentials = make(map[string]string, len(credentials))
+		for id, secret := range credentials {
+			g.credentials[id] = secret
+		}
+	}
+}
+
 // WithHostBucket enables or disables bucket rewriting in the router.
 // If active, the URL 'http://mybucket.localhost/object' will be routed
 // as if the URL path was '/mybucket/object'.
 //
+// Path-style requests are still accepted if the Host does not encode a
+// bucket, so 'http://localhost/mybucket/object' will be routed as before.
+// Hosts with only one label, like 'localhost', and IP addresses are treated
+// this way.
+//
 // See https://docs.aws.amazon.com/AmazonS3/latest/dev/UsingBucket.html
 // for details.
 func WithHostBucket(enabled bool) Option {
 	return func(g *GoFakeS3) { g.hostBucket = enabled }
 }
 
+// WithHostBucketBase sets the base domain used to find the bucket in the
+// Host when WithHostBucket is enabled. The bucket is everything to the left of
+// the base domain, so if the base is 's3.example.com', the URL
+// 'http://my.bucket.s3.example.com/object' will be routed as if the URL path
+// was '/my.bucket/object'. Any port in the Host is ignored.
+//
+// Requests to the base domain itself, or to hosts outside it, are routed as
+// path-style requests.
+//
+// If no base is set, the bucket is taken from the first label of the Host.
+// This option has no effect unless WithHostBucket is also enabled.
+func WithHostBucketBase(domain string) Option {
+	return func(g *GoFakeS3) {
+		g.hostBucketBase = strings.Trim(strings.ToLower(domain), ".")
+	}
+}
+
+// WithRoute allows you to serve your own handler alongside the S3 API, for
+// example to add a health check or an admin endpoint to a test fixture:
+//
+//	gofakes3.New(backend, gofakes3.WithRoute("/healthz", healthz))
+//
+// The pattern is matched by http.ServeMux. Whether it may include a method or
+// wildcards, like 'GET /healthz', depends on the Go version of your main
+// module, so check r.Method in the handler if it should only accept some
+// methods. Requests that match a route never reach the S3 API, or any of its
+// middleware: they are not rewritten by WithHostBucket, checked by
+// WithTimeSkewLimit, delayed by WithLatencyProfile or refused by SetMaintenance.
+//
+// Be careful not to use a pattern that matches S3 requests. '/healthz' is
+// also a valid path for a bucket named 'healthz', and the pattern '/' will
+// match every request.
+func WithRoute(pattern string, handler http.Handler) Option {
+	return func(g *GoFakeS3) {
+		if g.routes == nil {
+			g.routes = http.NewServeMux()
+		}
+		g.routes.Handle(pattern, handler)
+	}
+}
+
+// WithAdminAPI adds endpoints for resetting and seeding the server's state,
+// which make it easier to isolate test cases that share one GoFakeS3:
+//
+//	POST /__admin/reset  deletes every bucket, along with everything in it,
+//	                     and aborts all multipart uploads.
+//	POST /__admin/seed   creates buckets and objects from a JSON body.
+//	POST /__admin/delete-prefix?bucket=<bucket>&prefix=<prefix>
+//	                     deletes every object under the prefix, and
+//	                     responds with '{"deleted": <count>}'.
+//
+// The seed body looks like this, where only one of 'body' or 'bodyBase64'
+// should be set for each object. Missing buckets are created:
+//
+//	{
+//		"buckets": ["empty-bucket"],
+//		"objects": [
+//			{"bucket": "mybucket", "key": "hello.txt", "body": "hello",
+//			 "metadata": {"Content-Type": "text/plain"}}
+//		]
+//	}
+//
+// These endpoints are added using WithRoute, so the same caveats apply. They
+// are not authenticated; never enable this for a server that is reachable by
+// anyone you do not trust.
+func WithAdminAPI() Option {
+	return func(g *GoFakeS3) {
+		WithRoute("/__admin/reset", g.adminPost(g.adminReset))(g)
+		WithRoute("/__admin/seed", g.adminPost(g.adminSeed))(g)
+		WithRoute("/__admin/delete-prefix", g.adminPost(g.adminDeletePrefix))(g)
+	}
+}
+
+// WithOwner allows you to replace the owner reported by GoFakeS3 in its
+// responses. GoFakeS3 has only one owner, which is reported for bucket
+// listings, object listings (if requested with 'fetch-owner' when using
+// ListObjectsV2), object version listings, multipart upload listings and
+// ACLs. Requests with an 'x-amz-expected-bucket-owner' header that does not
+// match the owner ID are denied with ErrAccessDenied.
+//
+// If this option is not passed, the owner ID is
+// "fe7272ea58be830e56fe1663b10fafef" and the display name is "GoFakeS3".
+func WithOwner(id, displayName string) Option {
+	return func(g *GoFakeS3) { g.owner = UserInfo{ID: id, DisplayName: displayName} }
+}
+
+// WithPreserveMetadataCase stores the names of user metadata entries
+// ('x-amz-meta-*') with the casing they were given in, and returns them with
+// that casing, rather than canonicalizing them. S3 itself does not preserve
+// the casing, so this is only for clients that depend on it.
+//
+// Go's net/http canonicalizes the names of request headers before GoFakeS3
+// sees them, so this only preserves the casing that reaches GoFakeS3: the
+// form fields of browser-based POST uploads, and metadata stored directly in
+// the Backend.
+func WithPreserveMetadataCase() Option {
+	return func(g *GoFakeS3) { g.preserveMetadataCase = true }
+}
+
+// WithReadOnly denies every operation that would change a bucket or object,
+// like PutObject, DeleteObject, CreateBucket, the multipart upload operations
+// and the subresource 'Put' operations, with ErrAccessDenied. Reads, like
+// GetObject, HeadObject and the listing operations, proceed normally.
+//
+// This is useful for sharing a server with seeded data between tests that
+// must not modify it. The Backend can still be modified directly.
+func WithReadOnly() Option {
+	return func(g *GoFakeS3) { g.readOnly = true }
+}
+
+// WithRegion sets the region GoFakeS3 reports buckets to be in, using
+// GetBucketLocation. CreateBucket requests that ask for any other region in
+// their LocationConstraint fail with ErrInvalidLocationConstraint.
+//
+// If this option is not passed, any LocationConstraint is accepted and
+// reported back as given, and buckets created without one are reported to be
+// in us-east-1.
+func WithRegion(region string) Option {
+	return func(g *GoFakeS3) { g.region = region }
+}
+
+// WithMFA allows MFA Delete to be enabled for buckets with
+// PutBucketVersioning. While it is enabled, DeleteObject requests that
+// permanently delete a version fail with ErrAccessDenied unless they carry an
+// 'x-amz-mfa' header of the form 'serial token', where token is the value
+// currently returned by the token function. The header is also required to
+// change the versioning configuration of such a bucket, and to enable or
+// disable MFA Delete.
+//
+// If this option is not passed, attempts to enable MFA Delete are passed to
+// the Backend, which is likely to reject them with ErrNotImplemented.
+func WithMFA(serial string, token func() string) Option {
+	return func(g *GoFakeS3) {
+		g.mfaSerial = serial
+		g.mfaToken = token
+	}
+}
+
+// WithPolicyEnforcement denies requests that are made without credentials
+// (i.e. without an Authorization header or a presigned URL) with
+// ErrAccessDenied, unless the bucket policy allows them. Requests with
+// credentials are always allowed; the credentials are not checked.
+//
+// Only a small subset of the policy language is evaluated: Effect, a
+// Principal of "*", Action, and Resource, where Action and Resource may end
+// with a '*' wildcard. Only the s3:GetObject, s3:GetObjectVersion,
+// s3:PutObject, s3:DeleteObject and s3:ListBucket actions can be allowed.
+func WithPolicyEnforcement() Option {
+	return func(g *GoFakeS3) { g.policyEnforcement = true }
+}
+
+// WithResponseCompression gzips XML responses, like listings and errors, for
+// clients that send 'Accept-Encoding: gzip'. S3 itself never compresses its
+// responses; this saves bandwidth when large listings are sent over a slow
+// link. Object bodies returned by GetObject are always sent exactly as they
+// were stored.
+//
+// The Content-MD5 header added by WithResponseChecksums is not sent with
+// compressed responses.
+func WithResponseCompression() Option {
+	return func(g *GoFakeS3) { g.responseCompression = true }
+}
+
+// WithResponseChecksums adds a Content-MD5 header, computed over the exact
+// bytes of the body, to every successful XML response. S3 does not send this
+// header for most responses; the option exists to help test clients that
+// verify the integrity of what they receive.
+func WithResponseChecksums() Option {
+	return func(g *GoFakeS3) { g.responseChecksums = true }
+}
+
+// WithSelector allows you to evaluate the queries sent to SelectObjectContent.
+// GoFakeS3 does not include a query engine; if this option is not passed,
+// SelectObjectContent fails with ErrNotImplemented.
+func WithSelector(s Selector) Option {
+	return func(g *GoFakeS3) { g.selector = s }
+}
+
+// WithStrictMode allows you to reject requests with query string parameters
+// that their operation does not accept, like the misspelled 'delimeter' in
+// 'GET /bucket?delimeter=/', with ErrInvalidArgument.
+//
+// S3 itself ignores unknown parameters, so this is a testing aid to catch
+// mistakes that would otherwise go unnoticed against either S3 or GoFakeS3.
+// Requests for operations GoFakeS3 does not know about are not checked.
+func WithStrictMode() Option {
+	return func(g *GoFakeS3) { g.strictMode = true }
+}
+
+// WithStrictLimits rejects requests with a 'max-keys', 'max-uploads',
+// 'max-parts' or 'part-number-marker' that is negative or above the limit for
+// the operation, like 'max-uploads=1001', with ErrInvalidArgument.
+//
+// If this option is not passed, such values are clamped to the nearest valid
+// value. S3 itself rejects negative values but clamps ones that are too
+// large, so this is a testing aid to catch client bugs that clamping hides.
+func WithStrictLimits() Option {
+	return func(g *GoFakeS3) { g.strictLimits = true }
+}
+
+// WithStrictHeaders enables validation of the headers S3 requires on every
+// request. Requests without a Host header, or signed requests with a
+// malformed Authorization header or without a valid x-amz-date or Date
+// header, are rejected with ErrMissingSecurityHeader or ErrInvalidRequest.
+func WithStrictHeaders() Option {
+	return func(g *GoFakeS3) { g.strictHeaders = true }
+}
+
+// WithKeyTransform allows you to change the keys that GoFakeS3 uses to store
+// objects in the Backend. encode is applied to every key before it is passed
+// to the Backend, and decode must reverse it for every key the Backend
+// returns, so clients only ever see the original keys. This can be used to
+// shard keys for backends where long or similar keys are slow, like a
+// filesystem.
+//
+// Transformed keys need not preserve the order or prefixes of the original
+// keys, so listing a bucket reads every key in it from the Backend.
+func WithKeyTransform(encode, decode func(key string) string) Option {
+	return func(g *GoFakeS3) { g.keyTransform = &keyTransform{encode: encode, decode: decode} }
+}
+
 // WithoutVersioning disables versioning on the passed backend, if it supported it.
 func WithoutVersioning() Option {
 	return func(g *GoFakeS3) { g.versioned = nil }
@@ -74,9 +390,98 @@ func WithoutVersioning() Option {
 // WithUnimplementedPageError allows you to enable or disable the error that occurs
 // if the Backend does not implement paging.
 //
-// By default, GoFakeS3 will simply retry a request for a page of objects
-// without the page if the Backend does not implement pagination. This can
-// be used to enable an error in that condition instead.
+// By default, GoFakeS3 will retry a request for a page of objects without
+// the page if the Backend does not implement pagination, then select the
+// page from the full listing itself. This can be used to enable an error in
+// that condition instead.
 func WithUnimplementedPageError() Option {
 	return func(g *GoFakeS3) { g.failOnUnimplementedPage = true }
 }
+
+// WithMaxUploadParts allows you to reconfigure the highest part number that
+// may be used in a multipart upload. Part numbers start at 1, so this is also
+// the maximum number of parts an upload may contain.
+//
+// See MaxUploadPartNumber for the starting value.
+func WithMaxUploadParts(n int) Option {
+	return func(g *GoFakeS3) { g.uploader.maxPartNumber = n }
+}
+
+// WithMaxObjectSize allows you to limit the size of the objects that can be
+// uploaded, including each part of a multipart upload, the object the parts
+// are assembled into, and browser uploads. Uploads that exceed the limit
+// fail with ErrEntityTooLarge. The limit is checked against the
+// Content-Length before the body is read, and again while it is read in case
+// the client sends more than it claimed.
+//
+// There is no limit by default. Set to '0' to disable.
+func WithMaxObjectSize(bytes int64) Option {
+	return func(g *GoFakeS3) { g.maxObjectSize = bytes }
+}
+
+// WithCompactXML allows you to send XML responses without indentation, as S3
+// does. This makes large responses, like listings of buckets with many
+// objects, quite a bit smaller and faster to produce.
+//
+// Responses are indented by default, which is easier to read when debugging.
+func WithCompactXML() Option {
+	return func(g *GoFakeS3) { g.compactXML = true }
+}
+
+// WithMaxBuckets allows you to limit the number of buckets that can exist at
+// once, like the bucket quota of a real account (100 by default in S3).
+// CreateBucket fails with ErrTooManyBuckets once the limit is reached.
+//
+// The buckets are counted using Backend.ListBuckets before each bucket is
+// created, so requests that create buckets concurrently may slip past the
+// limit. There is no limit by default. Set to '0' to disable.
+func WithMaxBuckets(n int) Option {
+	return func(g *GoFakeS3) { g.maxBuckets = n }
+}
+
+// WithAutoBucket allows you to have PutObject and CreateMultipartUpload create
+// the bucket they write to if it does not exist yet, rather than failing with
+// ErrNoSuchBucket. The bucket name must still be valid, and the limit set by
+// WithMaxBuckets still applies.
+func WithAutoBucket() Option {
+	return func(g *GoFakeS3) { g.autoBucket = true }
+}
+
+// WithEventSink allows you to receive an Event whenever an object is created
+// or deleted through the S3 API, after the request has succeeded. Objects
+// written directly to the Backend do not produce events.
+//
+// If the bucket has a notification configuration (see
+// PutBucketNotificationConfiguration), only events that match its event
+// types and prefix/suffix filters are passed to the sink. Otherwise, every
+// event is.
+//
+// The sink is called synchronously, before the response is written, so it
+// must be safe for concurrent use and should not block.
+func WithEventSink(sink func(ev Event)) Option {
+	return func(g *GoFakeS3) { g.eventSink = sink }
+}
+
+// WithMinPartSize allows you to enforce a minimum size for every part but the
+// last when a multipart upload is completed. Uploads that violate this will
+// fail with ErrEntityTooSmall.
+//
+// S3 requires 5MB (see DefaultUploadPartSize), but GoFakeS3 does not enforce a
+// minimum by default so tests can use tiny parts. Set to '0' to disable.
+func WithMinPartSize(bytes int64) Option {
+	return func(g *GoFakeS3) { g.uploader.minPartSize = bytes }
+}
+
+// WithUploadTempDir allows you to store the parts of multipart uploads in
+// temporary files in dir, rather than in memory. If dir is empty, the
+// default directory for temporary files is used (see os.TempDir). Chunked
+// request bodies that are too large to hold in memory are also written to dir.
+//
+// The files are removed when the upload is completed or aborted. Uploads that
+// are never completed or aborted leave their files behind.
+func WithUploadTempDir(dir string) Option {
+	return func(g *GoFakeS3) {
+		g.uploader.spool = true
+		g.uploader.tempDir = dir
+	}
+}
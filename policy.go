@@ -0,0 +1,183 @@
+package gofakes3
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// bucketPolicy is the subset of the bucket policy language understood by
+// WithPolicyEnforcement. Elements that are not listed here, like Condition
+// or NotAction, are ignored, so a statement using them may apply more widely
+// than it would in S3.
+//
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/access-policy-language-overview.html
+type bucketPolicy struct {
+	Statement policyStatements
+}
+
+type policyStatement struct {
+	Effect    string
+	Principal policyPrincipal
+	Action    policyStrings
+	Resource  policyStrings
+}
+
+// policyStatements accepts either a single statement or a list of them, as
+// both are permitted by the policy language.
+type policyStatements []policyStatement
+
+func (ps *policyStatements) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '{' {
+		var one policyStatement
+		if err := json.Unmarshal(b, &one); err != nil {
+			return err
+		}
+		*ps = policyStatements{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]policyStatement)(ps))
+}
+
+// policyStrings accepts either a single string or a list of strings, as both
+// are permitted for most elements in the policy language.
+type policyStrings []string
+
+func (ps *policyStrings) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var one string
+		if err := json.Unmarshal(b, &one); err != nil {
+			return err
+		}
+		*ps = policyStrings{one}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(ps))
+}
+
+// policyPrincipal only records whether the principal includes everyone, which
+// can be expressed as either "*" or {"AWS": "*"}. Specific principals are
+// never matched, as GoFakeS3 does not know who is making a request.
+type policyPrincipal struct {
+	anyone bool
+}
+
+func (pp *policyPrincipal) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var one string
+		if err := json.Unmarshal(b, &one); err != nil {
+			return err
+		}
+		pp.anyone = one == "*"
+		return nil
+	}
+
+	var principals struct {
+		AWS policyStrings
+	}
+	if err := json.Unmarshal(b, &principals); err != nil {
+		return err
+	}
+	for _, p := range principals.AWS {
+		if p == "*" {
+			pp.anyone = true
+		}
+	}
+	return nil
+}
+
+func parseBucketPolicy(b []byte) (*bucketPolicy, error) {
+	var policy bucketPolicy
+	if err := json.Unmarshal(b, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// allowsAnonymous reports whether the policy allows anyone to perform the
+// action on the resource. As in S3, an explicit Deny overrides any Allow.
+func (p *bucketPolicy) allowsAnonymous(action, resource string) bool {
+	allowed := false
+	for _, stmt := range p.Statement {
+		if !stmt.Principal.anyone || !stmt.Action.matches(action, true) || !stmt.Resource.matches(resource, false) {
+			continue
+		}
+		switch stmt.Effect {
+		case "Deny":
+			return false
+		case "Allow":
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// matches reports whether any of the patterns matches the value. A pattern
+// may end with '*' to match any value with that prefix. Actions are case
+// insensitive but resources are not, hence foldCase.
+func (ps policyStrings) matches(value string, foldCase bool) bool {
+	if foldCase {
+		value = strings.ToLower(value)
+	}
+	for _, pattern := range ps {
+		if foldCase {
+			pattern = strings.ToLower(pattern)
+		}
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(value, pattern[:len(pattern)-1]) {
+				return true
+			}
+		} else if pattern == value {
+			return true
+		}
+	}
+	return false
+}
+
+// operationPolicyActions maps an Operation to the S3 action used to evaluate
+// it against a bucket policy. Only the operations that are commonly made
+// public are mapped; anything else, including every subresource, can never
+// be allowed by a policy.
+var operationPolicyActions = map[Operation]string{
+	OpCopyObject:    "s3:PutObject",
+	OpDeleteObject:  "s3:DeleteObject",
+	OpGetObject:     "s3:GetObject",
+	OpHeadBucket:    "s3:ListBucket",
+	OpHeadObject:    "s3:GetObject",
+	OpListObjects:   "s3:ListBucket",
+	OpListObjectsV2: "s3:ListBucket",
+	OpPutObject:     "s3:PutObject",
+}
+
+// policyAction maps a request to the S3 action and resource ARN used to
+// evaluate it against a bucket policy. action is empty if the request's
+// Operation is not in operationPolicyActions.
+func policyAction(bucket, object string, rq *http.Request) (action, resource string) {
+	op := requestOperation(rq)
+	action, ok := operationPolicyActions[op]
+	if !ok {
+		return "", ""
+	}
+	if object == "" {
+		return action, "arn:aws:s3:::" + bucket
+	}
+
+	if action == "s3:GetObject" && versionFromQuery(rq.URL.Query()["versionId"]) != "" {
+		action = "s3:GetObjectVersion"
+	}
+	return action, "arn:aws:s3:::" + bucket + "/" + object
+}
+
+// isAnonymousRequest reports whether the request was sent without any
+// credentials, either in the Authorization header or in a presigned URL.
+// The credentials themselves are not checked.
+func isAnonymousRequest(rq *http.Request) bool {
+	if rq.Header.Get("Authorization") != "" {
+		return false
+	}
+	query := rq.URL.Query()
+	if query.Get("X-Amz-Signature") != "" || query.Get("Signature") != "" {
+		return false
+	}
+	return true
+}
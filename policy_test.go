@@ -0,0 +1,49 @@
+package gofakes3
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBucketPolicyAllowsAnonymous(t *testing.T) {
+	const (
+		obj    = "arn:aws:s3:::bucket/public/object"
+		bucket = "arn:aws:s3:::bucket"
+	)
+
+	for idx, tc := range []struct {
+		policy   string
+		action   string
+		resource string
+		allowed  bool
+	}{
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}]}`, "s3:GetObject", obj, true},
+		{`{"Statement":{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}}`, "s3:GetObject", obj, true},
+		{`{"Statement":[{"Effect":"Allow","Principal":{"AWS":"*"},"Action":["s3:GetObject"],"Resource":["arn:aws:s3:::bucket/public/*"]}]}`, "s3:GetObject", obj, true},
+		{`{"Statement":[{"Effect":"Allow","Principal":{"AWS":["*"]},"Action":"s3:Get*","Resource":"arn:aws:s3:::bucket/*"}]}`, "s3:GetObject", obj, true},
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"S3:GETOBJECT","Resource":"arn:aws:s3:::bucket/*"}]}`, "s3:GetObject", obj, true},
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:*","Resource":"arn:aws:s3:::bucket"}]}`, "s3:ListBucket", bucket, true},
+
+		// Wrong action, resource or principal:
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}]}`, "s3:PutObject", obj, false},
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/private/*"}]}`, "s3:GetObject", obj, false},
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::BUCKET/*"}]}`, "s3:GetObject", obj, false},
+		{`{"Statement":[{"Effect":"Allow","Principal":{"AWS":"arn:aws:iam::111122223333:root"},"Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}]}`, "s3:GetObject", obj, false},
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"}]}`, "s3:ListBucket", bucket, false},
+
+		// Deny overrides allow:
+		{`{"Statement":[{"Effect":"Allow","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/*"},{"Effect":"Deny","Principal":"*","Action":"s3:GetObject","Resource":"arn:aws:s3:::bucket/public/*"}]}`, "s3:GetObject", obj, false},
+
+		{`{}`, "s3:GetObject", obj, false},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			policy, err := parseBucketPolicy([]byte(tc.policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allowed := policy.allowsAnonymous(tc.action, tc.resource); allowed != tc.allowed {
+				t.Fatal("expected allowed", tc.allowed, "found", allowed)
+			}
+		})
+	}
+}
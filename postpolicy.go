@@ -0,0 +1,226 @@
+package gofakes3
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"mime/multipart"
+	"strings"
+	"time"
+)
+
+// postPolicy is the policy document that accompanies a browser-based upload:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-HTTPPOSTConstructPolicy.html
+type postPolicy struct {
+	Expiration string            `json:"expiration"`
+	Conditions []json.RawMessage `json:"conditions"`
+}
+
+type postPolicyCondition struct {
+	// op is "eq", "starts-with" or "content-length-range".
+	op    string
+	field string
+	value string
+
+	// min and max are only used by "content-length-range":
+	min, max int64
+}
+
+// postPolicyExemptFields do not need to be covered by a condition in the
+// policy, nor do fields with an 'x-ignore-' prefix. All other form fields do.
+var postPolicyExemptFields = map[string]bool{
+	"awsaccesskeyid":  true,
+	"file":            true,
+	"policy":          true,
+	"signature":       true,
+	"x-amz-signature": true,
+}
+
+func parsePostPolicyConditions(raw []json.RawMessage) ([]postPolicyCondition, error) {
+	var conds []postPolicyCondition
+
+	for _, rc := range raw {
+		if len(rc) > 0 && rc[0] == '{' {
+			// {"field": "value"} is an exact match:
+			var exact map[string]string
+			if err := json.Unmarshal(rc, &exact); err != nil {
+				return nil, err
+			}
+			for field, value := range exact {
+				conds = append(conds, postPolicyCondition{op: "eq", field: strings.ToLower(field), value: value})
+			}
+			continue
+		}
+
+		var parts []interface{}
+		if err := json.Unmarshal(rc, &parts); err != nil {
+			return nil, err
+		}
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("condition %s must have 3 elements", rc)
+		}
+		op, _ := parts[0].(string)
+		op = strings.ToLower(op)
+
+		switch op {
+		case "eq", "starts-with":
+			field, ok1 := parts[1].(string)
+			value, ok2 := parts[2].(string)
+			if !ok1 || !ok2 || !strings.HasPrefix(field, "$") {
+				return nil, fmt.Errorf("invalid condition %s", rc)
+			}
+			conds = append(conds, postPolicyCondition{op: op, field: strings.ToLower(field[1:]), value: value})
+
+		case "content-length-range":
+			min, ok1 := parts[1].(float64)
+			max, ok2 := parts[2].(float64)
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("invalid condition %s", rc)
+			}
+			conds = append(conds, postPolicyCondition{op: op, min: int64(min), max: int64(max)})
+
+		default:
+			return nil, fmt.Errorf("unknown condition %q", op)
+		}
+	}
+
+	return conds, nil
+}
+
+// verifyPostPolicy checks the policy and signature supplied with a browser
+// upload to the bucket against the credentials configured using
+// WithCredentials. Both Signature Version 4 and Signature Version 2 are
+// supported. Any failure is reported as ErrAccessDenied, with a message like
+// the one S3 sends.
+func (g *GoFakeS3) verifyPostPolicy(bucket string, form *multipart.Form, size int64) error {
+	fields := map[string]string{}
+	for name, values := range form.Value {
+		if len(values) > 0 {
+			fields[strings.ToLower(name)] = values[0]
+		}
+	}
+
+	encoded := fields["policy"]
+	if encoded == "" {
+		return ErrorMessage(ErrAccessDenied, "Bucket POST must contain a field named 'policy'.")
+	}
+	if err := g.verifyPostPolicySignature(encoded, fields); err != nil {
+		return err
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return ErrorMessage(ErrAccessDenied, "Invalid Policy: Invalid 'Base64' encoding.")
+	}
+	var policy postPolicy
+	if err := json.Unmarshal(raw, &policy); err != nil {
+		return ErrorMessage(ErrAccessDenied, "Invalid Policy: Invalid JSON.")
+	}
+
+	expiration, err := time.Parse(time.RFC3339, policy.Expiration)
+	if err != nil {
+		return ErrorMessage(ErrAccessDenied, "Invalid Policy: Invalid 'expiration' value.")
+	}
+	if !g.timeSource.Now().Before(expiration) {
+		return ErrorMessage(ErrAccessDenied, "Invalid according to Policy: Policy expired.")
+	}
+
+	conds, err := parsePostPolicyConditions(policy.Conditions)
+	if err != nil {
+		return ErrorMessagef(ErrAccessDenied, "Invalid Policy: %v", err)
+	}
+
+	// The bucket is not a form field, but may still be the subject of a
+	// condition:
+	fields["bucket"] = bucket
+	covered := map[string]bool{}
+
+	for _, cond := range conds {
+		switch cond.op {
+		case "content-length-range":
+			if size < cond.min || size > cond.max {
+				return ErrorMessagef(ErrAccessDenied, "Invalid according to Policy: Policy Condition failed: [\"content-length-range\", %d, %d]", cond.min, cond.max)
+			}
+
+		case "eq":
+			if fields[cond.field] != cond.value {
+				return ErrorMessagef(ErrAccessDenied, "Invalid according to Policy: Policy Condition failed: [\"eq\", \"$%s\", %q]", cond.field, cond.value)
+			}
+			covered[cond.field] = true
+
+		case "starts-with":
+			if !strings.HasPrefix(fields[cond.field], cond.value) {
+				return ErrorMessagef(ErrAccessDenied, "Invalid according to Policy: Policy Condition failed: [\"starts-with\", \"$%s\", %q]", cond.field, cond.value)
+			}
+			covered[cond.field] = true
+		}
+	}
+
+	for name := range form.Value {
+		name = strings.ToLower(name)
+		if !covered[name] && !postPolicyExemptFields[name] && !strings.HasPrefix(name, "x-ignore-") {
+			return ErrorMessagef(ErrAccessDenied, "Invalid according to Policy: Extra input fields: %s", name)
+		}
+	}
+
+	return nil
+}
+
+func (g *GoFakeS3) verifyPostPolicySignature(encodedPolicy string, fields map[string]string) error {
+	var accessKey, expected, provided string
+
+	if provided = fields["x-amz-signature"]; provided != "" {
+		if alg := fields["x-amz-algorithm"]; alg != "AWS4-HMAC-SHA256" {
+			return ErrorMessagef(ErrAccessDenied, "Unsupported x-amz-algorithm %q", alg)
+		}
+
+		// The credential is in the form "<key>/<date>/<region>/<service>/aws4_request":
+		scope := strings.Split(fields["x-amz-credential"], "/")
+		if len(scope) != 5 || scope[4] != "aws4_request" {
+			return ErrorMessage(ErrAccessDenied, "Invalid x-amz-credential")
+		}
+		accessKey = scope[0]
+		secret, ok := g.credentials[accessKey]
+		if !ok {
+			return ErrorMessage(ErrAccessDenied, "The AWS Access Key Id you provided does not exist in our records.")
+		}
+		key := signingKeyV4(secret, scope[1], scope[2], scope[3])
+		expected = hex.EncodeToString(hmacSum(sha256.New, key, encodedPolicy))
+
+	} else if provided = fields["signature"]; provided != "" {
+		accessKey = fields["awsaccesskeyid"]
+		secret, ok := g.credentials[accessKey]
+		if !ok {
+			return ErrorMessage(ErrAccessDenied, "The AWS Access Key Id you provided does not exist in our records.")
+		}
+		expected = base64.StdEncoding.EncodeToString(hmacSum(sha1.New, []byte(secret), encodedPolicy))
+
+	} else {
+		return ErrorMessage(ErrAccessDenied, "Bucket POST must contain a field named 'x-amz-signature'.")
+	}
+
+	if !hmac.Equal([]byte(expected), []byte(provided)) {
+		return ErrorMessage(ErrAccessDenied, "The request signature we calculated does not match the signature you provided. Check your key and signing method.")
+	}
+	return nil
+}
+
+// signingKeyV4 derives the Signature Version 4 signing key for the scope:
+// https://docs.aws.amazon.com/general/latest/gr/sigv4-calculate-signature.html
+func signingKeyV4(secret, date, region, service string) []byte {
+	key := hmacSum(sha256.New, []byte("AWS4"+secret), date)
+	key = hmacSum(sha256.New, key, region)
+	key = hmacSum(sha256.New, key, service)
+	return hmacSum(sha256.New, key, "aws4_request")
+}
+
+func hmacSum(h func() hash.Hash, key []byte, data string) []byte {
+	mac := hmac.New(h, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -13,13 +13,23 @@ type ObjectRange struct {
 
 func (o *ObjectRange) writeHeader(sz int64, w http.ResponseWriter) {
 	if o != nil {
-		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", o.Start, o.Start+o.Length-1, sz))
+		w.Header().Set("Content-Range", o.contentRange(sz))
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", o.Length))
 	} else {
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", sz))
 	}
 }
 
+// contentRange formats the Content-Range header for the range of an object
+// of size sz. An empty range has no last byte, so it is formatted as
+// unsatisfiable ('bytes */sz') rather than with a negative end.
+func (o *ObjectRange) contentRange(sz int64) string {
+	if o.Length <= 0 {
+		return fmt.Sprintf("bytes */%d", sz)
+	}
+	return fmt.Sprintf("bytes %d-%d/%d", o.Start, o.Start+o.Length-1, sz)
+}
+
 type ObjectRangeRequest struct {
 	Start, End int64
 	FromEnd    bool
@@ -53,6 +63,10 @@ func (o *ObjectRangeRequest) Range(size int64) (*ObjectRange, error) {
 		length = size - start
 	}
 
+	// A start at or past the end of the object is unsatisfiable even if the
+	// range is open-ended (i.e. 'bytes=<size>-'); S3 responds with a 416
+	// rather than an empty body. This also means a satisfiable range is
+	// never empty:
 	if start < 0 || length < 0 || start >= size {
 		return nil, ErrInvalidRange
 	}
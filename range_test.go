@@ -2,6 +2,7 @@ package gofakes3
 
 import (
 	"fmt"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -27,6 +28,9 @@ func TestRangeRequest(t *testing.T) {
 		{fail: true, inst: 1, inend: 1, sz: 1},
 		{fail: true, inst: 10, inend: 15, sz: 10},
 		{fail: true, inst: 40, inend: 50, sz: 11},
+		{fail: true, inst: 10, inend: RangeNoEnd, sz: 10},
+		{fail: true, inst: 11, inend: RangeNoEnd, sz: 10},
+		{fail: true, inst: 0, inend: RangeNoEnd, sz: 0},
 		{fail: true, rev: true, inend: 20, sz: 10},
 		{fail: true, rev: true, inend: 11, sz: 10},
 		{fail: true, rev: true, inend: 0, sz: 10}, // zero suffix-length is not satisfiable
@@ -49,3 +53,59 @@ func TestRangeRequest(t *testing.T) {
 		})
 	}
 }
+
+func TestObjectRangeWriteHeader(t *testing.T) {
+	for idx, tc := range []struct {
+		rng    *ObjectRange
+		sz     int64
+		outrng string
+		outln  string
+	}{
+		{nil, 10, "", "10"},
+		{&ObjectRange{Start: 0, Length: 10}, 10, "bytes 0-9/10", "10"},
+		{&ObjectRange{Start: 5, Length: 1}, 10, "bytes 5-5/10", "1"},
+		{&ObjectRange{Start: 0, Length: 0}, 0, "bytes */0", "0"},
+		{&ObjectRange{Start: 10, Length: 0}, 10, "bytes */10", "0"},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			rs := httptest.NewRecorder()
+			tc.rng.writeHeader(tc.sz, rs)
+			if rng := rs.Header().Get("Content-Range"); rng != tc.outrng {
+				t.Fatalf("unexpected Content-Range %q, expected %q", rng, tc.outrng)
+			}
+			if ln := rs.Header().Get("Content-Length"); ln != tc.outln {
+				t.Fatalf("unexpected Content-Length %q, expected %q", ln, tc.outln)
+			}
+		})
+	}
+}
+
+func TestParseRangeHeaderAtEnd(t *testing.T) {
+	// An open-ended range starting at or past the end of the object parses,
+	// but can't be satisfied:
+	for idx, tc := range []struct {
+		hdr  string
+		sz   int64
+		fail bool
+	}{
+		{"bytes=1023-", 1024, false},
+		{"bytes=1024-", 1024, true},
+		{"bytes=1025-", 1024, true},
+		{"bytes=0-", 0, true},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			orr, err := parseRangeHeader(tc.hdr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rng, err := orr.Range(tc.sz)
+			if tc.fail {
+				if !HasErrorCode(err, ErrInvalidRange) {
+					t.Fatal("expected ErrInvalidRange, found", err)
+				}
+			} else if err != nil || rng.Length <= 0 {
+				t.Fatal("unexpected range", rng, err)
+			}
+		})
+	}
+}
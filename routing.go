@@ -2,11 +2,25 @@ package gofakes3
 
 import (
 	"encoding/base64"
-	"fmt"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 )
 
+// splitBucketObject splits a path-style request path into its bucket and
+// object key. Slashes after the bucket name are ignored if there is no key,
+// but a trailing slash in a key is kept, so that 'folder/' and 'folder' are
+// different objects, as they are in S3.
+func splitBucketObject(path string) (bucket, object string) {
+	parts := strings.SplitN(strings.TrimLeft(path, "/"), "/", 2)
+	bucket = parts[0]
+	if len(parts) == 2 && strings.Trim(parts[1], "/") != "" {
+		object = parts[1]
+	}
+	return bucket, object
+}
+
 // routeBase is a http.HandlerFunc that dispatches top level routes for
 // GoFakeS3.
 //
@@ -16,171 +30,438 @@ import (
 //
 // The operation for most of the core functionality is built around HTTP
 // verbs, but outside the core functionality, the clean separation starts
-// to degrade, especially around multipart uploads.
-//
+// to degrade, especially around multipart uploads. The rules live in
+// routes; requestOperation uses the same table, so the Operation seen by
+// the middleware is always the one that is dispatched here.
 func (g *GoFakeS3) routeBase(w http.ResponseWriter, r *http.Request) {
 	var (
-		path   = strings.Trim(r.URL.Path, "/")
-		parts  = strings.SplitN(path, "/", 2)
-		bucket = parts[0]
-		query  = r.URL.Query()
-		object = ""
-		err    error
+		bucket, object = splitBucketObject(r.URL.Path)
+		query          = r.URL.Query()
 	)
 
 	hdr := w.Header()
 
-	id := fmt.Sprintf("%016X", g.nextRequestID())
+	id := g.newRequestID()
 	hdr.Set("x-amz-id-2", base64.StdEncoding.EncodeToString([]byte(id+id+id+id))) // x-amz-id-2 is 48 bytes of random stuff
 	hdr.Set("x-amz-request-id", id)
-	hdr.Set("Server", "AmazonS3")
 
-	if len(parts) == 2 {
-		object = parts[1]
+	if bucket != "" {
+		if err := g.checkExpectedBucketOwner(r); err != nil {
+			g.httpError(w, r, err)
+			return
+		}
 	}
 
-	if uploadID := UploadID(query.Get("uploadId")); uploadID != "" {
-		err = g.routeMultipartUpload(bucket, object, uploadID, w, r)
+	op, err := resolveOperation(r, bucket, object, query)
+	if err == nil && op == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if err == nil {
+		err = operationHandlers[op](g, bucket, object, w, r)
+	}
+	if err != nil {
+		g.httpError(w, r, err)
+	}
+}
 
-	} else if _, ok := query["uploads"]; ok {
-		err = g.routeMultipartUploadBase(bucket, object, w, r)
+// route is an entry in routes. The first route whose match function returns
+// true is used for a request. If its operation function returns the empty
+// string, the route does not accept the request's method.
+type route struct {
+	match     func(bucket, object string, query url.Values) bool
+	operation func(r *http.Request) Operation
+}
 
-	} else if _, ok := query["versioning"]; ok {
-		err = g.routeVersioning(bucket, w, r)
+// routes holds the routing rules for GoFakeS3, in the order they are
+// checked. Requests for an unimplemented subresource are rejected after the
+// implemented subresources have been checked, but before the plain bucket
+// and object routes, so that a request like 'GET /bucket?logging' is not
+// mistaken for a plain request for the bucket.
+var routes = []route{
+	{hasSubresource("uploadId"), byMethod(map[string]Operation{
+		"GET":    OpListParts,
+		"PUT":    OpUploadPart,
+		"DELETE": OpAbortMultipartUpload,
+		"POST":   OpCompleteMultipartUpload,
+	})},
 
-	} else if _, ok := query["versions"]; ok {
-		err = g.routeVersions(bucket, w, r)
+	{hasSubresource("uploads"), byMethod(map[string]Operation{
+		"GET":  OpListMultipartUploads,
+		"POST": OpCreateMultipartUpload,
+	})},
 
-	} else if versionID := versionFromQuery(query["versionId"]); versionID != "" {
-		err = g.routeVersion(bucket, object, VersionID(versionID), w, r)
+	{hasSubresource("versioning"), byMethod(map[string]Operation{
+		"GET": OpGetBucketVersioning,
+		"PUT": OpPutBucketVersioning,
+	})},
 
-	} else if bucket != "" && object != "" {
-		err = g.routeObject(bucket, object, w, r)
+	{hasSubresource("versions"), byMethod(map[string]Operation{
+		"GET": OpListObjectVersions,
+	})},
 
-	} else if bucket != "" {
-		err = g.routeBucket(bucket, w, r)
+	{objectSubresource("tagging"), byMethod(map[string]Operation{
+		"GET":    OpGetObjectTagging,
+		"PUT":    OpPutObjectTagging,
+		"DELETE": OpDeleteObjectTagging,
+	})},
 
-	} else if r.Method == "GET" {
-		err = g.listBuckets(w, r)
+	{objectSubresource("acl"), byMethod(map[string]Operation{
+		"GET": OpGetObjectACL,
+		"PUT": OpPutObjectACL,
+	})},
 
-	} else {
-		http.NotFound(w, r)
-		return
+	{bucketSubresource("acl"), byMethod(map[string]Operation{
+		"GET": OpGetBucketACL,
+		"PUT": OpPutBucketACL,
+	})},
+
+	{bucketSubresource("policy"), byMethod(map[string]Operation{
+		"GET":    OpGetBucketPolicy,
+		"PUT":    OpPutBucketPolicy,
+		"DELETE": OpDeleteBucketPolicy,
+	})},
+
+	{bucketSubresource("cors"), byMethod(map[string]Operation{
+		"GET":    OpGetBucketCORS,
+		"PUT":    OpPutBucketCORS,
+		"DELETE": OpDeleteBucketCORS,
+	})},
+
+	{bucketSubresource("notification"), byMethod(map[string]Operation{
+		"GET": OpGetBucketNotification,
+		"PUT": OpPutBucketNotification,
+	})},
+
+	{bucketSubresource("location"), byMethod(map[string]Operation{
+		"GET": OpGetBucketLocation,
+	})},
+
+	{bucketSubresource("requestPayment"), byMethod(map[string]Operation{
+		"GET": OpGetBucketRequestPayment,
+		"PUT": OpPutBucketRequestPayment,
+	})},
+
+	{objectSubresource("attributes"), byMethod(map[string]Operation{
+		"GET": OpGetObjectAttributes,
+	})},
+
+	{objectSubresource("select"), byMethod(map[string]Operation{
+		"POST": OpSelectObjectContent,
+	})},
+
+	{
+		func(bucket, object string, query url.Values) bool {
+			sub, _ := unimplementedSubresource(bucket, object, "", query)
+			return sub != ""
+		},
+		nil, // Handled by resolveOperation.
+	},
+
+	{
+		func(bucket, object string, query url.Values) bool {
+			return versionFromQuery(query["versionId"]) != ""
+		},
+		byMethod(map[string]Operation{
+			"GET":    OpGetObject,
+			"HEAD":   OpHeadObject,
+			"DELETE": OpDeleteObject,
+		}),
+	},
+
+	{
+		func(bucket, object string, query url.Values) bool {
+			return bucket != "" && object != ""
+		},
+		func(r *http.Request) Operation {
+			if _, ok := r.Header["X-Amz-Copy-Source"]; ok && r.Method == "PUT" {
+				return OpCopyObject
+			}
+			return byMethod(map[string]Operation{
+				"GET":    OpGetObject,
+				"HEAD":   OpHeadObject,
+				"PUT":    OpPutObject,
+				"DELETE": OpDeleteObject,
+			})(r)
+		},
+	},
+
+	{
+		func(bucket, object string, query url.Values) bool {
+			return bucket != ""
+		},
+		func(r *http.Request) Operation {
+			query := r.URL.Query()
+			if r.Method == "GET" && query.Get("list-type") == "2" {
+				return OpListObjectsV2
+			}
+			if _, ok := query["delete"]; ok && r.Method == "POST" {
+				return OpDeleteObjects
+			}
+			return byMethod(map[string]Operation{
+				"GET":    OpListObjects,
+				"PUT":    OpCreateBucket,
+				"DELETE": OpDeleteBucket,
+				"HEAD":   OpHeadBucket,
+				"POST":   OpPostObject,
+			})(r)
+		},
+	},
+}
+
+// resolveOperation works out which Operation a request is for, using routes.
+// If no route matches the request, the empty string and a nil error are
+// returned. The request URL must already be in path-style form (i.e. after
+// hostBucketMiddleware has run).
+func resolveOperation(r *http.Request, bucket, object string, query url.Values) (Operation, error) {
+	for _, rt := range routes {
+		if !rt.match(bucket, object, query) {
+			continue
+		}
+		if rt.operation == nil {
+			sub, op := unimplementedSubresource(bucket, object, r.Method, query)
+			return "", ErrorMessagef(ErrNotImplemented, "%s (the '%s' subresource) is not implemented", op, sub)
+		}
+		if op := rt.operation(r); op != "" {
+			return op, nil
+		}
+		return "", ErrMethodNotAllowed
 	}
 
-	if err != nil {
-		g.httpError(w, r, err)
+	if bucket == "" && r.Method == "GET" {
+		return OpListBuckets, nil
 	}
+	return "", nil
 }
 
-// routeObject oandles URLs that contain both a bucket path segment and an
-// object path segment.
-func (g *GoFakeS3) routeObject(bucket, object string, w http.ResponseWriter, r *http.Request) (err error) {
-	switch r.Method {
-	case "GET":
-		return g.getObject(bucket, object, "", w, r)
-	case "HEAD":
-		return g.headObject(bucket, object, "", w, r)
-	case "PUT":
-		return g.createObject(bucket, object, w, r)
-	case "DELETE":
-		return g.deleteObject(bucket, object, w, r)
-	default:
-		return ErrMethodNotAllowed
+func byMethod(ops map[string]Operation) func(r *http.Request) Operation {
+	return func(r *http.Request) Operation {
+		return ops[r.Method]
 	}
 }
 
-// routeBucket handles URLs that contain only a bucket path segment, not an
-// object path segment.
-func (g *GoFakeS3) routeBucket(bucket string, w http.ResponseWriter, r *http.Request) (err error) {
-	switch r.Method {
-	case "GET":
-		return g.listBucket(bucket, w, r)
-	case "PUT":
-		return g.createBucket(bucket, w, r)
-	case "DELETE":
-		return g.deleteBucket(bucket, w, r)
-	case "HEAD":
-		return g.headBucket(bucket, w, r)
-	case "POST":
-		if _, ok := r.URL.Query()["delete"]; ok {
-			return g.deleteMulti(bucket, w, r)
-		} else {
-			return g.createObjectBrowserUpload(bucket, w, r)
-		}
-	default:
-		return ErrMethodNotAllowed
+// hasSubresource matches routes that contain the subresource in the query
+// string. These routes may or may not have a value for bucket or object;
+// this is validated and handled in the target handler functions.
+func hasSubresource(key string) func(bucket, object string, query url.Values) bool {
+	return func(bucket, object string, query url.Values) bool {
+		_, ok := query[key]
+		return ok
 	}
 }
 
-// routeMultipartUploadBase operates on routes that contain '?uploads' in the
-// query string. These routes may or may not have a value for bucket or object;
-// this is validated and handled in the target handler functions.
-func (g *GoFakeS3) routeMultipartUploadBase(bucket, object string, w http.ResponseWriter, r *http.Request) error {
-	switch r.Method {
-	case "GET":
-		return g.listMultipartUploads(bucket, w, r)
-	case "POST":
-		return g.initiateMultipartUpload(bucket, object, w, r)
-	default:
-		return ErrMethodNotAllowed
+// bucketSubresource matches routes that contain the subresource in the query
+// string and refer to a bucket.
+func bucketSubresource(key string) func(bucket, object string, query url.Values) bool {
+	return func(bucket, object string, query url.Values) bool {
+		_, ok := query[key]
+		return ok && bucket != "" && object == ""
 	}
 }
 
-// routeVersioningBase operates on routes that contain '?versioning' in the
-// query string. These routes may or may not have a value for bucket; this is
-// validated and handled in the target handler functions.
-func (g *GoFakeS3) routeVersioning(bucket string, w http.ResponseWriter, r *http.Request) error {
-	switch r.Method {
-	case "GET":
-		return g.getBucketVersioning(bucket, w, r)
-	case "PUT":
-		return g.putBucketVersioning(bucket, w, r)
-	default:
-		return ErrMethodNotAllowed
+// objectSubresource matches routes that contain the subresource in the query
+// string and refer to an object.
+func objectSubresource(key string) func(bucket, object string, query url.Values) bool {
+	return func(bucket, object string, query url.Values) bool {
+		_, ok := query[key]
+		return ok && object != ""
 	}
 }
 
-// routeVersions operates on routes that contain '?versions' in the query string.
-func (g *GoFakeS3) routeVersions(bucket string, w http.ResponseWriter, r *http.Request) error {
-	switch r.Method {
-	case "GET":
+// operationHandlers dispatches each Operation returned by resolveOperation.
+var operationHandlers = map[Operation]func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error{
+	OpAbortMultipartUpload: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.abortMultipartUpload(bucket, object, UploadID(r.URL.Query().Get("uploadId")), w, r)
+	},
+	OpCompleteMultipartUpload: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.completeMultipartUpload(bucket, object, UploadID(r.URL.Query().Get("uploadId")), w, r)
+	},
+	OpCopyObject: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.copyObject(bucket, object, w, r)
+	},
+	OpCreateBucket: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.createBucket(bucket, w, r)
+	},
+	OpCreateMultipartUpload: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.initiateMultipartUpload(bucket, object, w, r)
+	},
+	OpDeleteBucket: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.deleteBucket(bucket, w, r)
+	},
+	OpDeleteBucketCORS: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.deleteBucketCORS(bucket, w, r)
+	},
+	OpDeleteBucketPolicy: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.deleteBucketPolicy(bucket, w, r)
+	},
+	OpDeleteObject: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		if versionID := versionFromQuery(r.URL.Query()["versionId"]); versionID != "" {
+			return g.deleteObjectVersion(bucket, object, VersionID(versionID), w, r)
+		}
+		return g.deleteObject(bucket, object, w, r)
+	},
+	OpDeleteObjectTagging: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.deleteObjectTagging(bucket, object, w, r)
+	},
+	OpDeleteObjects: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.deleteMulti(bucket, w, r)
+	},
+	OpGetBucketACL: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketACL(bucket, w, r)
+	},
+	OpGetBucketCORS: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketCORS(bucket, w, r)
+	},
+	OpGetBucketLocation: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketLocation(bucket, w, r)
+	},
+	OpGetBucketNotification: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketNotification(bucket, w, r)
+	},
+	OpGetBucketPolicy: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketPolicy(bucket, w, r)
+	},
+	OpGetBucketRequestPayment: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketRequestPayment(bucket, w, r)
+	},
+	OpGetBucketVersioning: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getBucketVersioning(bucket, w, r)
+	},
+	OpGetObject: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getObject(bucket, object, VersionID(versionFromQuery(r.URL.Query()["versionId"])), w, r)
+	},
+	OpGetObjectACL: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getObjectACL(bucket, object, w, r)
+	},
+	OpGetObjectAttributes: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getObjectAttributes(bucket, object, VersionID(versionFromQuery(r.URL.Query()["versionId"])), w, r)
+	},
+	OpGetObjectTagging: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.getObjectTagging(bucket, object, w, r)
+	},
+	OpHeadBucket: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.headBucket(bucket, w, r)
+	},
+	OpHeadObject: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.headObject(bucket, object, VersionID(versionFromQuery(r.URL.Query()["versionId"])), w, r)
+	},
+	OpListBuckets: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.listBuckets(w, r)
+	},
+	OpListMultipartUploads: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.listMultipartUploads(bucket, w, r)
+	},
+	OpListObjectVersions: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
 		return g.listBucketVersions(bucket, w, r)
-	default:
-		return ErrMethodNotAllowed
-	}
+	},
+	OpListObjects: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.listBucket(bucket, w, r)
+	},
+	OpListObjectsV2: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.listBucket(bucket, w, r)
+	},
+	OpListParts: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.listMultipartUploadParts(bucket, object, UploadID(r.URL.Query().Get("uploadId")), w, r)
+	},
+	OpPostObject: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.createObjectBrowserUpload(bucket, w, r)
+	},
+	OpPutBucketACL: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putBucketACL(bucket, w, r)
+	},
+	OpPutBucketCORS: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putBucketCORS(bucket, w, r)
+	},
+	OpPutBucketNotification: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putBucketNotification(bucket, w, r)
+	},
+	OpPutBucketPolicy: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putBucketPolicy(bucket, w, r)
+	},
+	OpPutBucketRequestPayment: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putBucketRequestPayment(bucket, w, r)
+	},
+	OpPutBucketVersioning: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putBucketVersioning(bucket, w, r)
+	},
+	OpPutObject: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.createObject(bucket, object, w, r)
+	},
+	OpPutObjectACL: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putObjectACL(bucket, object, w, r)
+	},
+	OpPutObjectTagging: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putObjectTagging(bucket, object, w, r)
+	},
+	OpSelectObjectContent: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.selectObject(bucket, object, w, r)
+	},
+	OpUploadPart: func(g *GoFakeS3, bucket, object string, w http.ResponseWriter, r *http.Request) error {
+		return g.putMultipartUploadPart(bucket, object, UploadID(r.URL.Query().Get("uploadId")), w, r)
+	},
 }
 
-// routeVersion operates on routes that contain '?versionId=<id>' in the
-// query string.
-func (g *GoFakeS3) routeVersion(bucket, object string, versionID VersionID, w http.ResponseWriter, r *http.Request) error {
-	switch r.Method {
-	case "GET":
-		return g.getObject(bucket, object, versionID, w, r)
-	case "HEAD":
-		return g.headObject(bucket, object, versionID, w, r)
-	case "DELETE":
-		return g.deleteObjectVersion(bucket, object, versionID, w, r)
-	default:
-		return ErrMethodNotAllowed
-	}
+// unimplementedBucketSubresources and unimplementedObjectSubresources list the
+// subresources of the S3 API that GoFakeS3 knows about but does not implement,
+// along with the name of the operations they belong to, less the "Get", "Put"
+// or "Delete". Without these, a request like 'GET /bucket?logging' would be
+// mistaken for a plain request for the bucket or object.
+var unimplementedBucketSubresources = map[string]string{
+	"accelerate":          "BucketAccelerateConfiguration",
+	"analytics":           "BucketAnalyticsConfiguration",
+	"encryption":          "BucketEncryption",
+	"intelligent-tiering": "BucketIntelligentTieringConfiguration",
+	"inventory":           "BucketInventoryConfiguration",
+	"lifecycle":           "BucketLifecycleConfiguration",
+	"logging":             "BucketLogging",
+	"metrics":             "BucketMetricsConfiguration",
+	"object-lock":         "ObjectLockConfiguration",
+	"ownershipControls":   "BucketOwnershipControls",
+	"policyStatus":        "BucketPolicyStatus",
+	"publicAccessBlock":   "PublicAccessBlock",
+	"replication":         "BucketReplication",
+	"tagging":             "BucketTagging",
+	"website":             "BucketWebsite",
 }
 
-// routeMultipartUpload operates on routes that contain '?uploadId=<id>' in the
-// query string.
-func (g *GoFakeS3) routeMultipartUpload(bucket, object string, uploadID UploadID, w http.ResponseWriter, r *http.Request) error {
-	switch r.Method {
-	case "GET":
-		return g.listMultipartUploadParts(bucket, object, uploadID, w, r)
-	case "PUT":
-		return g.putMultipartUploadPart(bucket, object, uploadID, w, r)
-	case "DELETE":
-		return g.abortMultipartUpload(bucket, object, uploadID, w, r)
-	case "POST":
-		return g.completeMultipartUpload(bucket, object, uploadID, w, r)
-	default:
-		return ErrMethodNotAllowed
+var unimplementedObjectSubresources = map[string]string{
+	"legal-hold": "ObjectLegalHold",
+	"retention":  "ObjectRetention",
+	"torrent":    "ObjectTorrent",
+}
+
+// unimplementedSubresource returns the first unimplemented subresource found
+// in the query (in alphabetical order), and the name of the operation the
+// request was for. If there isn't one, sub is empty.
+func unimplementedSubresource(bucket, object, method string, query url.Values) (sub, op string) {
+	if bucket == "" {
+		return "", ""
+	}
+	subresources := unimplementedBucketSubresources
+	if object != "" {
+		subresources = unimplementedObjectSubresources
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if name, ok := subresources[key]; ok {
+			switch method {
+			case "GET", "HEAD":
+				name = "Get" + name
+			case "PUT":
+				name = "Put" + name
+			case "DELETE":
+				name = "Delete" + name
+			}
+			return key, name
+		}
 	}
+	return "", ""
 }
 
 func versionFromQuery(qv []string) string {
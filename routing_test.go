@@ -1,7 +1,11 @@
 package gofakes3_test
 
 import (
+	"encoding/xml"
+	"net/http"
 	"testing"
+
+	"github.com/johannesboyne/gofakes3"
 )
 
 func TestRoutingSlashes(t *testing.T) {
@@ -27,6 +31,56 @@ func TestRoutingSlashes(t *testing.T) {
 	assertStatus("test//", 200) // don't care how many slashes
 	assertStatus("test/nope", 404)
 	assertStatus("test/obj", 200)
-	assertStatus("test/obj/", 200)
-	assertStatus("test/obj//", 200)
+
+	// A trailing slash is part of the key, as it is in S3:
+	assertStatus("test/obj/", 404)
+	assertStatus("test/obj//", 404)
+	ts.backendPutString("test", "folder/", nil, "")
+	assertStatus("test/folder/", 200)
+	assertStatus("test/folder", 404)
+}
+
+func TestRoutingUnimplementedSubresource(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	ts.backendPutString(defaultBucket, "obj", nil, "yep")
+
+	for _, tc := range []struct {
+		method  string
+		url     string
+		message string
+	}{
+		{"GET", "?logging", "GetBucketLogging (the 'logging' subresource) is not implemented"},
+		{"PUT", "?replication", "PutBucketReplication (the 'replication' subresource) is not implemented"},
+		{"DELETE", "?lifecycle", "DeleteBucketLifecycleConfiguration (the 'lifecycle' subresource) is not implemented"},
+		{"GET", "?tagging", "GetBucketTagging (the 'tagging' subresource) is not implemented"},
+		{"GET", "/obj?retention", "GetObjectRetention (the 'retention' subresource) is not implemented"},
+		{"PUT", "/obj?legal-hold&versionId=1", "PutObjectLegalHold (the 'legal-hold' subresource) is not implemented"},
+	} {
+		t.Run(tc.method+" "+tc.url, func(t *testing.T) {
+			rq, err := http.NewRequest(tc.method, ts.url("/"+defaultBucket+tc.url), nil)
+			ts.OK(err)
+			rs, err := httpClient().Do(rq)
+			ts.OK(err)
+			defer rs.Body.Close()
+
+			if rs.StatusCode != http.StatusNotImplemented {
+				t.Fatal("expected status", http.StatusNotImplemented, "found", rs.StatusCode)
+			}
+			var errResp gofakes3.ErrorResponse
+			ts.OK(xml.NewDecoder(rs.Body).Decode(&errResp))
+			if errResp.Code != gofakes3.ErrNotImplemented || errResp.Message != tc.message {
+				t.Fatal("unexpected error", errResp.Code, errResp.Message)
+			}
+		})
+	}
+
+	// Subresources are only unimplemented where they are expected; an object
+	// can be called 'logging' or have a 'logging' query parameter:
+	rs, err := httpClient().Get(ts.url("/" + defaultBucket + "/obj?logging"))
+	ts.OK(err)
+	rs.Body.Close()
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("unexpected status", rs.StatusCode)
+	}
 }
@@ -0,0 +1,185 @@
+package gofakes3
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Selector evaluates S3 Select queries for the SelectObjectContent
+// operation. GoFakeS3 does not include a query engine of its own; it handles
+// the request, the object and the response framing, and leaves the query to
+// the Selector.
+type Selector interface {
+	// Select runs the query described by rq over the object's contents and
+	// writes the results to out. They are sent to the client in the format
+	// requested in rq.OutputSerialization.
+	//
+	// from is always an uncompressed reader; GoFakeS3 decompresses the object
+	// according to rq.InputSerialization.CompressionType before calling
+	// Select.
+	//
+	// If Select returns an error before writing anything, it is sent to the
+	// client as a normal error response. Once results have been written, the
+	// error is sent as an error event in the response stream.
+	//
+	// A Selector that does not support the requested input or output format
+	// should return ErrNotImplemented, or an ErrorMessage with that code,
+	// which is sent as '501 Not Implemented'.
+	Select(rq *SelectObjectContentRequest, from io.Reader, out io.Writer) error
+}
+
+// validateSelectInput checks that exactly one input format was given, so
+// that a Selector only has to check which.
+func validateSelectInput(in SelectInput) error {
+	formats := 0
+	for _, set := range []bool{in.CSV != nil, in.JSON != nil, in.Parquet != nil} {
+		if set {
+			formats++
+		}
+	}
+	if formats != 1 {
+		return ErrInvalidDataSource
+	}
+	return nil
+}
+
+// selectInputReader decompresses the object, if necessary, for a Selector.
+func selectInputReader(rdr io.Reader, compression string) (io.Reader, error) {
+	switch strings.ToUpper(compression) {
+	case "", "NONE":
+		return rdr, nil
+	case "GZIP":
+		gz, err := gzip.NewReader(rdr)
+		if err != nil {
+			return nil, ErrorMessage(ErrInvalidCompressionFormat, "GZIP is not applicable to the queried object. Please correct the request and try again.")
+		}
+		return gz, nil
+	case "BZIP2":
+		return bzip2.NewReader(rdr), nil
+	default:
+		return nil, ErrorInvalidArgument("CompressionType", compression, "Invalid compression format")
+	}
+}
+
+// countingReader counts the bytes read through it; it is used to compute the
+// SelectStats of a query.
+type countingReader struct {
+	inner io.Reader
+	n     int64
+}
+
+func (cr *countingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.inner.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// selectRecordsWriter sends each chunk written by a Selector to the client
+// as a Records event, flushing it immediately so results are streamed.
+//
+// The response headers are not sent until the Selector writes something, so
+// that an error it returns up front can still be reported the normal way.
+type selectRecordsWriter struct {
+	w       http.ResponseWriter
+	events  *eventStreamWriter
+	started bool
+	written int64
+}
+
+func (sw *selectRecordsWriter) start() {
+	if !sw.started {
+		sw.w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		sw.w.WriteHeader(http.StatusOK)
+		sw.started = true
+	}
+}
+
+func (sw *selectRecordsWriter) Write(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	sw.start()
+	if err := sw.events.Records(p); err != nil {
+		return 0, err
+	}
+	if flusher, ok := sw.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	sw.written += int64(len(p))
+	return len(p), nil
+}
+
+// selectObject handles the SelectObjectContent operation.
+func (g *GoFakeS3) selectObject(bucket, object string, w http.ResponseWriter, r *http.Request) error {
+	g.log.Print(LogInfo, "SELECT OBJECT:", bucket, object)
+
+	if g.selector == nil {
+		return ErrorMessage(ErrNotImplemented, "SelectObjectContent is not implemented; a Selector must be set using WithSelector")
+	}
+
+	if r.URL.Query().Get("select-type") != "2" {
+		return ErrorInvalidArgument("select-type", r.URL.Query().Get("select-type"), "Invalid select-type")
+	}
+
+	var in SelectObjectContentRequest
+	if err := g.xmlDecodeBody(r.Body, &in); err != nil {
+		return err
+	}
+	if in.ExpressionType != "SQL" {
+		return ErrorMessagef(ErrInvalidExpressionType, "The ExpressionType %q is invalid. Only SQL expressions are supported.", in.ExpressionType)
+	}
+	if in.Expression == "" {
+		return ErrorMessage(ErrInvalidRequest, "The Expression is required.")
+	}
+	if err := validateSelectInput(in.InputSerialization); err != nil {
+		return err
+	}
+
+	obj, err := g.storage.GetObject(bucket, object, nil)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		g.log.Print(LogErr, "unexpected nil object for key", bucket, object)
+		return ErrInternal
+	}
+	defer obj.Contents.Close()
+
+	scanned := &countingReader{inner: obj.Contents}
+	decompressed, err := selectInputReader(scanned, in.InputSerialization.CompressionType)
+	if err != nil {
+		return err
+	}
+	processed := &countingReader{inner: decompressed}
+
+	events := newEventStreamWriter(w)
+	out := &selectRecordsWriter{w: w, events: events}
+
+	err = g.selector.Select(&in, processed, out)
+	if err != nil && !out.started {
+		return err
+	}
+	out.start()
+
+	if err != nil {
+		g.log.Print(LogErr, "select failed after the response started:", err)
+		resp := ensureErrorResponse(err, "")
+		message := resp.ErrorCode().Message()
+		if er, ok := resp.(*ErrorResponse); ok && er.Message != "" {
+			message = er.Message
+		}
+		return events.Error(resp.ErrorCode(), message)
+	}
+
+	if err := events.Stats(SelectStats{
+		BytesScanned:   scanned.n,
+		BytesProcessed: processed.n,
+		BytesReturned:  out.written,
+	}); err != nil {
+		return err
+	}
+	return events.End()
+}
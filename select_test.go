@@ -0,0 +1,256 @@
+package gofakes3
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testEventMessage struct {
+	headers map[string]string
+	payload []byte
+}
+
+func decodeTestEventStream(t *testing.T, data []byte) (msgs []testEventMessage) {
+	t.Helper()
+
+	for len(data) > 0 {
+		if len(data) < 16 {
+			t.Fatal("short message")
+		}
+		total := binary.BigEndian.Uint32(data[0:4])
+		hdrLen := binary.BigEndian.Uint32(data[4:8])
+		if crc32.ChecksumIEEE(data[0:8]) != binary.BigEndian.Uint32(data[8:12]) {
+			t.Fatal("prelude CRC mismatch")
+		}
+		msg := data[:total]
+		if crc32.ChecksumIEEE(msg[:total-4]) != binary.BigEndian.Uint32(msg[total-4:]) {
+			t.Fatal("message CRC mismatch")
+		}
+
+		headers := map[string]string{}
+		hdrs := msg[12 : 12+hdrLen]
+		for len(hdrs) > 0 {
+			nameLen := int(hdrs[0])
+			name := string(hdrs[1 : 1+nameLen])
+			if hdrs[1+nameLen] != eventStreamStringType {
+				t.Fatal("unexpected header type", hdrs[1+nameLen])
+			}
+			valueLen := int(binary.BigEndian.Uint16(hdrs[2+nameLen:]))
+			headers[name] = string(hdrs[4+nameLen : 4+nameLen+valueLen])
+			hdrs = hdrs[4+nameLen+valueLen:]
+		}
+
+		msgs = append(msgs, testEventMessage{headers, msg[12+hdrLen : total-4]})
+		data = data[total:]
+	}
+	return msgs
+}
+
+func TestEventStreamWriter(t *testing.T) {
+	var buf bytes.Buffer
+	es := newEventStreamWriter(&buf)
+	TT{t}.OKAll(
+		es.Records([]byte("a,b\n")),
+		es.Stats(SelectStats{BytesScanned: 1, BytesProcessed: 2, BytesReturned: 3}),
+		es.End(),
+		es.Error(ErrInternal, "oops"),
+	)
+
+	msgs := decodeTestEventStream(t, buf.Bytes())
+	if len(msgs) != 4 {
+		t.Fatal("unexpected message count", len(msgs))
+	}
+	if msgs[0].headers[":event-type"] != "Records" || string(msgs[0].payload) != "a,b\n" {
+		t.Fatal("unexpected records message", msgs[0])
+	}
+	if msgs[1].headers[":event-type"] != "Stats" || !strings.Contains(string(msgs[1].payload), "<BytesReturned>3</BytesReturned>") {
+		t.Fatal("unexpected stats message", msgs[1])
+	}
+	if msgs[2].headers[":event-type"] != "End" || len(msgs[2].payload) != 0 {
+		t.Fatal("unexpected end message", msgs[2])
+	}
+	if msgs[3].headers[":message-type"] != "error" || msgs[3].headers[":error-code"] != "InternalError" {
+		t.Fatal("unexpected error message", msgs[3])
+	}
+}
+
+type selectTestBackend struct {
+	Backend
+	contents string
+}
+
+func (b *selectTestBackend) GetObject(bucketName, objectName string, rangeRequest *ObjectRangeRequest) (*Object, error) {
+	return &Object{
+		Name:     objectName,
+		Size:     int64(len(b.contents)),
+		Contents: ioutil.NopCloser(strings.NewReader(b.contents)),
+	}, nil
+}
+
+// upperSelector returns the object in upper case, in two chunks.
+type upperSelector struct{ err error }
+
+func (s *upperSelector) Select(rq *SelectObjectContentRequest, from io.Reader, out io.Writer) error {
+	data, err := ioutil.ReadAll(from)
+	if err != nil {
+		return err
+	}
+	if s.err != nil && len(data) == 0 {
+		return s.err
+	}
+	upper := strings.ToUpper(string(data))
+	half := len(upper) / 2
+	io.WriteString(out, upper[:half])
+	io.WriteString(out, upper[half:])
+	return s.err
+}
+
+// csvOnlySelector is like upperSelector, but only supports CSV input.
+type csvOnlySelector struct{ upperSelector }
+
+func (s *csvOnlySelector) Select(rq *SelectObjectContentRequest, from io.Reader, out io.Writer) error {
+	if rq.InputSerialization.CSV == nil {
+		return ErrorMessage(ErrNotImplemented, "Only CSV input is supported")
+	}
+	return s.upperSelector.Select(rq, from, out)
+}
+
+func TestSelectObject(t *testing.T) {
+	const body = `<SelectObjectContentRequest>` +
+		`<Expression>SELECT * FROM S3Object</Expression>` +
+		`<ExpressionType>SQL</ExpressionType>` +
+		`<InputSerialization><CSV/></InputSerialization>` +
+		`<OutputSerialization><CSV/></OutputSerialization>` +
+		`</SelectObjectContentRequest>`
+
+	serve := func(g *GoFakeS3, url, body string) *httptest.ResponseRecorder {
+		rq := httptest.NewRequest("POST", url, strings.NewReader(body))
+		rs := httptest.NewRecorder()
+		g.routeBase(rs, rq)
+		return rs
+	}
+
+	newSelectGoFakeS3 := func(contents string, selector Selector) *GoFakeS3 {
+		return &GoFakeS3{
+			storage:  &selectTestBackend{contents: contents},
+			selector: selector,
+			log:      DiscardLog(),
+		}
+	}
+
+	t.Run("records", func(t *testing.T) {
+		g := newSelectGoFakeS3("a,b\nc,d\n", &upperSelector{})
+		rs := serve(g, "/bucket/object?select&select-type=2", body)
+		if rs.Code != 200 {
+			t.Fatal("unexpected status", rs.Code, rs.Body.String())
+		}
+		if ct := rs.Header().Get("Content-Type"); ct != "application/vnd.amazon.eventstream" {
+			t.Fatal("unexpected content type", ct)
+		}
+
+		msgs := decodeTestEventStream(t, rs.Body.Bytes())
+		var records []byte
+		var types []string
+		for _, msg := range msgs {
+			types = append(types, msg.headers[":event-type"])
+			if msg.headers[":event-type"] == "Records" {
+				records = append(records, msg.payload...)
+			}
+		}
+		if strings.Join(types, ",") != "Records,Records,Stats,End" {
+			t.Fatal("unexpected events", types)
+		}
+		if string(records) != "A,B\nC,D\n" {
+			t.Fatal("unexpected records", string(records))
+		}
+		if !strings.Contains(string(msgs[2].payload), "<BytesScanned>8</BytesScanned>") {
+			t.Fatal("unexpected stats", string(msgs[2].payload))
+		}
+	})
+
+	t.Run("not-implemented", func(t *testing.T) {
+		g := newSelectGoFakeS3("a,b\n", nil)
+		rs := serve(g, "/bucket/object?select&select-type=2", body)
+		if rs.Code != 501 {
+			t.Fatal("unexpected status", rs.Code)
+		}
+		var errResp ErrorResponse
+		if err := xml.Unmarshal(rs.Body.Bytes(), &errResp); err != nil {
+			t.Fatal(err)
+		}
+		if errResp.Code != ErrNotImplemented || !strings.Contains(errResp.Message, "WithSelector") {
+			t.Fatal("unexpected error", errResp.Code, errResp.Message)
+		}
+	})
+
+	t.Run("unsupported-format", func(t *testing.T) {
+		g := newSelectGoFakeS3("PAR1", &csvOnlySelector{})
+		rs := serve(g, "/bucket/object?select&select-type=2", strings.Replace(body, "<InputSerialization><CSV/>", "<InputSerialization><Parquet/>", 1))
+		if rs.Code != 501 || !strings.Contains(rs.Body.String(), "Only CSV input is supported") {
+			t.Fatal("unexpected response", rs.Code, rs.Body.String())
+		}
+
+		// The same Selector still handles CSV:
+		rs = serve(g, "/bucket/object?select&select-type=2", body)
+		if rs.Code != 200 {
+			t.Fatal("unexpected status", rs.Code, rs.Body.String())
+		}
+	})
+
+	t.Run("invalid-data-source", func(t *testing.T) {
+		for _, input := range []string{
+			"<InputSerialization></InputSerialization>",
+			"<InputSerialization><CSV/><JSON/></InputSerialization>",
+		} {
+			g := newSelectGoFakeS3("a,b\n", &upperSelector{})
+			rs := serve(g, "/bucket/object?select&select-type=2", strings.Replace(body, "<InputSerialization><CSV/></InputSerialization>", input, 1))
+			if rs.Code != 400 || !strings.Contains(rs.Body.String(), string(ErrInvalidDataSource)) {
+				t.Fatal("unexpected response", input, rs.Code, rs.Body.String())
+			}
+		}
+	})
+
+	t.Run("bad-expression-type", func(t *testing.T) {
+		g := newSelectGoFakeS3("a,b\n", &upperSelector{})
+		rs := serve(g, "/bucket/object?select&select-type=2", strings.Replace(body, ">SQL<", ">XPATH<", 1))
+		if rs.Code != 400 || !strings.Contains(rs.Body.String(), string(ErrInvalidExpressionType)) {
+			t.Fatal("unexpected response", rs.Code, rs.Body.String())
+		}
+	})
+
+	t.Run("bad-gzip", func(t *testing.T) {
+		g := newSelectGoFakeS3("a,b\n", &upperSelector{})
+		rs := serve(g, "/bucket/object?select&select-type=2", strings.Replace(body, "<CSV/></InputSerialization>", "<CSV/><CompressionType>GZIP</CompressionType></InputSerialization>", 1))
+		if rs.Code != 400 || !strings.Contains(rs.Body.String(), string(ErrInvalidCompressionFormat)) {
+			t.Fatal("unexpected response", rs.Code, rs.Body.String())
+		}
+	})
+
+	t.Run("error-before-records", func(t *testing.T) {
+		g := newSelectGoFakeS3("", &upperSelector{err: ErrorMessage(ErrInvalidRequest, "nope")})
+		rs := serve(g, "/bucket/object?select&select-type=2", body)
+		if rs.Code != 400 {
+			t.Fatal("unexpected status", rs.Code)
+		}
+	})
+
+	t.Run("error-after-records", func(t *testing.T) {
+		g := newSelectGoFakeS3("a,b\n", &upperSelector{err: ErrorMessage(ErrInvalidRequest, "nope")})
+		rs := serve(g, "/bucket/object?select&select-type=2", body)
+		if rs.Code != 200 {
+			t.Fatal("unexpected status", rs.Code)
+		}
+		msgs := decodeTestEventStream(t, rs.Body.Bytes())
+		last := msgs[len(msgs)-1]
+		if last.headers[":message-type"] != "error" || last.headers[":error-code"] != "InvalidRequest" || last.headers[":error-message"] != "nope" {
+			t.Fatal("unexpected final message", last.headers)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package gofakes3
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// SelectCSVWriter writes records in the CSV format requested in a
+// SelectObjectContentRequest's OutputSerialization. It is intended for use by
+// Selector implementations:
+//
+//	func (s *mySelector) Select(rq *gofakes3.SelectObjectContentRequest, from io.Reader, out io.Writer) error {
+//		cw := gofakes3.NewSelectCSVWriter(out, rq.OutputSerialization.CSV)
+//		for _, record := range s.query(rq, from) {
+//			if err := cw.Write(record); err != nil {
+//				return err
+//			}
+//		}
+//		return cw.Flush()
+//	}
+//
+// Unlike encoding/csv, any field or record delimiter may be used, and fields
+// may be quoted always rather than only when necessary. The output is
+// buffered, so Flush must be called once all records have been written.
+type SelectCSVWriter struct {
+	w *bufio.Writer
+
+	fieldDelimiter  string
+	recordDelimiter string
+	quote           string
+	escape          string
+	quoteAlways     bool
+}
+
+// NewSelectCSVWriter creates a SelectCSVWriter for the options in opts, which
+// may be nil. Missing options take the same defaults as S3: fields are
+// delimited by ',', records by '\n', the quote character is '"', quotes inside
+// fields are escaped by doubling them, and fields are only quoted when they
+// need to be.
+func NewSelectCSVWriter(w io.Writer, opts *SelectCSVOutput) *SelectCSVWriter {
+	if opts == nil {
+		opts = &SelectCSVOutput{}
+	}
+	cw := &SelectCSVWriter{
+		w:               bufio.NewWriter(w),
+		fieldDelimiter:  opts.FieldDelimiter,
+		recordDelimiter: opts.RecordDelimiter,
+		quote:           opts.QuoteCharacter,
+		escape:          opts.QuoteEscapeCharacter,
+		quoteAlways:     strings.EqualFold(opts.QuoteFields, "ALWAYS"),
+	}
+	if cw.fieldDelimiter == "" {
+		cw.fieldDelimiter = ","
+	}
+	if cw.recordDelimiter == "" {
+		cw.recordDelimiter = "\n"
+	}
+	if cw.quote == "" {
+		cw.quote = `"`
+	}
+	if cw.escape == "" {
+		cw.escape = cw.quote
+	}
+	return cw
+}
+
+// Write writes a single record, followed by the record delimiter.
+func (cw *SelectCSVWriter) Write(record []string) error {
+	for i, field := range record {
+		if i > 0 {
+			if _, err := cw.w.WriteString(cw.fieldDelimiter); err != nil {
+				return err
+			}
+		}
+		if err := cw.writeField(field); err != nil {
+			return err
+		}
+	}
+	_, err := cw.w.WriteString(cw.recordDelimiter)
+	return err
+}
+
+// Flush writes any buffered records to the underlying io.Writer.
+func (cw *SelectCSVWriter) Flush() error {
+	return cw.w.Flush()
+}
+
+func (cw *SelectCSVWriter) writeField(field string) error {
+	if !cw.quoteAlways && !cw.needsQuotes(field) {
+		_, err := cw.w.WriteString(field)
+		return err
+	}
+
+	// The escape character must itself be escaped if it differs from the
+	// quote character, otherwise a reader can't tell where it came from:
+	if cw.escape != cw.quote {
+		field = strings.Replace(field, cw.escape, cw.escape+cw.escape, -1)
+	}
+	field = strings.Replace(field, cw.quote, cw.escape+cw.quote, -1)
+
+	_, err := cw.w.WriteString(cw.quote + field + cw.quote)
+	return err
+}
+
+func (cw *SelectCSVWriter) needsQuotes(field string) bool {
+	return strings.Contains(field, cw.fieldDelimiter) ||
+		strings.Contains(field, cw.recordDelimiter) ||
+		strings.Contains(field, cw.quote) ||
+		strings.Contains(field, cw.escape) ||
+		strings.ContainsAny(field, "\r\n")
+}
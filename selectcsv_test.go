@@ -0,0 +1,37 @@
+package gofakes3
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestSelectCSVWriter(t *testing.T) {
+	for idx, tc := range []struct {
+		opts   *SelectCSVOutput
+		record []string
+		out    string
+	}{
+		{nil, []string{"a", "b"}, "a,b\n"},
+		{nil, []string{"a,b", "c"}, "\"a,b\",c\n"},
+		{nil, []string{"a\nb", "c"}, "\"a\nb\",c\n"},
+		{nil, []string{`say "hi"`}, `"say ""hi"""` + "\n"},
+		{nil, []string{"", "b"}, ",b\n"},
+		{&SelectCSVOutput{QuoteFields: "ALWAYS"}, []string{"a", ""}, `"a",""` + "\n"},
+		{&SelectCSVOutput{QuoteFields: "ASNEEDED"}, []string{"a", "b c"}, "a,b c\n"},
+		{&SelectCSVOutput{FieldDelimiter: "\t", RecordDelimiter: "\r\n"}, []string{"a,b", "c\td"}, "a,b\t\"c\td\"\r\n"},
+		{&SelectCSVOutput{FieldDelimiter: "|", RecordDelimiter: ";"}, []string{"a;b", "c"}, "\"a;b\"|c;"},
+		{&SelectCSVOutput{QuoteCharacter: "'"}, []string{"it's", `"x"`}, `'it''s',"x"` + "\n"},
+		{&SelectCSVOutput{QuoteEscapeCharacter: `\`}, []string{`a"b`, `c\d`}, `"a\"b","c\\d"` + "\n"},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			var buf bytes.Buffer
+			cw := NewSelectCSVWriter(&buf, tc.opts)
+			TT{t}.OK(cw.Write(tc.record))
+			TT{t}.OK(cw.Flush())
+			if buf.String() != tc.out {
+				t.Fatalf("expected %q, found %q", tc.out, buf.String())
+			}
+		})
+	}
+}
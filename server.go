@@ -0,0 +1,39 @@
+package gofakes3
+
+import (
+	"net/http"
+	"time"
+)
+
+// ServerTimeouts configures the timeouts of the http.Server returned by
+// NewServer. They have the same meaning as the fields of http.Server with
+// the same names; zero means no timeout.
+type ServerTimeouts struct {
+	ReadHeader time.Duration
+	Read       time.Duration
+	Write      time.Duration
+
+	// Idle is how long a keep-alive connection may wait for its next
+	// request. If zero, Read is used; if both are zero, there is no timeout.
+	Idle time.Duration
+}
+
+// NewServer creates a GoFakeS3 using the supplied Backend and options, and
+// returns an http.Server that serves it on addr with the timeouts set by
+// WithServerTimeouts. Keep-alives are enabled, and HTTP/2 is negotiated
+// automatically if the server is started with TLS.
+//
+// The server does not close the Backend when it is shut down. If you need
+// the GoFakeS3 itself, for example to call SetMaintenance or Close, use New
+// and pass GoFakeS3.Server to your own http.Server instead.
+func NewServer(addr string, backend Backend, options ...Option) *http.Server {
+	g := New(backend, options...)
+	return &http.Server{
+		Addr:              addr,
+		Handler:           g.Server(),
+		ReadHeaderTimeout: g.serverTimeouts.ReadHeader,
+		ReadTimeout:       g.serverTimeouts.Read,
+		WriteTimeout:      g.serverTimeouts.Write,
+		IdleTimeout:       g.serverTimeouts.Idle,
+	}
+}
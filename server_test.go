@@ -0,0 +1,71 @@
+package gofakes3_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestNewServer(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket(defaultBucket); err != nil {
+		t.Fatal(err)
+	}
+
+	timeouts := gofakes3.ServerTimeouts{
+		ReadHeader: 1 * time.Second,
+		Read:       2 * time.Second,
+		Write:      3 * time.Second,
+		Idle:       4 * time.Second,
+	}
+	srv := gofakes3.NewServer("127.0.0.1:0", backend, gofakes3.WithServerTimeouts(timeouts))
+	if srv.ReadHeaderTimeout != timeouts.ReadHeader || srv.ReadTimeout != timeouts.Read ||
+		srv.WriteTimeout != timeouts.Write || srv.IdleTimeout != timeouts.Idle {
+		t.Fatal("unexpected timeouts", srv)
+	}
+
+	listener, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(listener)
+	defer srv.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{}}
+	url := "http://" + listener.Addr().String() + "/" + defaultBucket
+
+	// HEAD requests are used to probe for buckets and objects, so they must
+	// not close the connection:
+	for i := 0; i < 3; i++ {
+		var reused bool
+		trace := &httptrace.ClientTrace{
+			GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused },
+		}
+		rq, err := http.NewRequest("HEAD", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rq = rq.WithContext(httptrace.WithClientTrace(rq.Context(), trace))
+
+		rs, err := client.Do(rq)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		if rs.Close {
+			t.Fatal("server asked to close the connection")
+		}
+		if i > 0 && !reused {
+			t.Fatal("connection was not reused for request", i)
+		}
+	}
+}
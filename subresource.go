@@ -0,0 +1,356 @@
+package gofakes3
+
+import (
+	"sync"
+)
+
+// subresourceStore holds the state for subresources (like '?tagging',
+// '?acl', '?policy', '?cors', '?notification', '?requestPayment',
+// '?location' and '?attributes') associated with buckets and objects.
+//
+// Like the uploader, subresources do not currently interface with the Backend,
+// so they do not persist across reboots. Subresources are associated with the
+// object key only; individual object versions do not have their own state.
+type subresourceStore struct {
+	buckets map[string]*bucketSubresources
+	objects map[objectRef]*objectSubresources
+	mu      sync.Mutex
+}
+
+type bucketSubresources struct {
+	// policy is the JSON policy document, stored verbatim. It is nil if no
+	// policy has been set.
+	policy []byte
+
+	// cors is nil if no CORS configuration has been set.
+	cors []CORSRule
+
+	// acl is nil if no ACL has been set for the bucket, in which case the
+	// 'private' canned ACL applies.
+	acl []Grant
+
+	// notification is nil if no notification configuration has been set.
+	notification *NotificationConfiguration
+
+	// payer is empty if no request payment configuration has been set, in
+	// which case the bucket owner pays.
+	payer Payer
+
+	// location is the region given when the bucket was created. It is empty
+	// for buckets that were created directly in the Backend.
+	location string
+
+	// mfaDelete is the MFA Delete state set with PutBucketVersioning. It is
+	// only stored if WithMFA is used.
+	mfaDelete MFADeleteStatus
+}
+
+type objectRef struct {
+	bucket string
+	object string
+}
+
+type objectSubresources struct {
+	tags []Tag
+
+	// acl is nil if no ACL has been set for the object, in which case the
+	// 'private' canned ACL applies.
+	acl []Grant
+
+	// parts is set if the object was created by CompleteMultipartUpload and
+	// the Backend does not implement ObjectPartsBackend.
+	parts *PartsManifest
+}
+
+func newSubresourceStore() *subresourceStore {
+	return &subresourceStore{
+		buckets: make(map[string]*bucketSubresources),
+		objects: make(map[objectRef]*objectSubresources),
+	}
+}
+
+// BucketPolicy returns the policy document set for the bucket, or nil if
+// none has been set.
+func (ss *subresourceStore) BucketPolicy(bucket string) []byte {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil || sub.policy == nil {
+		return nil
+	}
+	return append([]byte{}, sub.policy...)
+}
+
+// SetBucketPolicy replaces the bucket's policy document. If policy is nil,
+// the policy is removed.
+func (ss *subresourceStore) SetBucketPolicy(bucket string, policy []byte) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.bucketUnlocked(bucket)
+	if policy == nil {
+		sub.policy = nil
+		return
+	}
+	sub.policy = append([]byte{}, policy...)
+}
+
+// BucketCORS returns the CORS rules set for the bucket, or nil if none have
+// been set.
+func (ss *subresourceStore) BucketCORS(bucket string) []CORSRule {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil || sub.cors == nil {
+		return nil
+	}
+	out := make([]CORSRule, len(sub.cors))
+	copy(out, sub.cors)
+	return out
+}
+
+// SetBucketCORS replaces the bucket's CORS rules. If rules is nil, the CORS
+// configuration is removed.
+func (ss *subresourceStore) SetBucketCORS(bucket string, rules []CORSRule) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.bucketUnlocked(bucket)
+	if rules == nil {
+		sub.cors = nil
+		return
+	}
+	sub.cors = make([]CORSRule, len(rules))
+	copy(sub.cors, rules)
+}
+
+// BucketNotification returns the bucket's notification configuration, which
+// is empty if none has been set.
+func (ss *subresourceStore) BucketNotification(bucket string) NotificationConfiguration {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil || sub.notification == nil {
+		return NotificationConfiguration{}
+	}
+	return *sub.notification
+}
+
+// SetBucketNotification replaces the bucket's notification configuration.
+// The configuration must not be modified after it is passed in.
+func (ss *subresourceStore) SetBucketNotification(bucket string, config NotificationConfiguration) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.bucketUnlocked(bucket).notification = &config
+}
+
+// BucketRequestPayer returns the party that pays for requests to the bucket.
+func (ss *subresourceStore) BucketRequestPayer(bucket string) Payer {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil || sub.payer == "" {
+		return PayerBucketOwner
+	}
+	return sub.payer
+}
+
+func (ss *subresourceStore) SetBucketRequestPayer(bucket string, payer Payer) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.bucketUnlocked(bucket).payer = payer
+}
+
+// BucketMFADelete returns the MFA Delete state of the bucket, or
+// MFADeleteNone if it has never been set.
+func (ss *subresourceStore) BucketMFADelete(bucket string) MFADeleteStatus {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil {
+		return MFADeleteNone
+	}
+	return sub.mfaDelete
+}
+
+func (ss *subresourceStore) SetBucketMFADelete(bucket string, status MFADeleteStatus) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.bucketUnlocked(bucket).mfaDelete = status
+}
+
+// BucketLocation returns the region the bucket was created in, or an empty
+// string if it was not created through GoFakeS3.
+func (ss *subresourceStore) BucketLocation(bucket string) string {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil {
+		return ""
+	}
+	return sub.location
+}
+
+func (ss *subresourceStore) SetBucketLocation(bucket string, location string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.bucketUnlocked(bucket).location = location
+}
+
+// BucketACL returns the grants set for the bucket, or nil if none have been
+// set.
+func (ss *subresourceStore) BucketACL(bucket string) []Grant {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.buckets[bucket]
+	if sub == nil || sub.acl == nil {
+		return nil
+	}
+	out := make([]Grant, len(sub.acl))
+	copy(out, sub.acl)
+	return out
+}
+
+// SetBucketACL replaces the bucket's grants. If grants is nil, the bucket
+// reverts to the 'private' canned ACL.
+func (ss *subresourceStore) SetBucketACL(bucket string, grants []Grant) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.bucketUnlocked(bucket)
+	if grants == nil {
+		sub.acl = nil
+		return
+	}
+	sub.acl = make([]Grant, len(grants))
+	copy(sub.acl, grants)
+}
+
+// RemoveBucket discards all subresources associated with the bucket and the
+// objects in it. It should be called whenever the bucket is deleted.
+func (ss *subresourceStore) RemoveBucket(bucket string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.buckets, bucket)
+	for ref := range ss.objects {
+		if ref.bucket == bucket {
+			delete(ss.objects, ref)
+		}
+	}
+}
+
+func (ss *subresourceStore) ObjectTags(bucket, object string) []Tag {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	var tags []Tag
+	if sub := ss.objects[objectRef{bucket, object}]; sub != nil {
+		tags = sub.tags
+	}
+	out := make([]Tag, len(tags))
+	copy(out, tags)
+	return out
+}
+
+func (ss *subresourceStore) SetObjectTags(bucket, object string, tags []Tag) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.objectUnlocked(bucket, object)
+	sub.tags = make([]Tag, len(tags))
+	copy(sub.tags, tags)
+}
+
+// ObjectACL returns the grants set for the object, or nil if none have been
+// set.
+func (ss *subresourceStore) ObjectACL(bucket, object string) []Grant {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.objects[objectRef{bucket, object}]
+	if sub == nil || sub.acl == nil {
+		return nil
+	}
+	out := make([]Grant, len(sub.acl))
+	copy(out, sub.acl)
+	return out
+}
+
+// SetObjectACL replaces the object's grants. If grants is nil, the object
+// reverts to the 'private' canned ACL.
+func (ss *subresourceStore) SetObjectACL(bucket, object string, grants []Grant) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.objectUnlocked(bucket, object)
+	if grants == nil {
+		sub.acl = nil
+		return
+	}
+	sub.acl = make([]Grant, len(grants))
+	copy(sub.acl, grants)
+}
+
+// ObjectParts returns the parts manifest of an object created by a multipart
+// upload. If the object was not created by a multipart upload, it returns
+// nil.
+func (ss *subresourceStore) ObjectParts(bucket, object string) *PartsManifest {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sub := ss.objects[objectRef{bucket, object}]
+	if sub == nil || sub.parts == nil {
+		return nil
+	}
+	return sub.parts.clone()
+}
+
+// SetObjectParts records that the object was created from the parts of a
+// multipart upload.
+func (ss *subresourceStore) SetObjectParts(bucket, object string, manifest PartsManifest) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	ss.objectUnlocked(bucket, object).parts = manifest.clone()
+}
+
+// RemoveObject discards all subresources associated with the object. It should
+// be called whenever the object is deleted or replaced.
+func (ss *subresourceStore) RemoveObject(bucket, object string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.objects, objectRef{bucket, object})
+}
+
+// bucketUnlocked assumes ss.mu is acquired
+func (ss *subresourceStore) bucketUnlocked(bucket string) *bucketSubresources {
+	sub := ss.buckets[bucket]
+	if sub == nil {
+		sub = &bucketSubresources{}
+		ss.buckets[bucket] = sub
+	}
+	return sub
+}
+
+// objectUnlocked assumes ss.mu is acquired
+func (ss *subresourceStore) objectUnlocked(bucket, object string) *objectSubresources {
+	ref := objectRef{bucket, object}
+	sub := ss.objects[ref]
+	if sub == nil {
+		sub = &objectSubresources{}
+		ss.objects[ref] = sub
+	}
+	return sub
+}
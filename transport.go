@@ -0,0 +1,242 @@
+package gofakes3
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RoundTripper returns an http.RoundTripper that serves requests with
+// GoFakeS3.Server in-process, without opening a socket. It can be used as the
+// Transport of an http.Client passed to an S3 SDK, so tests do not need an
+// httptest.Server:
+//
+//	client := &http.Client{Transport: faker.RoundTripper()}
+//
+// Request bodies are passed to the handler as they are read, and response
+// bodies are streamed back as the handler writes them, so large objects are
+// never held in memory in full. The request URL's host is ignored, except
+// that it is passed to the handler as Request.Host for WithHostBucket.
+func (g *GoFakeS3) RoundTripper() http.RoundTripper {
+	return &roundTripper{handler: g.Server()}
+}
+
+type roundTripper struct {
+	handler http.Handler
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL == nil {
+		closeRequestBody(req)
+		return nil, fmt.Errorf("gofakes3: nil Request.URL")
+	}
+
+	ctx, cancel := context.WithCancel(req.Context())
+	rq := serverRequest(ctx, req)
+
+	pr, pw := io.Pipe()
+	rw := &pipeResponseWriter{
+		header: make(http.Header),
+		req:    req,
+		body:   pw,
+		ready:  make(chan struct{}),
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer closeRequestBody(req)
+		defer func() {
+			if r := recover(); r != nil {
+				rw.fail(fmt.Errorf("gofakes3: handler panicked: %v", r))
+				return
+			}
+			rw.finish()
+		}()
+		rt.handler.ServeHTTP(rw, rq)
+	}()
+
+	// Like a dropped connection, cancelling the request stops the handler
+	// next time it writes, and fails any read from the response body:
+	go func() {
+		select {
+		case <-ctx.Done():
+			pr.CloseWithError(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	select {
+	case <-rw.ready:
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+	if rw.err != nil {
+		cancel()
+		return nil, rw.err
+	}
+
+	rs := rw.response
+	if bodyAllowed(req.Method, rs.StatusCode) {
+		rs.Body = &cancelOnClose{ReadCloser: pr, cancel: cancel}
+	} else {
+		pr.Close()
+		cancel()
+		rs.Body = http.NoBody
+	}
+	return rs, nil
+}
+
+// serverRequest converts a client request into the form an http.Handler
+// receives from http.Server.
+func serverRequest(ctx context.Context, req *http.Request) *http.Request {
+	rq := req.Clone(ctx)
+	rq.RequestURI = req.URL.RequestURI()
+	rq.RemoteAddr = "127.0.0.1:0"
+	if rq.Host == "" {
+		rq.Host = req.URL.Host
+	}
+
+	u := *req.URL
+	u.Scheme, u.Host, u.User = "", "", nil
+	rq.URL = &u
+
+	// A client treats a zero ContentLength with a Body as unknown, and sends
+	// it chunked. http.Server only reports a Content-Length it was sent:
+	length := req.ContentLength
+	if length == 0 && req.Body != nil && req.Body != http.NoBody {
+		length = -1
+	}
+	rq.ContentLength = length
+	if length > 0 || (length == 0 && methodHasBody(req.Method)) {
+		rq.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+	} else if length < 0 {
+		rq.TransferEncoding = []string{"chunked"}
+	}
+
+	if req.Body == nil {
+		rq.Body = http.NoBody
+	} else {
+		// The transport closes the client's body once the handler is done:
+		rq.Body = ioutil.NopCloser(req.Body)
+	}
+	return rq
+}
+
+func methodHasBody(method string) bool {
+	return method == "POST" || method == "PUT" || method == "PATCH"
+}
+
+func bodyAllowed(method string, status int) bool {
+	if method == http.MethodHead {
+		return false
+	}
+	return !(status >= 100 && status < 200) && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// pipeResponseWriter is the http.ResponseWriter passed to the handler by the
+// RoundTripper. The response is made available as soon as the handler
+// writes its status, and the body is streamed through a pipe after that.
+type pipeResponseWriter struct {
+	header http.Header
+	req    *http.Request
+	body   *io.PipeWriter
+
+	once     sync.Once
+	ready    chan struct{}
+	response *http.Response
+	err      error
+}
+
+var _ http.Flusher = &pipeResponseWriter{}
+
+func (w *pipeResponseWriter) Header() http.Header { return w.header }
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		hdr := w.header.Clone()
+		if hdr.Get("Date") == "" {
+			hdr.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		}
+
+		length := int64(-1)
+		if cl := hdr.Get("Content-Length"); cl != "" {
+			if n, err := strconv.ParseInt(cl, 10, 64); err == nil {
+				length = n
+			}
+		}
+
+		rs := &http.Response{
+			Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+			StatusCode:    status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        hdr,
+			ContentLength: length,
+			Request:       w.req,
+		}
+		if length < 0 && bodyAllowed(w.req.Method, status) {
+			rs.TransferEncoding = []string{"chunked"}
+		}
+		w.response = rs
+		close(w.ready)
+	})
+}
+
+func (w *pipeResponseWriter) Write(b []byte) (int, error) {
+	if _, ok := w.header["Content-Type"]; !ok && len(b) > 0 && w.response == nil {
+		// http.Server sniffs the Content-Type if the handler doesn't set it:
+		w.header.Set("Content-Type", http.DetectContentType(b))
+	}
+	w.WriteHeader(http.StatusOK)
+	if !bodyAllowed(w.req.Method, w.response.StatusCode) {
+		return len(b), nil
+	}
+	return w.body.Write(b)
+}
+
+// Flush sends the status if it hasn't been sent yet. There is nothing else to
+// flush; the pipe is unbuffered, so every Write has already been read by the
+// time it returns.
+func (w *pipeResponseWriter) Flush() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *pipeResponseWriter) finish() {
+	w.WriteHeader(http.StatusOK)
+	w.body.Close()
+}
+
+func (w *pipeResponseWriter) fail(err error) {
+	w.once.Do(func() {
+		w.err = err
+		close(w.ready)
+	})
+	w.body.CloseWithError(err)
+}
+
+// cancelOnClose releases the request's context once the response body is
+// closed, which also stops the handler if it is still writing.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
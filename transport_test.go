@@ -0,0 +1,110 @@
+package gofakes3_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/johannesboyne/gofakes3"
+)
+
+func TestRoundTripper(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	client := &http.Client{Transport: ts.RoundTripper()}
+	config := aws.NewConfig().
+		WithEndpoint("http://gofakes3.invalid").
+		WithRegion("region").
+		WithCredentials(credentials.NewStaticCredentials("dummy-access", "dummy-secret", "")).
+		WithS3ForcePathStyle(true).
+		WithHTTPClient(client)
+	svc := s3.New(session.New(), config)
+
+	body := bytes.Repeat([]byte("0123456789"), 100000)
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+		Body:   bytes.NewReader(body),
+	}))
+	ts.assertObject(defaultBucket, "object", nil, body)
+
+	obj, err := svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	got, err := ioutil.ReadAll(obj.Body)
+	obj.Body.Close()
+	ts.OK(err)
+	if !bytes.Equal(got, body) || aws.Int64Value(obj.ContentLength) != int64(len(body)) {
+		t.Fatal("unexpected body", len(got), aws.Int64Value(obj.ContentLength))
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("object"),
+	})
+	ts.OK(err)
+	if aws.Int64Value(head.ContentLength) != int64(len(body)) {
+		t.Fatal("unexpected length", aws.Int64Value(head.ContentLength))
+	}
+
+	_, err = svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("missing"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrNoSuchKey) {
+		t.Fatal("expected NoSuchKey, found", err)
+	}
+
+	t.Run("chunked-request", func(t *testing.T) {
+		// A reader of unknown length is sent without a Content-Length:
+		rq, err := http.NewRequest("PUT", "http://gofakes3.invalid/"+defaultBucket+"/chunked", ioutil.NopCloser(strings.NewReader("hello")))
+		ts.OK(err)
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status", rs.StatusCode)
+		}
+		ts.assertObject(defaultBucket, "chunked", nil, "hello")
+	})
+
+	t.Run("close-early", func(t *testing.T) {
+		// Closing the body before it has been read must not leave the
+		// handler blocked:
+		for i := 0; i < 10; i++ {
+			rs, err := client.Get("http://gofakes3.invalid/" + defaultBucket + "/object")
+			ts.OK(err)
+			buf := make([]byte, 10)
+			_, err = rs.Body.Read(buf)
+			ts.OK(err)
+			ts.OK(rs.Body.Close())
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		rq, err := http.NewRequest("GET", "http://gofakes3.invalid/"+defaultBucket+"/object", nil)
+		ts.OK(err)
+		rs, err := client.Do(rq.WithContext(ctx))
+		ts.OK(err)
+		defer rs.Body.Close()
+		cancel()
+
+		// The body is closed asynchronously, as it is by http.Transport:
+		time.Sleep(10 * time.Millisecond)
+		if _, err := ioutil.ReadAll(rs.Body); err == nil {
+			t.Fatal("expected an error after cancellation")
+		}
+	})
+}
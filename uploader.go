@@ -1,11 +1,17 @@
 package gofakes3
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"net/url"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -106,17 +112,16 @@ func (bu *bucketUploads) remove(uploadID UploadID) {
 		uploads = upv.([]*multipartUpload)
 	}
 
-	var found = -1
-	var v *multipartUpload
-	for found, v = range uploads {
-		if v.ID == uploadID {
-			break
+	// The slice is copied rather than modified in place, so one that was
+	// fetched from objectIndex earlier is never changed underneath its
+	// holder:
+	remaining := make([]*multipartUpload, 0, len(uploads))
+	for _, v := range uploads {
+		if v.ID != uploadID {
+			remaining = append(remaining, v)
 		}
 	}
-
-	if found >= 0 {
-		uploads = append(uploads[:found], uploads[found+1:]...) // delete the found index
-	}
+	uploads = remaining
 
 	if len(uploads) == 0 {
 		bu.objectIndex.Delete(upload.Object)
@@ -133,10 +138,10 @@ func (bu *bucketUploads) remove(uploadID UploadID) {
 //	- uploads do not interface with the Backend, so they do not
 // 	  currently persist across reboots
 //
-//	- upload parts are held in memory, so if you want to upload something huge
-//	  in multiple parts (which is pretty much exactly what you'd want multipart
-//	  uploads for), you'll need to make sure your memory is also sufficiently
-//	  huge!
+//	- upload parts are held in memory by default, so if you want to upload
+//	  something huge in multiple parts (which is pretty much exactly what
+//	  you'd want multipart uploads for), you'll need to make sure your memory
+//	  is also sufficiently huge, or use WithUploadTempDir!
 //
 // At this stage, the current thinking would be to add a second optional
 // Backend interface that allows persistent operations on multipart upload
@@ -153,16 +158,27 @@ type uploader struct {
 
 	buckets map[string]*bucketUploads
 	mu      sync.Mutex
+
+	// Limits applied to each multipartUpload created by Begin. See
+	// WithMaxUploadParts and WithMinPartSize.
+	maxPartNumber int
+	minPartSize   int64
+
+	// If spool is set, parts are written to temporary files in tempDir
+	// rather than held in memory. See WithUploadTempDir.
+	spool   bool
+	tempDir string
 }
 
 func newUploader() *uploader {
 	return &uploader{
-		buckets:  make(map[string]*bucketUploads),
-		uploadID: new(big.Int),
+		buckets:       make(map[string]*bucketUploads),
+		uploadID:      new(big.Int),
+		maxPartNumber: MaxUploadPartNumber,
 	}
 }
 
-func (u *uploader) Begin(bucket, object string, meta map[string]string, initiated time.Time) *multipartUpload {
+func (u *uploader) Begin(bucket, object string, meta map[string]string, initiated time.Time, checksumAlgorithm ChecksumAlgorithm) *multipartUpload {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 
@@ -174,9 +190,15 @@ func (u *uploader) Begin(bucket, object string, meta map[string]string, initiate
 		Object:    object,
 		Meta:      meta,
 		Initiated: initiated,
+
+		ChecksumAlgorithm: checksumAlgorithm,
+
+		maxPartNumber: u.maxPartNumber,
+		minPartSize:   u.minPartSize,
+		spool:         u.spool,
+		tempDir:       u.tempDir,
 	}
 
-	// FIXME: make sure the uploader responds to DeleteBucket
 	bucketUploads := u.buckets[bucket]
 	if bucketUploads == nil {
 		u.buckets[bucket] = newBucketUploads()
@@ -188,42 +210,55 @@ func (u *uploader) Begin(bucket, object string, meta map[string]string, initiate
 	return mpu
 }
 
+// ListParts lists the parts of an upload. Only the upload's own lock is held
+// while the parts are listed, so uploads do not block each other.
 func (u *uploader) ListParts(bucket, object string, uploadID UploadID, marker int, limit int64) (*ListMultipartUploadPartsResult, error) {
-	u.mu.Lock()
-	defer u.mu.Unlock()
-
-	mpu, err := u.getUnlocked(bucket, object, uploadID)
+	mpu, err := u.Get(bucket, object, uploadID)
 	if err != nil {
 		return nil, err
 	}
 
+	mpu.mu.Lock()
+	defer mpu.mu.Unlock()
+
+	if mpu.closed {
+		// The upload was completed or aborted after it was found:
+		return nil, ErrNoSuchUpload
+	}
+
 	var result = ListMultipartUploadPartsResult{
-		Bucket:           bucket,
-		Key:              object,
-		UploadID:         uploadID,
-		MaxParts:         limit,
-		PartNumberMarker: marker,
-		StorageClass:     "STANDARD", // FIXME
+		Bucket:            bucket,
+		Key:               object,
+		UploadID:          uploadID,
+		MaxParts:          limit,
+		PartNumberMarker:  marker,
+		StorageClass:      "STANDARD", // FIXME
+		ChecksumAlgorithm: mpu.ChecksumAlgorithm,
 	}
 
+	// mpu.parts is indexed by part number. The marker is the last part
+	// number the client has already seen, so the listing starts after it,
+	// and NextPartNumberMarker is the last part number in this page:
 	var cnt int64
-	for partNumber, part := range mpu.parts[marker:] {
+	for partNumber := marker + 1; partNumber < len(mpu.parts); partNumber++ {
+		part := mpu.parts[partNumber]
 		if part == nil {
 			continue
 		}
 
 		if cnt >= limit {
 			result.IsTruncated = true
-			result.NextPartNumberMarker = partNumber
 			break
 		}
 
 		result.Parts = append(result.Parts, ListMultipartUploadPartItem{
 			ETag:         part.ETag,
-			Size:         int64(len(part.Body)),
+			Size:         part.Size,
 			PartNumber:   partNumber,
 			LastModified: part.LastModified,
+			Checksums:    part.Checksums,
 		})
+		result.NextPartNumberMarker = partNumber
 
 		cnt++
 	}
@@ -344,18 +379,86 @@ done:
 	return &result, nil
 }
 
-func (u *uploader) Complete(bucket, object string, id UploadID) (*multipartUpload, error) {
+// Complete reassembles the upload from the parts listed in the input (see
+// Reassemble). If that succeeds, the upload is removed from the uploader and
+// returned along with the reassembled object, and any parts that arrive
+// afterwards will be rejected with ErrNoSuchUpload. If it fails, the upload
+// is left as it was, so the client can fix its parts and try again.
+func (u *uploader) Complete(bucket, object string, id UploadID, input *CompleteMultipartUploadRequest, maxObjectSize int64) (up *multipartUpload, body io.ReadCloser, size int64, parts []ObjectPart, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	up, err = u.getUnlocked(bucket, object, id)
+	if err != nil {
+		return nil, nil, 0, nil, err
+	}
+
+	body, size, parts, err = up.Reassemble(input, maxObjectSize)
+	if err != nil {
+		return nil, nil, 0, nil, err
+	}
+
+	// if getUnlocked succeeded, so will this:
+	u.buckets[bucket].remove(id)
+
+	return up, body, size, parts, nil
+}
+
+// Abort removes the upload from the uploader and discards any parts that have
+// been uploaded so far. Parts that are still in flight when the upload is
+// aborted will be rejected with ErrNoSuchUpload rather than being added to
+// the discarded upload.
+func (u *uploader) Abort(bucket, object string, id UploadID) error {
 	u.mu.Lock()
 	defer u.mu.Unlock()
 	up, err := u.getUnlocked(bucket, object, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// if getUnlocked succeeded, so will this:
 	u.buckets[bucket].remove(id)
 
-	return up, nil
+	up.close(true)
+
+	return nil
+}
+
+// AbortAll aborts every upload in progress, discarding their parts.
+func (u *uploader) AbortAll() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, bucketUps := range u.buckets {
+		for _, up := range bucketUps.uploads {
+			up.close(true)
+		}
+	}
+	u.buckets = make(map[string]*bucketUploads)
+}
+
+// AbortBucket aborts every upload in progress in the bucket, discarding their
+// parts. This is used when the bucket is deleted.
+func (u *uploader) AbortBucket(bucket string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if bucketUps, ok := u.buckets[bucket]; ok {
+		for _, up := range bucketUps.uploads {
+			up.close(true)
+		}
+		delete(u.buckets, bucket)
+	}
+}
+
+// Count returns the number of uploads in progress across every bucket.
+func (u *uploader) Count() (n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	for _, bucketUps := range u.buckets {
+		n += len(bucketUps.uploads)
+	}
+	return n
 }
 
 func (u *uploader) Get(bucket, object string, id UploadID) (mu *multipartUpload, err error) {
@@ -419,8 +522,33 @@ func uploadListMarkerFromQuery(q url.Values) *UploadListMarker {
 type multipartUploadPart struct {
 	PartNumber   int
 	ETag         string
-	Body         []byte
+	Size         int64
 	LastModified ContentTime
+
+	// Checksums holds the checksum calculated when the part was uploaded,
+	// if one was requested, or if the upload has a ChecksumAlgorithm:
+	Checksums Checksums
+
+	// The contents of the part are held in body, or in the temporary file
+	// named by file if the upload was spooled to disk:
+	body []byte
+	file string
+}
+
+// open returns a reader for the contents of the part.
+func (part *multipartUploadPart) open() (io.ReadCloser, error) {
+	if part.file == "" {
+		return ioutil.NopCloser(bytes.NewReader(part.body)), nil
+	}
+	return os.Open(part.file)
+}
+
+// discard releases the contents of the part.
+func (part *multipartUploadPart) discard() {
+	if part.file != "" {
+		os.Remove(part.file)
+	}
+	part.body = nil
 }
 
 type multipartUpload struct {
@@ -430,6 +558,16 @@ type multipartUpload struct {
 	Meta      map[string]string
 	Initiated time.Time
 
+	// ChecksumAlgorithm is the algorithm given when the upload was created.
+	// If it is set, every part has a checksum calculated with it.
+	ChecksumAlgorithm ChecksumAlgorithm
+
+	// Copied from the uploader when the upload begins:
+	maxPartNumber int
+	minPartSize   int64
+	spool         bool
+	tempDir       string
+
 	// Part numbers are limited in S3 to 10,000, so we can be a little wasteful.
 	// If a new part number is added, the slice is grown to that size. Depending
 	// on how bad the input is, this could mean you have a 10,000 element slice
@@ -444,37 +582,137 @@ type multipartUpload struct {
 	// Do not attempt to access parts without locking mu.
 	parts []*multipartUploadPart
 
+	// closed is set once the upload has been removed from the uploader by
+	// either completing or aborting it. No more parts may be added after
+	// this happens. Do not attempt to access closed without locking mu.
+	closed bool
+
+	// mu must not be acquired before uploader.mu if both are required.
 	mu sync.Mutex
 }
 
-func (mpu *multipartUpload) AddPart(partNumber int, at time.Time, body []byte) (etag string, err error) {
-	if partNumber > MaxUploadPartNumber {
-		return "", ErrInvalidPart
+// close prevents any further parts from being added to the upload. If discard
+// is true, the parts are released so their memory can be reclaimed even if a
+// request goroutine still holds a reference to the upload.
+func (mpu *multipartUpload) close(discard bool) {
+	mpu.mu.Lock()
+	defer mpu.mu.Unlock()
+	mpu.closed = true
+	if discard {
+		for _, part := range mpu.parts {
+			if part != nil {
+				part.discard()
+			}
+		}
+		mpu.parts = nil
 	}
+}
+
+// AddPart reads size bytes from body and stores them as the part. The body is
+// read before the upload is locked, as it may take some time to arrive.
+//
+// If the checksum's algorithm is set, or the upload has a ChecksumAlgorithm,
+// the checksum of the part is calculated and returned in checksums, and the
+// part is rejected if it does not match the expected checksum.
+func (mpu *multipartUpload) AddPart(partNumber int, at time.Time, body io.Reader, size int64, checksum requestedChecksum) (etag string, checksums Checksums, err error) {
+	if partNumber > mpu.maxPartNumber {
+		return "", checksums, invalidPartNumber(strconv.Itoa(partNumber), mpu.maxPartNumber)
+	}
+
+	if checksum.algorithm == "" {
+		checksum.algorithm = mpu.ChecksumAlgorithm
+	} else if mpu.ChecksumAlgorithm != "" && checksum.algorithm != mpu.ChecksumAlgorithm {
+		return "", checksums, ErrorMessagef(ErrInvalidRequest,
+			"Checksum Type mismatch occurred, expected checksum Type: %s, actual checksum Type: %s",
+			strings.ToLower(string(mpu.ChecksumAlgorithm)), strings.ToLower(string(checksum.algorithm)))
+	}
+
+	part, err := mpu.readPart(body, size, checksum)
+	if err != nil {
+		return "", checksums, err
+	}
+	part.PartNumber = partNumber
+	part.LastModified = NewContentTime(at)
 
 	mpu.mu.Lock()
 	defer mpu.mu.Unlock()
 
+	if mpu.closed {
+		// The upload was completed or aborted by another request while
+		// this part was being received:
+		part.discard()
+		return "", checksums, ErrNoSuchUpload
+	}
+
+	if partNumber >= len(mpu.parts) {
+		mpu.parts = append(mpu.parts, make([]*multipartUploadPart, partNumber-len(mpu.parts)+1)...)
+	}
+	if old := mpu.parts[partNumber]; old != nil {
+		old.discard()
+	}
+	mpu.parts[partNumber] = part
+	return part.ETag, part.Checksums, nil
+}
+
+func (mpu *multipartUpload) readPart(body io.Reader, size int64, checksum requestedChecksum) (part *multipartUploadPart, err error) {
 	// What the ETag actually is is not specified, so let's just invent any old thing
 	// from guaranteed unique input:
 	hash := md5.New()
-	hash.Write([]byte(body))
-	etag = fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil)))
+	body = io.TeeReader(body, hash)
 
-	part := multipartUploadPart{
-		PartNumber:   partNumber,
-		Body:         body,
-		ETag:         etag,
-		LastModified: NewContentTime(at),
+	var cr *checksumReader
+	if checksum.algorithm != "" {
+		cr = newChecksumReader(body, checksum)
+		body = cr
 	}
-	if partNumber >= len(mpu.parts) {
-		mpu.parts = append(mpu.parts, make([]*multipartUploadPart, partNumber-len(mpu.parts)+1)...)
+
+	part = &multipartUploadPart{Size: size}
+
+	if !mpu.spool {
+		if part.body, err = ReadAll(body, size); err != nil {
+			return nil, err
+		}
+
+	} else {
+		f, err := ioutil.TempFile(mpu.tempDir, "gofakes3-part-")
+		if err != nil {
+			return nil, err
+		}
+		part.file = f.Name()
+
+		// Reading one byte more than size detects a body that is too long, in
+		// the same way as ReadAll:
+		n, err := io.Copy(f, io.LimitReader(body, size+1))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil && n != size {
+			err = ErrIncompleteBody
+		}
+		if err != nil {
+			part.discard()
+			return nil, err
+		}
 	}
-	mpu.parts[partNumber] = &part
-	return etag, nil
+
+	part.ETag = fmt.Sprintf(`"%s"`, hex.EncodeToString(hash.Sum(nil)))
+	if cr != nil {
+		part.Checksums = newChecksums(checksum.algorithm, cr.Value())
+	}
+	return part, nil
 }
 
-func (mpu *multipartUpload) Reassemble(input *CompleteMultipartUploadRequest) (body []byte, etag string, err error) {
+// Reassemble checks the parts listed in the input against the parts that
+// were uploaded, and returns a reader that concatenates them, along with
+// their total size and a description of each of them. The parts are read one
+// at a time as the reader is consumed, so the object is never assembled in
+// memory. If maxObjectSize is greater than 0, a larger object is rejected with
+// ErrEntityTooLarge.
+//
+// If the parts are valid, the upload is closed, so no more parts can be added
+// to it; nothing is changed if they are not. The parts must not be discarded
+// until the reader has been closed.
+func (mpu *multipartUpload) Reassemble(input *CompleteMultipartUploadRequest, maxObjectSize int64) (body io.ReadCloser, size int64, objectParts []ObjectPart, err error) {
 	mpu.mu.Lock()
 	defer mpu.mu.Unlock()
 
@@ -484,34 +722,104 @@ func (mpu *multipartUpload) Reassemble(input *CompleteMultipartUploadRequest) (b
 	// end up uploading more parts than you need to assemble, so it should
 	// probably just ignore that?
 	if len(input.Parts) > mpuPartsLen {
-		return nil, "", ErrInvalidPart
+		return nil, 0, nil, ErrInvalidPart
 	}
 
 	if !input.partsAreSorted() {
-		return nil, "", ErrInvalidPartOrder
+		return nil, 0, nil, ErrInvalidPartOrder
 	}
 
-	var size int64
+	last := len(input.Parts) - 1
+	parts := make([]*multipartUploadPart, 0, len(input.Parts))
 
-	for _, inPart := range input.Parts {
+	for idx, inPart := range input.Parts {
 		if inPart.PartNumber >= mpuPartsLen || mpu.parts[inPart.PartNumber] == nil {
-			return nil, "", ErrorMessagef(ErrInvalidPart, "unexpected part number %d in complete request", inPart.PartNumber)
+			return nil, 0, nil, ErrorMessagef(ErrInvalidPart, "unexpected part number %d in complete request", inPart.PartNumber)
 		}
 
 		upPart := mpu.parts[inPart.PartNumber]
 		if inPart.ETag != upPart.ETag {
-			return nil, "", ErrorMessagef(ErrInvalidPart, "unexpected part etag for number %d in complete request", inPart.PartNumber)
+			return nil, 0, nil, ErrorMessagef(ErrInvalidPart, "unexpected part etag for number %d in complete request", inPart.PartNumber)
+		}
+		if err := mpu.checkPartChecksums(inPart, upPart); err != nil {
+			return nil, 0, nil, err
 		}
 
-		size += int64(len(upPart.Body))
+		// "Each part must be at least 5 MB in size, except the last part."
+		if idx != last && upPart.Size < mpu.minPartSize {
+			return nil, 0, nil, ErrorMessagef(ErrEntityTooSmall, "part number %d is smaller than the minimum allowed size %d", inPart.PartNumber, mpu.minPartSize)
+		}
+
+		size += upPart.Size
+		parts = append(parts, upPart)
+		objectParts = append(objectParts, ObjectPart{
+			PartNumber: upPart.PartNumber,
+			Size:       upPart.Size,
+			Checksums:  upPart.Checksums,
+		})
 	}
 
-	body = make([]byte, 0, size)
-	for _, part := range input.Parts {
-		body = append(body, mpu.parts[part.PartNumber].Body...)
+	if maxObjectSize > 0 && size > maxObjectSize {
+		return nil, 0, nil, entityTooLarge(size, maxObjectSize)
+	}
+
+	mpu.closed = true
+	return &multipartPartsReader{parts: parts}, size, objectParts, nil
+}
+
+// checkPartChecksums checks the checksums given for a part in a
+// CompleteMultipartUpload request against the ones calculated when the part
+// was uploaded. If the upload has a ChecksumAlgorithm, the request must give
+// the part's checksum.
+func (mpu *multipartUpload) checkPartChecksums(inPart CompletedPart, upPart *multipartUploadPart) error {
+	if mpu.ChecksumAlgorithm != "" && inPart.Get(mpu.ChecksumAlgorithm) == "" {
+		return ErrorMessagef(ErrInvalidRequest,
+			"The upload was created using a %s checksum. The complete request must include the checksum for each part. It was missing for part %d in the request.",
+			strings.ToLower(string(mpu.ChecksumAlgorithm)), inPart.PartNumber)
 	}
+	for _, alg := range checksumAlgorithms {
+		if value := inPart.Get(alg); value != "" && value != upPart.Checksums.Get(alg) {
+			return ErrorMessagef(ErrInvalidPart, "unexpected part checksum for number %d in complete request", inPart.PartNumber)
+		}
+	}
+	return nil
+}
 
-	hash := fmt.Sprintf("%x", md5.Sum(body))
+// multipartPartsReader reads each of the parts in turn, opening them only as
+// they are needed.
+type multipartPartsReader struct {
+	parts []*multipartUploadPart
+	cur   io.ReadCloser
+}
 
-	return body, hash, nil
+func (pr *multipartPartsReader) Read(b []byte) (n int, err error) {
+	for {
+		if pr.cur == nil {
+			if len(pr.parts) == 0 {
+				return 0, io.EOF
+			}
+			if pr.cur, err = pr.parts[0].open(); err != nil {
+				return 0, err
+			}
+			pr.parts = pr.parts[1:]
+		}
+
+		n, err = pr.cur.Read(b)
+		if err == io.EOF {
+			pr.cur.Close()
+			pr.cur = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (pr *multipartPartsReader) Close() error {
+	if pr.cur != nil {
+		return pr.cur.Close()
+	}
+	return nil
 }
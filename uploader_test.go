@@ -1,10 +1,23 @@
 package gofakes3_test
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
 )
 
 func TestMultipartUpload(t *testing.T) {
@@ -158,3 +171,854 @@ func TestListMultipartUploadParts(t *testing.T) {
 	// No parts should be returned after the upload is completed:
 	ts.assertListUploadPartsFails(gofakes3.ErrNoSuchUpload, defaultBucket, "foo", id, listUploadPartsOpts{})
 }
+
+func TestMultipartUploadMaxParts(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMaxUploadParts(2)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+	ts.uploadPart(defaultBucket, "foo", id, 2, []byte("abc"))
+
+	_, err := svc.UploadPart(&s3.UploadPartInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("foo"),
+		Body:       bytes.NewReader([]byte("def")),
+		UploadId:   aws.String(id),
+		PartNumber: aws.Int64(3),
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidArgument) {
+		t.Fatal("expected ErrInvalidArgument, found", err)
+	}
+}
+
+func TestMultipartUploadListParts(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithOwner("owner-id", "owner-name")))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+	var parts []*s3.CompletedPart
+	for _, num := range []int64{1, 2, 3, 5} {
+		parts = append(parts, ts.uploadPart(defaultBucket, "foo", id, num, []byte(strings.Repeat("x", int(num)))))
+	}
+
+	assertPage := func(out *s3.ListPartsOutput, truncated bool, next int64, expected ...*s3.CompletedPart) {
+		t.Helper()
+		if aws.StringValue(out.Initiator.ID) != "owner-id" || aws.StringValue(out.Owner.ID) != "owner-id" {
+			t.Fatal("unexpected owner", out.Initiator, out.Owner)
+		}
+		if aws.StringValue(out.StorageClass) != "STANDARD" {
+			t.Fatal("unexpected storage class", aws.StringValue(out.StorageClass))
+		}
+		if aws.BoolValue(out.IsTruncated) != truncated || aws.Int64Value(out.NextPartNumberMarker) != next {
+			t.Fatal("unexpected truncation", aws.BoolValue(out.IsTruncated), aws.Int64Value(out.NextPartNumberMarker))
+		}
+		if len(out.Parts) != len(expected) {
+			t.Fatal("unexpected parts", out.Parts)
+		}
+		for i, part := range out.Parts {
+			num := aws.Int64Value(expected[i].PartNumber)
+			if aws.Int64Value(part.PartNumber) != num || aws.StringValue(part.ETag) != aws.StringValue(expected[i].ETag) {
+				t.Fatal("unexpected part", part, "!=", expected[i])
+			}
+			if aws.Int64Value(part.Size) != num {
+				t.Fatal("unexpected size", aws.Int64Value(part.Size), "!=", num)
+			}
+			if !aws.TimeValue(part.LastModified).Equal(defaultDate) {
+				t.Fatal("unexpected last modified", aws.TimeValue(part.LastModified))
+			}
+		}
+	}
+
+	out, err := svc.ListParts(&s3.ListPartsInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("foo"),
+		UploadId: aws.String(id),
+		MaxParts: aws.Int64(2),
+	})
+	ts.OK(err)
+	assertPage(out, true, 2, parts[0], parts[1])
+
+	// The marker is exclusive, so the next page starts after part 2:
+	out, err = svc.ListParts(&s3.ListPartsInput{
+		Bucket:           aws.String(defaultBucket),
+		Key:              aws.String("foo"),
+		UploadId:         aws.String(id),
+		MaxParts:         aws.Int64(2),
+		PartNumberMarker: out.NextPartNumberMarker,
+	})
+	ts.OK(err)
+	assertPage(out, false, 5, parts[2], parts[3])
+
+	out, err = svc.ListParts(&s3.ListPartsInput{
+		Bucket:           aws.String(defaultBucket),
+		Key:              aws.String("foo"),
+		UploadId:         aws.String(id),
+		PartNumberMarker: aws.Int64(100),
+	})
+	ts.OK(err)
+	assertPage(out, false, 0)
+}
+
+func TestMultipartUploadPartReplaced(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	mpu, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+		Bucket:               aws.String(defaultBucket),
+		Key:                  aws.String("foo"),
+		ServerSideEncryption: aws.String("aws:kms"),
+		SSEKMSKeyId:          aws.String("key-id"),
+	})
+	ts.OK(err)
+	if aws.StringValue(mpu.ServerSideEncryption) != "aws:kms" {
+		t.Fatal("encryption settings not echoed", mpu)
+	}
+
+	upload := func(body string) *s3.UploadPartOutput {
+		t.Helper()
+		out, err := svc.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String("foo"),
+			UploadId:   mpu.UploadId,
+			PartNumber: aws.Int64(1),
+			Body:       strings.NewReader(body),
+		})
+		ts.OK(err)
+		if aws.StringValue(out.ServerSideEncryption) != "aws:kms" || aws.StringValue(out.SSEKMSKeyId) != "key-id" {
+			t.Fatal("encryption settings not echoed", out)
+		}
+		return out
+	}
+	upload("abc")
+
+	// Replacing a part replaces its size and time as well as its contents:
+	ts.Advance(time.Minute)
+	replaced := upload("abcdef")
+
+	out, err := svc.ListParts(&s3.ListPartsInput{Bucket: aws.String(defaultBucket), Key: aws.String("foo"), UploadId: mpu.UploadId})
+	ts.OK(err)
+	if len(out.Parts) != 1 {
+		t.Fatal("unexpected parts", out.Parts)
+	}
+	part := out.Parts[0]
+	if aws.Int64Value(part.Size) != 6 || aws.StringValue(part.ETag) != aws.StringValue(replaced.ETag) {
+		t.Fatal("unexpected part", part)
+	}
+	if !aws.TimeValue(part.LastModified).Equal(defaultDate.Add(time.Minute)) {
+		t.Fatal("unexpected last modified", aws.TimeValue(part.LastModified))
+	}
+
+	completed, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("foo"),
+		UploadId: mpu.UploadId,
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{
+			{PartNumber: aws.Int64(1), ETag: replaced.ETag},
+		}},
+	})
+	ts.OK(err)
+	if aws.StringValue(completed.ServerSideEncryption) != "aws:kms" {
+		t.Fatal("encryption settings not echoed", completed)
+	}
+	ts.assertObject(defaultBucket, "foo", nil, "abcdef")
+}
+
+func TestMultipartUploadInvalidPartNumber(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	client := httpClient()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+
+	for _, partNumber := range []string{"", "abc", "0", "-1", "1.5", "10001"} {
+		rq, err := http.NewRequest("PUT", ts.url("/"+defaultBucket+"/foo?uploadId="+id+"&partNumber="+partNumber), strings.NewReader("abc"))
+		ts.OK(err)
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		body, err := ioutil.ReadAll(rs.Body)
+		rs.Body.Close()
+		ts.OK(err)
+
+		if rs.StatusCode != http.StatusBadRequest {
+			t.Fatal("unexpected status for", partNumber, rs.StatusCode)
+		}
+		var resp struct {
+			Code          string
+			ArgumentName  string
+			ArgumentValue string
+		}
+		ts.OK(xml.Unmarshal(body, &resp))
+		if resp.Code != "InvalidArgument" || resp.ArgumentName != "partNumber" || resp.ArgumentValue != partNumber {
+			t.Fatal("unexpected error for", partNumber, string(body))
+		}
+	}
+
+	// A part that is missing when the upload is completed is still an
+	// InvalidPart:
+	svc := ts.s3Client()
+	part := ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc"))
+	_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(defaultBucket),
+		Key:      aws.String("foo"),
+		UploadId: aws.String(id),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: []*s3.CompletedPart{
+			part, {PartNumber: aws.Int64(2), ETag: part.ETag},
+		}},
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidPart) {
+		t.Fatal("expected ErrInvalidPart, found", err)
+	}
+}
+
+func TestMultipartUploadMinPartSize(t *testing.T) {
+	complete := func(ts *testServer, id string, parts []*s3.CompletedPart) error {
+		svc := ts.s3Client()
+		_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(defaultBucket),
+			Key:             aws.String("foo"),
+			UploadId:        aws.String(id),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		return err
+	}
+
+	t.Run("too-small", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(4)))
+		defer ts.Close()
+
+		id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+		parts := []*s3.CompletedPart{
+			ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc")),
+			ts.uploadPart(defaultBucket, "foo", id, 2, []byte("def")),
+		}
+		if err := complete(ts, id, parts); !hasErrorCode(err, gofakes3.ErrEntityTooSmall) {
+			t.Fatal("expected ErrEntityTooSmall, found", err)
+		}
+
+		// The upload is left open, so the part can be replaced:
+		parts[0] = ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abcd"))
+		ts.assertCompleteUpload(defaultBucket, "foo", id, parts, []byte("abcddef"))
+	})
+
+	t.Run("last-part-may-be-small", func(t *testing.T) {
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(4)))
+		defer ts.Close()
+
+		id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+		parts := []*s3.CompletedPart{
+			ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abcd")),
+			ts.uploadPart(defaultBucket, "foo", id, 2, []byte("ef")),
+		}
+		ts.assertCompleteUpload(defaultBucket, "foo", id, parts, []byte("abcdef"))
+	})
+}
+
+func TestAbortMultipartUploadWhileUploadingParts(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+
+	const parts = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, parts)
+
+	for i := int64(1); i <= parts; i++ {
+		wg.Add(1)
+		go func(num int64) {
+			defer wg.Done()
+			_, err := svc.UploadPart(&s3.UploadPartInput{
+				Bucket:     aws.String(defaultBucket),
+				Key:        aws.String("foo"),
+				Body:       bytes.NewReader(randomFileBody(1024)),
+				UploadId:   aws.String(id),
+				PartNumber: aws.Int64(num),
+			})
+			errs <- err
+		}(i)
+	}
+
+	ts.assertAbortMultipartUpload(defaultBucket, "foo", gofakes3.UploadID(id))
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil && !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+			t.Fatal("expected nil or ErrNoSuchUpload, found", err)
+		}
+	}
+
+	// Parts uploaded after the abort must not resurrect the upload:
+	ts.assertListUploadPartsFails(gofakes3.ErrNoSuchUpload, defaultBucket, "foo", id, listUploadPartsOpts{})
+}
+
+func TestMultipartUploadTempDir(t *testing.T) {
+	assertTempFiles := func(t *testing.T, dir string, expected int) {
+		t.Helper()
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != expected {
+			t.Fatal("expected", expected, "temp files, found", len(files))
+		}
+	}
+
+	t.Run("complete", func(t *testing.T) {
+		dir := t.TempDir()
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithUploadTempDir(dir)))
+		defer ts.Close()
+
+		id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+		ts.uploadPart(defaultBucket, "foo", id, 1, []byte("replaced"))
+		parts := []*s3.CompletedPart{
+			ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc")),
+			ts.uploadPart(defaultBucket, "foo", id, 2, []byte("def")),
+		}
+		assertTempFiles(t, dir, 2)
+
+		ts.assertCompleteUpload(defaultBucket, "foo", id, parts, []byte("abcdef"))
+		assertTempFiles(t, dir, 0)
+	})
+
+	t.Run("abort", func(t *testing.T) {
+		dir := t.TempDir()
+		ts := newTestServer(t, withFakerOptions(gofakes3.WithUploadTempDir(dir)))
+		defer ts.Close()
+
+		id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+		ts.uploadPart(defaultBucket, "foo", id, 1, []byte("abc"))
+		assertTempFiles(t, dir, 1)
+
+		ts.assertAbortMultipartUpload(defaultBucket, "foo", gofakes3.UploadID(id))
+		assertTempFiles(t, dir, 0)
+	})
+}
+
+func TestMultipartUploadChecksums(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	client := httpClient()
+
+	crc32c := func(s string) []byte {
+		sum := make([]byte, 4)
+		binary.BigEndian.PutUint32(sum, crc32.Checksum([]byte(s), crc32.MakeTable(crc32.Castagnoli)))
+		return sum
+	}
+	b64 := base64.StdEncoding.EncodeToString
+
+	do := func(method, url string, hdr map[string]string, body string) (*http.Response, []byte) {
+		t.Helper()
+		rq, err := http.NewRequest(method, ts.url(url), strings.NewReader(body))
+		ts.OK(err)
+		for k, v := range hdr {
+			rq.Header.Set(k, v)
+		}
+		rs, err := client.Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		out, err := ioutil.ReadAll(rs.Body)
+		ts.OK(err)
+		return rs, out
+	}
+	assertError := func(rs *http.Response, body []byte, status int, code gofakes3.ErrorCode) {
+		t.Helper()
+		var resp struct{ Code gofakes3.ErrorCode }
+		ts.OK(xml.Unmarshal(body, &resp))
+		if rs.StatusCode != status || resp.Code != code {
+			t.Fatal("expected", status, code, "found", rs.StatusCode, string(body))
+		}
+	}
+
+	initiate := func(object string) gofakes3.UploadID {
+		t.Helper()
+		rs, body := do("POST", object+"?uploads", map[string]string{"x-amz-checksum-algorithm": "CRC32C"}, "")
+		var initiated gofakes3.InitiateMultipartUpload
+		ts.OK(xml.Unmarshal(body, &initiated))
+		if rs.Header.Get("x-amz-checksum-algorithm") != "CRC32C" {
+			t.Fatal("unexpected algorithm", rs.Header)
+		}
+		return initiated.UploadID
+	}
+
+	object := "/" + defaultBucket + "/foo"
+	id := initiate(object)
+	partURL := func(n int) string {
+		return fmt.Sprintf("%s?uploadId=%s&partNumber=%d", object, id, n)
+	}
+
+	rs, body := do("PUT", partURL(1), map[string]string{"x-amz-checksum-crc32c": b64(crc32c("bad"))}, "abc")
+	assertError(rs, body, http.StatusBadRequest, gofakes3.ErrBadDigest)
+
+	rs, body = do("PUT", partURL(1), map[string]string{"x-amz-checksum-sha256": b64(make([]byte, 32))}, "abc")
+	assertError(rs, body, http.StatusBadRequest, gofakes3.ErrInvalidRequest)
+
+	rs, _ = do("PUT", partURL(1), map[string]string{"x-amz-checksum-crc32c": b64(crc32c("abc"))}, "abc")
+	if rs.StatusCode != http.StatusOK || rs.Header.Get("x-amz-checksum-crc32c") != b64(crc32c("abc")) {
+		t.Fatal("unexpected part 1 response", rs.StatusCode, rs.Header)
+	}
+	etag1 := rs.Header.Get("ETag")
+
+	// A part with no checksum of its own gets the upload's algorithm:
+	rs, _ = do("PUT", partURL(2), nil, "def")
+	if rs.StatusCode != http.StatusOK || rs.Header.Get("x-amz-checksum-crc32c") != b64(crc32c("def")) {
+		t.Fatal("unexpected part 2 response", rs.StatusCode, rs.Header)
+	}
+	etag2 := rs.Header.Get("ETag")
+
+	_, body = do("GET", object+"?uploadId="+string(id), nil, "")
+	var listed gofakes3.ListMultipartUploadPartsResult
+	ts.OK(xml.Unmarshal(body, &listed))
+	if listed.ChecksumAlgorithm != gofakes3.ChecksumCRC32C || len(listed.Parts) != 2 ||
+		listed.Parts[0].ChecksumCRC32C != b64(crc32c("abc")) ||
+		listed.Parts[1].ChecksumCRC32C != b64(crc32c("def")) {
+		t.Fatal("unexpected parts", string(body))
+	}
+
+	complete := func(object string, id gofakes3.UploadID, parts ...gofakes3.CompletedPart) (*http.Response, []byte) {
+		doc, err := xml.Marshal(struct {
+			XMLName xml.Name `xml:"CompleteMultipartUpload"`
+			gofakes3.CompleteMultipartUploadRequest
+		}{CompleteMultipartUploadRequest: gofakes3.CompleteMultipartUploadRequest{Parts: parts}})
+		ts.OK(err)
+		return do("POST", object+"?uploadId="+string(id), nil, string(doc))
+	}
+
+	rs, body = complete(object, id,
+		gofakes3.CompletedPart{PartNumber: 1, ETag: etag1, Checksums: gofakes3.Checksums{ChecksumCRC32C: b64(crc32c("abc"))}},
+		gofakes3.CompletedPart{PartNumber: 2, ETag: etag2, Checksums: gofakes3.Checksums{ChecksumCRC32C: b64(crc32c("def"))}})
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("complete failed", string(body))
+	}
+	var result gofakes3.CompleteMultipartUploadResult
+	ts.OK(xml.Unmarshal(body, &result))
+	composite := b64(crc32c(string(crc32c("abc"))+string(crc32c("def")))) + "-2"
+	if result.ChecksumCRC32C != composite {
+		t.Fatal("unexpected composite checksum", result.ChecksumCRC32C, "expected", composite)
+	}
+
+	_, body = do("GET", object+"?attributes", map[string]string{
+		"x-amz-object-attributes": "Checksum,ObjectParts,ObjectSize",
+		"x-amz-max-parts":         "1",
+	}, "")
+	var attrs gofakes3.GetObjectAttributesResult
+	ts.OK(xml.Unmarshal(body, &attrs))
+	if attrs.Checksum == nil || attrs.Checksum.ChecksumCRC32C != composite {
+		t.Fatal("unexpected checksum", string(body))
+	}
+	if attrs.ObjectSize == nil || *attrs.ObjectSize != 6 || attrs.ETag != "" {
+		t.Fatal("unexpected attributes", string(body))
+	}
+	parts := attrs.ObjectParts
+	if parts == nil || parts.TotalPartsCount != 2 || !parts.IsTruncated || parts.NextPartNumberMarker != 1 ||
+		len(parts.Parts) != 1 || parts.Parts[0].ChecksumCRC32C != b64(crc32c("abc")) || parts.Parts[0].Size != 3 {
+		t.Fatal("unexpected object parts", string(body))
+	}
+
+	rs, body = do("GET", object+"?attributes", map[string]string{"x-amz-object-attributes": "Nope"}, "")
+	assertError(rs, body, http.StatusBadRequest, gofakes3.ErrInvalidArgument)
+
+	// An upload created with a checksum algorithm can't be completed without
+	// the checksums of its parts:
+	other := "/" + defaultBucket + "/bar"
+	otherID := initiate(other)
+	rs, _ = do("PUT", fmt.Sprintf("%s?uploadId=%s&partNumber=1", other, otherID), nil, "abc")
+	otherETag, otherChecksum := rs.Header.Get("ETag"), rs.Header.Get("x-amz-checksum-crc32c")
+	rs, body = complete(other, otherID, gofakes3.CompletedPart{PartNumber: 1, ETag: otherETag})
+	assertError(rs, body, http.StatusBadRequest, gofakes3.ErrInvalidRequest)
+
+	// The upload is left open, so it can be completed once the checksum is
+	// given:
+	rs, body = complete(other, otherID, gofakes3.CompletedPart{PartNumber: 1, ETag: otherETag, Checksums: gofakes3.Checksums{ChecksumCRC32C: otherChecksum}})
+	if rs.StatusCode != http.StatusOK {
+		t.Fatal("complete failed", string(body))
+	}
+}
+
+func TestMultipartUploadPartSize(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	put := func(id string, partNumber int, body []byte, chunked bool) *http.Response {
+		t.Helper()
+		rq, err := http.NewRequest("PUT", ts.url(fmt.Sprintf("/%s/foo?uploadId=%s&partNumber=%d", defaultBucket, id, partNumber)), bytes.NewReader(body))
+		ts.OK(err)
+		if chunked {
+			rq.Body = ioutil.NopCloser(bytes.NewReader(body))
+			rq.ContentLength = -1 // Forces 'Transfer-Encoding: chunked'
+		}
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		rs.Body.Close()
+		return rs
+	}
+
+	id := ts.createMultipartUpload(defaultBucket, "foo", nil)
+	var parts []*s3.CompletedPart
+	for i, tc := range []struct {
+		body    string
+		chunked bool
+	}{
+		{"abc", true},
+		{"", false}, // 'Content-Length: 0' is an empty part, not a missing length
+	} {
+		rs := put(id, i+1, []byte(tc.body), tc.chunked)
+		if rs.StatusCode != http.StatusOK {
+			t.Fatal("unexpected status for part", i+1, rs.StatusCode)
+		}
+		parts = append(parts, &s3.CompletedPart{ETag: aws.String(rs.Header.Get("ETag")), PartNumber: aws.Int64(int64(i + 1))})
+	}
+
+	_, err := svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(defaultBucket),
+		Key:             aws.String("foo"),
+		UploadId:        aws.String(id),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+	})
+	ts.OK(err)
+	ts.assertObject(defaultBucket, "foo", nil, "abc")
+}
+
+// TestConcurrentMultipartUploads races uploads to the same key against each
+// other, and against listings. It is most useful with 'go test -race'.
+func TestConcurrentMultipartUploads(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	const uploaders = 8
+	const partsPerUpload = 3
+	const object = "race"
+
+	// Parts and listings can always fail with NoSuchUpload if the upload is
+	// completed or aborted by another goroutine first:
+	errs := make(chan error, 1000)
+	report := func(err error) {
+		if err != nil && !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	}
+
+	ids := make(chan string, uploaders)
+	done := make(chan struct{})
+	var listers sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		listers.Add(1)
+		go func() {
+			defer listers.Done()
+			for {
+				select {
+				case <-done:
+					return
+				case id := <-ids:
+					_, err := svc.ListParts(&s3.ListPartsInput{Bucket: aws.String(defaultBucket), Key: aws.String(object), UploadId: aws.String(id)})
+					report(err)
+				default:
+				}
+				_, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)})
+				report(err)
+			}
+		}()
+	}
+
+	completed := make(chan string, uploaders)
+	var wg sync.WaitGroup
+	for i := 0; i < uploaders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			mpu, err := svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{Bucket: aws.String(defaultBucket), Key: aws.String(object)})
+			if err != nil {
+				report(err)
+				return
+			}
+			id := mpu.UploadId
+			ids <- *id
+
+			parts := make([]*s3.CompletedPart, partsPerUpload)
+			var partsWG sync.WaitGroup
+			for n := 1; n <= partsPerUpload; n++ {
+				partsWG.Add(1)
+				go func(n int) {
+					defer partsWG.Done()
+					rs, err := svc.UploadPart(&s3.UploadPartInput{
+						Bucket:     aws.String(defaultBucket),
+						Key:        aws.String(object),
+						UploadId:   id,
+						PartNumber: aws.Int64(int64(n)),
+						Body:       strings.NewReader(fmt.Sprintf("%d-%d;", i, n)),
+					})
+					if err != nil {
+						report(err)
+						return
+					}
+					parts[n-1] = &s3.CompletedPart{ETag: rs.ETag, PartNumber: aws.Int64(int64(n))}
+				}(n)
+			}
+			partsWG.Wait()
+
+			if i%2 == 1 {
+				_, err := svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{Bucket: aws.String(defaultBucket), Key: aws.String(object), UploadId: id})
+				report(err)
+				return
+			}
+			_, err = svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(defaultBucket),
+				Key:             aws.String(object),
+				UploadId:        id,
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+			})
+			if err != nil {
+				report(err)
+				return
+			}
+			completed <- fmt.Sprintf("%d-1;%d-2;%d-3;", i, i, i)
+		}(i)
+	}
+
+	wg.Wait()
+	close(done)
+	listers.Wait()
+	close(errs)
+	close(completed)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// Whichever upload was completed last wins:
+	obj, err := svc.GetObject(&s3.GetObjectInput{Bucket: aws.String(defaultBucket), Key: aws.String(object)})
+	ts.OK(err)
+	defer obj.Body.Close()
+	body, err := ioutil.ReadAll(obj.Body)
+	ts.OK(err)
+	found := false
+	for expected := range completed {
+		found = found || string(body) == expected
+	}
+	if !found {
+		t.Fatal("unexpected object contents", string(body))
+	}
+
+	if rs, err := svc.ListMultipartUploads(&s3.ListMultipartUploadsInput{Bucket: aws.String(defaultBucket)}); err == nil && len(rs.Uploads) > 0 {
+		t.Fatal("uploads left over", rs.Uploads)
+	} else if err != nil && !hasErrorCode(err, gofakes3.ErrNoSuchUpload) {
+		t.Fatal(err)
+	}
+}
+
+func TestGetObjectPartNumber(t *testing.T) {
+	ts := newTestServer(t, withFakerOptions(gofakes3.WithMinPartSize(4)))
+	defer ts.Close()
+	svc := ts.s3Client()
+
+	// Part numbers need not be contiguous; GetObject numbers the parts of
+	// the completed object from 1:
+	id := ts.createMultipartUpload(defaultBucket, "multi", nil)
+	parts := []*s3.CompletedPart{
+		ts.uploadPart(defaultBucket, "multi", id, 1, []byte("abcd")),
+		ts.uploadPart(defaultBucket, "multi", id, 3, []byte("efgh")),
+		ts.uploadPart(defaultBucket, "multi", id, 7, []byte("ij")),
+	}
+	ts.assertCompleteUpload(defaultBucket, "multi", id, parts, []byte("abcdefghij"))
+
+	getPart := func(key string, partNumber int64) (*s3.GetObjectOutput, string, error) {
+		out, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String(key),
+			PartNumber: aws.Int64(partNumber),
+		})
+		if err != nil {
+			return nil, "", err
+		}
+		defer out.Body.Close()
+		body, err := ioutil.ReadAll(out.Body)
+		ts.OK(err)
+		return out, string(body), nil
+	}
+
+	for _, tc := range []struct {
+		part int64
+		body string
+		rnge string
+	}{
+		{1, "abcd", "bytes 0-3/10"},
+		{2, "efgh", "bytes 4-7/10"},
+		{3, "ij", "bytes 8-9/10"},
+	} {
+		out, body, err := getPart("multi", tc.part)
+		ts.OK(err)
+		if body != tc.body || aws.StringValue(out.ContentRange) != tc.rnge || aws.Int64Value(out.PartsCount) != 3 {
+			t.Fatal("unexpected part", tc.part, body, aws.StringValue(out.ContentRange), aws.Int64Value(out.PartsCount))
+		}
+	}
+
+	head, err := svc.HeadObject(&s3.HeadObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("multi"),
+		PartNumber: aws.Int64(2),
+	})
+	ts.OK(err)
+	if aws.Int64Value(head.ContentLength) != 4 || aws.Int64Value(head.PartsCount) != 3 {
+		t.Fatal("unexpected head", aws.Int64Value(head.ContentLength), aws.Int64Value(head.PartsCount))
+	}
+
+	if _, _, err := getPart("multi", 4); !hasErrorCode(err, gofakes3.ErrInvalidPartNumber) {
+		t.Fatal("expected InvalidPartNumber, found", err)
+	}
+
+	_, err = svc.GetObject(&s3.GetObjectInput{
+		Bucket:     aws.String(defaultBucket),
+		Key:        aws.String("multi"),
+		PartNumber: aws.Int64(1),
+		Range:      aws.String("bytes=0-1"),
+	})
+	if !hasErrorCode(err, gofakes3.ErrInvalidRequest) {
+		t.Fatal("expected InvalidRequest, found", err)
+	}
+
+	// An object that was uploaded in one go has a single part:
+	ts.backendPutString(defaultBucket, "single", nil, "hello")
+	out, body, err := getPart("single", 1)
+	ts.OK(err)
+	if body != "hello" || out.PartsCount != nil {
+		t.Fatal("unexpected part", body, aws.Int64Value(out.PartsCount))
+	}
+	if _, _, err := getPart("single", 2); !hasErrorCode(err, gofakes3.ErrInvalidPartNumber) {
+		t.Fatal("expected InvalidPartNumber, found", err)
+	}
+
+	// Overwriting the object forgets its parts:
+	ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(defaultBucket),
+		Key:    aws.String("multi"),
+		Body:   strings.NewReader("hello"),
+	}))
+	if _, _, err := getPart("multi", 2); !hasErrorCode(err, gofakes3.ErrInvalidPartNumber) {
+		t.Fatal("expected InvalidPartNumber, found", err)
+	}
+}
+
+// partlessBackend hides the ObjectPartsBackend implementation of the wrapped
+// Backend, so GoFakeS3 has to keep the parts manifests itself.
+type partlessBackend struct {
+	gofakes3.Backend
+}
+
+func TestObjectPartsManifest(t *testing.T) {
+	complete := func(ts *testServer, key string, bodies ...string) string {
+		ts.Helper()
+		id := ts.createMultipartUpload(defaultBucket, key, nil)
+		var parts []*s3.CompletedPart
+		for i, body := range bodies {
+			parts = append(parts, ts.uploadPart(defaultBucket, key, id, int64(i+1), []byte(body)))
+		}
+		out, err := ts.s3Client().CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+			Bucket:          aws.String(defaultBucket),
+			Key:             aws.String(key),
+			UploadId:        aws.String(id),
+			MultipartUpload: &s3.CompletedMultipartUpload{Parts: parts},
+		})
+		ts.OK(err)
+		return aws.StringValue(out.VersionId)
+	}
+
+	getPart := func(ts *testServer, key, version string, partNumber int64) (body string, partsCount int64, err error) {
+		ts.Helper()
+		input := &s3.GetObjectInput{
+			Bucket:     aws.String(defaultBucket),
+			Key:        aws.String(key),
+			PartNumber: aws.Int64(partNumber),
+		}
+		if version != "" {
+			input.VersionId = aws.String(version)
+		}
+		out, err := ts.s3Client().GetObject(input)
+		if err != nil {
+			return "", 0, err
+		}
+		defer out.Body.Close()
+		bts, err := ioutil.ReadAll(out.Body)
+		ts.OK(err)
+		return string(bts), aws.Int64Value(out.PartsCount), nil
+	}
+
+	for _, tc := range []struct {
+		name    string
+		backend gofakes3.Backend
+	}{
+		{"backend", nil},
+		{"fallback", partlessBackend{s3mem.New(s3mem.WithTimeSource(gofakes3.FixedTimeSource(defaultDate)))}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []testServerOption{withFakerOptions(gofakes3.WithMinPartSize(4))}
+			if tc.backend != nil {
+				opts = append(opts, withBackend(tc.backend))
+			}
+			ts := newTestServer(t, opts...)
+			defer ts.Close()
+
+			complete(ts, "object", "abcd", "ef")
+			body, count, err := getPart(ts, "object", "", 2)
+			ts.OK(err)
+			if body != "ef" || count != 2 {
+				t.Fatal("unexpected part", body, count)
+			}
+		})
+	}
+
+	t.Run("versions", func(t *testing.T) {
+		// Only a Backend that implements ObjectPartsBackend can keep the
+		// manifest of a version that is no longer current:
+		ts := newTestServer(t, withVersioning(), withFakerOptions(gofakes3.WithMinPartSize(4)))
+		defer ts.Close()
+		svc := ts.s3Client()
+
+		first := complete(ts, "object", "abcd", "ef")
+		second := complete(ts, "object", "ghij", "klmn", "op")
+		ts.OKAll(svc.PutObject(&s3.PutObjectInput{
+			Bucket: aws.String(defaultBucket),
+			Key:    aws.String("object"),
+			Body:   strings.NewReader("hello"),
+		}))
+
+		for _, tc := range []struct {
+			version string
+			part    int64
+			body    string
+			count   int64
+		}{
+			{first, 2, "ef", 2},
+			{second, 2, "klmn", 3},
+			{second, 3, "op", 3},
+			{"", 1, "hello", 0},
+		} {
+			body, count, err := getPart(ts, "object", tc.version, tc.part)
+			ts.OK(err)
+			if body != tc.body || count != tc.count {
+				t.Fatal("unexpected part", tc.version, tc.part, body, count)
+			}
+		}
+
+		rq, err := http.NewRequest("GET", ts.url("/"+defaultBucket+"/object?attributes&versionId="+first), nil)
+		ts.OK(err)
+		rq.Header.Set("x-amz-object-attributes", "ObjectParts")
+		rs, err := httpClient().Do(rq)
+		ts.OK(err)
+		defer rs.Body.Close()
+		var attrs gofakes3.GetObjectAttributesResult
+		ts.OK(xml.NewDecoder(rs.Body).Decode(&attrs))
+		if attrs.ObjectParts == nil || attrs.ObjectParts.TotalPartsCount != 2 {
+			t.Fatal("unexpected parts", attrs.ObjectParts)
+		}
+	})
+}
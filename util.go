@@ -1,12 +1,32 @@
 package gofakes3
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http"
+	"os"
 	"strconv"
 )
 
-func parseClampedInt(in string, defaultValue, min, max int64) (int64, error) {
+// clampMode controls what parseClampedInt does with a value that is out of
+// range.
+type clampMode int
+
+const (
+	// clampToRange replaces the value with the nearest bound, like S3 does
+	// for most limits.
+	clampToRange clampMode = iota
+
+	// rejectOutOfRange fails with errOutOfRange. See WithStrictLimits.
+	rejectOutOfRange
+)
+
+var errOutOfRange = errors.New("gofakes3: value out of range")
+
+func parseClampedInt(in string, defaultValue, min, max int64, mode clampMode) (int64, error) {
 	var v int64
 	if in == "" {
 		v = defaultValue
@@ -18,6 +38,10 @@ func parseClampedInt(in string, defaultValue, min, max int64) (int64, error) {
 		}
 	}
 
+	if in != "" && mode == rejectOutOfRange && (v < min || v > max) {
+		return defaultValue, errOutOfRange
+	}
+
 	if v < min {
 		v = min
 	} else if v > max {
@@ -27,6 +51,18 @@ func parseClampedInt(in string, defaultValue, min, max int64) (int64, error) {
 	return v, nil
 }
 
+// parseLimit parses a limit like 'max-keys' using parseClampedInt, with a
+// minimum of 0. Values that are out of range fail with an
+// ErrorInvalidArgumentResponse naming the argument if the mode is
+// rejectOutOfRange; other errors are passed through.
+func parseLimit(name, in string, defaultValue, max int64, mode clampMode) (int64, error) {
+	v, err := parseClampedInt(in, defaultValue, 0, max, mode)
+	if err == errOutOfRange {
+		return v, ErrorInvalidArgument(name, in, fmt.Sprintf("Argument %s must be an integer between 0 and %d", name, max))
+	}
+	return v, err
+}
+
 // ReadAll is a fakeS3-centric replacement for ioutil.ReadAll(), for use when
 // the size of the result is known ahead of time. It is considerably faster to
 // preallocate the entire slice than to allow growslice to be triggered
@@ -56,3 +92,114 @@ func ReadAll(r io.Reader, size int64) (b []byte, err error) {
 
 	return b, nil
 }
+
+// sizeLimitReader fails with ErrEntityTooLarge if more than limit bytes are
+// read from it, so a client can't send more than it claimed in the
+// Content-Length header.
+type sizeLimitReader struct {
+	inner     io.Reader
+	limit     int64
+	remaining int64
+}
+
+func (lr *sizeLimitReader) Read(p []byte) (n int, err error) {
+	if lr.remaining < 0 {
+		return 0, entityTooLarge(0, lr.limit)
+	}
+
+	// Reading one byte more than the limit is enough to detect a body that
+	// exceeds it:
+	if int64(len(p)) > lr.remaining+1 {
+		p = p[:lr.remaining+1]
+	}
+	n, err = lr.inner.Read(p)
+	lr.remaining -= int64(n)
+	if lr.remaining < 0 {
+		return n + int(lr.remaining), entityTooLarge(0, lr.limit)
+	}
+	return n, err
+}
+
+// spoolBody reads rdr to the end so that its size is known, for a request body
+// that was sent without a Content-Length (i.e. with 'Transfer-Encoding:
+// chunked'). Up to memLimit bytes are held in memory; a larger body is
+// written to a temporary file in dir instead, which is removed when the
+// returned ReadCloser is closed. If dir is empty, os.TempDir is used.
+func spoolBody(rdr io.Reader, dir string, memLimit int64) (body io.ReadCloser, size int64, err error) {
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, rdr, memLimit+1)
+	if err == io.EOF {
+		return ioutil.NopCloser(&buf), n, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	f, err := ioutil.TempFile(dir, "gofakes3-")
+	if err != nil {
+		return nil, 0, err
+	}
+	spooled := &spooledFile{f}
+	defer func() {
+		if err != nil {
+			spooled.Close()
+		}
+	}()
+
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return nil, 0, err
+	}
+	m, err := io.Copy(f, rdr)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return spooled, n + m, nil
+}
+
+// requestBodySize returns the size of an upload's body from its
+// Content-Length header, which falls into one of three cases:
+//
+//   - A non-negative integer is the size of the body. '0' is a valid empty
+//     object or part, not a missing length.
+//
+//   - A missing header, or '-1' (which some SDKs send when they stream a
+//     body), means the size is unknown. If the body is chunked, known is
+//     false and the size can only be found by reading it all (see spoolBody).
+//     Otherwise, the body can't be sized, and ErrMissingContentLength is
+//     returned.
+//
+//   - Anything else is invalid, and an InvalidArgument error is returned.
+//
+// net/http rejects most malformed Content-Length headers before they reach
+// a handler, but requests passed to GoFakeS3.Server directly (or through
+// GoFakeS3.RoundTripper) are not checked.
+func requestBodySize(r *http.Request) (size int64, known bool, err error) {
+	hdr := r.Header.Get("Content-Length")
+	if hdr == "" || hdr == "-1" {
+		if r.ContentLength < 0 {
+			return 0, false, nil
+		}
+		return 0, false, ErrMissingContentLength
+	}
+
+	size, err = strconv.ParseInt(hdr, 10, 64)
+	if err != nil || size < 0 {
+		return 0, false, ErrorInvalidArgument("Content-Length", hdr, "Content-Length must be a non-negative integer")
+	}
+	return size, true, nil
+}
+
+// spooledFile is a temporary file that is removed when it is closed.
+type spooledFile struct {
+	*os.File
+}
+
+func (f *spooledFile) Close() error {
+	err := f.File.Close()
+	if rerr := os.Remove(f.Name()); err == nil {
+		err = rerr
+	}
+	return err
+}
@@ -1,6 +1,10 @@
 package gofakes3
 
 import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -18,7 +22,38 @@ func TestParseClampedIntValid(t *testing.T) {
 		{in: "1000", dflt: 0, min: 2, max: 100, out: 100},
 	} {
 		t.Run("", func(t *testing.T) {
-			result, err := parseClampedInt(tc.in, tc.dflt, tc.min, tc.max)
+			result, err := parseClampedInt(tc.in, tc.dflt, tc.min, tc.max, clampToRange)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != tc.out {
+				t.Fatal(result, "!=", tc.out)
+			}
+		})
+	}
+}
+
+func TestParseClampedIntReject(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		dflt     int64
+		out      int64
+		outOfRng bool
+	}{
+		{in: "", dflt: 1, out: 1},
+		{in: "0", dflt: 1, out: 0},
+		{in: "100", dflt: 1, out: 100},
+		{in: "-1", dflt: 1, outOfRng: true},
+		{in: "101", dflt: 1, outOfRng: true},
+	} {
+		t.Run(tc.in, func(t *testing.T) {
+			result, err := parseClampedInt(tc.in, tc.dflt, 0, 100, rejectOutOfRange)
+			if tc.outOfRng {
+				if err != errOutOfRange {
+					t.Fatal("expected errOutOfRange, found", err)
+				}
+				return
+			}
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -62,3 +97,112 @@ func TestReadAll(t *testing.T) {
 		}
 	})
 }
+
+func TestSizeLimitReader(t *testing.T) {
+	for _, tc := range []struct {
+		in    string
+		limit int64
+		fails bool
+	}{
+		{in: "", limit: 3},
+		{in: "abc", limit: 3},
+		{in: "abcd", limit: 3, fails: true},
+		{in: strings.Repeat("a", 10000), limit: 3, fails: true},
+	} {
+		t.Run("", func(t *testing.T) {
+			rdr := &sizeLimitReader{inner: strings.NewReader(tc.in), limit: tc.limit, remaining: tc.limit}
+			out, err := ioutil.ReadAll(rdr)
+			if tc.fails {
+				if !HasErrorCode(err, ErrEntityTooLarge) {
+					t.Fatal("expected ErrEntityTooLarge, found", err)
+				}
+				if int64(len(out)) != tc.limit {
+					t.Fatal("read", len(out), "bytes, expected the limit", tc.limit)
+				}
+			} else if err != nil || string(out) != tc.in {
+				t.Fatal("unexpected result", err, string(out))
+			}
+		})
+	}
+}
+
+func TestSpoolBody(t *testing.T) {
+	t.Run("memory", func(t *testing.T) {
+		tt := TT{t}
+		body, size, err := spoolBody(strings.NewReader("test"), "", 4)
+		tt.OK(err)
+		defer body.Close()
+		if _, ok := body.(*spooledFile); ok {
+			t.Fatal("unexpected temp file")
+		}
+		b, err := ioutil.ReadAll(body)
+		tt.OK(err)
+		if size != 4 || string(b) != "test" {
+			t.Fatal(size, string(b), "!=", 4, "test")
+		}
+	})
+
+	t.Run("temp-file", func(t *testing.T) {
+		tt := TT{t}
+		dir := t.TempDir()
+		body, size, err := spoolBody(strings.NewReader("testing"), dir, 4)
+		tt.OK(err)
+		spooled, ok := body.(*spooledFile)
+		if !ok {
+			t.Fatal("expected temp file, found", body)
+		}
+		if filepath.Dir(spooled.Name()) != dir {
+			t.Fatal("expected temp file in", dir, "found", spooled.Name())
+		}
+		b, err := ioutil.ReadAll(body)
+		tt.OK(err)
+		if size != 7 || string(b) != "testing" {
+			t.Fatal(size, string(b), "!=", 7, "testing")
+		}
+
+		tt.OK(body.Close())
+		if _, err := os.Stat(spooled.Name()); !os.IsNotExist(err) {
+			t.Fatal("temp file was not removed", err)
+		}
+	})
+}
+
+func TestRequestBodySize(t *testing.T) {
+	for _, tc := range []struct {
+		header        string
+		contentLength int64
+		size          int64
+		known         bool
+		err           ErrorCode
+	}{
+		{header: "0", size: 0, known: true},
+		{header: "5", contentLength: 5, size: 5, known: true},
+		{header: "", contentLength: -1, known: false},
+		{header: "-1", contentLength: -1, known: false},
+		{header: "", contentLength: 0, err: ErrMissingContentLength},
+		{header: "-1", contentLength: 0, err: ErrMissingContentLength},
+		{header: "-2", err: ErrInvalidArgument},
+		{header: "abc", err: ErrInvalidArgument},
+		{header: "1.5", err: ErrInvalidArgument},
+	} {
+		t.Run(tc.header, func(t *testing.T) {
+			rq := &http.Request{Header: make(http.Header), ContentLength: tc.contentLength}
+			if tc.header != "" {
+				rq.Header.Set("Content-Length", tc.header)
+			}
+			size, known, err := requestBodySize(rq)
+			if tc.err != "" {
+				if !HasErrorCode(err, tc.err) {
+					t.Fatal("expected", tc.err, "found", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != tc.size || known != tc.known {
+				t.Fatal("unexpected result", size, known)
+			}
+		})
+	}
+}
@@ -2,45 +2,71 @@ package gofakes3
 
 import (
 	"net"
+	"net/http"
 	"regexp"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
-// This pattern can be used to match both the entire bucket name (including period-
-// separated labels) and the individual label components, presuming you have already
-// split the string by period.
-var bucketNamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9\.-]+)[a-z0-9]$`)
+// bucketNameCharsPattern matches the characters that may appear anywhere in a
+// bucket name.
+var bucketNameCharsPattern = regexp.MustCompile(`^[a-z0-9.-]+$`)
+
+// bucketLabelPattern matches an individual label component of a bucket name,
+// presuming you have already split the string by period.
+var bucketLabelPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?$`)
 
 // ValidateBucketName applies the rules from the AWS docs:
-// https://docs.aws.amazon.com/AmazonS3/latest/dev/BucketRestrictions.html#bucketnamingrules
+// https://docs.aws.amazon.com/AmazonS3/latest/userguide/bucketnamingrules.html
 //
-// 1. Bucket names must comply with DNS naming conventions.
-// 2. Bucket names must be at least 3 and no more than 63 characters long.
-// 3. Bucket names must not contain uppercase characters or underscores.
-// 4. Bucket names must start with a lowercase letter or number.
+// 1. Bucket names must be between 3 and 63 characters long.
+// 2. Bucket names can consist only of lowercase letters, numbers, dots (.), and hyphens (-).
+// 3. Bucket names must begin and end with a letter or number.
+// 4. Bucket names must not contain two adjacent periods.
+// 5. Bucket names must not be formatted as an IP address (for example, 192.168.5.4).
+// 6. Bucket names must not start with the prefix 'xn--' or 'sthree-'.
+// 7. Bucket names must not end with the suffix '-s3alias' or '--ol-s3'.
 //
-// The DNS RFC confirms that the valid range of characters in an LDH label is 'a-z0-9-':
+// Rules 3 and 4 are applied to each period-separated label, which also
+// prevents a hyphen from appearing next to a period, in keeping with DNS
+// naming conventions. The DNS RFC confirms that the valid range of characters
+// in an LDH label is 'a-z0-9-':
 // https://tools.ietf.org/html/rfc5890#section-2.3.1
 //
 func ValidateBucketName(name string) error {
 	if len(name) < 3 || len(name) > 63 {
 		return ErrorMessage(ErrInvalidBucketName, "bucket name must be >= 3 characters and <= 63")
 	}
-	if !bucketNamePattern.MatchString(name) {
-		return ErrorMessage(ErrInvalidBucketName, "bucket must start and end with 'a-z, 0-9', and contain only 'a-z, 0-9, -' in between")
+	if !bucketNameCharsPattern.MatchString(name) {
+		return ErrorMessage(ErrInvalidBucketName, "bucket name must contain only 'a-z, 0-9, ., -'")
+	}
+	if strings.Contains(name, "..") {
+		return ErrorMessage(ErrInvalidBucketName, "bucket name must not contain two adjacent periods")
 	}
 
 	if net.ParseIP(name) != nil {
 		return ErrorMessage(ErrInvalidBucketName, "bucket names must not be formatted as an IP address")
 	}
 
+	for _, prefix := range []string{"xn--", "sthree-"} {
+		if strings.HasPrefix(name, prefix) {
+			return ErrorMessagef(ErrInvalidBucketName, "bucket name must not start with %q", prefix)
+		}
+	}
+	for _, suffix := range []string{"-s3alias", "--ol-s3"} {
+		if strings.HasSuffix(name, suffix) {
+			return ErrorMessagef(ErrInvalidBucketName, "bucket name must not end with %q", suffix)
+		}
+	}
+
 	// Bucket names must be a series of one or more labels. Adjacent labels are
 	// separated by a single period (.). Bucket names can contain lowercase
 	// letters, numbers, and hyphens. Each label must start and end with a
 	// lowercase letter or a number.
 	labels := strings.Split(name, ".")
 	for _, label := range labels {
-		if !bucketNamePattern.MatchString(label) {
+		if !bucketLabelPattern.MatchString(label) {
 			return ErrorMessage(ErrInvalidBucketName, "label must start and end with 'a-z, 0-9', and contain only 'a-z, 0-9, -' in between")
 		}
 	}
@@ -48,8 +74,131 @@ func ValidateBucketName(name string) error {
 	return nil
 }
 
+// authV4Pattern matches the structure of an AWS Signature Version 4
+// Authorization header, without validating the signature itself:
+// https://docs.aws.amazon.com/AmazonS3/latest/API/sigv4-auth-using-authorization-header.html
+var authV4Pattern = regexp.MustCompile(`^AWS4-HMAC-SHA256 ` +
+	`Credential=[^/,\s]+/\d{8}/[^/,\s]+/[^/,\s]+/aws4_request,\s*` +
+	`SignedHeaders=[a-z0-9\-;]+,\s*` +
+	`Signature=[0-9a-f]{64}$`)
+
+// authV2Pattern matches the structure of an AWS Signature Version 2
+// Authorization header:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/RESTAuthentication.html
+var authV2Pattern = regexp.MustCompile(`^AWS [^:\s]+:[A-Za-z0-9+/=]+$`)
+
+// validateRequiredHeaders checks that the headers S3 requires on every request
+// are present and well-formed. It does not verify signatures.
+func validateRequiredHeaders(rq *http.Request) error {
+	if rq.Host == "" {
+		return ErrorMessage(ErrMissingSecurityHeader, "Your request is missing the required header Host")
+	}
+
+	auth := rq.Header.Get("Authorization")
+	if auth == "" {
+		// Anonymous requests need nothing further.
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(auth, "AWS4-HMAC-SHA256 "):
+		if !authV4Pattern.MatchString(auth) {
+			return ErrorMessage(ErrInvalidRequest, "The authorization header is malformed")
+		}
+	case strings.HasPrefix(auth, "AWS "):
+		if !authV2Pattern.MatchString(auth) {
+			return ErrorMessage(ErrInvalidRequest, "The authorization header is malformed")
+		}
+	default:
+		return ErrorMessage(ErrInvalidRequest, "Unsupported Authorization Type")
+	}
+
+	// Signed requests must carry the time they were signed in either
+	// x-amz-date or Date; x-amz-date takes precedence if both are present.
+	if date := rq.Header.Get("x-amz-date"); date != "" {
+		if _, err := time.Parse("20060102T150405Z", date); err != nil {
+			return ErrorMessage(ErrInvalidRequest, "The x-amz-date header is malformed")
+		}
+	} else if date := rq.Header.Get("Date"); date != "" {
+		if _, err := http.ParseTime(date); err != nil {
+			return ErrorMessage(ErrInvalidRequest, "The Date header is malformed")
+		}
+	} else {
+		return ErrorMessage(ErrMissingSecurityHeader, "Your request is missing the required header x-amz-date or Date")
+	}
+
+	return nil
+}
+
 var etagPattern = regexp.MustCompile(`^"[a-z0-9]+"$`)
 
 func validETag(v string) bool {
 	return etagPattern.MatchString(v)
 }
+
+// tagCharsPattern matches the characters allowed in tag keys and values:
+// letters, numbers and spaces representable in UTF-8, and the characters
+// '+ - = . _ : / @'.
+var tagCharsPattern = regexp.MustCompile(`^[\p{L}\p{N}\p{Z}+\-=._:/@]*$`)
+
+// validateTagSet applies the object tagging restrictions from the AWS docs:
+// https://docs.aws.amazon.com/AmazonS3/latest/dev/object-tagging.html
+//
+// The error codes and messages match what S3 returns for each violation.
+func validateTagSet(tags []Tag) error {
+	if len(tags) > MaxObjectTags {
+		return ErrorMessagef(ErrBadRequest, "Object tags cannot be greater than %d", MaxObjectTags)
+	}
+
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if tag.Key == "" || !tagCharsPattern.MatchString(tag.Key) {
+			return ErrorMessagef(ErrInvalidTag, "The TagKey you have provided is invalid: %q", tag.Key)
+		}
+		if utf8.RuneCountInString(tag.Key) > MaxTagKeyLength {
+			return ErrorMessagef(ErrInvalidTag, "The TagKey you have provided is too long, max %d", MaxTagKeyLength)
+		}
+		if !tagCharsPattern.MatchString(tag.Value) {
+			return ErrorMessagef(ErrInvalidTag, "The TagValue you have provided is invalid: %q", tag.Value)
+		}
+		if utf8.RuneCountInString(tag.Value) > MaxTagValueLength {
+			return ErrorMessagef(ErrInvalidTag, "The TagValue you have provided is too long, max %d", MaxTagValueLength)
+		}
+		if _, ok := seen[tag.Key]; ok {
+			return ErrorMessagef(ErrInvalidTag, "Cannot provide multiple Tags with the same key: %q", tag.Key)
+		}
+		seen[tag.Key] = struct{}{}
+	}
+
+	return nil
+}
+
+// validateNotificationConfiguration applies the structural checks S3 makes
+// on a notification configuration. The destinations are not checked, as
+// GoFakeS3 never delivers to them.
+func validateNotificationConfiguration(config NotificationConfiguration) error {
+	var targets []NotificationTarget
+	targets = append(targets, config.TopicConfigurations...)
+	targets = append(targets, config.QueueConfigurations...)
+	targets = append(targets, config.LambdaFunctionConfigurations...)
+
+	for _, target := range targets {
+		if target.arn() == "" || len(target.Events) == 0 {
+			return ErrMalformedXML
+		}
+		for _, event := range target.Events {
+			if !strings.HasPrefix(event, "s3:") {
+				return ErrorInvalidArgument("Event", event, "The event is not supported for notifications")
+			}
+		}
+		if target.Filter == nil {
+			continue
+		}
+		for _, rule := range target.Filter.Rules {
+			if name := strings.ToLower(rule.Name); name != "prefix" && name != "suffix" {
+				return ErrorInvalidArgument("FilterRule", rule.Name, "filter rule name must be either prefix or suffix")
+			}
+		}
+	}
+	return nil
+}
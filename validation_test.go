@@ -2,6 +2,7 @@ package gofakes3
 
 import (
 	"fmt"
+	"net/http/httptest"
 	"strings"
 	"testing"
 )
@@ -21,6 +22,29 @@ func TestValidateBucketName(t *testing.T) {
 		// Appending labels to these causes them to pass:
 		{"192.168.1.1", ErrInvalidBucketName},     // IP addresses are not allowed as bucket names. These may trip the "3-char min" rule first.
 		{"192.168.111.111", ErrInvalidBucketName}, // These should not trip the 3-char min but should still fail.
+
+		// Labels may be shorter than the minimum length of a name, so these
+		// are not in nameCases either:
+		{"1", ErrInvalidBucketName},  // Too short
+		{"12", ErrInvalidBucketName}, // Too short
+		{"a.b", ErrNone},
+		{"ab.1.cd", ErrNone},
+		{"a..b", ErrInvalidBucketName}, // Adjacent periods
+		{".ab", ErrInvalidBucketName},
+		{"ab.", ErrInvalidBucketName},
+		{"ab.-cd", ErrInvalidBucketName},
+		{"ab-.cd", ErrInvalidBucketName},
+
+		// Reserved prefixes and suffixes:
+		{"xn--yep", ErrInvalidBucketName},
+		{"xn-yep", ErrNone},
+		{"yep.xn--yep", ErrNone}, // Only applies to the start of the name
+		{"sthree-yep", ErrInvalidBucketName},
+		{"sthreeyep", ErrNone},
+		{"yep-s3alias", ErrInvalidBucketName},
+		{"yep-s3alias.yep", ErrNone}, // Only applies to the end of the name
+		{"yep--ol-s3", ErrInvalidBucketName},
+		{"yep-ol-s3", ErrNone},
 	}
 
 	nameCases := []tcase{
@@ -35,9 +59,8 @@ func TestValidateBucketName(t *testing.T) {
 		{"-nup", ErrInvalidBucketName},
 		{"nup-", ErrInvalidBucketName},
 		{"-nup-", ErrInvalidBucketName},
+		{"n_p", ErrInvalidBucketName},
 
-		{"1", ErrInvalidBucketName},  // Too short
-		{"12", ErrInvalidBucketName}, // Too short
 		{"123", ErrNone},
 		{strings.Repeat("1", 64), ErrInvalidBucketName},
 	}
@@ -67,3 +90,72 @@ func TestValidateBucketName(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateRequiredHeaders(t *testing.T) {
+	const v4 = "AWS4-HMAC-SHA256 Credential=AKID/20190101/us-east-1/s3/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+	for idx, tc := range []struct {
+		host    string
+		headers map[string]string
+		errCode ErrorCode
+	}{
+		{"localhost", nil, ErrNone},
+		{"", nil, ErrMissingSecurityHeader},
+
+		{"localhost", map[string]string{"Authorization": v4, "x-amz-date": "20190101T120000Z"}, ErrNone},
+		{"localhost", map[string]string{"Authorization": v4, "Date": "Tue, 01 Jan 2019 12:00:00 GMT"}, ErrNone},
+		{"localhost", map[string]string{"Authorization": v4}, ErrMissingSecurityHeader},
+		{"localhost", map[string]string{"Authorization": v4, "x-amz-date": "yesterday"}, ErrInvalidRequest},
+		{"localhost", map[string]string{"Authorization": v4, "Date": "yesterday"}, ErrInvalidRequest},
+		{"localhost", map[string]string{"Authorization": "AWS4-HMAC-SHA256 Credential=AKID", "x-amz-date": "20190101T120000Z"}, ErrInvalidRequest},
+
+		{"localhost", map[string]string{"Authorization": "AWS AKID:c2lnbmF0dXJl", "Date": "Tue, 01 Jan 2019 12:00:00 GMT"}, ErrNone},
+		{"localhost", map[string]string{"Authorization": "AWS AKID", "Date": "Tue, 01 Jan 2019 12:00:00 GMT"}, ErrInvalidRequest},
+
+		{"localhost", map[string]string{"Authorization": "Bearer nope", "x-amz-date": "20190101T120000Z"}, ErrInvalidRequest},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			rq := httptest.NewRequest("GET", "/", nil)
+			rq.Host = tc.host
+			for k, v := range tc.headers {
+				rq.Header.Set(k, v)
+			}
+			err := validateRequiredHeaders(rq)
+			if !HasErrorCode(err, tc.errCode) {
+				t.Fatal("expected error code", tc.errCode, "found", err)
+			}
+		})
+	}
+}
+
+func TestValidateTagSet(t *testing.T) {
+	for idx, tc := range []struct {
+		tags    []Tag
+		errCode ErrorCode
+	}{
+		{nil, ErrNone},
+		{[]Tag{{"key", "value"}}, ErrNone},
+		{[]Tag{{"key", ""}}, ErrNone},
+		{[]Tag{{"ключ", "значение"}}, ErrNone},
+		{[]Tag{{"a b+c-d=e.f_g:h/i@j", "a b+c-d=e.f_g:h/i@j"}}, ErrNone},
+		{[]Tag{{strings.Repeat("k", MaxTagKeyLength), strings.Repeat("v", MaxTagValueLength)}}, ErrNone},
+		{[]Tag{{strings.Repeat("ü", MaxTagKeyLength), ""}}, ErrNone}, // Limit is in characters, not bytes
+
+		{[]Tag{{"", "value"}}, ErrInvalidTag},
+		{[]Tag{{"key*", "value"}}, ErrInvalidTag},
+		{[]Tag{{"key", "value?"}}, ErrInvalidTag},
+		{[]Tag{{strings.Repeat("k", MaxTagKeyLength+1), "value"}}, ErrInvalidTag},
+		{[]Tag{{"key", strings.Repeat("v", MaxTagValueLength+1)}}, ErrInvalidTag},
+		{[]Tag{{"key", "a"}, {"key", "b"}}, ErrInvalidTag},
+		{make([]Tag, MaxObjectTags+1), ErrBadRequest},
+	} {
+		t.Run(fmt.Sprintf("%d", idx), func(t *testing.T) {
+			err := validateTagSet(tc.tags)
+			if !HasErrorCode(err, tc.errCode) {
+				t.Fatal("expected error code", tc.errCode, "found", err)
+			}
+		})
+	}
+}